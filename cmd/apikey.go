@@ -0,0 +1,121 @@
+/*
+Copyright © 2019 NAME HERE <EMAIL ADDRESS>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/brnsampson/echopilot/pkg/auth"
+	"github.com/spf13/cobra"
+)
+
+// apikeyCmd represents the apikey command
+var apikeyCmd = &cobra.Command{
+	Use:   "apikey",
+	Short: "Generate API keys and bearer tokens for clients.",
+	Long: `Generate the keys clients authenticate with. The keys file named by authKeysFile only
+holds their SHA-256 hashes, so a key is shown once and cannot be recovered from the file.
+The server reads the file again on every reload. For example:
+
+  echopilot apikey generate ci --file etc/echopilot-keys.json
+  echopilot apikey generate dashboard --type bearer --file etc/echopilot-keys.json
+  kill -HUP $(pidof echopilot)`,
+}
+
+var apikeyGenerateCmd = &cobra.Command{
+	Use:   "generate NAME",
+	Short: "Generate a key and print it along with its keys file entry.",
+	Args:  cobra.ExactArgs(1),
+	Run:   runAPIKeyGenerate,
+}
+
+func runAPIKeyGenerate(cmd *cobra.Command, args []string) {
+	flags := cmd.Flags()
+	typ, _ := flags.GetString("type")
+	file, _ := flags.GetString("file")
+	if typ != string(auth.TypeAPIKey) && typ != string(auth.TypeBearer) {
+		fmt.Printf("--type must be apiKey or bearer, not %q\n", typ)
+		os.Exit(1)
+	}
+
+	secret := auth.NewSecret()
+	key := auth.Key{Name: args[0], Type: auth.KeyType(typ), SHA256: auth.Hash(secret)}
+	if file == "" {
+		entry, _ := json.Marshal(key)
+		fmt.Printf("Key:   %s\n", secret)
+		fmt.Printf("Entry: %s\n", entry)
+		return
+	}
+
+	if err := addKey(file, key); err != nil {
+		fmt.Printf("Error adding key to %s: %v\n", file, err)
+		os.Exit(1)
+	}
+	fmt.Printf("Key:   %s\n", secret)
+	fmt.Printf("Added %s to %s, reload the server to use it\n", key.Name, file)
+}
+
+// addKey adds key to the keys file, creating it if needed. The file is replaced
+// atomically so a server reloading at the same time never reads half of it.
+func addKey(file string, key auth.Key) error {
+	var keys struct {
+		Keys []auth.Key `json:"keys"`
+	}
+	data, err := os.ReadFile(file)
+	if err == nil {
+		if err := json.Unmarshal(data, &keys); err != nil {
+			return err
+		}
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	for _, existing := range keys.Keys {
+		if existing.Name == key.Name {
+			return fmt.Errorf("there already is a key called %s", key.Name)
+		}
+	}
+	keys.Keys = append(keys.Keys, key)
+
+	data, err = json.MarshalIndent(keys, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(file), filepath.Base(file)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(append(data, '\n')); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), file)
+}
+
+func init() {
+	rootCmd.AddCommand(apikeyCmd)
+	apikeyCmd.AddCommand(apikeyGenerateCmd)
+	apikeyGenerateCmd.Flags().String("type", string(auth.TypeAPIKey), "apiKey (sent in X-API-Key) or bearer (sent in Authorization)")
+	apikeyGenerateCmd.Flags().String("file", "", "Add the key to this keys file instead of printing its entry")
+}
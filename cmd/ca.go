@@ -0,0 +1,169 @@
+/*
+Copyright © 2019 NAME HERE <EMAIL ADDRESS>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/brnsampson/echopilot/pkg/localca"
+	"github.com/spf13/cobra"
+)
+
+// caCmd represents the ca command
+var caCmd = &cobra.Command{
+	Use:   "ca",
+	Short: "Manage a local certificate authority for development and testing.",
+	Long: `Create a local CA and issue server and client certificates from it so that
+development and mTLS test setups do not need any external tools. For example:
+
+  echopilot ca init --dir etc/ca
+  echopilot ca server --dir etc/ca localhost 127.0.0.1
+  echopilot ca client --dir etc/ca my-test-client
+
+Never use these certificates for anything exposed to the internet.`,
+}
+
+var caInitCmd = &cobra.Command{
+	Use:   "init",
+	Short: "Create a new CA.",
+	Args:  cobra.NoArgs,
+	Run:   runCaInit,
+}
+
+var caServerCmd = &cobra.Command{
+	Use:   "server HOST...",
+	Short: "Issue a server certificate for the given hostnames and IP addresses.",
+	Args:  cobra.MinimumNArgs(1),
+	Run:   runCaServer,
+}
+
+var caClientCmd = &cobra.Command{
+	Use:   "client NAME",
+	Short: "Issue a client certificate for mTLS with NAME as the common name.",
+	Args:  cobra.ExactArgs(1),
+	Run:   runCaClient,
+}
+
+func runCaInit(cmd *cobra.Command, args []string) {
+	flags := cmd.Flags()
+	dir, _ := flags.GetString("dir")
+	name, _ := flags.GetString("name")
+	days, _ := flags.GetInt("days")
+	force, _ := flags.GetBool("force")
+
+	certFile, _ := localca.Files(dir, "ca")
+	if _, err := os.Stat(certFile); err == nil && !force {
+		fmt.Printf("A CA already exists in %s. Use --force to replace it.\n", dir)
+		os.Exit(1)
+	}
+
+	ca, err := localca.NewCA(name, time.Duration(days)*24*time.Hour)
+	if err != nil {
+		fmt.Printf("Error creating CA: %v\n", err)
+		os.Exit(1)
+	}
+
+	var pass []byte
+	if encrypt, _ := flags.GetBool("encrypt"); encrypt {
+		pass = newPassphrase(cmd)
+	}
+	if err := ca.Save(dir, pass); err != nil {
+		fmt.Printf("Error saving CA: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("Created CA %q in %s\n", name, certFile)
+}
+
+func runCaServer(cmd *cobra.Command, args []string) {
+	ca, out, name, validity := loadCaForIssue(cmd, "server")
+
+	issued, err := ca.IssueServer(args, validity)
+	if err != nil {
+		fmt.Printf("Error issuing server certificate: %v\n", err)
+		os.Exit(1)
+	}
+	saveIssued(issued, out, name)
+}
+
+func runCaClient(cmd *cobra.Command, args []string) {
+	ca, out, name, validity := loadCaForIssue(cmd, args[0])
+
+	issued, err := ca.IssueClient(args[0], validity)
+	if err != nil {
+		fmt.Printf("Error issuing client certificate: %v\n", err)
+		os.Exit(1)
+	}
+	saveIssued(issued, out, name)
+}
+
+func loadCaForIssue(cmd *cobra.Command, defaultName string) (*localca.CA, string, string, time.Duration) {
+	flags := cmd.Flags()
+	dir, _ := flags.GetString("dir")
+	out, _ := flags.GetString("out")
+	name, _ := flags.GetString("name")
+	days, _ := flags.GetInt("days")
+
+	if out == "" {
+		out = dir
+	}
+	if name == "" {
+		name = defaultName
+	}
+
+	ca, err := localca.LoadCA(dir, keyPassphrase(cmd))
+	if err != nil {
+		fmt.Printf("Error loading CA from %s (did you run `echopilot ca init`?): %v\n", dir, err)
+		os.Exit(1)
+	}
+	return ca, out, filepath.Base(name), time.Duration(days) * 24 * time.Hour
+}
+
+func saveIssued(issued *localca.Issued, out, name string) {
+	if err := issued.Save(out, name); err != nil {
+		fmt.Printf("Error saving certificate: %v\n", err)
+		os.Exit(1)
+	}
+
+	certFile, keyFile := localca.Files(out, name)
+	fmt.Printf("Wrote certificate to %s and key to %s (valid until %s)\n", certFile, keyFile, issued.Cert.NotAfter.Format(time.RFC3339))
+}
+
+func init() {
+	rootCmd.AddCommand(caCmd)
+	caCmd.AddCommand(caInitCmd)
+	caCmd.AddCommand(caServerCmd)
+	caCmd.AddCommand(caClientCmd)
+
+	caCmd.PersistentFlags().String("dir", "./etc/ca", "Directory holding the CA certificate and key")
+
+	caInitCmd.Flags().String("name", "echopilot development CA", "Common name of the CA")
+	caInitCmd.Flags().Int("days", 365, "How long the CA is valid for (in days)")
+	caInitCmd.Flags().Bool("force", false, "Replace an existing CA")
+	caInitCmd.Flags().Bool("encrypt", false, "Encrypt the CA key with a passphrase")
+
+	for _, c := range []*cobra.Command{caInitCmd, caServerCmd, caClientCmd} {
+		addPassphraseFlag(c)
+	}
+
+	for _, c := range []*cobra.Command{caServerCmd, caClientCmd} {
+		c.Flags().String("out", "", "Directory to write the certificate and key to (default is --dir)")
+		c.Flags().String("name", "", "Base name of the written files (default is 'server' or the client name)")
+		c.Flags().Int("days", 90, "How long the certificate is valid for (in days)")
+	}
+}
@@ -16,12 +16,18 @@ limitations under the License.
 package cmd
 
 import (
+	"context"
+	"crypto/tls"
 	"fmt"
 	"strings"
     "time"
     "os"
 
 	"github.com/brnsampson/echopilot/rpc/echo"
+	"github.com/brnsampson/echopilot/pkg/auth"
+	"github.com/brnsampson/echopilot/pkg/httpsig"
+	"github.com/brnsampson/echopilot/pkg/jwt"
+	"github.com/brnsampson/echopilot/pkg/signing"
 	"github.com/brnsampson/echopilot/pkg/option"
 	"github.com/spf13/cobra"
 )
@@ -61,9 +67,32 @@ func runClient(cmd *cobra.Command, args []string) {
         fmt.Printf("Error reading tlsSkipVerify flag: %v", err)
         os.Exit(1)
 	}
-    sv := option.NewOption(tlsSkipVerify)
 
-	client, err := echo.NewRemoteEchoClient(addr, t, sv)
+	// Sign requests and verify the signatures of responses if asked to (RFC 9421).
+	var signer *httpsig.Signer
+	if keyFile, _ := flags.GetString("signingKey"); keyFile != "" {
+		key, err := signing.ReadEncryptedPrivateKeyFile(keyFile, keyPassphrase(cmd))
+		if err != nil {
+			fmt.Printf("Error reading signing key: %v\n", err)
+			os.Exit(1)
+		}
+		signer = httpsig.NewSigner(httpsig.StaticKey{Private: key})
+	}
+
+	var verifier *httpsig.Verifier
+	if pubFile, _ := flags.GetString("serverPub"); pubFile != "" {
+		pub, err := signing.ReadPublicKeyFile(pubFile)
+		if err != nil {
+			fmt.Printf("Error reading server public key: %v\n", err)
+			os.Exit(1)
+		}
+		verifier = httpsig.NewVerifier(httpsig.StaticKey{Public: pub})
+	} else if jwksURL, _ := flags.GetString("serverJwks"); jwksURL != "" {
+		verifier = httpsig.NewVerifier(jwt.NewRemoteKeySet(jwksURL, nil))
+	}
+
+	tlsConf := tls.Config{InsecureSkipVerify: tlsSkipVerify}
+	client, err := echo.NewSignedRemoteEchoClient(addr, t, &tlsConf, signer, verifier)
 	if err != nil {
 		fmt.Printf("Error while creating client: %v", err)
         os.Exit(1)
@@ -71,7 +100,15 @@ func runClient(cmd *cobra.Command, args []string) {
 
     request := echo.NewStringRequest(strings.Join(args, " "))
 
-	result, err := client.EchoString(request)
+	// Authenticate with an API key or bearer token if given. See 'echopilot apikey'.
+	ctx := context.Background()
+	if apiKey, _ := flags.GetString("apiKey"); apiKey != "" {
+		ctx = auth.WithAPIKey(ctx, apiKey)
+	} else if token, _ := flags.GetString("token"); token != "" {
+		ctx = auth.WithBearerToken(ctx, token)
+	}
+
+	result, err := client.EchoStringContext(ctx, request)
 	if err != nil {
 		fmt.Printf("Error during client request: %v", err)
         os.Exit(1)
@@ -95,4 +132,10 @@ func init() {
 	clientCmd.Flags().String("addr", "127.0.0.1:8080", "Address of the echo server")
 	clientCmd.Flags().Int("timeout", 10, "Request timeout (in seconds)")
 	clientCmd.Flags().Bool("tlsSkipVerify", false, "Skip TLS verification when connecting to GRPC server. Useful when running server with self signed certs.")
+	clientCmd.Flags().String("signingKey", "", "Sign requests with this private key (RFC 9421 HTTP message signatures)")
+	clientCmd.Flags().String("serverPub", "", "Reject responses which are not signed by this public key")
+	clientCmd.Flags().String("serverJwks", "", "Reject responses which are not signed by a key published at this JWKS URL")
+	clientCmd.Flags().String("apiKey", os.Getenv("ECHOPILOT_API_KEY"), "Authenticate with this API key (also ECHOPILOT_API_KEY)")
+	clientCmd.Flags().String("token", os.Getenv("ECHOPILOT_TOKEN"), "Authenticate with this bearer token or JWT (also ECHOPILOT_TOKEN)")
+	addPassphraseFlag(clientCmd)
 }
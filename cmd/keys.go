@@ -0,0 +1,315 @@
+/*
+Copyright © 2019 NAME HERE <EMAIL ADDRESS>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+
+	"github.com/brnsampson/echopilot/pkg/signing"
+	"github.com/spf13/cobra"
+)
+
+// keysCmd represents the keys command
+var keysCmd = &cobra.Command{
+	Use:   "keys",
+	Short: "Generate and inspect signing keys.",
+	Long: `Generate, inspect and rotate the keys used by 'echopilot sign' and 'echopilot verify'.
+Key files are always written atomically with 0600 (private) and 0644 (public)
+permissions. With --encrypt, private keys are written as passphrase encrypted PKCS#8.
+The passphrase is read from --passphraseFile, ECHOPILOT_SIGNING_PASSPHRASE or a prompt.
+For example:
+
+  echopilot keys generate --alg ed25519 --encrypt --key etc/signing/key.pem --pub etc/signing/pub.pem
+  echopilot keys fingerprint etc/signing/pub.pem
+  echopilot keys rotate --key etc/signing/key.pem --pub etc/signing/pub.pem`,
+}
+
+var keysGenerateCmd = &cobra.Command{
+	Use:   "generate",
+	Short: "Generate a new key pair.",
+	Args:  cobra.NoArgs,
+	Run:   runKeysGenerate,
+}
+
+var keysInspectCmd = &cobra.Command{
+	Use:   "inspect FILE",
+	Short: "Show the algorithm, encoding and fingerprint of a private or public key file.",
+	Args:  cobra.ExactArgs(1),
+	Run:   runKeysInspect,
+}
+
+var keysFingerprintCmd = &cobra.Command{
+	Use:   "fingerprint FILE",
+	Short: "Print the SHA-256 fingerprint of a private or public key file.",
+	Args:  cobra.ExactArgs(1),
+	Run:   runKeysFingerprint,
+}
+
+var keysRotateCmd = &cobra.Command{
+	Use:   "rotate",
+	Short: "Replace a key pair, keeping the old pair next to it.",
+	Long: `Generate a new key pair in place of an existing one. The old files are kept as
+<file>.<key id> so signatures made with the old key can still be verified. If the old
+key was encrypted, the new key and the archived old key are encrypted with the same
+passphrase.`,
+	Args: cobra.NoArgs,
+	Run:  runKeysRotate,
+}
+
+var keysEncryptCmd = &cobra.Command{
+	Use:   "encrypt FILE",
+	Short: "Encrypt a plaintext private key file in place.",
+	Args:  cobra.ExactArgs(1),
+	Run:   runKeysEncrypt,
+}
+
+func runKeysGenerate(cmd *cobra.Command, args []string) {
+	flags := cmd.Flags()
+	keyFile, _ := flags.GetString("key")
+	pubFile, _ := flags.GetString("pub")
+	force, _ := flags.GetBool("force")
+	alg := keyAlgorithm(cmd)
+
+	key, err := signing.GenerateKey(alg)
+	if err != nil {
+		fmt.Printf("Error generating key: %v\n", err)
+		os.Exit(1)
+	}
+
+	var pass []byte
+	if encrypt, _ := flags.GetBool("encrypt"); encrypt {
+		pass = newPassphrase(cmd)
+	}
+	writeKeys(key, keyFile, pubFile, force, pass)
+	fmt.Printf("Wrote %s key %s to %s and %s\n", alg, key.Public().KeyID(), keyFile, pubFile)
+}
+
+func runKeysInspect(cmd *cobra.Command, args []string) {
+	block, pub, private := readAnyKey(cmd, args[0])
+
+	kind := "public"
+	if private {
+		kind = "private"
+	}
+	fmt.Printf("File:        %s\n", args[0])
+	fmt.Printf("Type:        %s key\n", kind)
+	fmt.Printf("Encoding:    %s\n", block)
+	fmt.Printf("Algorithm:   %s\n", pub.Algorithm())
+	fmt.Printf("Size:        %s\n", keySize(pub))
+	fmt.Printf("Key ID:      %s\n", pub.KeyID())
+	fmt.Printf("Fingerprint: %s\n", pub.Fingerprint())
+
+	if private {
+		if info, err := os.Stat(args[0]); err == nil && info.Mode().Perm() != signing.KeyFilePerms {
+			fmt.Printf("WARNING:     permissions are %o, expected %o\n", info.Mode().Perm(), signing.KeyFilePerms)
+		}
+		switch block {
+		case "ENCRYPTED PRIVATE KEY":
+		case "PRIVATE KEY":
+			fmt.Println("NOTE:        not encrypted, see 'echopilot keys encrypt'")
+		default:
+			fmt.Println("NOTE:        legacy encoding, regenerate or rotate to get PKCS#8")
+		}
+	}
+}
+
+func runKeysFingerprint(cmd *cobra.Command, args []string) {
+	_, pub, _ := readAnyKey(cmd, args[0])
+	fmt.Println(pub.Fingerprint())
+}
+
+func runKeysRotate(cmd *cobra.Command, args []string) {
+	flags := cmd.Flags()
+	keyFile, _ := flags.GetString("key")
+	pubFile, _ := flags.GetString("pub")
+	force, _ := flags.GetBool("force")
+
+	// Remember the passphrase of an encrypted key to reuse it for the new one.
+	var pass []byte
+	old, err := signing.ReadEncryptedPrivateKeyFile(keyFile, func() ([]byte, error) {
+		p, err := keyPassphrase(cmd)()
+		pass = p
+		return p, err
+	})
+	if err != nil {
+		fmt.Printf("Error reading current key: %v\n", err)
+		os.Exit(1)
+	}
+
+	alg := old.Algorithm()
+	if flags.Changed("alg") {
+		alg = keyAlgorithm(cmd)
+	}
+	key, err := signing.GenerateKey(alg)
+	if err != nil {
+		fmt.Printf("Error generating key: %v\n", err)
+		os.Exit(1)
+	}
+
+	if encrypt, _ := flags.GetBool("encrypt"); encrypt && pass == nil {
+		pass = newPassphrase(cmd)
+	}
+
+	// Keep the old pair around before anything is replaced.
+	oldID := old.Public().KeyID()
+	writeKeys(old, keyFile+"."+oldID, pubFile+"."+oldID, force, pass)
+	writeKeys(key, keyFile, pubFile, true, pass)
+	fmt.Printf("Rotated %s key %s to %s. The old key was kept as %s.%s\n", alg, oldID, key.Public().KeyID(), keyFile, oldID)
+}
+
+func runKeysEncrypt(cmd *cobra.Command, args []string) {
+	data, err := os.ReadFile(args[0])
+	if err != nil {
+		fmt.Printf("Error reading %s: %v\n", args[0], err)
+		os.Exit(1)
+	}
+	if signing.IsEncryptedPEM(data) {
+		fmt.Printf("%s is already encrypted\n", args[0])
+		os.Exit(1)
+	}
+
+	key, err := signing.ReadPrivateKeyFile(args[0])
+	if err != nil {
+		fmt.Printf("Error reading %s: %v\n", args[0], err)
+		os.Exit(1)
+	}
+
+	encrypted, err := key.MarshalEncryptedPEM(newPassphrase(cmd))
+	if err != nil {
+		fmt.Printf("Error encrypting key: %v\n", err)
+		os.Exit(1)
+	}
+	if err := signing.WriteFileAtomic(args[0], encrypted, signing.KeyFilePerms, true); err != nil {
+		fmt.Printf("Error writing %s: %v\n", args[0], err)
+		os.Exit(1)
+	}
+	fmt.Printf("Encrypted %s key %s in %s\n", key.Algorithm(), key.Public().KeyID(), args[0])
+}
+
+// newPassphrase asks for a passphrase to encrypt a key with, confirming it if prompted.
+func newPassphrase(cmd *cobra.Command) []byte {
+	pass, err := passphrase(cmd, "New passphrase: ", true)
+	if err != nil {
+		fmt.Printf("Error reading passphrase: %v\n", err)
+		os.Exit(1)
+	}
+	if len(pass) == 0 {
+		fmt.Println("Refusing to encrypt with an empty passphrase")
+		os.Exit(1)
+	}
+	return pass
+}
+
+func keyAlgorithm(cmd *cobra.Command) signing.Algorithm {
+	name, _ := cmd.Flags().GetString("alg")
+	alg, err := signing.ParseAlgorithm(name)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	return alg
+}
+
+// writeKeys writes a key pair, encrypting the private key if pass is set.
+func writeKeys(key *signing.PrivateKey, keyFile, pubFile string, force bool, pass []byte) {
+	var err error
+	if pass != nil {
+		err = signing.WriteEncryptedKeyFiles(key, keyFile, pubFile, pass, force)
+	} else {
+		err = signing.WriteKeyFiles(key, keyFile, pubFile, force)
+	}
+	if errors.Is(err, fs.ErrExist) {
+		fmt.Printf("Refusing to overwrite existing key files (%v). Use --force to replace them.\n", err)
+		os.Exit(1)
+	} else if err != nil {
+		fmt.Printf("Error writing keys: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// readAnyKey reads a private or public key file, returning the PEM block type and the
+// public key either way. Encrypted private keys are decrypted to get at the public key.
+func readAnyKey(cmd *cobra.Command, file string) (string, *signing.PublicKey, bool) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		fmt.Printf("Error reading %s: %v\n", file, err)
+		os.Exit(1)
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		fmt.Printf("Error reading %s: %v\n", file, signing.ErrNoPEMBlock)
+		os.Exit(1)
+	}
+
+	if signing.IsEncryptedPEM(data) {
+		key, err := signing.DecryptPrivateKeyPEM(data, keyPassphrase(cmd))
+		if err != nil {
+			fmt.Printf("Error reading %s: %v\n", file, err)
+			os.Exit(1)
+		}
+		return block.Type, key.Public(), true
+	}
+	if key, err := signing.ParsePrivateKeyPEM(data); err == nil {
+		return block.Type, key.Public(), true
+	}
+	pub, err := signing.ParsePublicKeyPEM(data)
+	if err != nil {
+		fmt.Printf("Error reading %s: %v\n", file, err)
+		os.Exit(1)
+	}
+	return block.Type, pub, false
+}
+
+func keySize(pub *signing.PublicKey) string {
+	switch k := pub.Key().(type) {
+	case *ecdsa.PublicKey:
+		return k.Curve.Params().Name
+	case *rsa.PublicKey:
+		return fmt.Sprintf("%d bits", k.N.BitLen())
+	case ed25519.PublicKey:
+		return "256 bits"
+	}
+	return "unknown"
+}
+
+func init() {
+	rootCmd.AddCommand(keysCmd)
+	keysCmd.AddCommand(keysGenerateCmd)
+	keysCmd.AddCommand(keysInspectCmd)
+	keysCmd.AddCommand(keysFingerprintCmd)
+	keysCmd.AddCommand(keysRotateCmd)
+	keysCmd.AddCommand(keysEncryptCmd)
+
+	for _, c := range []*cobra.Command{keysGenerateCmd, keysRotateCmd, keysInspectCmd, keysFingerprintCmd, keysEncryptCmd} {
+		addPassphraseFlag(c)
+	}
+
+	for _, c := range []*cobra.Command{keysGenerateCmd, keysRotateCmd} {
+		c.Flags().String("key", "./etc/signing/key.pem", "Private key file")
+		c.Flags().String("pub", "./etc/signing/pub.pem", "Public key file")
+		c.Flags().String("alg", "ecdsa-p384", "Key algorithm: ecdsa-p256, ecdsa-p384, ecdsa-p521, ed25519 or rsa")
+		c.Flags().Bool("force", false, "Overwrite existing files")
+		c.Flags().Bool("encrypt", false, "Encrypt the private key with a passphrase")
+	}
+}
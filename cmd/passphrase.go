@@ -0,0 +1,85 @@
+/*
+Copyright © 2019 NAME HERE <EMAIL ADDRESS>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/brnsampson/echopilot/pkg/signing"
+	"github.com/spf13/cobra"
+	"golang.org/x/term"
+)
+
+// Same variable as the server uses for its keyring.
+const passphraseEnv = "ECHOPILOT_SIGNING_PASSPHRASE"
+
+// keyPassphrase returns the passphrase source for reading keys. Nothing is asked for
+// unless a key turns out to be encrypted.
+func keyPassphrase(cmd *cobra.Command) signing.Passphrase {
+	return func() ([]byte, error) {
+		return passphrase(cmd, "Passphrase: ", false)
+	}
+}
+
+// passphrase reads a passphrase from --passphraseFile, then ECHOPILOT_SIGNING_PASSPHRASE,
+// and finally prompts for it on the terminal. When confirm is set the prompt asks twice.
+func passphrase(cmd *cobra.Command, prompt string, confirm bool) ([]byte, error) {
+	if file, _ := cmd.Flags().GetString("passphraseFile"); file != "" {
+		return signing.PassphraseFile(file)()
+	}
+	if pass, found := os.LookupEnv(passphraseEnv); found {
+		return []byte(pass), nil
+	}
+
+	// Prompt on the terminal itself so that stdin can still carry the data being signed.
+	tty, err := os.OpenFile("/dev/tty", os.O_RDWR, 0)
+	if err != nil {
+		if !term.IsTerminal(int(os.Stdin.Fd())) {
+			return nil, fmt.Errorf("%w: use --passphraseFile or %s", signing.ErrNoPassphrase, passphraseEnv)
+		}
+		tty = os.Stdin
+	} else {
+		defer tty.Close()
+	}
+
+	read := func(prompt string) ([]byte, error) {
+		fmt.Fprint(os.Stderr, prompt)
+		defer fmt.Fprintln(os.Stderr)
+		return term.ReadPassword(int(tty.Fd()))
+	}
+
+	pass, err := read(prompt)
+	if err != nil {
+		return nil, err
+	}
+	if confirm {
+		again, err := read("Repeat passphrase: ")
+		if err != nil {
+			return nil, err
+		}
+		if !bytes.Equal(pass, again) {
+			return nil, errors.New("passphrases do not match")
+		}
+	}
+	return pass, nil
+}
+
+func addPassphraseFlag(cmd *cobra.Command) {
+	cmd.Flags().String("passphraseFile", "", "Read the key passphrase from the first line of this file instead of "+passphraseEnv+" or a prompt")
+}
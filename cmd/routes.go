@@ -0,0 +1,79 @@
+/*
+Copyright © 2019 NAME HERE <EMAIL ADDRESS>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/brnsampson/echopilot/internal/appserver"
+	"github.com/spf13/cobra"
+)
+
+// routesCmd represents the routes command
+var routesCmd = &cobra.Command{
+	Use:   "routes",
+	Short: "List every route the server would expose.",
+	Long: `Assemble the server from the same flags, environment and config files as
+'echopilot serve' without starting it or loading any certificate or key, and list every route with its method, owning
+feature and middleware stack. Connect services are listed per procedure. A running
+server lists its routes at GET /admin/routes. For example:
+
+  echopilot routes --config etc/echopilot.json
+  echopilot routes --features echo --json`,
+	Args: cobra.NoArgs,
+	Run:  runRoutes,
+}
+
+func runRoutes(cmd *cobra.Command, args []string) {
+	// Listing routes needs no certificates or keys, so it works on a fresh checkout too.
+	srv, err := appserver.NewAppServerWithoutSecrets(cmd.Flags())
+	if err != nil {
+		fmt.Printf("Error assembling the server: %v\n", err)
+		os.Exit(1)
+	}
+	routes := srv.Routes()
+
+	if asJSON, _ := cmd.Flags().GetBool("json"); asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(routes); err != nil {
+			fmt.Printf("Error encoding routes: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "METHOD\tPATH\tFEATURE\tMIDDLEWARE")
+	for _, route := range routes {
+		feature := route.Feature
+		if feature == "" {
+			feature = "-"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", route.Method, route.Path, feature, strings.Join(route.Middleware, " > "))
+	}
+	w.Flush()
+}
+
+func init() {
+	rootCmd.AddCommand(routesCmd)
+	addServerFlags(routesCmd.Flags())
+	routesCmd.Flags().Bool("json", false, "Print the routes as JSON instead of a table")
+}
@@ -20,7 +20,9 @@ import (
 
 	// NOTE: if you fork this repo you will need to change this path.
 	"github.com/brnsampson/echopilot/internal/appserver"
+	"github.com/charmbracelet/log"
 	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
 )
 
 // serveCmd represents the serve command
@@ -39,6 +41,7 @@ func runServe(cmd *cobra.Command, args []string) {
 
     srv, err := appserver.NewAppServer(cmd.Flags())
     if err != nil {
+        log.Error("Could not start the server", "error", err)
         os.Exit(1)
     }
 	exitCode := srv.BlockingRun()
@@ -59,12 +62,27 @@ func init() {
 	// serveCmd.Flags().BoolP("toggle", "t", false, "Help message for toggle")
 
 	// NOTE: add any additional flags here.
-	serveCmd.Flags().String("config", "", "Location of a environment config file. All flags can be set via file.")
-	serveCmd.Flags().String("host", "localhost", "Address to bind GRPC server")
-	serveCmd.Flags().String("ip", "127.0.0.1", "Address to bind REST gateway for grpc server")
-	serveCmd.Flags().Int("port", 3000, "Address to bind REST gateway for grpc server")
-	serveCmd.Flags().String("tlsCert", "", "Location of server certificate for TLS")
-	serveCmd.Flags().String("tlsKey", "", "Location of server key for TLS")
-	serveCmd.Flags().Bool("tlsEnabled", true, "Enable tls")
-	serveCmd.Flags().Bool("tlsSkipVerify", false, "Skip TLS verification between REST proxy and GRPC server. Almost never needed.")
+	addServerFlags(serveCmd.Flags())
+}
+
+// addServerFlags adds the flags configuring the server, for every command which assembles it.
+func addServerFlags(flags *pflag.FlagSet) {
+	flags.StringSlice("config", nil, "Location of a environment config file. All flags can be set via file. May be repeated, later files take precedence.")
+	flags.String("profile", "", "Config profile (e.g. dev, staging, prod). Loads <config>.<profile>.json over each config file.")
+	flags.String("host", "localhost", "Address to bind GRPC server")
+	flags.String("ip", "127.0.0.1", "Address to bind REST gateway for grpc server")
+	flags.Int("port", 3000, "Address to bind REST gateway for grpc server")
+	flags.String("tlsCert", "", "Location of server certificate for TLS")
+	flags.String("tlsKey", "", "Location of server key for TLS")
+	flags.String("tlsClientCA", "", "Location of a CA bundle used to verify client certificates. Client certificates are optional if unset.")
+	flags.Bool("tlsEnabled", true, "Enable tls")
+	flags.Bool("tlsSkipVerify", false, "Skip TLS verification between REST proxy and GRPC server. Almost never needed.")
+	flags.Bool("dev", false, "Serve a certificate for localhost signed by an ephemeral development CA. Never use this in production.")
+	flags.String("devCertDir", "", "Persist the development CA and certificate in this directory so clients can trust them across restarts.")
+	flags.String("signingKeyring", "", "Directory holding the keyring.json manifest and signing keys. Reloaded with the rest of the config.")
+	flags.String("signingPassphraseFile", "", "File containing the passphrase for encrypted keys in the signing keyring. Use ECHOPILOT_SIGNING_PASSPHRASE to pass it directly.")
+	flags.String("httpSigClientKeyring", "", "Directory holding a keyring.json manifest with the public keys of clients which sign their RPC requests.")
+	flags.String("accessLogFormat", "", "Format of the access log: text, logfmt or json.")
+	flags.String("tracing", "", "Where to export traces: none, otlp, stdout or file.")
+	flags.StringSlice("features", nil, "Features to enable, e.g. --features echo,memory. Every registered feature is enabled if unset.")
 }
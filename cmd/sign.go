@@ -0,0 +1,171 @@
+/*
+Copyright © 2019 NAME HERE <EMAIL ADDRESS>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/brnsampson/echopilot/pkg/signing"
+	"github.com/spf13/cobra"
+)
+
+// signCmd represents the sign command
+var signCmd = &cobra.Command{
+	Use:   "sign [FILE]",
+	Short: "Create a detached signature over a file or stdin.",
+	Long: `Sign a file (or stdin if no file or - is given) with an ECDSA, Ed25519 or RSA
+private key and print the base64 encoded signature. Encrypted keys are decrypted with
+--passphraseFile, ECHOPILOT_SIGNING_PASSPHRASE or a prompt. For example:
+
+  echopilot sign --key etc/signing/key.pem release.tar.gz > release.tar.gz.sig
+  echopilot verify --pub etc/signing/pub.pem --sigFile release.tar.gz.sig release.tar.gz`,
+	Args: cobra.MaximumNArgs(1),
+	Run:  runSign,
+}
+
+// verifyCmd represents the verify command
+var verifyCmd = &cobra.Command{
+	Use:   "verify [FILE]",
+	Short: "Verify a detached signature over a file or stdin.",
+	Long: `Verify a base64 encoded signature created by 'echopilot sign' (or anything else
+using the same key and format) over a file, or stdin if no file or - is given.
+Exits with status 1 if the signature does not match.`,
+	Args: cobra.MaximumNArgs(1),
+	Run:  runVerify,
+}
+
+func runSign(cmd *cobra.Command, args []string) {
+	flags := cmd.Flags()
+	keyFile, _ := flags.GetString("key")
+	out, _ := flags.GetString("out")
+	format := signatureFormat(cmd)
+
+	key, err := signing.ReadEncryptedPrivateKeyFile(keyFile, keyPassphrase(cmd))
+	if err != nil {
+		fmt.Printf("Error reading signing key: %v\n", err)
+		os.Exit(1)
+	}
+
+	input := openInput(args)
+	defer input.Close()
+
+	sig, err := key.SignReader(input, format)
+	if err != nil {
+		fmt.Printf("Error signing: %v\n", err)
+		os.Exit(1)
+	}
+
+	encoded := signing.EncodeSignature(sig) + "\n"
+	if out == "" {
+		fmt.Print(encoded)
+		return
+	}
+	if err := os.WriteFile(out, []byte(encoded), signing.PubKeyFilePerms); err != nil {
+		fmt.Printf("Error writing signature: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func runVerify(cmd *cobra.Command, args []string) {
+	flags := cmd.Flags()
+	pubFile, _ := flags.GetString("pub")
+	encoded, _ := flags.GetString("sig")
+	sigFile, _ := flags.GetString("sigFile")
+	format := signatureFormat(cmd)
+
+	if (encoded == "") == (sigFile == "") {
+		fmt.Println("Exactly one of --sig or --sigFile is required")
+		os.Exit(1)
+	}
+	if sigFile != "" {
+		data, err := os.ReadFile(sigFile)
+		if err != nil {
+			fmt.Printf("Error reading signature: %v\n", err)
+			os.Exit(1)
+		}
+		encoded = string(data)
+	}
+
+	sig, err := signing.DecodeSignature(encoded)
+	if err != nil {
+		fmt.Printf("Signature is not valid base64: %v\n", err)
+		os.Exit(1)
+	}
+
+	pub, err := signing.ReadPublicKeyFile(pubFile)
+	if err != nil {
+		fmt.Printf("Error reading public key: %v\n", err)
+		os.Exit(1)
+	}
+
+	input := openInput(args)
+	defer input.Close()
+
+	err = pub.VerifyReader(input, sig, format)
+	if errors.Is(err, signing.ErrInvalidSignature) {
+		fmt.Println("Signature does NOT match")
+		os.Exit(1)
+	} else if err != nil {
+		fmt.Printf("Error verifying: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Println("Signature OK")
+}
+
+func signatureFormat(cmd *cobra.Command) signing.Format {
+	name, _ := cmd.Flags().GetString("format")
+	format, err := signing.ParseFormat(name)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	return format
+}
+
+func openInput(args []string) io.ReadCloser {
+	if len(args) == 0 || args[0] == "-" {
+		return io.NopCloser(os.Stdin)
+	}
+
+	f, err := os.Open(args[0])
+	if err != nil {
+		fmt.Printf("Error opening input: %v\n", err)
+		os.Exit(1)
+	}
+	return f
+}
+
+func init() {
+	rootCmd.AddCommand(signCmd)
+	rootCmd.AddCommand(verifyCmd)
+
+	signCmd.Flags().String("key", "", "Private key to sign with (must be 0600)")
+	signCmd.Flags().String("out", "", "Write the signature to this file instead of stdout")
+	signCmd.MarkFlagRequired("key")
+	addPassphraseFlag(signCmd)
+
+	verifyCmd.Flags().String("pub", "", "Public key to verify with")
+	verifyCmd.Flags().String("sig", "", "Base64 encoded signature")
+	verifyCmd.Flags().String("sigFile", "", "File containing the base64 encoded signature")
+	verifyCmd.MarkFlagRequired("pub")
+
+	for _, c := range []*cobra.Command{signCmd, verifyCmd} {
+		c.Flags().String("format", "asn1", "ECDSA signature format: asn1 (DER, openssl compatible) or raw (r||s, JOSE compatible). Ignored for other keys.")
+	}
+}
@@ -0,0 +1,10 @@
+package features
+
+// Every feature the server ships with. Features register themselves with pkg/router
+// when their package is imported, so this is the only place a new feature has to be
+// added. Whether a feature is constructed at all is up to the config.
+
+import (
+	_ "github.com/brnsampson/echopilot/features/memory"
+	_ "github.com/brnsampson/echopilot/rpc/echo"
+)
@@ -8,7 +8,7 @@ import (
 
 	pb "github.com/brnsampson/echopilot/proto/gen/echo/v1"
 	"github.com/brnsampson/echopilot/proto/gen/echo/v1/echov1connect"
-	"github.com/bufbuild/connect-go"
+	"connectrpc.com/connect"
     "github.com/brnsampson/echopilot/pkg/option"
 )
 
@@ -26,15 +26,16 @@ func (rh *MemoryResourceHandler) postMemory(w http.ResponseWriter, r *http.Reque
     if r.Form.Has("content") {
         content := r.Form.Get("content")
         m := records.NewMemory(content)
-        log.Infof("created memories %v", m)
-        rh.store.Create(m)
+        log.FromContext(r.Context()).Infof("created memories %v", m)
+        rh.store.Create(r.Context(), m)
     }
-    http.Redirect(w, r, "/memory", http.StatusSeeOther)
+    // Back to the list, which shares the path wherever the feature is mounted.
+    http.Redirect(w, r, r.URL.Path, http.StatusSeeOther)
 }
 
 func (rh *MemoryResourceHandler) listMemories(w http.ResponseWriter, r *http.Request) {
-    memories := rh.store.List(option.None[*records.Memory](), 0, 0)
-    log.Infof("current memories %v", memories)
+    memories := rh.store.List(r.Context(), option.None[*records.Memory](), 0, 0)
+    log.FromContext(r.Context()).Infof("current memories %v", memories)
 
     templates.Page(memories).Render(r.Context(), w)
 }
@@ -1,10 +1,12 @@
 package memory
 
 import (
-    "net/http"
     "github.com/brnsampson/echopilot/features/memory/internal/rest_handler"
     "github.com/brnsampson/echopilot/features/memory/records"
+    "github.com/brnsampson/echopilot/pkg/router"
     "github.com/charmbracelet/log"
+    "github.com/go-chi/chi/v5"
+    "github.com/prometheus/client_golang/prometheus"
 )
 
 // Interfaces for strategy pattern
@@ -17,18 +19,47 @@ type Store[T any] interface {
     Delete(T) (T, error)
 }
 
+func init() {
+    router.Register("memory", func(host router.Host) router.Feature {
+        return NewFeature(host)
+    })
+}
+
 // Service
-func NewFeature(logger *log.Logger) *Feature {
+func NewFeature(host router.Host) *Feature {
     memories := records.NewMemoryStore()
+    logger := host.Logger.With("package", "memory")
 
-	return &Feature{ logger.With("package", "memory"), memories}
+    if host.Metrics != nil {
+        err := host.Metrics.Register(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+            Namespace: "echopilot",
+            Subsystem: "memory",
+            Name: "store_memories",
+            Help: "Memories held by the memory store.",
+        }, func() float64 { return float64(memories.Len()) }))
+        if err != nil {
+            logger.Error("Registering memory store metrics failed", "error", err)
+        }
+    }
+
+	return &Feature{ logger: logger, store: memories}
 }
 
 type Feature struct{
+    router.Base
     logger *log.Logger
     store *records.MemoryStore
 }
 
-func (f *Feature) GetHandler() (string, http.Handler) {
-    return "/memory", rest_handler.NewRestHandler(f.store)
+func (f *Feature) Name() string {
+    return "memory"
+}
+
+// The default prefix. The UI and redirects follow wherever the feature is mounted.
+func (f *Feature) Prefix() string {
+    return "/memory"
+}
+
+func (f *Feature) Routes(r chi.Router) {
+    r.Mount("/", rest_handler.NewRestHandler(f.store))
 }
@@ -1,6 +1,24 @@
 package records
 
-import "github.com/brnsampson/echopilot/pkg/option"
+import (
+    "context"
+    "sync"
+
+    "github.com/brnsampson/echopilot/pkg/option"
+    "go.opentelemetry.io/otel"
+    "go.opentelemetry.io/otel/attribute"
+    "go.opentelemetry.io/otel/trace"
+)
+
+// Store operations are traced as children of the request they serve.
+const tracerName = "github.com/brnsampson/echopilot/features/memory/records"
+
+func startSpan(ctx context.Context, operation string) (context.Context, trace.Span) {
+    return otel.Tracer(tracerName).Start(ctx, "MemoryStore." + operation,
+        trace.WithSpanKind(trace.SpanKindInternal),
+        trace.WithAttributes(attribute.String("db.system", "memory"), attribute.String("db.operation", operation)),
+    )
+}
 
 func NewMemory(to_save string) *Memory {
     return &Memory { to_save }
@@ -15,20 +33,34 @@ func (m *Memory) Display() string {
 }
 
 type MemoryStore struct {
+    mu sync.RWMutex
     saved []*Memory
 }
 
 func NewMemoryStore() *MemoryStore {
     memories := make([]*Memory, 0)
 
-	return &MemoryStore { memories }
+	return &MemoryStore { saved: memories }
 }
 
-func (s *MemoryStore) Create(m *Memory) (*Memory, error) {
+func (s *MemoryStore) Create(ctx context.Context, m *Memory) (*Memory, error) {
+    _, span := startSpan(ctx, "Create")
+    defer span.End()
+
+    s.mu.Lock()
+    defer s.mu.Unlock()
     s.saved = append(s.saved, m)
+    span.SetAttributes(attribute.Int("memory.count", len(s.saved)))
     return m, nil
 }
 
+// Len is the number of memories in the store.
+func (s *MemoryStore) Len() int {
+    s.mu.RLock()
+    defer s.mu.RUnlock()
+    return len(s.saved)
+}
+
 //func (s *MemoryStore) Update(m *Memory) (*Memory, error) {
 //    return m, nil
 //}
@@ -41,8 +73,14 @@ func (s *MemoryStore) Create(m *Memory) (*Memory, error) {
 //    return m
 //}
 
-func (s *MemoryStore) List(m option.Option[*Memory], page, offset int) []*Memory {
+func (s *MemoryStore) List(ctx context.Context, m option.Option[*Memory], page, offset int) []*Memory {
+    _, span := startSpan(ctx, "List")
+    defer span.End()
+
     // TODO: filter results based on passed memory option
+    s.mu.RLock()
+    defer s.mu.RUnlock()
+    span.SetAttributes(attribute.Int("memory.count", len(s.saved)))
     return s.saved
 }
 
@@ -0,0 +1,225 @@
+package admin
+
+// Admin API for inspecting and changing a running server.
+//
+// Every endpoint requires the admin token as a bearer token. While no token is
+// configured the whole API answers 404, so it is effectively disabled by default.
+//
+//	GET   /config               effective config, secrets redacted
+//	PATCH /config[?persist=1]   validate and apply a partial config, then reload
+//	POST  /reload               reload, exactly like SIGHUP
+//	POST  /tokens               issue a JWT signed with the active keyring key
+//	POST  /tokens/verify        verify a JWT and return its claims
+//	GET   /routes               every route with its feature and middleware
+//	GET   /metrics              Prometheus metrics, also without a token at metricsPath
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/brnsampson/echopilot/pkg/config"
+	"github.com/brnsampson/echopilot/pkg/jwt"
+	"github.com/brnsampson/echopilot/pkg/requestid"
+	"github.com/brnsampson/echopilot/pkg/router"
+	"github.com/brnsampson/echopilot/pkg/signing"
+	"github.com/charmbracelet/log"
+	"github.com/go-chi/chi/v5"
+)
+
+// Patches are small JSON documents. Anything larger is a mistake or an attack.
+const maxPatchSize = 64 << 10
+
+type ConfigManager interface {
+	GetStaticConfig() config.StaticConfig
+	ApplyPatch(data []byte, persist bool) (config.StaticConfig, error)
+}
+
+type Reloader interface {
+	Reload()
+}
+
+type TokenService interface {
+	GetTokenIssuer() *jwt.Issuer
+	GetTokenVerifier() *jwt.Verifier
+}
+
+type RouteLister interface {
+	Routes() []router.Route
+}
+
+func NewHandler(logger *log.Logger, conf ConfigManager, reloader Reloader, tokens TokenService, routes RouteLister, metrics http.Handler) *Handler {
+	return &Handler{logger.With("package", "admin"), conf, reloader, tokens, routes, metrics}
+}
+
+type Handler struct {
+	logger   *log.Logger
+	conf     ConfigManager
+	reloader Reloader
+	tokens   TokenService
+	routes   RouteLister
+	metrics  http.Handler
+}
+
+// GetHandler returns the path the admin API is mounted at and its router.
+func (h *Handler) GetHandler() (string, http.Handler) {
+	r := chi.NewRouter()
+	r.Use(router.Named("adminToken", h.authenticate))
+	r.Get("/config", h.getConfig)
+	r.Patch("/config", h.patchConfig)
+	r.Post("/reload", h.reload)
+	r.Post("/tokens", h.issueToken)
+	r.Post("/tokens/verify", h.verifyToken)
+	r.Get("/routes", h.getRoutes)
+	r.Method("GET", "/metrics", h.metrics)
+	return "/admin", r
+}
+
+// loggerFor returns the logger for lines about r, which carry its request ID.
+func (h *Handler) loggerFor(r *http.Request) *log.Logger {
+	return requestid.Logger(r.Context(), h.logger)
+}
+
+func (h *Handler) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The token is reloadable, so look it up on every request.
+		token := h.conf.GetStaticConfig().AdminToken
+		if token == "" {
+			http.NotFound(w, r)
+			return
+		}
+
+		given, found := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !found || subtle.ConstantTimeCompare([]byte(given), []byte(token)) != 1 {
+			h.loggerFor(r).Warn("Rejected unauthenticated admin request", "path", r.URL.Path, "remote", r.RemoteAddr)
+			w.Header().Set("WWW-Authenticate", `Bearer realm="echopilot-admin"`)
+			writeError(w, http.StatusUnauthorized, errors.New("missing or invalid admin token"))
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (h *Handler) getConfig(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, h.conf.GetStaticConfig().Redacted())
+}
+
+func (h *Handler) patchConfig(w http.ResponseWriter, r *http.Request) {
+	persist := false
+	if p := r.URL.Query().Get("persist"); p != "" {
+		var err error
+		if persist, err = strconv.ParseBool(p); err != nil {
+			writeError(w, http.StatusBadRequest, errors.New("persist must be a boolean"))
+			return
+		}
+	}
+
+	data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxPatchSize))
+	if err != nil {
+		writeError(w, http.StatusRequestEntityTooLarge, err)
+		return
+	}
+
+	next, err := h.conf.ApplyPatch(data, persist)
+	if err != nil {
+		var invalid *config.ValidationError
+		if errors.As(err, &invalid) {
+			writeJSON(w, http.StatusUnprocessableEntity, map[string]interface{}{"error": "invalid config", "problems": invalid.Problems})
+			return
+		}
+		h.loggerFor(r).Error("Failed to apply config patch", "error", err)
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	h.loggerFor(r).Info("Config patched through admin API. Reloading...", "persist", persist, "remote", r.RemoteAddr)
+	h.reloader.Reload()
+	writeJSON(w, http.StatusAccepted, next.Redacted())
+}
+
+func (h *Handler) reload(w http.ResponseWriter, r *http.Request) {
+	h.loggerFor(r).Info("Reload requested through admin API", "remote", r.RemoteAddr)
+	h.reloader.Reload()
+	writeJSON(w, http.StatusAccepted, map[string]string{"status": "reloading"})
+}
+
+type tokenRequest struct {
+	Subject  string   `json:"sub"`
+	Audience []string `json:"aud"`
+	// Overrides jwtLifetimeMinutes.
+	TTLSeconds int                    `json:"ttlSeconds"`
+	Claims     map[string]interface{} `json:"claims"`
+}
+
+type tokenResponse struct {
+	Token  string     `json:"token"`
+	Claims jwt.Claims `json:"claims"`
+}
+
+func (h *Handler) issueToken(w http.ResponseWriter, r *http.Request) {
+	var req tokenRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxPatchSize)).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if req.TTLSeconds < 0 {
+		writeError(w, http.StatusBadRequest, errors.New("ttlSeconds must not be negative"))
+		return
+	}
+
+	claims := jwt.Claims{Subject: req.Subject, Audience: req.Audience, Extra: req.Claims}
+	if req.TTLSeconds > 0 {
+		claims.ExpiresAt = time.Now().Add(time.Duration(req.TTLSeconds) * time.Second)
+	}
+
+	token, claims, err := h.tokens.GetTokenIssuer().Issue(claims)
+	if errors.Is(err, signing.ErrNoActiveKey) {
+		writeError(w, http.StatusServiceUnavailable, err)
+		return
+	} else if err != nil {
+		h.loggerFor(r).Error("Failed to issue token", "error", err)
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	h.loggerFor(r).Info("Issued token through admin API", "sub", claims.Subject, "jti", claims.ID, "exp", claims.ExpiresAt, "remote", r.RemoteAddr)
+	writeJSON(w, http.StatusCreated, tokenResponse{token, claims})
+}
+
+func (h *Handler) verifyToken(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Token string `json:"token"`
+	}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxPatchSize)).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	claims, err := h.tokens.GetTokenVerifier().Verify(req.Token)
+	if err != nil {
+		writeError(w, http.StatusUnauthorized, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, tokenResponse{req.Token, claims})
+}
+
+func (h *Handler) getRoutes(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, h.routes.Routes())
+}
+
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
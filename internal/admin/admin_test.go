@@ -0,0 +1,166 @@
+package admin_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/brnsampson/echopilot/internal/admin"
+	"github.com/brnsampson/echopilot/pkg/config"
+	"github.com/brnsampson/echopilot/pkg/router"
+	"github.com/brnsampson/echopilot/pkg/signing"
+	"github.com/charmbracelet/log"
+	"github.com/go-chi/chi/v5"
+	"github.com/spf13/pflag"
+)
+
+// ok fails the test if an err is not nil.
+func ok(tb testing.TB, err error) {
+	if err != nil {
+		_, file, line, _ := runtime.Caller(1)
+		fmt.Printf("\033[31m%s:%d: unexpected error: %s\033[39m\n\n", filepath.Base(file), line, err.Error())
+		tb.FailNow()
+	}
+}
+
+// equals fails the test if exp is not equal to act.
+func equals(tb testing.TB, exp, act interface{}) {
+	if !reflect.DeepEqual(exp, act) {
+		_, file, line, _ := runtime.Caller(1)
+		fmt.Printf("\033[31m%s:%d:\n\n\texp: %#v\n\n\tgot: %#v\033[39m\n\n", filepath.Base(file), line, exp, act)
+		tb.FailNow()
+	}
+}
+
+type reloader struct {
+	reloads int
+}
+
+func (r *reloader) Reload() {
+	r.reloads++
+}
+
+// newAdmin serves the admin API for a server started with the given config file.
+func newAdmin(t *testing.T, fileContents string) (http.Handler, *reloader) {
+	file := filepath.Join(t.TempDir(), "echopilot.json")
+	ok(t, os.WriteFile(file, []byte(fileContents), 0640))
+	flags := pflag.NewFlagSet("test", pflag.ContinueOnError)
+	flags.StringSlice("config", []string{file}, "")
+	flags.Bool("tlsEnabled", false, "")
+	conf, err := config.NewServerConfig(flags, log.New(io.Discard))
+	ok(t, err)
+
+	reloads := &reloader{}
+	handler := admin.NewHandler(log.New(io.Discard), conf, reloads, conf, router.NewRouter(), http.NotFoundHandler())
+	mux := chi.NewRouter()
+	mux.Mount(handler.GetHandler())
+	return mux, reloads
+}
+
+func call(h http.Handler, method, path, token, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	return w
+}
+
+func decode(t *testing.T, w *httptest.ResponseRecorder, v interface{}) {
+	ok(t, json.Unmarshal(w.Body.Bytes(), v))
+}
+
+func TestDisabledWithoutToken(t *testing.T) {
+	h, _ := newAdmin(t, `{}`)
+	equals(t, http.StatusNotFound, call(h, "GET", "/admin/config", "", "").Code)
+	equals(t, http.StatusNotFound, call(h, "POST", "/admin/reload", "anything", "").Code)
+}
+
+func TestAuthenticate(t *testing.T) {
+	h, reloads := newAdmin(t, `{"adminToken": "secret"}`)
+
+	for _, token := range []string{"", "wrong", "secret2"} {
+		w := call(h, "POST", "/admin/reload", token, "")
+		equals(t, http.StatusUnauthorized, w.Code)
+		equals(t, `Bearer realm="echopilot-admin"`, w.Header().Get("WWW-Authenticate"))
+	}
+	equals(t, 0, reloads.reloads)
+
+	w := call(h, "GET", "/admin/config", "secret", "")
+	equals(t, http.StatusOK, w.Code)
+	var conf config.StaticConfig
+	decode(t, w, &conf)
+	equals(t, config.REDACTED, conf.AdminToken)
+
+	equals(t, http.StatusAccepted, call(h, "POST", "/admin/reload", "secret", "").Code)
+	equals(t, 1, reloads.reloads)
+}
+
+func TestPatchConfig(t *testing.T) {
+	h, reloads := newAdmin(t, `{"adminToken": "secret"}`)
+
+	w := call(h, "PATCH", "/admin/config", "secret", `{"serverPort": 0, "jwtLifetimeMinutes": 0}`)
+	equals(t, http.StatusUnprocessableEntity, w.Code)
+	var invalid struct {
+		Problems []string `json:"problems"`
+	}
+	decode(t, w, &invalid)
+	equals(t, 2, len(invalid.Problems))
+
+	equals(t, http.StatusUnprocessableEntity, call(h, "PATCH", "/admin/config", "secret", `{"noSuchField": 1}`).Code)
+	equals(t, http.StatusBadRequest, call(h, "PATCH", "/admin/config?persist=maybe", "secret", `{}`).Code)
+	equals(t, 0, reloads.reloads)
+
+	w = call(h, "PATCH", "/admin/config", "secret", `{"tlsExpiryWarnDays": 45}`)
+	equals(t, http.StatusAccepted, w.Code)
+	var next config.StaticConfig
+	decode(t, w, &next)
+	equals(t, 45, next.TlsExpiryWarnDays)
+	equals(t, 1, reloads.reloads)
+}
+
+func TestTokens(t *testing.T) {
+	// Without a signing keyring there is nothing to sign with.
+	h, _ := newAdmin(t, `{"adminToken": "secret"}`)
+	equals(t, http.StatusServiceUnavailable, call(h, "POST", "/admin/tokens", "secret", `{"sub": "alice"}`).Code)
+
+	key, err := signing.GenerateKey(signing.Ed25519)
+	ok(t, err)
+	dir := t.TempDir()
+	ok(t, signing.WriteKeyFiles(key, filepath.Join(dir, "key.pem"), filepath.Join(dir, "key.pub.pem"), true))
+	ok(t, os.WriteFile(filepath.Join(dir, signing.KeyringManifest), []byte(`{"keys": [{"file": "key.pem", "status": "active"}]}`), 0644))
+	h, _ = newAdmin(t, `{"adminToken": "secret", "signingKeyring": "`+dir+`"}`)
+
+	equals(t, http.StatusBadRequest, call(h, "POST", "/admin/tokens", "secret", `{"sub": "alice", "ttlSeconds": -1}`).Code)
+	w := call(h, "POST", "/admin/tokens", "secret", `{"sub": "alice", "ttlSeconds": 60, "claims": {"role": "ops"}}`)
+	equals(t, http.StatusCreated, w.Code)
+	var issued struct {
+		Token string `json:"token"`
+	}
+	decode(t, w, &issued)
+
+	w = call(h, "POST", "/admin/tokens/verify", "secret", `{"token": "`+issued.Token+`"}`)
+	equals(t, http.StatusOK, w.Code)
+	var verified struct {
+		Token  string                 `json:"token"`
+		Claims map[string]interface{} `json:"claims"`
+	}
+	decode(t, w, &verified)
+	equals(t, "alice", verified.Claims["sub"])
+	equals(t, "echopilot", verified.Claims["iss"])
+	equals(t, "ops", verified.Claims["role"])
+
+	tampered := issued.Token[:len(issued.Token)-4] + "AAAA"
+	equals(t, http.StatusUnauthorized, call(h, "POST", "/admin/tokens/verify", "secret", `{"token": "`+tampered+`"}`).Code)
+	// Verifying is part of the admin API too.
+	equals(t, http.StatusUnauthorized, call(h, "POST", "/admin/tokens/verify", "", `{"token": "`+issued.Token+`"}`).Code)
+}
@@ -13,63 +13,224 @@ package appserver
 // using the EchoConnectServer and generic SignaledServer pkg.
 
 import (
+    "context"
+    "expvar"
+    "fmt"
+    "net/http"
     "os"
-	"github.com/brnsampson/echopilot/rpc/echo"
-	"github.com/brnsampson/echopilot/features/memory"
+    "strings"
+    "time"
+
+	"connectrpc.com/connect"
+	"github.com/brnsampson/echopilot/internal/admin"
+	// Registers the features compiled into the server.
+	_ "github.com/brnsampson/echopilot/features"
 	"github.com/brnsampson/echopilot/pkg/server"
 	"github.com/brnsampson/echopilot/pkg/config"
+	"github.com/brnsampson/echopilot/pkg/health"
+	"github.com/brnsampson/echopilot/pkg/jwt"
+	"github.com/brnsampson/echopilot/pkg/metrics"
+	"github.com/brnsampson/echopilot/pkg/ratelimit"
+	"github.com/brnsampson/echopilot/pkg/requestid"
+	"github.com/brnsampson/echopilot/pkg/router"
+	"github.com/brnsampson/echopilot/pkg/tracing"
 	"github.com/spf13/pflag"
 
-    "github.com/go-chi/chi/v5"
     "github.com/go-chi/chi/v5/middleware"
     "github.com/charmbracelet/log"
 )
 
 // Struct used as primary entrypoint for an RPC based interface.
 func NewAppServer(flags *pflag.FlagSet) (*AppServer, error) {
-    logger := log.NewWithOptions(os.Stderr, log.Options{
+    logger := newLogger()
+    conf, err := config.NewServerConfig(flags, logger)
+    if err != nil {
+        return nil, err
+    }
+    return newAppServer(logger, conf)
+}
+
+// NewAppServerWithoutSecrets assembles the server like NewAppServer without loading any
+// certificate, keyring or keys file. It lists the routes the server would expose, but
+// cannot run.
+func NewAppServerWithoutSecrets(flags *pflag.FlagSet) (*AppServer, error) {
+    logger := newLogger()
+    conf, err := config.NewServerConfigWithoutSecrets(flags, logger)
+    if err != nil {
+        return nil, err
+    }
+    return newAppServer(logger, conf)
+}
+
+func newLogger() *log.Logger {
+    return log.NewWithOptions(os.Stderr, log.Options{
         ReportCaller: true,
         ReportTimestamp: true,
         Prefix: "echopilot",
         Level: log.DebugLevel,
     })
+}
+
+func newAppServer(logger *log.Logger, conf *config.ServerConfig) (*AppServer, error) {
+    checks := health.New()
+    checks.Register("tls", conf.GetCertMonitor())
 
-    conf, err := config.NewServerConfig(flags)
+    srv := server.NewServer(logger)
+
+    stats := metrics.New()
+    stats.WatchCertificates(conf.GetCertMonitor())
+    srv.OnTLSHandshakeError(stats.TLSHandshakeError)
+    srv.OnReload(stats.ObserveReload)
+
+    routes := router.NewRouter()
+    routes.Use(router.Named("requestID", func(next http.Handler) http.Handler {
+        return NewRequestIDHandler(next, conf, logger)
+    }))
+    routes.Use(router.Named("tracing", tracing.Middleware))
+    routes.Use(router.Named("accessLog", func(next http.Handler) http.Handler {
+        return NewLoggingHandler(next, conf, logger)
+    }))
+    routes.Use(router.Named("metrics", stats.Middleware))
+    // The admin API and metrics endpoint have their own rules.
+    adminHandler := admin.NewHandler(logger, conf, srv, conf, routes, stats.Handler())
+    adminPath, adminRoutes := adminHandler.GetHandler()
+    authExempt := []string{adminPath, conf.GetStaticConfig().MetricsPath}
+    // Middleware is set up per route, the buckets have to be shared. Requests are limited
+    // once authenticated, so that clients can be limited by who they authenticated as,
+    // and those failing authentication are limited by IP before it.
+    limiter := ratelimit.New()
+    routes.Use(router.Named("authFailureLimit", func(next http.Handler) http.Handler {
+        return NewAuthFailureLimitHandler(next, conf, limiter, routes)
+    }))
+    routes.Use(router.Named("authenticate", func(next http.Handler) http.Handler {
+        return NewAuthHandler(next, conf, routes, authExempt...)
+    }))
+    routes.Exempt("authenticate", authExempt...)
+    routes.Use(router.Named("rateLimit", func(next http.Handler) http.Handler {
+        return NewRateLimitHandler(next, conf, limiter, routes)
+    }))
+    routes.Use(middleware.Recoverer)
+    routes.Use(router.Named("signResponses", func(next http.Handler) http.Handler {
+        return NewResponseSigningHandler(next, conf, logger)
+    }))
+
+    mux := routes.Mux()
+    mux.Route("/", routeRoot(conf, routes))
+    mux.Method("GET", "/health", checks)
+    mux.Method("GET", "/debug/vars", expvar.Handler())
+    mux.Method("GET", jwt.JWKSPath, jwt.KeySetHandler(conf.GetKeyring()))
+    mux.Mount(adminPath, adminRoutes)
+    staticConf := conf.GetStaticConfig()
+    if staticConf.MetricsPath != "" {
+        if !strings.HasPrefix(staticConf.MetricsPath, "/") {
+            return nil, fmt.Errorf("metricsPath must start with /, not %q", staticConf.MetricsPath)
+        }
+        mux.Method("GET", staticConf.MetricsPath, stats.Handler())
+    }
+
+    host := router.Host{
+        Logger: logger,
+        Config: conf,
+        Authenticate: router.Named("verifySignatures", func(next http.Handler) http.Handler {
+            return NewSignatureAuthHandler(next, conf, logger)
+        }),
+        Interceptors: []connect.Interceptor{requestid.NewInterceptor(), tracing.Interceptor(), NewAccessLogInterceptor(), stats.Interceptor(), NewAuthInterceptor(conf)},
+        Metrics: stats.Registerer(),
+    }
+    // Disabled features are never constructed.
+    enabled, err := router.NewFeatures(host, staticConf.EnabledFeatures)
     if err != nil {
         return nil, err
     }
+    for name := range staticConf.FeaturePrefixes {
+        if !router.IsRegistered(name) {
+            return nil, fmt.Errorf("featurePrefixes: unknown feature %s", name)
+        }
+    }
+    for _, feature := range enabled {
+        prefix, found := staticConf.FeaturePrefixes[feature.Name()]
+        if !found {
+            prefix = feature.Prefix()
+        }
+        if err := routes.AddAt(feature, prefix); err != nil {
+            return nil, err
+        }
+        logger.Info("Mounted feature", "feature", feature.Name(), "path", prefix)
+    }
+    if len(enabled) == 0 {
+        logger.Warn("No features are enabled", "registered", router.Registered())
+    }
 
-    echoService := echo.NewService(logger)
-    memoryFeature := memory.NewFeature(logger)
-    //router := router.NewRouter()
-    router := chi.NewRouter()
-    router.Use(middleware.Logger)
-    router.Use(middleware.Recoverer)
-
-    router.Route("/", routeRoot)
-    router.Mount(memoryFeature.GetHandler())
-    router.Mount(echoService.GetHandler())
-    //router.AddHandlerFunc("/", serveEchoComponents)
+    if err := routes.Configure(staticConf.Features); err != nil {
+        return nil, err
+    }
+    conf.OnReload(func(config.ReloadEvent) {
+        if err := routes.Configure(conf.GetStaticConfig().Features); err != nil {
+            logger.Error("Reconfiguring features failed", "error", err)
+        }
+    })
 
 	return &AppServer{
-		router,
-		server.NewServer(logger),
+		routes,
+		srv,
 		logger,
 		conf,
+		checks,
 	}, nil
 }
 
 type AppServer struct {
-    router *chi.Mux
+    router *router.Router
 	server *server.Server
 	logger *log.Logger
 	config *config.ServerConfig
+	health *health.Health
+}
+
+// Routes lists what the server exposes. See pkg/router/routes.go.
+func (es *AppServer) Routes() []router.Route {
+	return es.router.Routes()
 }
 
+// How often certificates are re-checked for expiry between reloads.
+const certCheckInterval = time.Hour
+
+// How long buffered spans may take to export on shutdown.
+const tracingShutdownTimeout = 5 * time.Second
+
+// Run serves in the background. It goes through the same lifecycle as BlockingRun, so
+// features are halted and buffered spans flushed once the server stops.
 func (es *AppServer) Run() {
-	es.server.Run(es.router, es.config)
+	go es.BlockingRun()
 }
 
 func (es *AppServer) BlockingRun() int {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	shutdownTracing, err := tracing.Setup(ctx, es.config.GetStaticConfig())
+	if err != nil {
+		es.logger.Error("Setting up tracing failed", "error", err)
+		return 1
+	}
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), tracingShutdownTimeout)
+		defer cancel()
+		if err := shutdownTracing(ctx); err != nil {
+			es.logger.Error("Flushing traces failed", "error", err)
+		}
+	}()
+	go es.config.GetCertMonitor().Run(ctx, certCheckInterval)
+
+	if err := es.router.Run(ctx); err != nil {
+		es.logger.Error("Starting features failed", "error", err)
+		return 1
+	}
+	defer func() {
+		if err := es.router.Halt(); err != nil {
+			es.logger.Error("Halting features failed", "error", err)
+		}
+	}()
+
 	return es.server.BlockingRun(es.router, es.config)
 }
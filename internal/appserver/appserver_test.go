@@ -1,11 +1,23 @@
 package appserver_test
 
 import (
+	"context"
 	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
 	"path/filepath"
 	"reflect"
 	"runtime"
+	"strconv"
+	"syscall"
 	"testing"
+	"time"
+
+	"github.com/brnsampson/echopilot/internal/appserver"
+	"github.com/spf13/pflag"
 )
 
 // assert fails the test if the condition is false.
@@ -75,3 +87,64 @@ func (t *testLogger) Sync() error {
 	fmt.Println("test logger sync'd")
 	return nil
 }
+
+// clientAt makes requests from the given loopback address, so that the server sees
+// different clients. It does not follow redirects.
+func clientAt(ip string) *http.Client {
+	dialer := &net.Dialer{LocalAddr: &net.TCPAddr{IP: net.ParseIP(ip)}}
+	return &http.Client{
+		Transport: &http.Transport{DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			return dialer.DialContext(ctx, network, addr)
+		}},
+		CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+	}
+}
+
+func TestUIRateLimit(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	ok(t, err)
+	port := l.Addr().(*net.TCPAddr).Port
+	l.Close()
+
+	file := filepath.Join(t.TempDir(), "echopilot.json")
+	ok(t, os.WriteFile(file, []byte(`{
+		"bindHost": "127.0.0.1",
+		"serverPort": `+strconv.Itoa(port)+`,
+		"enabledFeatures": ["echo"],
+		"rateLimits": {"*": {"rate": 0.001, "burst": 1}}
+	}`), 0640))
+	flags := pflag.NewFlagSet("test", pflag.ContinueOnError)
+	flags.StringSlice("config", []string{file}, "")
+	flags.Bool("tlsEnabled", false, "")
+	srv, err := appserver.NewAppServer(flags)
+	ok(t, err)
+	exited := make(chan int)
+	go func() { exited <- srv.BlockingRun() }()
+
+	base := "http://127.0.0.1:" + strconv.Itoa(port)
+	post := func(client *http.Client) *http.Response {
+		var res *http.Response
+		var err error
+		for i := 0; i < 50; i++ {
+			if res, err = client.PostForm(base+"/echo", url.Values{"content": {"hi"}}); err == nil {
+				break
+			}
+			time.Sleep(20 * time.Millisecond)
+		}
+		ok(t, err)
+		io.Copy(io.Discard, res.Body)
+		res.Body.Close()
+		return res
+	}
+
+	// Each user has a bucket of their own, the server's calls to itself do not count.
+	alice, bob := clientAt("127.0.0.2"), clientAt("127.0.0.3")
+	equals(t, http.StatusSeeOther, post(alice).StatusCode)
+	equals(t, http.StatusSeeOther, post(bob).StatusCode)
+	res := post(alice)
+	equals(t, http.StatusTooManyRequests, res.StatusCode)
+	assert(t, res.Header.Get("Retry-After") != "", "expected a Retry-After header")
+
+	ok(t, syscall.Kill(syscall.Getpid(), syscall.SIGTERM))
+	equals(t, 0, <-exited)
+}
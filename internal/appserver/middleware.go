@@ -1,9 +1,29 @@
 package appserver
 
 import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"net"
 	"net/http"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
+
+	"connectrpc.com/connect"
+	"github.com/brnsampson/echopilot/pkg/auth"
+	"github.com/brnsampson/echopilot/pkg/config"
+	"github.com/brnsampson/echopilot/pkg/httpsig"
+	"github.com/brnsampson/echopilot/pkg/ratelimit"
+	"github.com/brnsampson/echopilot/pkg/requestid"
+	"github.com/brnsampson/echopilot/pkg/router"
     "github.com/charmbracelet/log"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // Helper structs for pilfering information from the wrapped handlers
@@ -14,34 +34,662 @@ type responseSpy struct {
 	bytesWritten int64
 }
 
-func (s responseSpy) Write(buf []byte) (int, error) {
-	written, e := s.ResponseWriter.Write(buf)
-	if e != nil {
-		return written, e
+func (s *responseSpy) Write(buf []byte) (int, error) {
+	if s.statusCode == 0 {
+		s.statusCode = http.StatusOK
 	}
+	written, e := s.ResponseWriter.Write(buf)
 	s.bytesWritten += int64(written)
 	return written, e
 }
 
-func (s responseSpy) WriteHeader(statusCode int) {
-	s.statusCode = statusCode
+func (s *responseSpy) WriteHeader(statusCode int) {
+	if s.statusCode == 0 {
+		s.statusCode = statusCode
+	}
 	s.ResponseWriter.WriteHeader(statusCode)
 }
 
-// Middleware struct for adding logging to requests
-func NewLoggingHandler(toWrap http.Handler, logger *log.Logger) *loggingHandler {
-	return &loggingHandler{toWrap, logger}
+// Flush keeps streaming responses, e.g. Connect server streams, working through the spy.
+func (s *responseSpy) Flush() {
+	if f, ok := s.ResponseWriter.(http.Flusher); ok {
+		if s.statusCode == 0 {
+			s.statusCode = http.StatusOK
+		}
+		f.Flush()
+	}
+}
+
+// Unwrap lets http.ResponseController reach the original writer.
+func (s *responseSpy) Unwrap() http.ResponseWriter {
+	return s.ResponseWriter
+}
+
+type bodySpy struct {
+	io.ReadCloser
+	bytesRead int64
+}
+
+func (b *bodySpy) Read(buf []byte) (int, error) {
+	read, e := b.ReadCloser.Read(buf)
+	b.bytesRead += int64(read)
+	return read, e
+}
+
+// Connect handlers report the procedure and code of a call here, since neither can be
+// told reliably from the HTTP response. See accessLogInterceptor.
+type accessRecord struct {
+	procedure string
+	code      string
+	// Set by the auth middleware.
+	principal string
+}
+
+type accessRecordKey struct{}
+
+// Middleware assigning every request an ID. IDs sent by peers in requestIdTrustedNets are
+// kept, anyone else could use them to mix their requests into someone else's logs. The ID
+// is returned in the X-Request-ID response header and the context carries both the ID and
+// a logger adding it to every line, see requestid.Logger and log.FromContext.
+func NewRequestIDHandler(toWrap http.Handler, conf *config.ServerConfig, logger *log.Logger) *requestIDHandler {
+	return &requestIDHandler{toWrap, conf, logger}
+}
+
+type requestIDHandler struct {
+	wrappedHandler http.Handler
+	conf           *config.ServerConfig
+	logger         *log.Logger
+}
+
+func (h *requestIDHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	id, found := "", false
+	if trustedPeer(h.conf.GetRequestIDTrustedNets(), r.RemoteAddr) {
+		id, found = requestid.FromRequest(r)
+	}
+	if !found {
+		id = requestid.New()
+	}
+
+	ctx := requestid.WithID(r.Context(), id)
+	ctx = log.WithContext(ctx, requestid.Logger(ctx, h.logger))
+	r = r.WithContext(ctx)
+	// Handlers reading the header directly see the ID actually in use.
+	r.Header.Set(requestid.Header, id)
+	w.Header().Set(requestid.Header, id)
+	h.wrappedHandler.ServeHTTP(w, r)
+}
+
+// trustedPeer reports whether the IP of remoteAddr is in one of the networks.
+func trustedPeer(nets []*net.IPNet, remoteAddr string) bool {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+	for _, network := range nets {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// Middleware enforcing the rateLimits config. A request counts against the limit of its
+// Connect procedure if there is one, else that of its route pattern, else *. Connect and
+// gRPC clients are refused with ResourceExhausted, anyone else with 429 Too Many Requests,
+// both with a Retry-After header. The limiter is shared by every route and keeps its
+// buckets across reloads. Limits by apiKey need the handler to run behind NewAuthHandler,
+// which also lets it pass the server's calls to itself.
+func NewRateLimitHandler(toWrap http.Handler, conf *config.ServerConfig, limiter *ratelimit.Limiter, routes *router.Router) *rateLimitHandler {
+	return &rateLimitHandler{toWrap, conf, limiter, routes, connect.NewErrorWriter()}
+}
+
+type rateLimitHandler struct {
+	wrappedHandler http.Handler
+	conf           *config.ServerConfig
+	limiter        *ratelimit.Limiter
+	routes         *router.Router
+	errorWriter    *connect.ErrorWriter
+}
+
+func (h *rateLimitHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	limits := h.conf.GetStaticConfig().RateLimits
+	// The UI calls back into the server on behalf of its users, who were limited on their
+	// way in already. Counting the calls again would make every user share a bucket.
+	principal, _ := auth.PrincipalFrom(r.Context())
+	if len(limits) == 0 || principal.IsLoopback() {
+		h.wrappedHandler.ServeHTTP(w, r)
+		return
+	}
+
+	name, limit, found := matchLimit(limits, h.routes, h.errorWriter, r)
+	if !found {
+		h.wrappedHandler.ServeHTTP(w, r)
+		return
+	}
+
+	allowed, retry := h.limiter.Allow(name, ratelimit.Limit{Rate: limit.Rate, Burst: limit.Burst}, ratelimit.Client(r, limit.By))
+	if allowed {
+		h.wrappedHandler.ServeHTTP(w, r)
+		return
+	}
+	refuseRateLimited(w, r, h.errorWriter, retry)
+}
+
+// matchLimit finds the limit r counts against: that of its Connect procedure if there is
+// one, else that of its route pattern, else *.
+func matchLimit(limits map[string]config.RateLimit, routes *router.Router, errorWriter *connect.ErrorWriter, r *http.Request) (string, config.RateLimit, bool) {
+	name, limit, found := "", config.RateLimit{}, false
+	if errorWriter.IsSupported(r) {
+		name = procedure(r.URL.Path)
+		limit, found = limits[name]
+	}
+	if !found {
+		name = routes.Match(r.Method, r.URL.Path)
+		limit, found = limits[name]
+	}
+	if !found {
+		name = "*"
+		limit, found = limits[name]
+	}
+	return name, limit, found
+}
+
+func refuseRateLimited(w http.ResponseWriter, r *http.Request, errorWriter *connect.ErrorWriter, retry time.Duration) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retry.Seconds()))))
+	if errorWriter.IsSupported(r) {
+		errorWriter.Write(w, r, connect.NewError(connect.CodeResourceExhausted, errors.New("rate limit exceeded")))
+		return
+	}
+	http.Error(w, "rate limit exceeded", http.StatusTooManyRequests)
+}
+
+// Middleware in front of NewAuthHandler charging requests which fail authentication to
+// their IP, under the limit they would count against once authenticated. An IP which
+// used that up is refused before its credentials are looked at again, so that making up
+// keys, tokens or signatures stays as limited as anything else. Clients which
+// authenticate are counted by NewRateLimitHandler instead.
+func NewAuthFailureLimitHandler(toWrap http.Handler, conf *config.ServerConfig, limiter *ratelimit.Limiter, routes *router.Router) *authFailureLimitHandler {
+	return &authFailureLimitHandler{toWrap, conf, limiter, routes, connect.NewErrorWriter()}
+}
+
+type authFailureLimitHandler struct {
+	wrappedHandler http.Handler
+	conf           *config.ServerConfig
+	limiter        *ratelimit.Limiter
+	routes         *router.Router
+	errorWriter    *connect.ErrorWriter
+}
+
+// The auth middleware marks requests it rejects here.
+type authAttempt struct {
+	failed bool
+}
+
+type authAttemptKey struct{}
+
+func (h *authFailureLimitHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	name, configured, found := matchLimit(h.conf.GetStaticConfig().RateLimits, h.routes, h.errorWriter, r)
+	if !found {
+		h.wrappedHandler.ServeHTTP(w, r)
+		return
+	}
+	limit := ratelimit.Limit{Rate: configured.Rate, Burst: configured.Burst}
+
+	// Failures have a bucket of their own, so that an IP's anonymous requests do not
+	// lock out the clients authenticating from it.
+	client := "authFailed/" + ratelimit.Client(r, "ip")
+	if allowed, retry := h.limiter.Check(name, limit, client); !allowed {
+		refuseRateLimited(w, r, h.errorWriter, retry)
+		return
+	}
+	attempt := &authAttempt{}
+	h.wrappedHandler.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), authAttemptKey{}, attempt)))
+	if attempt.failed {
+		h.limiter.Allow(name, limit, client)
+	}
+}
+
+// procedure is the Connect procedure a path calls, e.g. echo.v1.EchoService/EchoString for
+// /api/echo.v1.EchoService/EchoString.
+func procedure(path string) string {
+	parts := strings.Split(strings.TrimSuffix(path, "/"), "/")
+	if len(parts) < 2 {
+		return ""
+	}
+	return parts[len(parts)-2] + "/" + parts[len(parts)-1]
+}
+
+// Middleware authenticating clients by API key or bearer token, see pkg/auth. Requests
+// without either which are signed with a key from the client keyring are authenticated as
+// its key ID. Requests with credentials or a signature which are not accepted are always
+// rejected. Requests without any are only rejected with authRequired, and not on
+// authPublicRoutes or below the exempt paths, e.g. the admin API which checks its own
+// token. The principal is put in the context and added to every line logged for the
+// request.
+func NewAuthHandler(toWrap http.Handler, conf *config.ServerConfig, routes *router.Router, exempt ...string) *authHandler {
+	paths := make([]string, 0, len(exempt))
+	for _, path := range exempt {
+		if path != "" {
+			paths = append(paths, path)
+		}
+	}
+	return &authHandler{toWrap, conf, routes, paths, httpsig.NewVerifier(conf.GetClientKeyring()), connect.NewErrorWriter()}
+}
+
+type authHandler struct {
+	wrappedHandler http.Handler
+	conf           *config.ServerConfig
+	routes         *router.Router
+	exempt         []string
+	verifier       *httpsig.Verifier
+	errorWriter    *connect.ErrorWriter
+}
+
+func (h *authHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	for _, path := range h.exempt {
+		if underPath(r.URL.Path, path) {
+			h.wrappedHandler.ServeHTTP(w, r)
+			return
+		}
+	}
+
+	principal, err := h.conf.GetAuthenticator().Authenticate(r.Header)
+	if errors.Is(err, auth.ErrNoCredentials) && signed(r) && h.conf.GetStaticConfig().HttpSigClientKeyring != "" {
+		body, readErr := io.ReadAll(http.MaxBytesReader(w, r.Body, httpsig.MaxBodySize))
+		if readErr != nil {
+			h.failed(r)
+			http.Error(w, readErr.Error(), http.StatusRequestEntityTooLarge)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		keyid, sigErr := h.verifier.VerifyRequest(r, body)
+		if sigErr == nil {
+			principal, err = auth.Principal{Name: keyid, Method: auth.MethodSignature}, nil
+			r = r.WithContext(httpsig.WithKeyID(r.Context(), keyid))
+		} else {
+			err = fmt.Errorf("%w: invalid request signature: %v", auth.ErrInvalidCredentials, sigErr)
+		}
+	}
+	if err == nil {
+		if record, ok := r.Context().Value(accessRecordKey{}).(*accessRecord); ok {
+			record.principal = principal.Name
+		}
+		ctx := auth.WithPrincipal(r.Context(), principal)
+		ctx = log.WithContext(ctx, log.FromContext(ctx).With("principal", principal.Name))
+		h.wrappedHandler.ServeHTTP(w, r.WithContext(ctx))
+		return
+	}
+
+	conf := h.conf.GetStaticConfig()
+	if errors.Is(err, auth.ErrNoCredentials) && (!conf.AuthRequired || h.public(conf.AuthPublicRoutes, r)) {
+		h.wrappedHandler.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), authCheckedKey{}, true)))
+		return
+	}
+
+	h.failed(r)
+	log.FromContext(r.Context()).Warn("Rejected unauthenticated request", "path", r.URL.Path, "remote", r.RemoteAddr, "error", err)
+	w.Header().Set("WWW-Authenticate", `Bearer realm="echopilot"`)
+	if h.errorWriter.IsSupported(r) {
+		h.errorWriter.Write(w, r, connect.NewError(connect.CodeUnauthenticated, err))
+		return
+	}
+	http.Error(w, err.Error(), http.StatusUnauthorized)
+}
+
+// failed tells NewAuthFailureLimitHandler, if it is in front, that r was rejected.
+func (h *authHandler) failed(r *http.Request) {
+	if attempt, ok := r.Context().Value(authAttemptKey{}).(*authAttempt); ok {
+		attempt.failed = true
+	}
+}
+
+// public reports whether r is for one of the public routes: its route pattern, a path
+// it is below or its Connect procedure. / only makes the index page public.
+func (h *authHandler) public(public []string, r *http.Request) bool {
+	pattern := h.routes.Match(r.Method, r.URL.Path)
+	called := ""
+	if h.errorWriter.IsSupported(r) {
+		called = procedure(r.URL.Path)
+	}
+	for _, route := range public {
+		switch {
+		case route == pattern, route == called:
+			return true
+		case route != "/" && strings.HasPrefix(route, "/") && underPath(r.URL.Path, route):
+			return true
+		}
+	}
+	return false
+}
+
+// Marks requests the auth middleware let through without credentials.
+type authCheckedKey struct{}
+
+// Connect interceptor doing what NewAuthHandler does for handlers served without it. Calls
+// the middleware has already checked are passed through.
+func NewAuthInterceptor(conf *config.ServerConfig) connect.Interceptor {
+	return authInterceptor{conf}
+}
+
+type authInterceptor struct {
+	conf *config.ServerConfig
+}
+
+// authenticate returns the context to handle a call with, or why it is rejected.
+func (a authInterceptor) authenticate(ctx context.Context, procedure string, header http.Header) (context.Context, error) {
+	if _, found := auth.PrincipalFrom(ctx); found || ctx.Value(authCheckedKey{}) != nil {
+		return ctx, nil
+	}
+	principal, err := a.conf.GetAuthenticator().Authenticate(header)
+	if keyid, verified := httpsig.KeyID(ctx); errors.Is(err, auth.ErrNoCredentials) && verified {
+		principal, err = auth.Principal{Name: keyid, Method: auth.MethodSignature}, nil
+	}
+	if err == nil {
+		return auth.WithPrincipal(ctx, principal), nil
+	}
+	if !errors.Is(err, auth.ErrNoCredentials) {
+		return ctx, connect.NewError(connect.CodeUnauthenticated, err)
+	}
+
+	conf := a.conf.GetStaticConfig()
+	if !conf.AuthRequired {
+		return ctx, nil
+	}
+	for _, route := range conf.AuthPublicRoutes {
+		if route == strings.TrimPrefix(procedure, "/") {
+			return ctx, nil
+		}
+	}
+	return ctx, connect.NewError(connect.CodeUnauthenticated, err)
+}
+
+func (a authInterceptor) WrapUnary(next connect.UnaryFunc) connect.UnaryFunc {
+	return func(ctx context.Context, req connect.AnyRequest) (connect.AnyResponse, error) {
+		if req.Spec().IsClient {
+			return next(ctx, req)
+		}
+		ctx, err := a.authenticate(ctx, req.Spec().Procedure, req.Header())
+		if err != nil {
+			return nil, err
+		}
+		return next(ctx, req)
+	}
+}
+
+func (a authInterceptor) WrapStreamingClient(next connect.StreamingClientFunc) connect.StreamingClientFunc {
+	return next
+}
+
+func (a authInterceptor) WrapStreamingHandler(next connect.StreamingHandlerFunc) connect.StreamingHandlerFunc {
+	return func(ctx context.Context, conn connect.StreamingHandlerConn) error {
+		ctx, err := a.authenticate(ctx, conn.Spec().Procedure, conn.RequestHeader())
+		if err != nil {
+			return err
+		}
+		return next(ctx, conn)
+	}
+}
+
+// Middleware writing one access log line per request. The format, exclusions and sampling
+// come from the accessLog* config fields and follow reloads.
+func NewLoggingHandler(toWrap http.Handler, conf *config.ServerConfig, logger *log.Logger) *loggingHandler {
+	// A line per request is enough, the caller would always be this file.
+	access := logger.WithPrefix("access")
+	access.SetReportCaller(false)
+	return &loggingHandler{wrappedHandler: toWrap, conf: conf, logger: access}
 }
 
 type loggingHandler struct {
 	wrappedHandler http.Handler
+	conf           *config.ServerConfig
 	logger         *log.Logger
+
+	mu     sync.Mutex
+	format string
+}
+
+// setFormat switches the formatter when the configured format changed.
+func (l *loggingHandler) setFormat(format string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if format == l.format {
+		return
+	}
+	l.format = format
+	switch format {
+	case "logfmt":
+		l.logger.SetFormatter(log.LogfmtFormatter)
+	case "json":
+		l.logger.SetFormatter(log.JSONFormatter)
+	default:
+		l.logger.SetFormatter(log.TextFormatter)
+	}
 }
 
 func (l *loggingHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	spy := responseSpy{w, 0, 0}
+	conf := l.conf.GetStaticConfig()
+	l.setFormat(conf.AccessLogFormat)
+	for _, prefix := range conf.AccessLogExclude {
+		if underPath(r.URL.Path, prefix) {
+			l.wrappedHandler.ServeHTTP(w, r)
+			return
+		}
+	}
+
+	spy := &responseSpy{ResponseWriter: w}
+	body := &bodySpy{ReadCloser: r.Body}
+	if r.Body != nil {
+		r.Body = body
+	}
+	record := &accessRecord{}
+	r = r.WithContext(context.WithValue(r.Context(), accessRecordKey{}, record))
+
 	begin := time.Now()
 	l.wrappedHandler.ServeHTTP(spy, r)
-	l.logger.Infof("Status Code %d for %s at %s in %+v", spy.statusCode, r.Method, r.URL.Path, time.Since(begin))
-	l.logger.Debugf("Replying to %s request with: %s", r.URL.Path)
+	latency := time.Since(begin)
+
+	if spy.statusCode == 0 {
+		spy.statusCode = http.StatusOK
+	}
+	// Sampling never drops server errors.
+	if spy.statusCode < 500 && rand.Float64() >= sampleRate(conf.AccessLogSample, r.URL.Path) {
+		return
+	}
+
+	keyvals := []interface{}{
+		"method", r.Method,
+		"path", r.URL.Path,
+		"route", router.RoutePattern(r),
+	}
+	if record.procedure != "" {
+		keyvals = append(keyvals, "procedure", record.procedure, "code", record.code)
+	}
+	if record.principal != "" {
+		keyvals = append(keyvals, "principal", record.principal)
+	}
+	keyvals = append(keyvals,
+		"status", spy.statusCode,
+		"bytes_in", body.bytesRead,
+		"bytes_out", spy.bytesWritten,
+		"latency", latency,
+		"peer", r.RemoteAddr,
+		"user_agent", r.UserAgent(),
+	)
+	if id, found := requestid.ID(r.Context()); found {
+		keyvals = append(keyvals, "request_id", id)
+	}
+	if span := trace.SpanContextFromContext(r.Context()); span.IsValid() {
+		keyvals = append(keyvals, "trace_id", span.TraceID().String())
+	}
+	if spy.statusCode >= 500 {
+		l.logger.Error("request", keyvals...)
+	} else {
+		l.logger.Info("request", keyvals...)
+	}
+}
+
+// underPath reports whether path is prefix or below it, e.g. /health/tls for /health.
+func underPath(path, prefix string) bool {
+	prefix = strings.TrimSuffix(prefix, "/")
+	return path == prefix || strings.HasPrefix(path, prefix+"/") || prefix == ""
+}
+
+// sampleRate is the rate of the longest path in rates which path is under, or 1.
+func sampleRate(rates map[string]float64, path string) float64 {
+	rate, longest := 1.0, -1
+	for prefix, r := range rates {
+		if underPath(path, prefix) && len(prefix) > longest {
+			rate, longest = r, len(prefix)
+		}
+	}
+	return rate
+}
+
+// Connect interceptor reporting the procedure and code of every call handled to the
+// access log.
+func NewAccessLogInterceptor() connect.Interceptor {
+	return accessLogInterceptor{}
+}
+
+type accessLogInterceptor struct{}
+
+func (accessLogInterceptor) WrapUnary(next connect.UnaryFunc) connect.UnaryFunc {
+	return func(ctx context.Context, req connect.AnyRequest) (connect.AnyResponse, error) {
+		res, err := next(ctx, req)
+		recordCall(ctx, req.Spec().Procedure, err)
+		return res, err
+	}
+}
+
+func (accessLogInterceptor) WrapStreamingClient(next connect.StreamingClientFunc) connect.StreamingClientFunc {
+	return next
+}
+
+func (accessLogInterceptor) WrapStreamingHandler(next connect.StreamingHandlerFunc) connect.StreamingHandlerFunc {
+	return func(ctx context.Context, conn connect.StreamingHandlerConn) error {
+		err := next(ctx, conn)
+		recordCall(ctx, conn.Spec().Procedure, err)
+		return err
+	}
+}
+
+func recordCall(ctx context.Context, procedure string, err error) {
+	record, ok := ctx.Value(accessRecordKey{}).(*accessRecord)
+	if !ok {
+		return
+	}
+	record.procedure = strings.TrimPrefix(procedure, "/")
+	record.code = "ok"
+	if err != nil {
+		record.code = connect.CodeOf(err).String()
+	}
+}
+
+// Middleware for signing responses with the signing keyring while httpSigResponses is
+// set. Responses are buffered so that their Content-Digest can be computed, which rules
+// out streaming through it.
+func NewResponseSigningHandler(toWrap http.Handler, conf *config.ServerConfig, logger *log.Logger) *responseSigningHandler {
+	return &responseSigningHandler{toWrap, conf, httpsig.NewSigner(conf.GetKeyring()), logger}
+}
+
+type responseSigningHandler struct {
+	wrappedHandler http.Handler
+	conf           *config.ServerConfig
+	signer         *httpsig.Signer
+	logger         *log.Logger
+}
+
+type bufferedResponse struct {
+	http.ResponseWriter
+	statusCode int
+	body       bytes.Buffer
+}
+
+func (b *bufferedResponse) Write(buf []byte) (int, error) {
+	return b.body.Write(buf)
+}
+
+func (b *bufferedResponse) WriteHeader(statusCode int) {
+	if b.statusCode == 0 {
+		b.statusCode = statusCode
+	}
+}
+
+func (s *responseSigningHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !s.conf.GetStaticConfig().HttpSigResponses {
+		s.wrappedHandler.ServeHTTP(w, r)
+		return
+	}
+
+	buffered := &bufferedResponse{ResponseWriter: w}
+	s.wrappedHandler.ServeHTTP(buffered, r)
+	if buffered.statusCode == 0 {
+		buffered.statusCode = http.StatusOK
+	}
+
+	body := buffered.body.Bytes()
+	if err := s.signer.SignResponse(buffered.statusCode, w.Header(), body); err != nil {
+		// Clients which verify signatures will reject the response, which is still better
+		// than failing it for everyone else.
+		requestid.Logger(r.Context(), s.logger).Error("Failed to sign response", "path", r.URL.Path, "error", err)
+	}
+	w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	w.WriteHeader(buffered.statusCode)
+	w.Write(body)
+}
+
+// Middleware for authenticating clients which sign their requests with a key from the
+// client keyring. Unsigned requests are passed through unless httpSigRequired is set, but
+// a request with an invalid signature is always rejected. The key ID of a verified
+// signature is available through httpsig.KeyID.
+func NewSignatureAuthHandler(toWrap http.Handler, conf *config.ServerConfig, logger *log.Logger) *signatureAuthHandler {
+	return &signatureAuthHandler{toWrap, conf, httpsig.NewVerifier(conf.GetClientKeyring()), logger}
+}
+
+// signed reports whether r carries a request signature.
+func signed(r *http.Request) bool {
+	return r.Header.Get("Signature-Input") != "" || r.Header.Get("Signature") != ""
+}
+
+type signatureAuthHandler struct {
+	wrappedHandler http.Handler
+	conf           *config.ServerConfig
+	verifier       *httpsig.Verifier
+	logger         *log.Logger
+}
+
+func (s *signatureAuthHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// Already verified by the auth middleware.
+	if _, verified := httpsig.KeyID(r.Context()); verified {
+		s.wrappedHandler.ServeHTTP(w, r)
+		return
+	}
+	conf := s.conf.GetStaticConfig()
+	if conf.HttpSigClientKeyring == "" || (!signed(r) && !conf.HttpSigRequired) {
+		s.wrappedHandler.ServeHTTP(w, r)
+		return
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, httpsig.MaxBodySize))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	logger := requestid.Logger(r.Context(), s.logger)
+	keyid, err := s.verifier.VerifyRequest(r, body)
+	if err != nil {
+		logger.Warn("Rejected request signature", "path", r.URL.Path, "remote", r.RemoteAddr, "keyid", keyid, "error", err)
+		http.Error(w, "invalid request signature: "+err.Error(), http.StatusUnauthorized)
+		return
+	}
+	logger.Debug("Verified request signature", "path", r.URL.Path, "keyid", keyid)
+
+	r = r.WithContext(httpsig.WithKeyID(r.Context(), keyid))
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	s.wrappedHandler.ServeHTTP(w, r)
 }
@@ -0,0 +1,598 @@
+package appserver_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"connectrpc.com/connect"
+	"github.com/brnsampson/echopilot/internal/appserver"
+	"github.com/brnsampson/echopilot/pkg/auth"
+	"github.com/brnsampson/echopilot/pkg/config"
+	"github.com/brnsampson/echopilot/pkg/httpsig"
+	"github.com/brnsampson/echopilot/pkg/option"
+	"github.com/brnsampson/echopilot/pkg/ratelimit"
+	"github.com/brnsampson/echopilot/pkg/requestid"
+	"github.com/brnsampson/echopilot/pkg/router"
+	"github.com/brnsampson/echopilot/pkg/signing"
+	"github.com/brnsampson/echopilot/proto/gen/echo/v1/echov1connect"
+	"github.com/brnsampson/echopilot/rpc/echo"
+	"github.com/charmbracelet/log"
+	"github.com/spf13/pflag"
+)
+
+// writeKeyring creates a keyring directory holding key with the given status.
+func writeKeyring(t *testing.T, key *signing.PrivateKey, status string) string {
+	dir := t.TempDir()
+	ok(t, signing.WriteKeyFiles(key, filepath.Join(dir, "key.pem"), filepath.Join(dir, "key.pub.pem"), true))
+	manifest := `{"keys": [{"file": "key.pem", "status": "` + status + `"}]}`
+	ok(t, os.WriteFile(filepath.Join(dir, signing.KeyringManifest), []byte(manifest), 0644))
+	return dir
+}
+
+func testServerConfig(t *testing.T, fileContents string) *config.ServerConfig {
+	file := filepath.Join(t.TempDir(), "echopilot.json")
+	ok(t, os.WriteFile(file, []byte(fileContents), 0640))
+
+	flags := pflag.NewFlagSet("test", pflag.ContinueOnError)
+	flags.StringSlice("config", []string{file}, "")
+	flags.Bool("tlsEnabled", false, "")
+
+	conf, err := config.NewServerConfig(flags, log.New(io.Discard))
+	ok(t, err)
+	return conf
+}
+
+func TestMessageSignatures(t *testing.T) {
+	serverKey, err := signing.GenerateKey(signing.ECDSAP256)
+	ok(t, err)
+	clientKey, err := signing.GenerateKey(signing.Ed25519)
+	ok(t, err)
+	conf := testServerConfig(t, `{
+		"signingKeyring": "`+writeKeyring(t, serverKey, "active")+`",
+		"httpSigResponses": true,
+		"httpSigClientKeyring": "`+writeKeyring(t, clientKey, "verify-only")+`",
+		"httpSigRequired": true
+	}`)
+
+	logger := log.New(io.Discard)
+	var handler http.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		keyid, _ := httpsig.KeyID(r.Context())
+		body, _ := io.ReadAll(r.Body)
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte(keyid + ": " + string(body)))
+	})
+	handler = appserver.NewSignatureAuthHandler(handler, conf, logger)
+	handler = appserver.NewResponseSigningHandler(handler, conf, logger)
+	srv := httptest.NewServer(handler)
+	defer srv.Close()
+
+	transport := &httpsig.Transport{
+		Signer:   httpsig.NewSigner(httpsig.StaticKey{Private: clientKey}),
+		Verifier: httpsig.NewVerifier(httpsig.StaticKey{Public: serverKey.Public()}),
+	}
+	client := &http.Client{Transport: transport}
+	res, err := client.Post(srv.URL, "text/plain", bytes.NewReader([]byte("hello")))
+	ok(t, err)
+	body, err := io.ReadAll(res.Body)
+	ok(t, err)
+	equals(t, http.StatusOK, res.StatusCode)
+	equals(t, clientKey.Public().KeyID()+": hello", string(body))
+
+	// Unsigned requests are rejected, but the rejection is still signed by the server.
+	transport.Signer = nil
+	res, err = client.Post(srv.URL, "text/plain", bytes.NewReader([]byte("hello")))
+	ok(t, err)
+	equals(t, http.StatusUnauthorized, res.StatusCode)
+
+	// Keys which are not in the client keyring are rejected.
+	stranger, err := signing.GenerateKey(signing.Ed25519)
+	ok(t, err)
+	transport.Signer = httpsig.NewSigner(httpsig.StaticKey{Private: stranger})
+	res, err = client.Post(srv.URL, "text/plain", bytes.NewReader([]byte("hello")))
+	ok(t, err)
+	equals(t, http.StatusUnauthorized, res.StatusCode)
+
+	// A client expecting another server key does not accept the response.
+	impostor, err := signing.GenerateKey(signing.ECDSAP256)
+	ok(t, err)
+	transport.Signer = httpsig.NewSigner(httpsig.StaticKey{Private: clientKey})
+	transport.Verifier = httpsig.NewVerifier(httpsig.StaticKey{Public: impostor.Public()})
+	_, err = client.Post(srv.URL, "text/plain", bytes.NewReader([]byte("hello")))
+	assert(t, errors.Is(err, signing.ErrUnknownKey), "expected ErrUnknownKey, got %v", err)
+}
+
+func TestMessageSignaturesDisabled(t *testing.T) {
+	conf := testServerConfig(t, `{}`)
+	logger := log.New(io.Discard)
+	var handler http.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("hello"))
+	})
+	handler = appserver.NewSignatureAuthHandler(handler, conf, logger)
+	handler = appserver.NewResponseSigningHandler(handler, conf, logger)
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+	equals(t, http.StatusOK, rec.Code)
+	equals(t, "", rec.Header().Get("Signature"))
+	equals(t, "hello", rec.Body.String())
+}
+
+func TestAccessLog(t *testing.T) {
+	conf := testServerConfig(t, `{
+		"accessLogFormat": "json",
+		"accessLogExclude": ["/health"],
+		"accessLogSample": {"/quiet": 0}
+	}`)
+	var out bytes.Buffer
+	logger := log.New(&out)
+
+	routes := router.NewRouter()
+	routes.Use(func(next http.Handler) http.Handler {
+		return appserver.NewRequestIDHandler(next, conf, log.New(io.Discard))
+	})
+	routes.Use(func(next http.Handler) http.Handler {
+		return appserver.NewLoggingHandler(next, conf, logger)
+	})
+	routes.Mux().Post("/upload/{name}", func(w http.ResponseWriter, r *http.Request) {
+		io.ReadAll(r.Body)
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte("stored"))
+	})
+	routes.Mux().Get("/health", func(w http.ResponseWriter, r *http.Request) {})
+	routes.Mux().Get("/quiet", func(w http.ResponseWriter, r *http.Request) {})
+	routes.Mux().Get("/quiet/broken", func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "broken", http.StatusInternalServerError)
+	})
+	host := router.Host{Logger: log.New(io.Discard), Interceptors: []connect.Interceptor{appserver.NewAccessLogInterceptor()}}
+	ok(t, routes.Add(echo.NewFeature(host)))
+	srv := httptest.NewServer(routes)
+	defer srv.Close()
+
+	lines := func() []map[string]interface{} {
+		entries := make([]map[string]interface{}, 0)
+		for _, line := range strings.Split(strings.TrimSpace(out.String()), "\n") {
+			if line == "" {
+				continue
+			}
+			entry := make(map[string]interface{})
+			ok(t, json.Unmarshal([]byte(line), &entry))
+			entries = append(entries, entry)
+		}
+		out.Reset()
+		return entries
+	}
+
+	req, err := http.NewRequest("POST", srv.URL+"/upload/notes", strings.NewReader("some notes"))
+	ok(t, err)
+	req.Header.Set("User-Agent", "test-agent")
+	req.Header.Set("X-Request-ID", "abc123")
+	_, err = http.DefaultClient.Do(req)
+	ok(t, err)
+	entries := lines()
+	equals(t, 1, len(entries))
+	equals(t, "POST", entries[0]["method"])
+	equals(t, "/upload/{name}", entries[0]["route"])
+	equals(t, float64(201), entries[0]["status"])
+	equals(t, float64(10), entries[0]["bytes_in"])
+	equals(t, float64(6), entries[0]["bytes_out"])
+	equals(t, "test-agent", entries[0]["user_agent"])
+	equals(t, "abc123", entries[0]["request_id"])
+	equals(t, "access", entries[0]["prefix"])
+
+	// Excluded and sampled away, except for server errors.
+	for _, path := range []string{"/health", "/quiet", "/quiet/broken"} {
+		_, err = http.Get(srv.URL + path)
+		ok(t, err)
+	}
+	entries = lines()
+	equals(t, 1, len(entries))
+	equals(t, "/quiet/broken", entries[0]["path"])
+	equals(t, "error", entries[0]["lvl"])
+
+	client, err := echo.NewRemoteEchoClient(srv.URL, option.None[time.Duration](), option.None[bool]())
+	ok(t, err)
+	_, err = client.EchoString(echo.NewStringRequest("hi"))
+	ok(t, err)
+	entries = lines()
+	equals(t, 1, len(entries))
+	equals(t, "echo.v1.EchoService/EchoString", entries[0]["procedure"])
+	equals(t, "ok", entries[0]["code"])
+}
+
+func TestRequestID(t *testing.T) {
+	conf := testServerConfig(t, `{}`)
+	var out bytes.Buffer
+	logger := log.New(&out)
+	logger.SetFormatter(log.JSONFormatter)
+
+	// The ID as sent by the client.
+	var sent string
+	routes := router.NewRouter()
+	routes.Use(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			sent = r.Header.Get("X-Request-ID")
+			next.ServeHTTP(w, r)
+		})
+	})
+	routes.Use(func(next http.Handler) http.Handler {
+		return appserver.NewRequestIDHandler(next, conf, logger)
+	})
+	routes.Mux().Get("/hello", func(w http.ResponseWriter, r *http.Request) {
+		log.FromContext(r.Context()).Info("hello")
+		id, _ := requestid.ID(r.Context())
+		w.Write([]byte(id))
+	})
+	host := router.Host{Logger: log.New(io.Discard), Interceptors: []connect.Interceptor{requestid.NewInterceptor()}}
+	ok(t, routes.Add(echo.NewFeature(host)))
+	srv := httptest.NewServer(routes)
+	defer srv.Close()
+
+	get := func(header, value string) (*http.Response, string) {
+		req, err := http.NewRequest("GET", srv.URL+"/hello", nil)
+		ok(t, err)
+		if header != "" {
+			req.Header.Set(header, value)
+		}
+		res, err := http.DefaultClient.Do(req)
+		ok(t, err)
+		body, err := io.ReadAll(res.Body)
+		ok(t, err)
+		return res, string(body)
+	}
+
+	// Loopback is trusted by default.
+	res, body := get("X-Request-ID", "abc123")
+	equals(t, "abc123", body)
+	equals(t, "abc123", res.Header.Get("X-Request-ID"))
+	entry := make(map[string]interface{})
+	ok(t, json.Unmarshal(out.Bytes(), &entry))
+	equals(t, "abc123", entry["request_id"])
+
+	_, body = get("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	equals(t, "4bf92f3577b34da6a3ce929d0e0e4736", body)
+
+	// Invalid IDs are replaced.
+	_, body = get("X-Request-ID", "no spaces allowed")
+	equals(t, 32, len(body))
+
+	// The Connect trailer carries the ID, and clients forward the ID of their context.
+	client, err := echo.NewRemoteEchoClient(srv.URL, option.None[time.Duration](), option.None[bool]())
+	ok(t, err)
+	_, err = client.EchoStringContext(requestid.WithID(context.Background(), "forwarded"), echo.NewStringRequest("hi"))
+	ok(t, err)
+	equals(t, "forwarded", sent)
+	raw := echov1connect.NewEchoServiceClient(http.DefaultClient, srv.URL)
+	req := connect.NewRequest(echo.NewStringRequest("hi"))
+	req.Header().Set("X-Request-ID", "from-connect")
+	response, err := raw.EchoString(context.Background(), req)
+	ok(t, err)
+	equals(t, "from-connect", response.Trailer().Get("X-Request-ID"))
+
+	// Peers outside the trusted networks always get a new ID.
+	untrusted := testServerConfig(t, `{"requestIdTrustedNets": []}`)
+	handler := appserver.NewRequestIDHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}), untrusted, logger)
+	rec := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Header.Set("X-Request-ID", "abc123")
+	handler.ServeHTTP(rec, r)
+	assert(t, rec.Header().Get("X-Request-ID") != "abc123", "untrusted request ID was kept")
+	equals(t, 32, len(rec.Header().Get("X-Request-ID")))
+}
+
+func TestRateLimit(t *testing.T) {
+	conf := testServerConfig(t, `{"rateLimits": {
+		"*": {"rate": 0.001},
+		"/hello": {"rate": 0.001, "burst": 2},
+		"echo.v1.EchoService/EchoString": {"rate": 0.001}
+	}}`)
+	limiter := ratelimit.New()
+	routes := router.NewRouter()
+	routes.Use(func(next http.Handler) http.Handler {
+		return appserver.NewRateLimitHandler(next, conf, limiter, routes)
+	})
+	routes.Mux().Get("/hello", func(w http.ResponseWriter, r *http.Request) {})
+	routes.Mux().Get("/other", func(w http.ResponseWriter, r *http.Request) {})
+	ok(t, routes.Add(echo.NewFeature(router.Host{Logger: log.New(io.Discard)})))
+	srv := httptest.NewServer(routes)
+	defer srv.Close()
+
+	get := func(path string) *http.Response {
+		res, err := http.Get(srv.URL + path)
+		ok(t, err)
+		io.ReadAll(res.Body)
+		return res
+	}
+
+	// Routes have limits of their own, the rest share *.
+	equals(t, http.StatusOK, get("/hello").StatusCode)
+	equals(t, http.StatusOK, get("/hello").StatusCode)
+	res := get("/hello")
+	equals(t, http.StatusTooManyRequests, res.StatusCode)
+	equals(t, "1000", res.Header.Get("Retry-After"))
+	equals(t, http.StatusOK, get("/other").StatusCode)
+	equals(t, http.StatusTooManyRequests, get("/other").StatusCode)
+
+	// Procedures are limited apart from the route serving them.
+	client := echov1connect.NewEchoServiceClient(http.DefaultClient, srv.URL)
+	_, err := client.EchoString(context.Background(), connect.NewRequest(echo.NewStringRequest("hi")))
+	ok(t, err)
+	_, err = client.EchoString(context.Background(), connect.NewRequest(echo.NewStringRequest("hi")))
+	equals(t, connect.CodeResourceExhausted, connect.CodeOf(err))
+	var connectErr *connect.Error
+	equals(t, true, errors.As(err, &connectErr))
+	equals(t, "1000", connectErr.Meta().Get("Retry-After"))
+
+	// Reloaded limits apply to the buckets there are.
+	_, err = conf.ApplyPatch([]byte(`{"rateLimits": {"/hello": {"rate": 1000}}}`), false)
+	ok(t, err)
+	_, err = conf.GetAddr(true)
+	ok(t, err)
+	time.Sleep(10 * time.Millisecond)
+	equals(t, http.StatusOK, get("/hello").StatusCode)
+	equals(t, http.StatusTooManyRequests, get("/other").StatusCode)
+}
+
+func TestRateLimitByAPIKey(t *testing.T) {
+	keys := filepath.Join(t.TempDir(), "keys.json")
+	ok(t, os.WriteFile(keys, []byte(`{"keys": [
+		{"name": "ci", "sha256": "`+auth.Hash("ci-secret")+`"},
+		{"name": "dashboard", "sha256": "`+auth.Hash("dashboard-secret")+`"}
+	]}`), 0600))
+	conf := testServerConfig(t, `{"authKeysFile": "`+keys+`", "rateLimits": {"/hello": {"rate": 0.001, "by": "apiKey"}}}`)
+	limiter := ratelimit.New()
+	routes := router.NewRouter()
+	routes.Use(func(next http.Handler) http.Handler {
+		return appserver.NewAuthHandler(next, conf, routes)
+	})
+	routes.Use(func(next http.Handler) http.Handler {
+		return appserver.NewRateLimitHandler(next, conf, limiter, routes)
+	})
+	routes.Mux().Get("/hello", func(w http.ResponseWriter, r *http.Request) {})
+	srv := httptest.NewServer(routes)
+	defer srv.Close()
+
+	get := func(apiKey string) int {
+		req, err := http.NewRequest("GET", srv.URL+"/hello", nil)
+		ok(t, err)
+		if apiKey != "" {
+			req.Header.Set(auth.APIKeyHeader, apiKey)
+		}
+		res, err := http.DefaultClient.Do(req)
+		ok(t, err)
+		io.ReadAll(res.Body)
+		return res.StatusCode
+	}
+
+	// Every client has a bucket of its own.
+	equals(t, http.StatusOK, get("ci-secret"))
+	equals(t, http.StatusTooManyRequests, get("ci-secret"))
+	equals(t, http.StatusOK, get("dashboard-secret"))
+
+	// Made up keys are refused before they get a bucket, and clients without a key share
+	// the one of their IP.
+	for i := 0; i < 3; i++ {
+		equals(t, http.StatusUnauthorized, get(fmt.Sprintf("made-up-%d", i)))
+	}
+	equals(t, 2, limiter.Len())
+	equals(t, http.StatusOK, get(""))
+	equals(t, http.StatusTooManyRequests, get(""))
+	equals(t, 3, limiter.Len())
+}
+
+func TestRateLimitAuthFailures(t *testing.T) {
+	keys := filepath.Join(t.TempDir(), "keys.json")
+	ok(t, os.WriteFile(keys, []byte(`{"keys": [{"name": "ci", "sha256": "`+auth.Hash("ci-secret")+`"}]}`), 0600))
+	conf := testServerConfig(t, `{
+		"authKeysFile": "`+keys+`",
+		"authRequired": true,
+		"authPublicRoutes": ["/public"],
+		"rateLimits": {"*": {"rate": 0.001, "burst": 2, "by": "apiKey"}}
+	}`)
+	limiter := ratelimit.New()
+	routes := router.NewRouter()
+	routes.Use(func(next http.Handler) http.Handler {
+		return appserver.NewAuthFailureLimitHandler(next, conf, limiter, routes)
+	})
+	routes.Use(func(next http.Handler) http.Handler {
+		return appserver.NewAuthHandler(next, conf, routes)
+	})
+	routes.Use(func(next http.Handler) http.Handler {
+		return appserver.NewRateLimitHandler(next, conf, limiter, routes)
+	})
+	routes.Mux().Get("/private", func(w http.ResponseWriter, r *http.Request) {})
+	routes.Mux().Get("/public", func(w http.ResponseWriter, r *http.Request) {})
+	srv := httptest.NewServer(routes)
+	defer srv.Close()
+
+	get := func(path, apiKey string) *http.Response {
+		req, err := http.NewRequest("GET", srv.URL+path, nil)
+		ok(t, err)
+		if apiKey != "" {
+			req.Header.Set(auth.APIKeyHeader, apiKey)
+		}
+		res, err := http.DefaultClient.Do(req)
+		ok(t, err)
+		io.ReadAll(res.Body)
+		return res
+	}
+
+	// Authenticated and anonymous requests do not count as failures.
+	equals(t, http.StatusOK, get("/private", "ci-secret").StatusCode)
+	equals(t, http.StatusOK, get("/public", "").StatusCode)
+
+	// Rejected requests do, until their IP is refused without looking at its credentials.
+	equals(t, http.StatusUnauthorized, get("/private", "made-up").StatusCode)
+	equals(t, http.StatusUnauthorized, get("/private", "").StatusCode)
+	res := get("/private", "made-up")
+	equals(t, http.StatusTooManyRequests, res.StatusCode)
+	equals(t, "1000", res.Header.Get("Retry-After"))
+	equals(t, http.StatusTooManyRequests, get("/private", "ci-secret").StatusCode)
+}
+
+func TestAuth(t *testing.T) {
+	keys := filepath.Join(t.TempDir(), "keys.json")
+	ok(t, os.WriteFile(keys, []byte(`{"keys": [{"name": "ci", "sha256": "`+auth.Hash("ci-secret")+`"}]}`), 0600))
+	conf := testServerConfig(t, `{
+		"authKeysFile": "`+keys+`",
+		"authRequired": true,
+		"authPublicRoutes": ["/", "/health", "echo.v1.EchoService/EchoInt"]
+	}`)
+
+	whoami := func(w http.ResponseWriter, r *http.Request) {
+		principal, _ := auth.PrincipalFrom(r.Context())
+		w.Write([]byte(principal.Name))
+	}
+	host := router.Host{Logger: log.New(io.Discard), Interceptors: []connect.Interceptor{appserver.NewAuthInterceptor(conf)}}
+	routes := router.NewRouter()
+	routes.Use(func(next http.Handler) http.Handler {
+		return appserver.NewAuthHandler(next, conf, routes, "/admin", "")
+	})
+	for _, path := range []string{"/", "/health/tls", "/private", "/admin/config"} {
+		routes.Mux().Get(path, whoami)
+	}
+	ok(t, routes.Add(echo.NewFeature(host)))
+	srv := httptest.NewServer(routes)
+	defer srv.Close()
+
+	get := func(path, apiKey string) (int, string) {
+		req, err := http.NewRequest("GET", srv.URL+path, nil)
+		ok(t, err)
+		if apiKey != "" {
+			req.Header.Set(auth.APIKeyHeader, apiKey)
+		}
+		res, err := http.DefaultClient.Do(req)
+		ok(t, err)
+		body, err := io.ReadAll(res.Body)
+		ok(t, err)
+		return res.StatusCode, string(body)
+	}
+
+	status, body := get("/private", "ci-secret")
+	equals(t, http.StatusOK, status)
+	equals(t, "ci", body)
+	status, _ = get("/private", "")
+	equals(t, http.StatusUnauthorized, status)
+	// Public routes need no credentials, but wrong ones are still rejected.
+	status, _ = get("/", "")
+	equals(t, http.StatusOK, status)
+	status, _ = get("/health/tls", "")
+	equals(t, http.StatusOK, status)
+	status, _ = get("/", "wrong")
+	equals(t, http.StatusUnauthorized, status)
+	status, _ = get("/admin/config", "")
+	equals(t, http.StatusOK, status)
+	// The UI calls back into the server with a key of its own.
+	_, body = get("/private", conf.GetLoopbackAPIKey())
+	equals(t, "ui", body)
+
+	client, err := echo.NewRemoteEchoClient(srv.URL, option.None[time.Duration](), option.None[bool]())
+	ok(t, err)
+	_, err = client.EchoString(echo.NewStringRequest("hi"))
+	equals(t, connect.CodeUnauthenticated, connect.CodeOf(err))
+	_, err = client.EchoStringContext(auth.WithAPIKey(context.Background(), "ci-secret"), echo.NewStringRequest("hi"))
+	ok(t, err)
+	_, err = client.EchoInt(echo.NewIntRequest(1))
+	ok(t, err)
+
+	// Without the middleware the interceptor does the same for Connect handlers.
+	bare := router.NewRouter()
+	ok(t, bare.Add(echo.NewFeature(host)))
+	bareSrv := httptest.NewServer(bare)
+	defer bareSrv.Close()
+	client, err = echo.NewRemoteEchoClient(bareSrv.URL, option.None[time.Duration](), option.None[bool]())
+	ok(t, err)
+	_, err = client.EchoString(echo.NewStringRequest("hi"))
+	equals(t, connect.CodeUnauthenticated, connect.CodeOf(err))
+	_, err = client.EchoStringContext(auth.WithAPIKey(context.Background(), "ci-secret"), echo.NewStringRequest("hi"))
+	ok(t, err)
+	_, err = client.EchoInt(echo.NewIntRequest(1))
+	ok(t, err)
+
+	// Without authRequired only credentials which are given are checked.
+	_, err = conf.ApplyPatch([]byte(`{"authRequired": false}`), false)
+	ok(t, err)
+	_, err = conf.GetAddr(true)
+	ok(t, err)
+	status, _ = get("/private", "")
+	equals(t, http.StatusOK, status)
+	status, _ = get("/private", "wrong")
+	equals(t, http.StatusUnauthorized, status)
+}
+
+func TestSignatureAuth(t *testing.T) {
+	clientKey, err := signing.GenerateKey(signing.Ed25519)
+	ok(t, err)
+	conf := testServerConfig(t, `{
+		"authRequired": true,
+		"httpSigClientKeyring": "`+writeKeyring(t, clientKey, "verify-only")+`"
+	}`)
+	logger := log.New(io.Discard)
+
+	host := router.Host{
+		Logger: logger,
+		Authenticate: func(next http.Handler) http.Handler {
+			return appserver.NewSignatureAuthHandler(next, conf, logger)
+		},
+		Interceptors: []connect.Interceptor{appserver.NewAuthInterceptor(conf)},
+	}
+	routes := router.NewRouter()
+	routes.Use(func(next http.Handler) http.Handler {
+		return appserver.NewAuthHandler(next, conf, routes)
+	})
+	routes.Mux().Post("/private", func(w http.ResponseWriter, r *http.Request) {
+		principal, _ := auth.PrincipalFrom(r.Context())
+		body, _ := io.ReadAll(r.Body)
+		w.Write([]byte(string(principal.Method) + "/" + principal.Name + ": " + string(body)))
+	})
+	ok(t, routes.Add(echo.NewFeature(host)))
+	srv := httptest.NewServer(routes)
+	defer srv.Close()
+	// Without the middleware the interceptor takes the principal from the signature.
+	bare := router.NewRouter()
+	ok(t, bare.Add(echo.NewFeature(host)))
+	bareSrv := httptest.NewServer(bare)
+	defer bareSrv.Close()
+
+	transport := &httpsig.Transport{Signer: httpsig.NewSigner(httpsig.StaticKey{Private: clientKey})}
+	client := &http.Client{Transport: transport}
+	post := func(url, contentType, body string) (int, string) {
+		res, err := client.Post(url, contentType, strings.NewReader(body))
+		ok(t, err)
+		got, err := io.ReadAll(res.Body)
+		ok(t, err)
+		return res.StatusCode, string(got)
+	}
+
+	// A signature is enough to authenticate, and the body is still there afterwards.
+	status, body := post(srv.URL+"/private", "text/plain", "hello")
+	equals(t, http.StatusOK, status)
+	equals(t, "signature/"+clientKey.Public().KeyID()+": hello", body)
+	for _, url := range []string{srv.URL, bareSrv.URL} {
+		status, body = post(url+"/echo.v1.EchoService/EchoString", "application/json", `{"content": "hi"}`)
+		equals(t, http.StatusOK, status)
+		equals(t, `{"content":"hi"}`, body)
+	}
+
+	// Signatures by other keys are rejected like wrong credentials.
+	stranger, err := signing.GenerateKey(signing.Ed25519)
+	ok(t, err)
+	transport.Signer = httpsig.NewSigner(httpsig.StaticKey{Private: stranger})
+	status, _ = post(srv.URL+"/private", "text/plain", "hello")
+	equals(t, http.StatusUnauthorized, status)
+	transport.Signer = nil
+	status, _ = post(srv.URL+"/private", "text/plain", "hello")
+	equals(t, http.StatusUnauthorized, status)
+	status, _ = post(bareSrv.URL+"/echo.v1.EchoService/EchoString", "application/json", `{"content": "hi"}`)
+	equals(t, http.StatusUnauthorized, status)
+}
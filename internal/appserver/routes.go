@@ -1,19 +1,41 @@
 package appserver
 
 import (
+    "errors"
 	"net/http"
     "time"
 
+    "connectrpc.com/connect"
     "github.com/brnsampson/echopilot/internal/templates"
+    "github.com/brnsampson/echopilot/pkg/auth"
+    "github.com/brnsampson/echopilot/pkg/config"
+    "github.com/brnsampson/echopilot/pkg/httpsig"
     "github.com/brnsampson/echopilot/pkg/option"
+    "github.com/brnsampson/echopilot/pkg/router"
     "github.com/brnsampson/echopilot/rpc/echo"
+    "github.com/charmbracelet/log"
     "github.com/go-chi/chi/v5"
 )
 
-func routeRoot(r chi.Router) {
-    r.Get("/", getIndex)
-    r.Get("/echo/{content}", getEcho)
-    r.Post("/echo", postEcho)
+// The UI links to features wherever routes mounted them.
+func routeRoot(conf *config.ServerConfig, routes *router.Router) func(chi.Router) {
+    return func(r chi.Router) {
+        r.Get("/", getIndex(routes))
+        r.Get("/echo/{content}", getEcho(routes))
+        r.Post("/echo", postEcho(conf, routes))
+    }
+}
+
+// featurePath is the path a feature is mounted at, or empty if it is disabled.
+func featurePath(routes *router.Router, name string) string {
+    prefix, found := routes.Prefix(name)
+    if !found {
+        return ""
+    }
+    if prefix == "" {
+        return "/"
+    }
+    return prefix
 }
 
 func errorHandler(w http.ResponseWriter, r *http.Request, status int) {
@@ -24,16 +46,33 @@ func errorHandler(w http.ResponseWriter, r *http.Request, status int) {
 	}
 }
 
-func getIndex(w http.ResponseWriter, r *http.Request) {
-    templates.Index().Render(r.Context(), w)
+func getIndex(routes *router.Router) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        templates.Index(featurePath(routes, "memory")).Render(r.Context(), w)
+    }
+}
+
+func getEcho(routes *router.Router) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        content := chi.URLParam(r, "content")
+        templates.Echo(content, featurePath(routes, "memory")).Render(r.Context(), w)
+    }
 }
 
-func getEcho(w http.ResponseWriter, r *http.Request) {
-    content := chi.URLParam(r, "content")
-    templates.Echo(content).Render(r.Context(), w)
+func postEcho(conf *config.ServerConfig, routes *router.Router) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        prefix, found := routes.Prefix("echo")
+        if !found {
+            errorHandler(w, r, http.StatusNotFound)
+            return
+        }
+        echoLoopback(conf, prefix, w, r)
+    }
 }
 
-func postEcho(w http.ResponseWriter, r *http.Request) {
+// The UI echoes through the RPC interface by calling back into this server. prefix is
+// where the echo feature is mounted, which Connect clients expect in the base URL.
+func echoLoopback(conf *config.ServerConfig, prefix string, w http.ResponseWriter, r *http.Request) {
     var content string
     r.ParseForm()
     if r.Form.Has("content") {
@@ -42,15 +81,44 @@ func postEcho(w http.ResponseWriter, r *http.Request) {
         content = ""
     }
 	timeout := option.NewOption(time.Duration(10) * time.Second)
-	skipVerify := option.NewOption(true)
-    client, err := echo.NewRemoteEchoClient("https://127.0.0.1:1443", timeout, skipVerify)
+    addr, err := conf.GetLoopbackURL(false)
     if err != nil {
+        log.FromContext(r.Context()).Error("Building the loopback URL failed", "error", err)
+        errorHandler(w, r, 500)
+        return
+    }
+    // Sign with our own key, so that listing it in the client keyring lets the UI work
+    // with httpSigRequired, and make sure our responses are signed when they should be.
+    staticConf := conf.GetStaticConfig()
+    var signer *httpsig.Signer
+    if staticConf.HttpSigClientKeyring != "" && staticConf.SigningKeyring != "" {
+        signer = httpsig.NewSigner(conf.GetKeyring())
+    }
+    var verifier *httpsig.Verifier
+    if staticConf.HttpSigResponses {
+        verifier = httpsig.NewVerifier(conf.GetKeyring())
+    }
+    client, err := echo.NewSignedRemoteEchoClient(addr + prefix, timeout, conf.GetClientTlsConfig(), signer, verifier)
+    if err != nil {
+        log.FromContext(r.Context()).Error("Creating the loopback echo client failed", "error", err)
         errorHandler(w, r, 500)
         return
     }
     req := echo.NewStringRequest(content)
-    res, err := client.EchoString(req)
+    // The UI is public, the echo service need not be.
+    ctx := auth.WithAPIKey(r.Context(), conf.GetLoopbackAPIKey())
+    res, err := client.EchoStringContext(ctx, req)
+    if connect.CodeOf(err) == connect.CodeResourceExhausted {
+        // Rate limited, which the user should hear about like any other limit.
+        var connectErr *connect.Error
+        if errors.As(err, &connectErr) && connectErr.Meta().Get("Retry-After") != "" {
+            w.Header().Set("Retry-After", connectErr.Meta().Get("Retry-After"))
+        }
+        errorHandler(w, r, http.StatusTooManyRequests)
+        return
+    }
     if err != nil {
+        log.FromContext(r.Context()).Error("Loopback call to the echo service failed", "url", addr + prefix, "error", err)
         errorHandler(w, r, 500)
         return
     }
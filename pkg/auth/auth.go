@@ -0,0 +1,164 @@
+package auth
+
+// Authentication of API clients.
+//
+// Clients authenticate with an API key in the X-API-Key header or a bearer token in the
+// Authorization header. Both are looked up in a KeySet loaded from the keys file, which
+// only holds hashes of the secrets. Bearer tokens may also be JWTs from a trusted issuer,
+// see pkg/jwt. Requests without either may instead be signed with a key from the client
+// keyring, see pkg/httpsig, and are authenticated as its key ID. The server puts the
+// Principal a request was authenticated as in its context.
+//
+// Clients set their credentials on a context with WithAPIKey or WithBearerToken, and
+// NewClientInterceptor sends them along with every Connect call made with it.
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"connectrpc.com/connect"
+	"github.com/brnsampson/echopilot/pkg/jwt"
+)
+
+const APIKeyHeader = "X-API-Key"
+
+var (
+	ErrNoCredentials      = errors.New("no credentials")
+	ErrInvalidCredentials = errors.New("invalid credentials")
+)
+
+// Method is how a principal authenticated.
+type Method string
+
+const (
+	MethodAPIKey Method = "apiKey"
+	MethodBearer Method = "bearer"
+	MethodJWT    Method = "jwt"
+	// A request signed with a key from the client keyring.
+	MethodSignature Method = "signature"
+)
+
+// Principal is who a request was authenticated as.
+type Principal struct {
+	// The name of the key, the subject of a JWT or the key ID of a request signature.
+	Name   string
+	Method Method
+	// Only set for JWTs.
+	Claims jwt.Claims
+}
+
+// IsLoopback reports whether p is the server calling itself, see LoopbackName.
+func (p Principal) IsLoopback() bool {
+	return p.Method == MethodAPIKey && p.Name == LoopbackName
+}
+
+type principalKey struct{}
+
+func WithPrincipal(ctx context.Context, p Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, p)
+}
+
+// PrincipalFrom returns the principal a request was authenticated as, if any.
+func PrincipalFrom(ctx context.Context) (Principal, bool) {
+	p, ok := ctx.Value(principalKey{}).(Principal)
+	return p, ok
+}
+
+// Authenticator checks the credentials of requests.
+type Authenticator struct {
+	Keys *KeySet
+	// Returns the verifier for JWT bearer tokens. Nil if only static tokens are accepted.
+	Tokens func() *jwt.Verifier
+}
+
+// Authenticate checks the credentials in header. Requests without any get
+// ErrNoCredentials, those with a key or token which is not accepted get an error wrapping
+// ErrInvalidCredentials.
+func (a Authenticator) Authenticate(header http.Header) (Principal, error) {
+	if apiKey := header.Get(APIKeyHeader); apiKey != "" {
+		key, found := a.Keys.Lookup(TypeAPIKey, apiKey)
+		if !found {
+			return Principal{}, fmt.Errorf("%w: unknown API key", ErrInvalidCredentials)
+		}
+		return Principal{Name: key.Name, Method: MethodAPIKey}, nil
+	}
+
+	authorization := header.Get("Authorization")
+	if authorization == "" {
+		return Principal{}, ErrNoCredentials
+	}
+	token, found := strings.CutPrefix(authorization, "Bearer ")
+	if !found || token == "" {
+		return Principal{}, fmt.Errorf("%w: only bearer tokens are accepted", ErrInvalidCredentials)
+	}
+	if key, found := a.Keys.Lookup(TypeBearer, token); found {
+		return Principal{Name: key.Name, Method: MethodBearer}, nil
+	}
+	// Anything else has to be a JWT, which is three dot separated segments.
+	if a.Tokens == nil || strings.Count(token, ".") != 2 {
+		return Principal{}, fmt.Errorf("%w: unknown bearer token", ErrInvalidCredentials)
+	}
+	claims, err := a.Tokens().Verify(token)
+	if err != nil {
+		return Principal{}, fmt.Errorf("%w: %v", ErrInvalidCredentials, err)
+	}
+	return Principal{Name: claims.Subject, Method: MethodJWT, Claims: claims}, nil
+}
+
+type credentials struct {
+	header string
+	value  string
+}
+
+type credentialsKey struct{}
+
+// WithAPIKey makes calls with the context authenticate with apiKey.
+func WithAPIKey(ctx context.Context, apiKey string) context.Context {
+	return context.WithValue(ctx, credentialsKey{}, credentials{APIKeyHeader, apiKey})
+}
+
+// WithBearerToken makes calls with the context authenticate with token, a static bearer
+// token or a JWT.
+func WithBearerToken(ctx context.Context, token string) context.Context {
+	return context.WithValue(ctx, credentialsKey{}, credentials{"Authorization", "Bearer " + token})
+}
+
+// SetCredentials adds the credentials of ctx to the header of an outgoing request.
+func SetCredentials(ctx context.Context, header http.Header) {
+	if creds, ok := ctx.Value(credentialsKey{}).(credentials); ok {
+		header.Set(creds.header, creds.value)
+	}
+}
+
+// NewClientInterceptor sends the credentials of the context with every call. The
+// credentials of a request being served are never passed on, a client has to set them
+// explicitly.
+func NewClientInterceptor() connect.Interceptor {
+	return clientInterceptor{}
+}
+
+type clientInterceptor struct{}
+
+func (clientInterceptor) WrapUnary(next connect.UnaryFunc) connect.UnaryFunc {
+	return func(ctx context.Context, req connect.AnyRequest) (connect.AnyResponse, error) {
+		if req.Spec().IsClient {
+			SetCredentials(ctx, req.Header())
+		}
+		return next(ctx, req)
+	}
+}
+
+func (clientInterceptor) WrapStreamingClient(next connect.StreamingClientFunc) connect.StreamingClientFunc {
+	return func(ctx context.Context, spec connect.Spec) connect.StreamingClientConn {
+		conn := next(ctx, spec)
+		SetCredentials(ctx, conn.RequestHeader())
+		return conn
+	}
+}
+
+func (clientInterceptor) WrapStreamingHandler(next connect.StreamingHandlerFunc) connect.StreamingHandlerFunc {
+	return next
+}
@@ -0,0 +1,154 @@
+package auth_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/brnsampson/echopilot/pkg/auth"
+	"github.com/brnsampson/echopilot/pkg/httpsig"
+	"github.com/brnsampson/echopilot/pkg/jwt"
+	"github.com/brnsampson/echopilot/pkg/signing"
+)
+
+// ok fails the test if an err is not nil.
+func ok(tb testing.TB, err error) {
+	if err != nil {
+		_, file, line, _ := runtime.Caller(1)
+		fmt.Printf("\033[31m%s:%d: unexpected error: %s\033[39m\n\n", filepath.Base(file), line, err.Error())
+		tb.FailNow()
+	}
+}
+
+// equals fails the test if exp is not equal to act.
+func equals(tb testing.TB, exp, act interface{}) {
+	if !reflect.DeepEqual(exp, act) {
+		_, file, line, _ := runtime.Caller(1)
+		fmt.Printf("\033[31m%s:%d:\n\n\texp: %#v\n\n\tgot: %#v\033[39m\n\n", filepath.Base(file), line, exp, act)
+		tb.FailNow()
+	}
+}
+
+func writeKeys(t *testing.T, contents string) string {
+	file := filepath.Join(t.TempDir(), "keys.json")
+	ok(t, os.WriteFile(file, []byte(contents), 0600))
+	return file
+}
+
+func TestKeySet(t *testing.T) {
+	keys := auth.NewKeySet()
+	keys.Add("ui", auth.TypeAPIKey, "loopback")
+	file := writeKeys(t, fmt.Sprintf(`{"keys": [
+		{"name": "ci", "sha256": %q},
+		{"name": "dashboard", "type": "bearer", "sha256": %q}
+	]}`, auth.Hash("ci-secret"), auth.Hash("dashboard-secret")))
+	ok(t, keys.Load(file))
+	equals(t, 3, keys.Len())
+
+	key, found := keys.Lookup(auth.TypeAPIKey, "ci-secret")
+	equals(t, true, found)
+	equals(t, "ci", key.Name)
+	// Keys only work the way they were handed out.
+	_, found = keys.Lookup(auth.TypeBearer, "ci-secret")
+	equals(t, false, found)
+	_, found = keys.Lookup(auth.TypeAPIKey, "loopback")
+	equals(t, true, found)
+
+	// A broken file keeps the keys loaded before.
+	broken := []string{
+		`{"keys": [{"sha256": "` + auth.Hash("a") + `"}]}`,
+		`{"keys": [{"name": "a", "sha256": "not hex"}]}`,
+		`{"keys": [{"name": "a", "type": "cookie", "sha256": "` + auth.Hash("a") + `"}]}`,
+		`{"keys": [{"name": "a", "sha256": "` + auth.Hash("a") + `"}, {"name": "a", "sha256": "` + auth.Hash("b") + `"}]}`,
+		`{"keys": [`,
+		// Would authenticate as the server.
+		`{"keys": [{"name": "ui", "sha256": "` + auth.Hash("a") + `"}]}`,
+	}
+	for _, contents := range broken {
+		equals(t, true, keys.Load(writeKeys(t, contents)) != nil)
+	}
+	equals(t, file, keys.File())
+	equals(t, 3, keys.Len())
+
+	// Keys added by the server outlive the file.
+	keys.Clear()
+	equals(t, 1, keys.Len())
+	_, found = keys.Lookup(auth.TypeAPIKey, "loopback")
+	equals(t, true, found)
+}
+
+func TestAuthenticate(t *testing.T) {
+	keys := auth.NewKeySet()
+	keys.Add("ci", auth.TypeAPIKey, "ci-secret")
+	keys.Add("dashboard", auth.TypeBearer, "dashboard-secret")
+	keys.Add(auth.LoopbackName, auth.TypeAPIKey, "loopback")
+	authenticator := auth.Authenticator{Keys: keys}
+
+	check := func(header, value string) (auth.Principal, error) {
+		h := http.Header{}
+		if header != "" {
+			h.Set(header, value)
+		}
+		return authenticator.Authenticate(h)
+	}
+
+	p, err := check(auth.APIKeyHeader, "ci-secret")
+	ok(t, err)
+	equals(t, auth.Principal{Name: "ci", Method: auth.MethodAPIKey}, p)
+	equals(t, false, p.IsLoopback())
+	p, err = check(auth.APIKeyHeader, "loopback")
+	ok(t, err)
+	equals(t, true, p.IsLoopback())
+	p, err = check("Authorization", "Bearer dashboard-secret")
+	ok(t, err)
+	equals(t, auth.Principal{Name: "dashboard", Method: auth.MethodBearer}, p)
+
+	_, err = check("", "")
+	equals(t, auth.ErrNoCredentials, err)
+	for _, header := range [][2]string{
+		{auth.APIKeyHeader, "dashboard-secret"},
+		{"Authorization", "Bearer ci-secret"},
+		{"Authorization", "Basic Y2k6c2VjcmV0"},
+	} {
+		_, err = check(header[0], header[1])
+		equals(t, true, errors.Is(err, auth.ErrInvalidCredentials))
+	}
+
+	// JWTs are only accepted with a verifier.
+	key, err := signing.GenerateKey(signing.Ed25519)
+	ok(t, err)
+	token, err := jwt.Sign(key, key.Public().KeyID(), jwt.Claims{Issuer: "echopilot", Subject: "alice", ExpiresAt: time.Now().Add(time.Minute)})
+	ok(t, err)
+	_, err = check("Authorization", "Bearer "+token)
+	equals(t, true, errors.Is(err, auth.ErrInvalidCredentials))
+
+	authenticator.Tokens = func() *jwt.Verifier {
+		verifier := jwt.NewVerifier("")
+		verifier.Trust("echopilot", httpsig.StaticKey{Public: key.Public()})
+		return verifier
+	}
+	p, err = check("Authorization", "Bearer "+token)
+	ok(t, err)
+	equals(t, "alice", p.Name)
+	equals(t, auth.MethodJWT, p.Method)
+	_, err = check("Authorization", "Bearer "+token[:len(token)-4]+"AAAA")
+	equals(t, true, errors.Is(err, auth.ErrInvalidCredentials))
+}
+
+func TestCredentials(t *testing.T) {
+	header := http.Header{}
+	auth.SetCredentials(context.Background(), header)
+	equals(t, 0, len(header))
+
+	auth.SetCredentials(auth.WithAPIKey(context.Background(), "secret"), header)
+	equals(t, "secret", header.Get(auth.APIKeyHeader))
+	auth.SetCredentials(auth.WithBearerToken(context.Background(), "token"), header)
+	equals(t, "Bearer token", header.Get("Authorization"))
+}
@@ -0,0 +1,188 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"sync/atomic"
+)
+
+// KeyType is how a client presents a key: in the X-API-Key header or as a bearer token.
+type KeyType string
+
+const (
+	TypeAPIKey KeyType = "apiKey"
+	TypeBearer KeyType = "bearer"
+)
+
+// LoopbackName is the name of the API key the server calls itself with. The keys file
+// may not use it, or its clients would pass for the server.
+const LoopbackName = "ui"
+
+// Key is an entry of the keys file. Only the SHA-256 hash of the secret is kept, so the
+// file is of no use to anyone who reads it:
+//
+//	{"keys": [{"name": "ci", "type": "apiKey", "sha256": "<hex>"}]}
+//
+// See echopilot apikey generate.
+type Key struct {
+	// Identifies the client in logs and is the name of its principal.
+	Name string `json:"name"`
+	// Defaults to apiKey.
+	Type   KeyType `json:"type,omitempty"`
+	SHA256 string  `json:"sha256"`
+}
+
+type keysFile struct {
+	Keys []Key `json:"keys"`
+}
+
+// NewSecret generates a random key to hand out to a client.
+func NewSecret() string {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		panic("auth: reading random bytes failed: " + err.Error())
+	}
+	return hex.EncodeToString(secret)
+}
+
+// Hash is what the keys file holds for secret. Secrets are random, so a plain hash is
+// as good as a slow one.
+func Hash(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+type keySnapshot struct {
+	file string
+	// As read from the file.
+	loaded []Key
+	// By type and hash.
+	keys map[KeyType]map[string]Key
+}
+
+// KeySet holds the keys clients may authenticate with. Like the signing keyring, a load
+// builds a complete snapshot and swaps it in, so a broken file leaves the previous keys
+// in use.
+type KeySet struct {
+	current atomic.Pointer[keySnapshot]
+	// Guards changes, lookups only need current.
+	mu sync.Mutex
+	// Keys added by the server itself, which every load keeps.
+	builtin []Key
+}
+
+func NewKeySet() *KeySet {
+	s := &KeySet{}
+	s.current.Store(s.snapshot("", nil))
+	return s
+}
+
+func (s *KeySet) snapshot(file string, loaded []Key) *keySnapshot {
+	snap := &keySnapshot{file: file, loaded: loaded, keys: map[KeyType]map[string]Key{TypeAPIKey: {}, TypeBearer: {}}}
+	for _, key := range append(append([]Key{}, loaded...), s.builtin...) {
+		snap.keys[key.Type][key.SHA256] = key
+	}
+	return snap
+}
+
+// Load reads the keys file and atomically replaces the keys loaded before.
+func (s *KeySet) Load(file string) error {
+	keys, err := readKeysFile(file)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.current.Store(s.snapshot(file, keys))
+	return nil
+}
+
+// Replace swaps in the keys loaded into other. The keys added to s by the server are
+// kept, those added to other are not.
+func (s *KeySet) Replace(other *KeySet) {
+	loaded := other.current.Load()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.current.Store(s.snapshot(loaded.file, loaded.loaded))
+}
+
+// Clear forgets every key but those added by the server.
+func (s *KeySet) Clear() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.current.Store(s.snapshot("", nil))
+}
+
+// Add lets clients authenticate with secret as name, whatever the keys file says. It is
+// meant for the server's calls to itself.
+func (s *KeySet) Add(name string, typ KeyType, secret string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.builtin = append(s.builtin, Key{Name: name, Type: typ, SHA256: Hash(secret)})
+	current := s.current.Load()
+	s.current.Store(s.snapshot(current.file, current.loaded))
+}
+
+// File is the keys file last loaded, if any.
+func (s *KeySet) File() string {
+	return s.current.Load().file
+}
+
+// Len is the number of keys, including those added by the server.
+func (s *KeySet) Len() int {
+	snap := s.current.Load()
+	return len(snap.keys[TypeAPIKey]) + len(snap.keys[TypeBearer])
+}
+
+// Lookup finds the key of the given type whose secret is secret.
+func (s *KeySet) Lookup(typ KeyType, secret string) (Key, bool) {
+	key, found := s.current.Load().keys[typ][Hash(secret)]
+	return key, found
+}
+
+func readKeysFile(file string) ([]Key, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var parsed keysFile
+	if err := json.Unmarshal(data, &parsed); err != nil {
+		return nil, fmt.Errorf("%s: %w", file, err)
+	}
+
+	names := make(map[string]bool)
+	hashes := make(map[string]bool)
+	for i := range parsed.Keys {
+		key := &parsed.Keys[i]
+		if key.Type == "" {
+			key.Type = TypeAPIKey
+		}
+		switch {
+		case key.Name == "":
+			return nil, fmt.Errorf("%s: key %d has no name", file, i)
+		case key.Name == LoopbackName:
+			return nil, fmt.Errorf("%s: key %s: the name is reserved for the server", file, key.Name)
+		case names[key.Name]:
+			return nil, fmt.Errorf("%s: key %s is listed twice", file, key.Name)
+		case key.Type != TypeAPIKey && key.Type != TypeBearer:
+			return nil, fmt.Errorf("%s: key %s: type must be apiKey or bearer, not %q", file, key.Name, key.Type)
+		case !validHash(key.SHA256):
+			return nil, fmt.Errorf("%s: key %s: sha256 must be 64 hex digits", file, key.Name)
+		case hashes[key.SHA256]:
+			return nil, fmt.Errorf("%s: key %s has the same secret as another key", file, key.Name)
+		}
+		names[key.Name] = true
+		hashes[key.SHA256] = true
+	}
+	return parsed.Keys, nil
+}
+
+func validHash(h string) bool {
+	decoded, err := hex.DecodeString(h)
+	return err == nil && len(decoded) == sha256.Size && h == hex.EncodeToString(decoded)
+}
@@ -0,0 +1,334 @@
+package certstore
+
+// Certificate store for TLS servers which need to present more than one certificate.
+//
+// The store is plugged into a tls.Config through GetCertificate, so the listener never
+// holds a reference to a certificate directly. Loading a new set of certificates builds
+// a complete snapshot first and then swaps it in atomically, which means a failed load
+// leaves the previous certificates in place and in-flight handshakes never observe a
+// partially updated store.
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"sync/atomic"
+)
+
+var ErrNoCertificate = errors.New("no certificate available for requested server name")
+
+// KeyPair identifies a certificate and private key on disk. The certificate file may
+// contain a full chain, leaf first.
+type KeyPair struct {
+	CertFile string `json:"cert"`
+	KeyFile  string `json:"key"`
+}
+
+// Entry describes a certificate currently served by the store.
+type Entry struct {
+	KeyPair
+	Names   []string
+	Default bool
+	Leaf    *x509.Certificate
+	// Chain holds any intermediates that were bundled with the leaf.
+	Chain []*x509.Certificate
+	cert  *tls.Certificate
+}
+
+type snapshot struct {
+	exact    map[string]*tls.Certificate
+	wildcard map[string]*tls.Certificate
+	def      *tls.Certificate
+	entries  []Entry
+}
+
+// Bundle of CA certificates used to verify client certificates.
+type clientCAs struct {
+	file  string
+	pool  *x509.CertPool
+	certs []*x509.Certificate
+}
+
+type Store struct {
+	current atomic.Pointer[snapshot]
+	cas     atomic.Pointer[clientCAs]
+}
+
+func New() *Store {
+	s := &Store{}
+	s.current.Store(&snapshot{
+		exact:    make(map[string]*tls.Certificate),
+		wildcard: make(map[string]*tls.Certificate),
+	})
+	return s
+}
+
+// Load reads the default key pair and any additional SNI key pairs from disk and
+// atomically replaces the contents of the store. If any pair fails to load, the store
+// is left untouched.
+func (s *Store) Load(def KeyPair, extra ...KeyPair) error {
+	entries := make([]Entry, 0, len(extra)+1)
+
+	e, err := loadEntry(def)
+	if err != nil {
+		return err
+	}
+	e.Default = true
+	entries = append(entries, e)
+
+	for _, pair := range extra {
+		e, err := loadEntry(pair)
+		if err != nil {
+			return err
+		}
+		entries = append(entries, e)
+	}
+
+	s.swap(entries)
+	return nil
+}
+
+// Reload re-reads every key pair currently in the store. This is useful when
+// certificate files are replaced in place, e.g. by an ACME client.
+func (s *Store) Reload() error {
+	snap := s.current.Load()
+	if len(snap.entries) == 0 {
+		return nil
+	}
+
+	var def KeyPair
+	extra := make([]KeyPair, 0, len(snap.entries))
+	for _, e := range snap.entries {
+		if e.Default {
+			def = e.KeyPair
+		} else {
+			extra = append(extra, e.KeyPair)
+		}
+	}
+	return s.Load(def, extra...)
+}
+
+// Set replaces the contents of the store with certificates that are already in memory.
+// The first certificate is used as the default.
+func (s *Store) Set(certs ...tls.Certificate) error {
+	entries := make([]Entry, 0, len(certs))
+	for i := range certs {
+		e, err := newEntry(KeyPair{}, certs[i])
+		if err != nil {
+			return err
+		}
+		e.Default = i == 0
+		entries = append(entries, e)
+	}
+	s.swap(entries)
+	return nil
+}
+
+// Replace makes the store serve everything other holds, certificates and client CA
+// bundle alike. Loading into a scratch store and replacing once everything else loaded
+// too keeps a failed reload from changing anything.
+func (s *Store) Replace(other *Store) {
+	s.current.Store(other.current.Load())
+	s.cas.Store(other.cas.Load())
+}
+
+// Entries returns a description of every certificate currently being served.
+func (s *Store) Entries() []Entry {
+	snap := s.current.Load()
+	entries := make([]Entry, len(snap.entries))
+	copy(entries, snap.entries)
+	return entries
+}
+
+// Pool returns a pool of every certificate being served, leaves included. Clients of
+// this server which trust the pool accept exactly the certificates it serves, whoever
+// issued them.
+func (s *Store) Pool() *x509.CertPool {
+	pool := x509.NewCertPool()
+	for _, e := range s.current.Load().entries {
+		pool.AddCert(e.Leaf)
+		for _, c := range e.Chain {
+			pool.AddCert(c)
+		}
+	}
+	return pool
+}
+
+// GetCertificate satisfies the tls.Config.GetCertificate hook. Certificates are
+// selected by exact name first, then by a wildcard covering the first label, and
+// finally the default certificate is used.
+func (s *Store) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	snap := s.current.Load()
+
+	name := strings.ToLower(strings.TrimSuffix(hello.ServerName, "."))
+	if name == "" && hello.Conn != nil {
+		// Clients connecting by IP do not send SNI, so try the address they connected to.
+		if host, _, err := net.SplitHostPort(hello.Conn.LocalAddr().String()); err == nil {
+			name = host
+		}
+	}
+
+	if name != "" {
+		if cert, ok := snap.exact[name]; ok {
+			return cert, nil
+		}
+
+		if i := strings.IndexByte(name, '.'); i > 0 {
+			if cert, ok := snap.wildcard[name[i+1:]]; ok {
+				return cert, nil
+			}
+		}
+	}
+
+	if snap.def != nil {
+		return snap.def, nil
+	}
+	return nil, fmt.Errorf("%w: %q", ErrNoCertificate, name)
+}
+
+// LoadClientCAs reads a PEM bundle of CA certificates used to verify client
+// certificates. An empty file name clears the bundle.
+func (s *Store) LoadClientCAs(file string) error {
+	if file == "" {
+		s.cas.Store(nil)
+		return nil
+	}
+
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return fmt.Errorf("loading client CA bundle %s: %w", file, err)
+	}
+
+	cas := &clientCAs{file: file, pool: x509.NewCertPool()}
+	for block, rest := pem.Decode(data); block != nil; block, rest = pem.Decode(rest) {
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		c, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return fmt.Errorf("parsing client CA bundle %s: %w", file, err)
+		}
+		cas.pool.AddCert(c)
+		cas.certs = append(cas.certs, c)
+	}
+
+	if len(cas.certs) == 0 {
+		return fmt.Errorf("client CA bundle %s contains no certificates", file)
+	}
+
+	s.cas.Store(cas)
+	return nil
+}
+
+// SetClientCAs replaces the client CA bundle with certificates that are already in
+// memory. Calling it without any certificates clears the bundle.
+func (s *Store) SetClientCAs(certs ...*x509.Certificate) {
+	if len(certs) == 0 {
+		s.cas.Store(nil)
+		return
+	}
+
+	cas := &clientCAs{file: "memory", pool: x509.NewCertPool(), certs: certs}
+	for _, c := range certs {
+		cas.pool.AddCert(c)
+	}
+	s.cas.Store(cas)
+}
+
+// ClientCAPool returns the pool used to verify client certificates, or nil when no
+// client CA bundle is loaded.
+func (s *Store) ClientCAPool() *x509.CertPool {
+	if cas := s.cas.Load(); cas != nil {
+		return cas.pool
+	}
+	return nil
+}
+
+// ClientCAs returns the certificates in the client CA bundle along with the file
+// they were loaded from.
+func (s *Store) ClientCAs() (string, []*x509.Certificate) {
+	if cas := s.cas.Load(); cas != nil {
+		return cas.file, cas.certs
+	}
+	return "", nil
+}
+
+func (s *Store) swap(entries []Entry) {
+	snap := &snapshot{
+		exact:    make(map[string]*tls.Certificate),
+		wildcard: make(map[string]*tls.Certificate),
+		entries:  entries,
+	}
+
+	for _, e := range entries {
+		if e.Default {
+			snap.def = e.cert
+		}
+		for _, name := range e.Names {
+			if strings.HasPrefix(name, "*.") {
+				// First pair listed wins when two certificates claim the same name.
+				if _, ok := snap.wildcard[name[2:]]; !ok {
+					snap.wildcard[name[2:]] = e.cert
+				}
+			} else if _, ok := snap.exact[name]; !ok {
+				snap.exact[name] = e.cert
+			}
+		}
+	}
+
+	s.current.Store(snap)
+}
+
+func loadEntry(pair KeyPair) (Entry, error) {
+	cert, err := tls.LoadX509KeyPair(pair.CertFile, pair.KeyFile)
+	if err != nil {
+		return Entry{}, fmt.Errorf("loading key pair %s: %w", pair.CertFile, err)
+	}
+	return newEntry(pair, cert)
+}
+
+func newEntry(pair KeyPair, cert tls.Certificate) (Entry, error) {
+	if len(cert.Certificate) == 0 {
+		return Entry{}, fmt.Errorf("key pair %s contains no certificates", pair.CertFile)
+	}
+
+	chain := make([]*x509.Certificate, 0, len(cert.Certificate))
+	for _, der := range cert.Certificate {
+		c, err := x509.ParseCertificate(der)
+		if err != nil {
+			return Entry{}, fmt.Errorf("parsing certificate %s: %w", pair.CertFile, err)
+		}
+		chain = append(chain, c)
+	}
+	leaf := chain[0]
+	cert.Leaf = leaf
+
+	return Entry{
+		KeyPair: pair,
+		Names:   certNames(leaf),
+		Leaf:    leaf,
+		Chain:   chain[1:],
+		cert:    &cert,
+	}, nil
+}
+
+func certNames(leaf *x509.Certificate) []string {
+	names := make([]string, 0, len(leaf.DNSNames)+len(leaf.IPAddresses))
+	for _, n := range leaf.DNSNames {
+		names = append(names, strings.ToLower(n))
+	}
+	for _, ip := range leaf.IPAddresses {
+		names = append(names, ip.String())
+	}
+
+	// Only fall back to the common name for legacy certificates without any SANs.
+	if len(names) == 0 && leaf.Subject.CommonName != "" {
+		names = append(names, strings.ToLower(leaf.Subject.CommonName))
+	}
+	return names
+}
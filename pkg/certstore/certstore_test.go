@@ -0,0 +1,185 @@
+package certstore_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/brnsampson/echopilot/pkg/certstore"
+)
+
+// assert fails the test if the condition is false.
+func assert(tb testing.TB, condition bool, msg string, v ...interface{}) {
+	if !condition {
+		_, file, line, _ := runtime.Caller(1)
+		fmt.Printf("\033[31m%s:%d: "+msg+"\033[39m\n\n", append([]interface{}{filepath.Base(file), line}, v...)...)
+		tb.FailNow()
+	}
+}
+
+// ok fails the test if an err is not nil.
+func ok(tb testing.TB, err error) {
+	if err != nil {
+		_, file, line, _ := runtime.Caller(1)
+		fmt.Printf("\033[31m%s:%d: unexpected error: %s\033[39m\n\n", filepath.Base(file), line, err.Error())
+		tb.FailNow()
+	}
+}
+
+// equals fails the test if exp is not equal to act.
+func equals(tb testing.TB, exp, act interface{}) {
+	if !reflect.DeepEqual(exp, act) {
+		_, file, line, _ := runtime.Caller(1)
+		fmt.Printf("\033[31m%s:%d:\n\n\texp: %#v\n\n\tgot: %#v\033[39m\n\n", filepath.Base(file), line, exp, act)
+		tb.FailNow()
+	}
+}
+
+func selfSigned(t *testing.T, cn string, names ...string) ([]byte, []byte) {
+	return selfSignedUntil(t, time.Now().Add(time.Hour), cn, names...)
+}
+
+func selfSignedUntil(t *testing.T, notAfter time.Time, cn string, names ...string) ([]byte, []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	ok(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: cn},
+		DNSNames:     names,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     notAfter,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	ok(t, err)
+
+	keyDer, err := x509.MarshalPKCS8PrivateKey(key)
+	ok(t, err)
+
+	certPem := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPem := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDer})
+	return certPem, keyPem
+}
+
+func writePair(t *testing.T, dir, name, cn string, names ...string) certstore.KeyPair {
+	certPem, keyPem := selfSigned(t, cn, names...)
+	pair := certstore.KeyPair{
+		CertFile: filepath.Join(dir, name+".crt"),
+		KeyFile:  filepath.Join(dir, name+".key"),
+	}
+	ok(t, os.WriteFile(pair.CertFile, certPem, 0644))
+	ok(t, os.WriteFile(pair.KeyFile, keyPem, 0600))
+	return pair
+}
+
+func servedName(t *testing.T, s *certstore.Store, serverName string) string {
+	cert, err := s.GetCertificate(&tls.ClientHelloInfo{ServerName: serverName})
+	ok(t, err)
+	return cert.Leaf.Subject.CommonName
+}
+
+func TestGetCertificateSelection(t *testing.T) {
+	dir := t.TempDir()
+	def := writePair(t, dir, "default", "default", "localhost")
+	exact := writePair(t, dir, "exact", "exact", "api.example.com")
+	wild := writePair(t, dir, "wild", "wild", "*.example.com")
+
+	s := certstore.New()
+	ok(t, s.Load(def, exact, wild))
+
+	equals(t, "default", servedName(t, s, "localhost"))
+	equals(t, "exact", servedName(t, s, "api.example.com"))
+	equals(t, "exact", servedName(t, s, "API.Example.com."))
+	equals(t, "wild", servedName(t, s, "www.example.com"))
+	// Wildcards only cover a single label.
+	equals(t, "default", servedName(t, s, "a.b.example.com"))
+	equals(t, "default", servedName(t, s, "unknown.test"))
+	equals(t, "default", servedName(t, s, ""))
+}
+
+func TestEmptyStore(t *testing.T) {
+	s := certstore.New()
+	_, err := s.GetCertificate(&tls.ClientHelloInfo{ServerName: "localhost"})
+	assert(t, err != nil, "empty store returned a certificate")
+}
+
+func TestFailedLoadKeepsPrevious(t *testing.T) {
+	dir := t.TempDir()
+	def := writePair(t, dir, "default", "default", "localhost")
+
+	s := certstore.New()
+	ok(t, s.Load(def))
+
+	missing := certstore.KeyPair{CertFile: filepath.Join(dir, "nope.crt"), KeyFile: filepath.Join(dir, "nope.key")}
+	err := s.Load(def, missing)
+	assert(t, err != nil, "loading a missing key pair should fail")
+
+	equals(t, 1, len(s.Entries()))
+	equals(t, "default", servedName(t, s, "localhost"))
+}
+
+func TestReloadPicksUpReplacedFiles(t *testing.T) {
+	dir := t.TempDir()
+	def := writePair(t, dir, "default", "first", "localhost")
+
+	s := certstore.New()
+	ok(t, s.Load(def))
+	equals(t, "first", servedName(t, s, "localhost"))
+
+	writePair(t, dir, "default", "second", "localhost")
+	ok(t, s.Reload())
+	equals(t, "second", servedName(t, s, "localhost"))
+}
+
+func TestSet(t *testing.T) {
+	certPem, keyPem := selfSigned(t, "memory", "localhost")
+	cert, err := tls.X509KeyPair(certPem, keyPem)
+	ok(t, err)
+
+	s := certstore.New()
+	ok(t, s.Set(cert))
+
+	entries := s.Entries()
+	equals(t, 1, len(entries))
+	assert(t, entries[0].Default, "first certificate passed to Set should be the default")
+	equals(t, []string{"localhost"}, entries[0].Names)
+	equals(t, "memory", servedName(t, s, "other.test"))
+}
+
+func TestPool(t *testing.T) {
+	dir := t.TempDir()
+	def := writePair(t, dir, "default", "default", "localhost")
+	exact := writePair(t, dir, "exact", "exact", "api.example.com")
+
+	s := certstore.New()
+	ok(t, s.Load(def, exact))
+
+	for _, e := range s.Entries() {
+		_, err := e.Leaf.Verify(x509.VerifyOptions{DNSName: e.Names[0], Roots: s.Pool()})
+		ok(t, err)
+	}
+
+	// Only what the store serves is trusted.
+	other := writePair(t, dir, "other", "other", "localhost")
+	ok(t, s.Load(other))
+	_, err := s.Entries()[0].Leaf.Verify(x509.VerifyOptions{DNSName: "localhost", Roots: certstore.New().Pool()})
+	assert(t, err != nil, "an empty pool should not trust anything")
+	cert, err := tls.LoadX509KeyPair(def.CertFile, def.KeyFile)
+	ok(t, err)
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	ok(t, err)
+	_, err = leaf.Verify(x509.VerifyOptions{DNSName: "localhost", Roots: s.Pool()})
+	assert(t, err != nil, "a certificate which is no longer served should not be trusted")
+}
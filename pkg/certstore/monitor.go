@@ -0,0 +1,206 @@
+package certstore
+
+import (
+	"context"
+	"crypto/x509"
+	"expvar"
+	"fmt"
+	"math"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/brnsampson/echopilot/pkg/health"
+	"github.com/charmbracelet/log"
+)
+
+// Exported through expvar so that the days remaining on each certificate can be scraped
+// alongside the rest of the process variables.
+var daysUntilExpiry = expvar.NewMap("tls_cert_days_until_expiry")
+
+type ExpiryLevel int
+
+const (
+	ExpiryOK ExpiryLevel = iota
+	ExpiryWarning
+	ExpiryCritical
+	ExpiryExpired
+)
+
+func (l ExpiryLevel) String() string {
+	switch l {
+	case ExpiryOK:
+		return "ok"
+	case ExpiryWarning:
+		return "warning"
+	case ExpiryCritical:
+		return "critical"
+	default:
+		return "expired"
+	}
+}
+
+// ExpiryThresholds control when the monitor starts warning about a certificate. Anything
+// with less than Warning remaining is logged as a warning, less than Critical marks the
+// server degraded, and an expired certificate marks the server as failing.
+type ExpiryThresholds struct {
+	Warning  time.Duration
+	Critical time.Duration
+}
+
+type Expiry struct {
+	// Source is the file the certificate was loaded from.
+	Source    string
+	Subject   string
+	NotAfter  time.Time
+	Remaining time.Duration
+	Level     ExpiryLevel
+}
+
+func (e Expiry) Name() string {
+	return e.Source + ":" + e.Subject
+}
+
+func (e Expiry) Days() float64 {
+	return math.Floor(e.Remaining.Hours()/24*10) / 10
+}
+
+func NewMonitor(logger *log.Logger, store *Store, thresholds ExpiryThresholds) *Monitor {
+	return &Monitor{
+		logger:     logger.With("package", "certstore"),
+		store:      store,
+		thresholds: thresholds,
+		now:        time.Now,
+	}
+}
+
+// Monitor inspects the certificates held by a Store and reports on how close they are
+// to expiring. It satisfies health.Checker.
+type Monitor struct {
+	logger     *log.Logger
+	store      *Store
+	mu         sync.Mutex
+	thresholds ExpiryThresholds
+	now        func() time.Time
+}
+
+func (m *Monitor) SetThresholds(thresholds ExpiryThresholds) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.thresholds = thresholds
+}
+
+// Inspect returns the expiry state of every certificate without logging anything.
+func (m *Monitor) Inspect() []Expiry {
+	m.mu.Lock()
+	thresholds := m.thresholds
+	now := m.now()
+	m.mu.Unlock()
+
+	expiries := make([]Expiry, 0)
+	for _, e := range m.store.Entries() {
+		source := e.CertFile
+		if source == "" {
+			source = "memory"
+		}
+		expiries = append(expiries, newExpiry(source, e.Leaf, now, thresholds))
+		for _, c := range e.Chain {
+			expiries = append(expiries, newExpiry(source, c, now, thresholds))
+		}
+	}
+
+	file, cas := m.store.ClientCAs()
+	for _, c := range cas {
+		expiries = append(expiries, newExpiry(file, c, now, thresholds))
+	}
+	return expiries
+}
+
+// Scan inspects every certificate, publishes the days remaining and logs at a level
+// matching how close each certificate is to expiry.
+func (m *Monitor) Scan() []Expiry {
+	expiries := m.Inspect()
+
+	daysUntilExpiry.Init()
+	for _, e := range expiries {
+		days := new(expvar.Float)
+		days.Set(e.Days())
+		daysUntilExpiry.Set(e.Name(), days)
+
+		kvs := []interface{}{"source", e.Source, "subject", e.Subject, "notAfter", e.NotAfter, "days", e.Days()}
+		switch e.Level {
+		case ExpiryExpired:
+			m.logger.Error("TLS certificate has expired", kvs...)
+		case ExpiryCritical:
+			m.logger.Error("TLS certificate is about to expire", kvs...)
+		case ExpiryWarning:
+			m.logger.Warn("TLS certificate will expire soon", kvs...)
+		default:
+			m.logger.Debug("TLS certificate expiry checked", kvs...)
+		}
+	}
+	return expiries
+}
+
+// Run checks certificates every interval until the context is cancelled.
+func (m *Monitor) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			m.Scan()
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Check reports the server as failing if any certificate has expired and degraded if
+// any is past the critical threshold.
+func (m *Monitor) Check(ctx context.Context) health.Result {
+	worst := ExpiryOK
+	msgs := make([]string, 0)
+	for _, e := range m.Inspect() {
+		if e.Level == ExpiryOK {
+			continue
+		}
+		if e.Level > worst {
+			worst = e.Level
+		}
+		msgs = append(msgs, fmt.Sprintf("%s %s (%.1f days)", e.Subject, e.Level, e.Days()))
+	}
+
+	msg := strings.Join(msgs, "; ")
+	switch worst {
+	case ExpiryExpired:
+		return health.Failing(msg)
+	case ExpiryCritical:
+		return health.Degraded(msg)
+	default:
+		return health.Result{Status: health.StatusOK, Message: msg}
+	}
+}
+
+func newExpiry(source string, c *x509.Certificate, now time.Time, thresholds ExpiryThresholds) Expiry {
+	remaining := c.NotAfter.Sub(now)
+
+	level := ExpiryOK
+	switch {
+	case remaining <= 0:
+		level = ExpiryExpired
+	case remaining < thresholds.Critical:
+		level = ExpiryCritical
+	case remaining < thresholds.Warning:
+		level = ExpiryWarning
+	}
+
+	return Expiry{
+		Source:    source,
+		Subject:   c.Subject.String(),
+		NotAfter:  c.NotAfter,
+		Remaining: remaining,
+		Level:     level,
+	}
+}
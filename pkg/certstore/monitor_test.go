@@ -0,0 +1,58 @@
+package certstore_test
+
+import (
+	"context"
+	"crypto/tls"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/brnsampson/echopilot/pkg/certstore"
+	"github.com/brnsampson/echopilot/pkg/health"
+	"github.com/charmbracelet/log"
+)
+
+var testThresholds = certstore.ExpiryThresholds{
+	Warning:  30 * 24 * time.Hour,
+	Critical: 7 * 24 * time.Hour,
+}
+
+func monitorFor(t *testing.T, notAfter time.Time) *certstore.Monitor {
+	certPem, keyPem := selfSignedUntil(t, notAfter, "monitored", "localhost")
+	cert, err := tls.X509KeyPair(certPem, keyPem)
+	ok(t, err)
+
+	s := certstore.New()
+	ok(t, s.Set(cert))
+	return certstore.NewMonitor(log.New(io.Discard), s, testThresholds)
+}
+
+func TestMonitorLevels(t *testing.T) {
+	cases := []struct {
+		remaining time.Duration
+		level     certstore.ExpiryLevel
+		status    health.Status
+	}{
+		{90 * 24 * time.Hour, certstore.ExpiryOK, health.StatusOK},
+		{20 * 24 * time.Hour, certstore.ExpiryWarning, health.StatusOK},
+		{3 * 24 * time.Hour, certstore.ExpiryCritical, health.StatusDegraded},
+		{-time.Hour, certstore.ExpiryExpired, health.StatusFailing},
+	}
+
+	for _, c := range cases {
+		m := monitorFor(t, time.Now().Add(c.remaining))
+
+		expiries := m.Scan()
+		equals(t, 1, len(expiries))
+		equals(t, c.level, expiries[0].Level)
+		equals(t, c.status, m.Check(context.Background()).Status)
+	}
+}
+
+func TestMonitorThresholdsUpdate(t *testing.T) {
+	m := monitorFor(t, time.Now().Add(20*24*time.Hour))
+	equals(t, certstore.ExpiryWarning, m.Inspect()[0].Level)
+
+	m.SetThresholds(certstore.ExpiryThresholds{Warning: 60 * 24 * time.Hour, Critical: 45 * 24 * time.Hour})
+	equals(t, certstore.ExpiryCritical, m.Inspect()[0].Level)
+}
@@ -0,0 +1,112 @@
+package config
+
+// Reporting on what changed between two configs.
+//
+// Fields tagged `reload:"restart"` on StaticConfig are only read once at startup, so a
+// reload cannot apply them. Rather than half-applying such a change, a reload keeps the
+// old value of those fields and reports the change as pending a restart.
+
+import (
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+)
+
+type FieldChange struct {
+	// Field is the name of the field as it appears in the config file.
+	Field           string      `json:"field"`
+	Old             interface{} `json:"old"`
+	New             interface{} `json:"new"`
+	RequiresRestart bool        `json:"requiresRestart"`
+}
+
+// ReloadEvent is emitted after every successful reload.
+type ReloadEvent struct {
+	Time time.Time `json:"time"`
+	// Applied lists the changes which took effect.
+	Applied []FieldChange `json:"applied"`
+	// PendingRestart lists changes which were ignored because they need a restart.
+	PendingRestart []FieldChange `json:"pendingRestart"`
+}
+
+func (e ReloadEvent) Changed() bool {
+	return len(e.Applied) > 0 || len(e.PendingRestart) > 0
+}
+
+type ReloadListener func(ReloadEvent)
+
+type reloadListeners struct {
+	mu        sync.Mutex
+	listeners []ReloadListener
+}
+
+func (l *reloadListeners) add(listener ReloadListener) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.listeners = append(l.listeners, listener)
+}
+
+func (l *reloadListeners) emit(event ReloadEvent) {
+	l.mu.Lock()
+	listeners := make([]ReloadListener, len(l.listeners))
+	copy(listeners, l.listeners)
+	l.mu.Unlock()
+
+	for _, listener := range listeners {
+		listener(event)
+	}
+}
+
+// Diff lists every field which differs between two configs. Secret values are redacted
+// in the result, but a changed secret is still reported.
+func Diff(old, new StaticConfig) []FieldChange {
+	oldV := reflect.ValueOf(old)
+	newV := reflect.ValueOf(new)
+	oldRedacted := reflect.ValueOf(old.Redacted())
+	newRedacted := reflect.ValueOf(new.Redacted())
+	t := oldV.Type()
+
+	changes := make([]FieldChange, 0)
+	for i := 0; i < t.NumField(); i++ {
+		if reflect.DeepEqual(oldV.Field(i).Interface(), newV.Field(i).Interface()) {
+			continue
+		}
+
+		f := t.Field(i)
+		changes = append(changes, FieldChange{
+			Field:           fieldName(f),
+			Old:             oldRedacted.Field(i).Interface(),
+			New:             newRedacted.Field(i).Interface(),
+			RequiresRestart: requiresRestart(f),
+		})
+	}
+	return changes
+}
+
+// withRestartFieldsFrom returns a copy of the config where every field which requires
+// a restart has the value it had in old.
+func (c StaticConfig) withRestartFieldsFrom(old StaticConfig) StaticConfig {
+	oldV := reflect.ValueOf(old)
+	newV := reflect.ValueOf(&c).Elem()
+	t := newV.Type()
+
+	for i := 0; i < t.NumField(); i++ {
+		if requiresRestart(t.Field(i)) {
+			newV.Field(i).Set(oldV.Field(i))
+		}
+	}
+	return c
+}
+
+func requiresRestart(f reflect.StructField) bool {
+	return f.Tag.Get("reload") == "restart"
+}
+
+func fieldName(f reflect.StructField) string {
+	name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+	if name == "" || name == "-" {
+		return f.Name
+	}
+	return name
+}
@@ -0,0 +1,51 @@
+package config_test
+
+import (
+	"os"
+	"testing"
+
+	"github.com/brnsampson/echopilot/pkg/config"
+)
+
+func TestDiff(t *testing.T) {
+	old := config.StaticConfig{Port: 3000, Host: "localhost", AdminToken: "old"}
+	new := config.StaticConfig{Port: 3001, Host: "localhost", AdminToken: "new", DevCertDir: "/tmp/dev"}
+
+	changes := config.Diff(old, new)
+	equals(t, 3, len(changes))
+
+	byField := make(map[string]config.FieldChange)
+	for _, c := range changes {
+		byField[c.Field] = c
+	}
+
+	equals(t, config.FieldChange{Field: "serverPort", Old: 3000, New: 3001}, byField["serverPort"])
+	equals(t, config.FieldChange{Field: "adminToken", Old: config.REDACTED, New: config.REDACTED}, byField["adminToken"])
+	equals(t, config.FieldChange{Field: "devCertDir", Old: "", New: "/tmp/dev", RequiresRestart: true}, byField["devCertDir"])
+
+	equals(t, 0, len(config.Diff(old, old)))
+}
+
+func TestReloadEvent(t *testing.T) {
+	conf, file := testServerConfig(t, `{"serverPort": 4000}`)
+
+	events := make([]config.ReloadEvent, 0)
+	conf.OnReload(func(e config.ReloadEvent) { events = append(events, e) })
+
+	// Nothing changed, so nothing is emitted.
+	_, err := conf.GetAddr(true)
+	ok(t, err)
+	equals(t, 0, len(events))
+
+	ok(t, os.WriteFile(file, []byte(`{"serverPort": 4001, "devCertDir": "/tmp/dev"}`), 0640))
+	_, err = conf.GetAddr(true)
+	ok(t, err)
+
+	equals(t, 1, len(events))
+	equals(t, []config.FieldChange{{Field: "serverPort", Old: 4000, New: 4001}}, events[0].Applied)
+	equals(t, []config.FieldChange{{Field: "devCertDir", Old: "", New: "/tmp/dev", RequiresRestart: true}}, events[0].PendingRestart)
+
+	// The restart-only field keeps its startup value instead of being half-applied.
+	equals(t, 4001, conf.GetStaticConfig().Port)
+	equals(t, "", conf.GetStaticConfig().DevCertDir)
+}
@@ -0,0 +1,121 @@
+package config
+
+// Layered config files.
+//
+// Every config file can be followed by overlays sitting next to it. For a base file
+// echopilot.json and profile prod, the layers are read in this order, each one merged
+// over the last:
+//
+//	echopilot.json        shared base, required
+//	echopilot.prod.json   profile overlay, optional
+//	echopilot.local.json  machine local overrides, optional and usually not committed
+//
+// Overlays which do not exist are skipped, but any file which exists has to load.
+// When several base files are given, each is followed by its own overlays before the
+// next base file is read.
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/brnsampson/echopilot/pkg/option"
+	"github.com/charmbracelet/log"
+)
+
+const localOverlay = "local"
+
+var validProfile = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+type configLayer struct {
+	file string
+	// Overlays are skipped when they do not exist. Base files are not.
+	overlay bool
+	profile bool
+}
+
+func validateProfile(profile string) error {
+	if profile == "" {
+		return nil
+	}
+	if !validProfile.MatchString(profile) {
+		return fmt.Errorf("profile %q may only contain letters, digits, '-' and '_'", profile)
+	}
+	if profile == localOverlay {
+		return fmt.Errorf("profile %q is reserved for local override files", profile)
+	}
+	return nil
+}
+
+// overlayFile returns the overlay of base with the given name, e.g. echopilot.prod.json
+// for echopilot.json.
+func overlayFile(base, name string) string {
+	ext := filepath.Ext(base)
+	return strings.TrimSuffix(base, ext) + "." + name + ext
+}
+
+// configLayers lists every file that makes up the config, lowest precedence first.
+func configLayers(files []string, profile string) []configLayer {
+	layers := make([]configLayer, 0, len(files)*3)
+	for _, file := range files {
+		layers = append(layers, configLayer{file: file})
+		if profile != "" {
+			layers = append(layers, configLayer{file: overlayFile(file, profile), overlay: true, profile: true})
+		}
+		layers = append(layers, configLayer{file: overlayFile(file, localOverlay), overlay: true})
+	}
+	return layers
+}
+
+// defaultConfigFiles falls back to the default config file, but only if it exists.
+func defaultConfigFiles(files []string) []string {
+	if len(files) > 0 {
+		return files
+	}
+	if _, err := os.Stat(DEFAULT_CONFIG_FILE); err == nil {
+		return []string{DEFAULT_CONFIG_FILE}
+	}
+	return nil
+}
+
+// withFiles merges every config file layer over conf and records which files were read.
+func (conf ReloadableConfig) withFiles() (ReloadableConfig, error) {
+	profile := conf.Profile.GetOr("")
+	if err := validateProfile(profile); err != nil {
+		return conf, err
+	}
+
+	conf.layers = nil
+	foundProfile := false
+	for _, layer := range configLayers(defaultConfigFiles(conf.ConfigFiles), profile) {
+		if layer.overlay {
+			if _, err := os.Stat(layer.file); errors.Is(err, os.ErrNotExist) {
+				log.Debug("Skipping missing config overlay", "filename", layer.file)
+				continue
+			}
+			foundProfile = foundProfile || layer.profile
+		}
+
+		// A file which is there but does not load would silently drop its settings, so
+		// that fails the whole config, whether it is a base file or an overlay.
+		fileConf, err := NewReloadableConfigFromFile(layer.file)
+		if err != nil {
+			return conf, fmt.Errorf("loading config file %s: %w", layer.file, err)
+		}
+
+		// Files cannot change which files are read.
+		fileConf.ConfigFiles = nil
+		fileConf.Profile = option.None[string]()
+
+		conf = conf.withMerge(fileConf)
+		conf.layers = append(conf.layers, layer.file)
+	}
+
+	if profile != "" && !foundProfile {
+		log.Warn("No overlay found for config profile", "profile", profile, "files", conf.ConfigFiles)
+	}
+	return conf, nil
+}
@@ -0,0 +1,115 @@
+package config_test
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/brnsampson/echopilot/pkg/config"
+	"github.com/charmbracelet/log"
+	"github.com/spf13/pflag"
+)
+
+func layeredServerConfig(t *testing.T, profile string, files map[string]string, base ...string) (*config.ServerConfig, error) {
+	dir := t.TempDir()
+	for name, contents := range files {
+		ok(t, os.WriteFile(filepath.Join(dir, name), []byte(contents), 0640))
+	}
+
+	paths := make([]string, 0, len(base))
+	for _, b := range base {
+		paths = append(paths, filepath.Join(dir, b))
+	}
+
+	flags := pflag.NewFlagSet("test", pflag.ContinueOnError)
+	flags.StringSlice("config", paths, "")
+	flags.String("profile", profile, "")
+	flags.Bool("tlsEnabled", false, "")
+	return config.NewServerConfig(flags, log.New(io.Discard))
+}
+
+func TestProfileOverlays(t *testing.T) {
+	files := map[string]string{
+		"echopilot.json":         `{"serverPort": 4000, "serverHost": "base.test", "bindHost": "127.0.0.2"}`,
+		"echopilot.prod.json":    `{"serverPort": 4001, "serverHost": "prod.test"}`,
+		"echopilot.staging.json": `{"serverPort": 4002}`,
+		"echopilot.local.json":   `{"serverHost": "local.test"}`,
+	}
+
+	conf, err := layeredServerConfig(t, "prod", files, "echopilot.json")
+	ok(t, err)
+	static := conf.GetStaticConfig()
+	equals(t, "prod", static.Profile)
+	equals(t, 4001, static.Port)
+	equals(t, "local.test", static.Host)
+	equals(t, "127.0.0.2", static.IP)
+	equals(t, 3, len(static.ConfigLayers))
+	equals(t, "echopilot.local.json", filepath.Base(static.ConfigLayers[2]))
+
+	// Without a profile only the base and local files apply.
+	conf, err = layeredServerConfig(t, "", files, "echopilot.json")
+	ok(t, err)
+	equals(t, 4000, conf.GetStaticConfig().Port)
+	equals(t, "local.test", conf.GetStaticConfig().Host)
+}
+
+func TestMultipleConfigFiles(t *testing.T) {
+	files := map[string]string{
+		"shared.json":      `{"serverPort": 4000, "serverHost": "shared.test"}`,
+		"shared.prod.json": `{"serverPort": 4001}`,
+		"service.json":     `{"serverHost": "service.test"}`,
+		// Files cannot redirect which files are read.
+		"service.prod.json": `{"profile": "staging", "configFiles": ["/nowhere.json"]}`,
+	}
+
+	conf, err := layeredServerConfig(t, "prod", files, "shared.json", "service.json")
+	ok(t, err)
+	static := conf.GetStaticConfig()
+	equals(t, 4001, static.Port)
+	equals(t, "service.test", static.Host)
+	equals(t, "prod", static.Profile)
+	equals(t, 2, len(static.ConfigFiles))
+	equals(t, 4, len(static.ConfigLayers))
+}
+
+func TestInvalidProfile(t *testing.T) {
+	for _, profile := range []string{"local", "../prod", "prod.json"} {
+		_, err := layeredServerConfig(t, profile, map[string]string{"echopilot.json": `{}`}, "echopilot.json")
+		assert(t, err != nil, "expected profile %q to be rejected", profile)
+	}
+}
+
+func TestInvalidConfigFiles(t *testing.T) {
+	for _, files := range []map[string]string{
+		{},
+		{"echopilot.json": `{"serverPort": `},
+		{"echopilot.json": `{"serverPort": "4000"}`},
+		{"echopilot.json": `{}`, "echopilot.prod.json": `{"serverPort": `},
+		{"echopilot.json": `{}`, "echopilot.local.json": `[]`},
+		// Renamed to configFiles.
+		{"echopilot.json": `{"configFile": "echopilot.json"}`},
+	} {
+		_, err := layeredServerConfig(t, "prod", files, "echopilot.json")
+		assert(t, err != nil, "expected %v to be rejected", files)
+	}
+}
+
+func TestApplyPatchPersistToOverlay(t *testing.T) {
+	files := map[string]string{
+		"echopilot.json":      `{"serverPort": 4000}`,
+		"echopilot.prod.json": `{"serverPort": 4001}`,
+	}
+	conf, err := layeredServerConfig(t, "prod", files, "echopilot.json")
+	ok(t, err)
+
+	// The profile overlay is the highest precedence file, so the patch goes there and is
+	// not shadowed on restart.
+	_, err = conf.ApplyPatch([]byte(`{"serverPort": 4003}`), true)
+	ok(t, err)
+	layers := conf.GetStaticConfig().ConfigLayers
+	data, err := os.ReadFile(layers[len(layers)-1])
+	ok(t, err)
+	assert(t, filepath.Base(layers[len(layers)-1]) == "echopilot.prod.json", "patch persisted to %s", layers[len(layers)-1])
+	assert(t, string(data) == "{\n  \"serverPort\": 4003\n}\n", "unexpected overlay contents %q", data)
+}
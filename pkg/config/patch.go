@@ -0,0 +1,278 @@
+package config
+
+// Runtime changes to the configuration.
+//
+// A patch is a partial JSON document using the same keys as the config file. Patches are
+// layered over flags, environment and config file, so they survive reloads until the
+// process restarts. They can optionally be persisted back to the config file so they
+// also survive restarts.
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/brnsampson/echopilot/pkg/auth"
+	"github.com/brnsampson/echopilot/pkg/certstore"
+	"github.com/brnsampson/echopilot/pkg/signing"
+)
+
+// Fields that make no sense to change through a patch.
+var unpatchableFields = map[string]bool{
+	"configFiles":  true,
+	"profile":      true,
+	"configLayers": true,
+}
+
+// ValidationError lists every problem found with a config rather than just the first.
+type ValidationError struct {
+	Problems []string
+}
+
+func (e *ValidationError) Error() string {
+	return "invalid config: " + strings.Join(e.Problems, "; ")
+}
+
+// Validate checks that the config could actually be served, including that every
+// configured certificate can be loaded.
+func (c StaticConfig) Validate() error {
+	return c.validate(true)
+}
+
+// validate checks the config, and with load set also loads every certificate, keyring
+// and keys file it names into scratch copies. Reloads load them anyway, so they skip
+// that.
+func (c StaticConfig) validate(load bool) error {
+	problems := make([]string, 0)
+
+	if c.Port < 1 || c.Port > 65535 {
+		problems = append(problems, fmt.Sprintf("serverPort %d is out of range", c.Port))
+	}
+
+	if c.IP != "" && net.ParseIP(c.IP) == nil {
+		problems = append(problems, fmt.Sprintf("bindHost %q is not an IP address", c.IP))
+	}
+
+	if c.TlsExpiryCriticalDays < 0 || c.TlsExpiryWarnDays < c.TlsExpiryCriticalDays {
+		problems = append(problems, "tlsExpiryWarnDays must be at least tlsExpiryCriticalDays, which must not be negative")
+	}
+
+	if load && c.TlsEnabled && !c.Dev {
+		// Load into a throwaway store so that nothing being served is touched.
+		scratch := certstore.New()
+		def := certstore.KeyPair{CertFile: c.TlsCert, KeyFile: c.TlsKey}
+		if err := scratch.Load(def, c.TlsCertificates...); err != nil {
+			problems = append(problems, err.Error())
+		}
+	}
+
+	if load && c.TlsEnabled && c.TlsClientCA != "" {
+		if err := certstore.New().LoadClientCAs(c.TlsClientCA); err != nil {
+			problems = append(problems, err.Error())
+		}
+	}
+
+	if c.JwtLifetimeMinutes < 1 {
+		problems = append(problems, "jwtLifetimeMinutes must be at least 1")
+	}
+
+	if (c.JwtRemoteIssuer == "") != (c.JwtRemoteJwksURL == "") {
+		problems = append(problems, "jwtRemoteIssuer and jwtRemoteJwksUrl must be set together")
+	} else if c.JwtRemoteJwksURL != "" {
+		if u, err := url.Parse(c.JwtRemoteJwksURL); err != nil || u.Scheme != "https" || u.Host == "" {
+			problems = append(problems, fmt.Sprintf("jwtRemoteJwksUrl %q must be an https URL", c.JwtRemoteJwksURL))
+		}
+	}
+
+	if c.JwtRemoteIssuer != "" && c.JwtRemoteIssuer == c.JwtIssuer {
+		problems = append(problems, "jwtRemoteIssuer must differ from jwtIssuer")
+	}
+
+	if load && c.SigningKeyring != "" {
+		if err := signing.NewKeyring().Load(c.SigningKeyring, c.keyPassphrase()); err != nil {
+			problems = append(problems, err.Error())
+		}
+	}
+
+	if c.HttpSigResponses && c.SigningKeyring == "" {
+		problems = append(problems, "httpSigResponses requires signingKeyring")
+	}
+
+	if c.HttpSigRequired && c.HttpSigClientKeyring == "" {
+		problems = append(problems, "httpSigRequired requires httpSigClientKeyring")
+	}
+
+	if load && c.HttpSigClientKeyring != "" {
+		if err := signing.NewKeyring().Load(c.HttpSigClientKeyring, nil); err != nil {
+			problems = append(problems, err.Error())
+		}
+	}
+
+	if c.AuthRequired && c.AuthKeysFile == "" && !c.AuthJwt && c.HttpSigClientKeyring == "" {
+		problems = append(problems, "authRequired requires authKeysFile, authJwt or httpSigClientKeyring")
+	}
+
+	if load && c.AuthKeysFile != "" {
+		if err := auth.NewKeySet().Load(c.AuthKeysFile); err != nil {
+			problems = append(problems, err.Error())
+		}
+	}
+
+	if c.AuthJwt && c.SigningKeyring == "" && c.JwtRemoteJwksURL == "" {
+		problems = append(problems, "authJwt requires signingKeyring or jwtRemoteJwksUrl")
+	}
+
+	for _, route := range c.AuthPublicRoutes {
+		if !strings.HasPrefix(route, "/") && !strings.Contains(route, "/") {
+			problems = append(problems, fmt.Sprintf("authPublicRoutes entry %q is neither a path nor a Connect procedure", route))
+		}
+	}
+
+	switch c.AccessLogFormat {
+	case "text", "logfmt", "json":
+	default:
+		problems = append(problems, fmt.Sprintf("accessLogFormat must be text, logfmt or json, not %q", c.AccessLogFormat))
+	}
+
+	for _, prefix := range c.AccessLogExclude {
+		if !strings.HasPrefix(prefix, "/") {
+			problems = append(problems, fmt.Sprintf("accessLogExclude path %q must start with /", prefix))
+		}
+	}
+
+	for name, limit := range c.RateLimits {
+		if limit.Rate <= 0 || limit.Burst < 0 {
+			problems = append(problems, fmt.Sprintf("rateLimits[%s]: rate must be positive and burst must not be negative", name))
+		}
+		switch limit.By {
+		case "", "ip", "apiKey", "identity":
+		default:
+			problems = append(problems, fmt.Sprintf("rateLimits[%s]: by must be ip, apiKey or identity, not %q", name, limit.By))
+		}
+	}
+
+	if _, err := parseNets(c.RequestIDTrustedNets); err != nil {
+		problems = append(problems, fmt.Sprintf("requestIdTrustedNets: %v", err))
+	}
+
+	for prefix, rate := range c.AccessLogSample {
+		if !strings.HasPrefix(prefix, "/") || rate < 0 || rate > 1 {
+			problems = append(problems, fmt.Sprintf("accessLogSample %q must map a path starting with / to a rate between 0 and 1", prefix))
+		}
+	}
+
+	if len(problems) > 0 {
+		return &ValidationError{problems}
+	}
+	return nil
+}
+
+// decodePatch parses a patch both into a ReloadableConfig and into its raw fields so
+// the latter can be written back to a config file untouched.
+func decodePatch(data []byte) (ReloadableConfig, map[string]json.RawMessage, error) {
+	patch := emptyReloadableConfig()
+
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return patch, nil, &ValidationError{[]string{"patch must be a JSON object: " + err.Error()}}
+	}
+
+	problems := make([]string, 0)
+	for field := range raw {
+		if unpatchableFields[field] {
+			problems = append(problems, fmt.Sprintf("%s cannot be patched", field))
+		}
+	}
+	if len(problems) > 0 {
+		sort.Strings(problems)
+		return patch, nil, &ValidationError{problems}
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&patch); err != nil {
+		return patch, nil, &ValidationError{[]string{err.Error()}}
+	}
+
+	return patch, raw, nil
+}
+
+// persistFile is the file a patch is persisted to: the last config layer which was read,
+// or the last base file if none could be read yet.
+func (c StaticConfig) persistFile() string {
+	if len(c.ConfigLayers) > 0 {
+		return c.ConfigLayers[len(c.ConfigLayers)-1]
+	}
+	if len(c.ConfigFiles) > 0 {
+		return c.ConfigFiles[len(c.ConfigFiles)-1]
+	}
+	return ""
+}
+
+// persistPatch overlays the patched fields on the config file, leaving every other
+// field exactly as it was. The file is replaced atomically.
+func persistPatch(file string, patch map[string]json.RawMessage) error {
+	current := make(map[string]json.RawMessage)
+	perms := os.FileMode(0644)
+
+	data, err := os.ReadFile(file)
+	if err == nil {
+		if err := json.Unmarshal(data, &current); err != nil {
+			return fmt.Errorf("config file %s is not a JSON object: %w", file, err)
+		}
+		if info, err := os.Stat(file); err == nil {
+			perms = info.Mode().Perm()
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	for field, value := range patch {
+		current[field] = value
+	}
+
+	out, err := json.MarshalIndent(current, "", "  ")
+	if err != nil {
+		return err
+	}
+	out = append(out, '\n')
+
+	tmp, err := os.CreateTemp(filepath.Dir(file), "."+filepath.Base(file)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(out); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(perms); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), file)
+}
+
+// parseNets parses a list of CIDRs, failing on the first invalid one.
+func parseNets(cidrs []string) ([]*net.IPNet, error) {
+	nets := make([]*net.IPNet, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, err
+		}
+		nets = append(nets, network)
+	}
+	return nets, nil
+}
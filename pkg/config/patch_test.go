@@ -0,0 +1,143 @@
+package config_test
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"testing"
+
+	"github.com/brnsampson/echopilot/pkg/config"
+	"github.com/charmbracelet/log"
+	"github.com/spf13/pflag"
+)
+
+// assert fails the test if the condition is false.
+func assert(tb testing.TB, condition bool, msg string, v ...interface{}) {
+	if !condition {
+		_, file, line, _ := runtime.Caller(1)
+		fmt.Printf("\033[31m%s:%d: "+msg+"\033[39m\n\n", append([]interface{}{filepath.Base(file), line}, v...)...)
+		tb.FailNow()
+	}
+}
+
+// ok fails the test if an err is not nil.
+func ok(tb testing.TB, err error) {
+	if err != nil {
+		_, file, line, _ := runtime.Caller(1)
+		fmt.Printf("\033[31m%s:%d: unexpected error: %s\033[39m\n\n", filepath.Base(file), line, err.Error())
+		tb.FailNow()
+	}
+}
+
+// equals fails the test if exp is not equal to act.
+func equals(tb testing.TB, exp, act interface{}) {
+	if !reflect.DeepEqual(exp, act) {
+		_, file, line, _ := runtime.Caller(1)
+		fmt.Printf("\033[31m%s:%d:\n\n\texp: %#v\n\n\tgot: %#v\033[39m\n\n", filepath.Base(file), line, exp, act)
+		tb.FailNow()
+	}
+}
+
+// testServerConfig builds a ServerConfig backed by a config file with TLS disabled.
+func testServerConfig(t *testing.T, fileContents string) (*config.ServerConfig, string) {
+	file := filepath.Join(t.TempDir(), "echopilot.json")
+	ok(t, os.WriteFile(file, []byte(fileContents), 0640))
+
+	flags := pflag.NewFlagSet("test", pflag.ContinueOnError)
+	flags.StringSlice("config", []string{file}, "")
+	flags.Bool("tlsEnabled", false, "")
+
+	conf, err := config.NewServerConfig(flags, log.New(io.Discard))
+	ok(t, err)
+	return conf, file
+}
+
+func TestApplyPatch(t *testing.T) {
+	conf, _ := testServerConfig(t, `{"serverPort": 4000}`)
+	equals(t, 4000, conf.GetStaticConfig().Port)
+
+	next, err := conf.ApplyPatch([]byte(`{"serverPort": 4001}`), false)
+	ok(t, err)
+	equals(t, 4001, next.Port)
+
+	// Patches only take effect once the server reloads.
+	equals(t, 4000, conf.GetStaticConfig().Port)
+	_, err = conf.GetAddr(true)
+	ok(t, err)
+	equals(t, 4001, conf.GetStaticConfig().Port)
+}
+
+func TestApplyPatchRejectsInvalid(t *testing.T) {
+	conf, _ := testServerConfig(t, `{"serverPort": 4000}`)
+
+	cases := []string{
+		`{"serverPort": 70000}`,
+		`{"configFiles": ["/somewhere/else.json"]}`,
+		`{"profile": "prod"}`,
+		`{"notAField": true}`,
+		`{"tlsExpiryWarnDays": 1, "tlsExpiryCriticalDays": 5}`,
+		`{"tlsEnabled": true, "tlsCert": "/does/not/exist.pem"}`,
+		`{"jwtLifetimeMinutes": 0}`,
+		`{"jwtRemoteIssuer": "partner"}`,
+		`{"jwtRemoteIssuer": "partner", "jwtRemoteJwksUrl": "http://partner.test/.well-known/jwks.json"}`,
+		`{"jwtRemoteIssuer": "echopilot", "jwtRemoteJwksUrl": "https://partner.test/.well-known/jwks.json"}`,
+		`{"httpSigResponses": true}`,
+		`{"httpSigRequired": true}`,
+		`{"httpSigClientKeyring": "/does/not/exist"}`,
+		`{"accessLogFormat": "xml"}`,
+		`{"requestIdTrustedNets": ["10.0.0.1"]}`,
+		`{"rateLimits": {"*": {"rate": 0}}}`,
+		`{"rateLimits": {"*": {"rate": 1, "by": "cookie"}}}`,
+		`{"authRequired": true}`,
+		`{"authKeysFile": "/does/not/exist.json"}`,
+		`{"authJwt": true}`,
+		`{"authPublicRoutes": ["health"]}`,
+		`{"accessLogExclude": ["health"]}`,
+		`{"accessLogSample": {"/echo.v1.EchoService": 1.5}}`,
+		`[1, 2, 3]`,
+	}
+	for _, c := range cases {
+		_, err := conf.ApplyPatch([]byte(c), false)
+		var invalid *config.ValidationError
+		assert(t, errors.As(err, &invalid), "expected a validation error for %s, got %v", c, err)
+	}
+
+	// None of the rejected patches may leak into the next reload.
+	_, err := conf.GetAddr(true)
+	ok(t, err)
+	equals(t, 4000, conf.GetStaticConfig().Port)
+}
+
+func TestApplyPatchPersist(t *testing.T) {
+	conf, file := testServerConfig(t, `{"serverPort": 4000, "serverHost": "example.test"}`)
+
+	_, err := conf.ApplyPatch([]byte(`{"serverPort": 4002}`), true)
+	ok(t, err)
+
+	data, err := os.ReadFile(file)
+	ok(t, err)
+	var persisted map[string]interface{}
+	ok(t, json.Unmarshal(data, &persisted))
+	equals(t, float64(4002), persisted["serverPort"])
+	equals(t, "example.test", persisted["serverHost"])
+
+	info, err := os.Stat(file)
+	ok(t, err)
+	equals(t, os.FileMode(0640), info.Mode().Perm())
+}
+
+func TestRedacted(t *testing.T) {
+	conf, _ := testServerConfig(t, `{"adminToken": "hunter2"}`)
+
+	static := conf.GetStaticConfig()
+	equals(t, "hunter2", static.AdminToken)
+	equals(t, config.REDACTED, static.Redacted().AdminToken)
+
+	static.AdminToken = ""
+	equals(t, "", static.Redacted().AdminToken)
+}
@@ -0,0 +1,46 @@
+package config
+
+import (
+	"reflect"
+
+	"github.com/brnsampson/echopilot/pkg/option"
+)
+
+const REDACTED = "[REDACTED]"
+
+// Redacted returns a copy of the config which is safe to log or return from an API.
+func (c StaticConfig) Redacted() StaticConfig {
+	return redact(c)
+}
+
+// Redacted returns a copy of the config which is safe to log or return from an API.
+func (r ReloadableConfig) Redacted() ReloadableConfig {
+	return redact(r)
+}
+
+func isSecret(f reflect.StructField) bool {
+	return f.Tag.Get("secret") == "true"
+}
+
+// redact replaces every non-empty string field tagged `secret:"true"`.
+func redact[T any](conf T) T {
+	v := reflect.ValueOf(&conf).Elem()
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		if !isSecret(t.Field(i)) {
+			continue
+		}
+
+		switch f := v.Field(i).Interface().(type) {
+		case string:
+			if f != "" {
+				v.Field(i).SetString(REDACTED)
+			}
+		case option.Option[string]:
+			if f.IsSome() {
+				v.Field(i).Set(reflect.ValueOf(option.Some(REDACTED)))
+			}
+		}
+	}
+	return conf
+}
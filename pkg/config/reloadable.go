@@ -5,7 +5,10 @@ import (
 	"errors"
 	"os"
 
+	"github.com/brnsampson/echopilot/pkg/certstore"
+	"github.com/brnsampson/echopilot/pkg/jwt"
 	"github.com/brnsampson/echopilot/pkg/option"
+	"github.com/brnsampson/echopilot/pkg/signing"
 
 	"github.com/caarlos0/env"
 	"github.com/spf13/pflag"
@@ -20,22 +23,112 @@ const DEFAULT_TLS_ENABLED = true
 const DEFAULT_TLS_SKIP_VERIFY = false
 const DEFAULT_TLS_CERT = "/etc/echopilot/tls/cert.pem"
 const DEFAULT_TLS_KEY = "/etc/echopilot/tls/key.pem"
+const DEFAULT_TLS_CLIENT_CA = ""
+const DEFAULT_TLS_EXPIRY_WARN_DAYS = 30
+const DEFAULT_TLS_EXPIRY_CRITICAL_DAYS = 7
+const DEFAULT_DEV = false
+const DEFAULT_DEV_CERT_DIR = ""
+const DEFAULT_ADMIN_TOKEN = ""
+const DEFAULT_SIGNING_KEYRING = ""
+const DEFAULT_SIGNING_PASSPHRASE = ""
+const DEFAULT_SIGNING_PASSPHRASE_FILE = ""
+const DEFAULT_JWT_ISSUER = "echopilot"
+const DEFAULT_JWT_AUDIENCE = ""
+const DEFAULT_JWT_LIFETIME_MINUTES = 15
+const DEFAULT_JWT_REMOTE_ISSUER = ""
+const DEFAULT_JWT_REMOTE_JWKS_URL = ""
+const DEFAULT_HTTPSIG_RESPONSES = false
+const DEFAULT_HTTPSIG_CLIENT_KEYRING = ""
+const DEFAULT_HTTPSIG_REQUIRED = false
+const DEFAULT_AUTH_KEYS_FILE = ""
+const DEFAULT_AUTH_REQUIRED = false
+const DEFAULT_AUTH_JWT = false
+const DEFAULT_ACCESS_LOG_FORMAT = "text"
+const DEFAULT_METRICS_PATH = "/metrics"
+const DEFAULT_TRACING_EXPORTER = "none"
+const DEFAULT_TRACING_ENDPOINT = "http://localhost:4318"
+const DEFAULT_TRACING_FILE = ""
+const DEFAULT_TRACING_SAMPLE_RATIO = 1.0
+
+// Loopback peers are trusted by default, so the UI's own RPC calls keep their request ID.
+var DEFAULT_REQUEST_ID_TRUSTED_NETS = []string{"127.0.0.0/8", "::1/128"}
+
+// The UI and what load balancers and clients need before they can authenticate.
+var DEFAULT_AUTH_PUBLIC_ROUTES = []string{"/", "/echo", "/echo/{content}", "/health", jwt.JWKSPath}
+
+// RateLimit allows Rate requests per second with bursts of up to Burst requests, counted
+// separately for every client. Clients are told apart by By: ip (default), apiKey or
+// identity, which is the subject of a verified client certificate. Clients without an
+// API key or certificate are counted by IP.
+type RateLimit struct {
+	Rate float64 `json:"rate"`
+	// Defaults to the rate rounded up.
+	Burst int    `json:"burst,omitempty"`
+	By    string `json:"by,omitempty"`
+}
 
+// The fully resolved configuration. Field names in JSON match ReloadableConfig so the
+// output of one can be fed back in as the other. Fields tagged secret are redacted
+// whenever the config is displayed, and fields tagged `reload:"restart"` keep their
+// startup value until the process restarts.
 type StaticConfig struct {
-	ConfigFile         string
-	Host               string
-	IP                 string
-	Port               int
-	TlsCert            string
-	TlsKey             string
-	TlsEnabled         bool
-	TlsSkipVerify      bool
+	ConfigFiles        []string            `json:"configFiles" reload:"restart"`
+	Profile            string              `json:"profile" reload:"restart"`
+	// Every config file which was read, lowest precedence first.
+	ConfigLayers       []string            `json:"configLayers"`
+	Host               string              `json:"serverHost"`
+	IP                 string              `json:"bindHost"`
+	Port               int                 `json:"serverPort"`
+	TlsCert            string              `json:"tlsCert"`
+	TlsKey             string              `json:"tlsKey"`
+	TlsEnabled         bool                `json:"tlsEnabled"`
+	TlsSkipVerify      bool                `json:"tlsSkipVerify"`
+	TlsCertificates    []certstore.KeyPair `json:"tlsCertificates"`
+	TlsClientCA        string              `json:"tlsClientCA"`
+	TlsExpiryWarnDays     int              `json:"tlsExpiryWarnDays"`
+	TlsExpiryCriticalDays int              `json:"tlsExpiryCriticalDays"`
+	Dev                bool                `json:"dev" reload:"restart"`
+	DevCertDir         string              `json:"devCertDir" reload:"restart"`
+	AdminToken         string              `json:"adminToken" secret:"true"`
+	SigningKeyring     string              `json:"signingKeyring"`
+	SigningPassphrase  string              `json:"signingPassphrase" secret:"true"`
+	SigningPassphraseFile string           `json:"signingPassphraseFile"`
+	JwtIssuer          string              `json:"jwtIssuer"`
+	JwtAudience        string              `json:"jwtAudience"`
+	JwtLifetimeMinutes int                 `json:"jwtLifetimeMinutes"`
+	JwtClaims          map[string]interface{} `json:"jwtClaims"`
+	JwtRemoteIssuer    string              `json:"jwtRemoteIssuer"`
+	JwtRemoteJwksURL   string              `json:"jwtRemoteJwksUrl"`
+	HttpSigResponses   bool                `json:"httpSigResponses"`
+	HttpSigClientKeyring string            `json:"httpSigClientKeyring"`
+	HttpSigRequired    bool                `json:"httpSigRequired"`
+	AuthKeysFile       string              `json:"authKeysFile"`
+	AuthRequired       bool                `json:"authRequired"`
+	AuthJwt            bool                `json:"authJwt"`
+	AuthPublicRoutes   []string            `json:"authPublicRoutes"`
+	Features           map[string]map[string]interface{} `json:"features"`
+	// Nil enables every registered feature.
+	EnabledFeatures    []string            `json:"enabledFeatures" reload:"restart"`
+	FeaturePrefixes    map[string]string   `json:"featurePrefixes" reload:"restart"`
+	AccessLogFormat    string              `json:"accessLogFormat"`
+	AccessLogExclude   []string            `json:"accessLogExclude"`
+	AccessLogSample    map[string]float64  `json:"accessLogSample"`
+	RequestIDTrustedNets []string          `json:"requestIdTrustedNets"`
+	// Empty if metrics are only served by the admin API.
+	MetricsPath        string              `json:"metricsPath" reload:"restart"`
+	RateLimits         map[string]RateLimit `json:"rateLimits"`
+	TracingExporter    string              `json:"tracingExporter" reload:"restart"`
+	TracingEndpoint    string              `json:"tracingEndpoint" reload:"restart"`
+	TracingFile        string              `json:"tracingFile" reload:"restart"`
+	TracingSampleRatio float64             `json:"tracingSampleRatio" reload:"restart"`
 }
 
 
 // Generic server configuration which can be reloaded on demand.
 type ReloadableConfig struct {
-	ConfigFile         option.Option[string] `json:"configFile" env:"ECHOPILOT_CONFIG_FILE"`
+	// Base config files, each followed by its profile and local overlays. See layers.go.
+	ConfigFiles        []string              `json:"configFiles" env:"ECHOPILOT_CONFIG_FILE"`
+	Profile            option.Option[string] `json:"profile" env:"ECHOPILOT_PROFILE"`
 	Host               option.Option[string] `json:"serverHost" env:"ECHOPILOT_HOST"`
 	IP                 option.Option[string] `json:"bindHost" env:"ECHOPILOT_BIND_IP"`
 	Port               option.Option[int]    `json:"serverPort" env:"ECHOPILOT_PORT"`
@@ -43,11 +136,89 @@ type ReloadableConfig struct {
 	TlsKey             option.Option[string] `json:"tlsKey" env:"ECHOPILOT_TLS_KEY"`
 	TlsEnabled         option.Option[bool]   `json:"tlsEnabled" env:"ECHOPILOT_TLS_ENABLED"`
 	TlsSkipVerify      option.Option[bool]   `json:"tlsSkipVerify" env:"ECHOPILOT_TLS_SKIP_VERIFY"`
+	// Additional certificates served by SNI. TlsCert/TlsKey remain the default pair.
+	TlsCertificates    []certstore.KeyPair   `json:"tlsCertificates"`
+	TlsClientCA        option.Option[string] `json:"tlsClientCA" env:"ECHOPILOT_TLS_CLIENT_CA"`
+	TlsExpiryWarnDays     option.Option[int] `json:"tlsExpiryWarnDays" env:"ECHOPILOT_TLS_EXPIRY_WARN_DAYS"`
+	TlsExpiryCriticalDays option.Option[int] `json:"tlsExpiryCriticalDays" env:"ECHOPILOT_TLS_EXPIRY_CRITICAL_DAYS"`
+	// Serve an ephemeral certificate signed by a local development CA instead of TlsCert/TlsKey.
+	Dev                option.Option[bool]   `json:"dev" env:"ECHOPILOT_DEV"`
+	// If set, the development CA and certificate are persisted here and reused on restart.
+	DevCertDir         option.Option[string] `json:"devCertDir" env:"ECHOPILOT_DEV_CERT_DIR"`
+	// Bearer token required by the admin API. The admin API is disabled while this is empty.
+	AdminToken         option.Option[string] `json:"adminToken" env:"ECHOPILOT_ADMIN_TOKEN" secret:"true"`
+	// Directory holding a keyring.json manifest and the keys it lists. See pkg/signing/keyring.go.
+	SigningKeyring     option.Option[string] `json:"signingKeyring" env:"ECHOPILOT_SIGNING_KEYRING"`
+	// Passphrase for encrypted keys in the keyring. SigningPassphraseFile wins if both are set.
+	SigningPassphrase  option.Option[string] `json:"signingPassphrase" env:"ECHOPILOT_SIGNING_PASSPHRASE" secret:"true"`
+	SigningPassphraseFile option.Option[string] `json:"signingPassphraseFile" env:"ECHOPILOT_SIGNING_PASSPHRASE_FILE"`
+	// iss claim of tokens signed with the keyring. See pkg/jwt.
+	JwtIssuer          option.Option[string] `json:"jwtIssuer" env:"ECHOPILOT_JWT_ISSUER"`
+	// If set, only tokens listing this audience are accepted.
+	JwtAudience        option.Option[string] `json:"jwtAudience" env:"ECHOPILOT_JWT_AUDIENCE"`
+	// Default lifetime of issued tokens.
+	JwtLifetimeMinutes option.Option[int]    `json:"jwtLifetimeMinutes" env:"ECHOPILOT_JWT_LIFETIME_MINUTES"`
+	// Claims added to every issued token.
+	JwtClaims          map[string]interface{} `json:"jwtClaims"`
+	// Also accept tokens from this issuer, verified with the keys published at JwtRemoteJwksURL.
+	JwtRemoteIssuer    option.Option[string] `json:"jwtRemoteIssuer" env:"ECHOPILOT_JWT_REMOTE_ISSUER"`
+	JwtRemoteJwksURL   option.Option[string] `json:"jwtRemoteJwksUrl" env:"ECHOPILOT_JWT_REMOTE_JWKS_URL"`
+	// Sign responses with the signing keyring (RFC 9421). See pkg/httpsig.
+	HttpSigResponses   option.Option[bool]   `json:"httpSigResponses" env:"ECHOPILOT_HTTPSIG_RESPONSES"`
+	// Keyring directory with the public keys of clients allowed to sign RPC requests.
+	HttpSigClientKeyring option.Option[string] `json:"httpSigClientKeyring" env:"ECHOPILOT_HTTPSIG_CLIENT_KEYRING"`
+	// Reject unsigned RPC requests. Otherwise only requests carrying a signature are checked.
+	HttpSigRequired    option.Option[bool]   `json:"httpSigRequired" env:"ECHOPILOT_HTTPSIG_REQUIRED"`
+	// JSON file with the hashes of the API keys and bearer tokens clients may use. See
+	// pkg/auth.
+	AuthKeysFile       option.Option[string] `json:"authKeysFile" env:"ECHOPILOT_AUTH_KEYS_FILE"`
+	// Reject requests without credentials, except on public routes. Otherwise only
+	// requests carrying credentials are checked.
+	AuthRequired       option.Option[bool]   `json:"authRequired" env:"ECHOPILOT_AUTH_REQUIRED"`
+	// Accept JWTs from the issuers trusted by the token verifier as bearer tokens.
+	AuthJwt            option.Option[bool]   `json:"authJwt" env:"ECHOPILOT_AUTH_JWT"`
+	// Route patterns, paths below which everything is public, and Connect procedures
+	// which need no credentials even with authRequired.
+	AuthPublicRoutes   []string              `json:"authPublicRoutes" env:"ECHOPILOT_AUTH_PUBLIC_ROUTES"`
+	// Config sections of features by feature name. See pkg/router.
+	Features           map[string]map[string]interface{} `json:"features"`
+	// Names of the features to construct and mount. Every registered feature is enabled
+	// if this is not set, an empty list disables all of them.
+	EnabledFeatures    []string              `json:"enabledFeatures" env:"ECHOPILOT_FEATURES"`
+	// Mount paths by feature name, overriding the default prefix of the feature.
+	FeaturePrefixes    map[string]string     `json:"featurePrefixes"`
+	// One of text, logfmt or json.
+	AccessLogFormat    option.Option[string] `json:"accessLogFormat" env:"ECHOPILOT_ACCESS_LOG_FORMAT"`
+	// Requests below these paths are never logged, e.g. /health.
+	AccessLogExclude   []string              `json:"accessLogExclude" env:"ECHOPILOT_ACCESS_LOG_EXCLUDE"`
+	// Fraction of requests below a path which are logged, e.g. {"/echo.v1.EchoService": 0.1}.
+	// The longest matching path wins. Server errors are always logged.
+	AccessLogSample    map[string]float64    `json:"accessLogSample"`
+	// Request IDs sent by peers in these networks are kept, everyone else gets a new one.
+	// An empty list trusts nobody. See pkg/requestid.
+	RequestIDTrustedNets []string            `json:"requestIdTrustedNets" env:"ECHOPILOT_REQUEST_ID_TRUSTED_NETS"`
+	// Limits by Connect procedure (echo.v1.EchoService/EchoString), route pattern
+	// (/echo/{content}) or * for every request without a limit of its own.
+	RateLimits         map[string]RateLimit  `json:"rateLimits"`
+	// Where Prometheus metrics are served without authentication. An empty path leaves
+	// them to /admin/metrics.
+	MetricsPath        option.Option[string] `json:"metricsPath" env:"ECHOPILOT_METRICS_PATH"`
+	// Where spans go: none, otlp, stdout or file. See pkg/tracing.
+	TracingExporter    option.Option[string] `json:"tracingExporter" env:"ECHOPILOT_TRACING_EXPORTER"`
+	// URL of the OTLP/HTTP collector, e.g. http://localhost:4318.
+	TracingEndpoint    option.Option[string] `json:"tracingEndpoint" env:"ECHOPILOT_TRACING_ENDPOINT"`
+	// Spans are appended to this file as JSON lines by the file exporter.
+	TracingFile        option.Option[string] `json:"tracingFile" env:"ECHOPILOT_TRACING_FILE"`
+	// Fraction of new traces which are sampled. Traces started by a caller follow its decision.
+	TracingSampleRatio option.Option[float64] `json:"tracingSampleRatio" env:"ECHOPILOT_TRACING_SAMPLE_RATIO"`
+
+	// Set by withFiles.
+	layers []string
 }
 
 func emptyReloadableConfig() ReloadableConfig {
     return ReloadableConfig {
-        ConfigFile: option.None[string](),
+        Profile: option.None[string](),
         Host: option.None[string](),
         IP: option.None[string](),
         Port: option.None[int](),
@@ -55,20 +226,38 @@ func emptyReloadableConfig() ReloadableConfig {
         TlsKey: option.None[string](),
         TlsEnabled: option.None[bool](),
         TlsSkipVerify: option.None[bool](),
+        TlsClientCA: option.None[string](),
+        TlsExpiryWarnDays: option.None[int](),
+        TlsExpiryCriticalDays: option.None[int](),
+        Dev: option.None[bool](),
+        DevCertDir: option.None[string](),
+        AdminToken: option.None[string](),
+        SigningKeyring: option.None[string](),
+        SigningPassphrase: option.None[string](),
+        SigningPassphraseFile: option.None[string](),
+        JwtIssuer: option.None[string](),
+        JwtAudience: option.None[string](),
+        JwtLifetimeMinutes: option.None[int](),
+        JwtRemoteIssuer: option.None[string](),
+        JwtRemoteJwksURL: option.None[string](),
+        HttpSigResponses: option.None[bool](),
+        HttpSigClientKeyring: option.None[string](),
+        HttpSigRequired: option.None[bool](),
+        AuthKeysFile: option.None[string](),
+        AuthRequired: option.None[bool](),
+        AuthJwt: option.None[bool](),
+        AccessLogFormat: option.None[string](),
+        MetricsPath: option.None[string](),
+        TracingExporter: option.None[string](),
+        TracingEndpoint: option.None[string](),
+        TracingFile: option.None[string](),
+        TracingSampleRatio: option.None[float64](),
     }
 }
 
 func (r *ReloadableConfig) Finalize() StaticConfig {
-    f := func() string {
-	    // We only want to default to using a config file if the default file exists
-	    if _, err := os.Stat(DEFAULT_CONFIG_FILE); err == nil {
-	    	// The file exists, so we default to using it.
-	    	return DEFAULT_CONFIG_FILE
-	    } else {
-            return ""
-        }
-    }
-    configFile := r.ConfigFile.UnwrapOrElse(f)
+    configFiles := defaultConfigFiles(r.ConfigFiles)
+    profile := r.Profile.UnwrapOrDefault("")
     host := r.Host.UnwrapOrDefault(DEFAULT_HOST)
     ip := r.IP.UnwrapOrDefault(DEFAULT_IP)
     port := r.Port.UnwrapOrDefault(DEFAULT_PORT)
@@ -76,9 +265,50 @@ func (r *ReloadableConfig) Finalize() StaticConfig {
     tlsKey := r.TlsKey.UnwrapOrDefault(DEFAULT_TLS_KEY)
     tlsEnabled := r.TlsEnabled.UnwrapOrDefault(DEFAULT_TLS_ENABLED)
     tlsSkipVerify := r.TlsSkipVerify.UnwrapOrDefault(DEFAULT_TLS_SKIP_VERIFY)
+    tlsClientCA := r.TlsClientCA.UnwrapOrDefault(DEFAULT_TLS_CLIENT_CA)
+    tlsExpiryWarnDays := r.TlsExpiryWarnDays.UnwrapOrDefault(DEFAULT_TLS_EXPIRY_WARN_DAYS)
+    tlsExpiryCriticalDays := r.TlsExpiryCriticalDays.UnwrapOrDefault(DEFAULT_TLS_EXPIRY_CRITICAL_DAYS)
+    dev := r.Dev.UnwrapOrDefault(DEFAULT_DEV)
+    devCertDir := r.DevCertDir.UnwrapOrDefault(DEFAULT_DEV_CERT_DIR)
+    adminToken := r.AdminToken.UnwrapOrDefault(DEFAULT_ADMIN_TOKEN)
+    signingKeyring := r.SigningKeyring.UnwrapOrDefault(DEFAULT_SIGNING_KEYRING)
+    signingPassphrase := r.SigningPassphrase.UnwrapOrDefault(DEFAULT_SIGNING_PASSPHRASE)
+    signingPassphraseFile := r.SigningPassphraseFile.UnwrapOrDefault(DEFAULT_SIGNING_PASSPHRASE_FILE)
+    jwtIssuer := r.JwtIssuer.UnwrapOrDefault(DEFAULT_JWT_ISSUER)
+    jwtAudience := r.JwtAudience.UnwrapOrDefault(DEFAULT_JWT_AUDIENCE)
+    jwtLifetimeMinutes := r.JwtLifetimeMinutes.UnwrapOrDefault(DEFAULT_JWT_LIFETIME_MINUTES)
+    jwtRemoteIssuer := r.JwtRemoteIssuer.UnwrapOrDefault(DEFAULT_JWT_REMOTE_ISSUER)
+    jwtRemoteJwksURL := r.JwtRemoteJwksURL.UnwrapOrDefault(DEFAULT_JWT_REMOTE_JWKS_URL)
+    httpSigResponses := r.HttpSigResponses.UnwrapOrDefault(DEFAULT_HTTPSIG_RESPONSES)
+    httpSigClientKeyring := r.HttpSigClientKeyring.UnwrapOrDefault(DEFAULT_HTTPSIG_CLIENT_KEYRING)
+    httpSigRequired := r.HttpSigRequired.UnwrapOrDefault(DEFAULT_HTTPSIG_REQUIRED)
+    authKeysFile := r.AuthKeysFile.UnwrapOrDefault(DEFAULT_AUTH_KEYS_FILE)
+    authRequired := r.AuthRequired.UnwrapOrDefault(DEFAULT_AUTH_REQUIRED)
+    authJwt := r.AuthJwt.UnwrapOrDefault(DEFAULT_AUTH_JWT)
+    accessLogFormat := r.AccessLogFormat.UnwrapOrDefault(DEFAULT_ACCESS_LOG_FORMAT)
+    metricsPath := r.MetricsPath.UnwrapOrDefault(DEFAULT_METRICS_PATH)
+    tracingExporter := r.TracingExporter.UnwrapOrDefault(DEFAULT_TRACING_EXPORTER)
+    tracingEndpoint := r.TracingEndpoint.UnwrapOrDefault(DEFAULT_TRACING_ENDPOINT)
+    tracingFile := r.TracingFile.UnwrapOrDefault(DEFAULT_TRACING_FILE)
+    tracingSampleRatio := r.TracingSampleRatio.UnwrapOrDefault(DEFAULT_TRACING_SAMPLE_RATIO)
+    requestIDTrustedNets := r.RequestIDTrustedNets
+    if requestIDTrustedNets == nil {
+        requestIDTrustedNets = DEFAULT_REQUEST_ID_TRUSTED_NETS
+    }
+    authPublicRoutes := r.AuthPublicRoutes
+    if authPublicRoutes == nil {
+        authPublicRoutes = DEFAULT_AUTH_PUBLIC_ROUTES
+    }
+
+    // Development mode is all about getting TLS without any setup, so it always enables it.
+    if dev {
+        tlsEnabled = true
+    }
 
     conf := StaticConfig {
-        ConfigFile: configFile,
+        ConfigFiles: configFiles,
+        Profile: profile,
+        ConfigLayers: r.layers,
         Host: host,
         IP: ip,
         Port: port,
@@ -86,14 +316,54 @@ func (r *ReloadableConfig) Finalize() StaticConfig {
         TlsKey: tlsKey,
         TlsEnabled: tlsEnabled,
         TlsSkipVerify: tlsSkipVerify,
+        TlsCertificates: r.TlsCertificates,
+        TlsClientCA: tlsClientCA,
+        TlsExpiryWarnDays: tlsExpiryWarnDays,
+        TlsExpiryCriticalDays: tlsExpiryCriticalDays,
+        Dev: dev,
+        DevCertDir: devCertDir,
+        AdminToken: adminToken,
+        SigningKeyring: signingKeyring,
+        SigningPassphrase: signingPassphrase,
+        SigningPassphraseFile: signingPassphraseFile,
+        JwtIssuer: jwtIssuer,
+        JwtAudience: jwtAudience,
+        JwtLifetimeMinutes: jwtLifetimeMinutes,
+        JwtClaims: r.JwtClaims,
+        JwtRemoteIssuer: jwtRemoteIssuer,
+        JwtRemoteJwksURL: jwtRemoteJwksURL,
+        HttpSigResponses: httpSigResponses,
+        HttpSigClientKeyring: httpSigClientKeyring,
+        HttpSigRequired: httpSigRequired,
+        AuthKeysFile: authKeysFile,
+        AuthRequired: authRequired,
+        AuthJwt: authJwt,
+        AuthPublicRoutes: authPublicRoutes,
+        Features: r.Features,
+        EnabledFeatures: r.EnabledFeatures,
+        FeaturePrefixes: r.FeaturePrefixes,
+        AccessLogFormat: accessLogFormat,
+        AccessLogExclude: r.AccessLogExclude,
+        AccessLogSample: r.AccessLogSample,
+        RequestIDTrustedNets: requestIDTrustedNets,
+        MetricsPath: metricsPath,
+        RateLimits: r.RateLimits,
+        TracingExporter: tracingExporter,
+        TracingEndpoint: tracingEndpoint,
+        TracingFile: tracingFile,
+        TracingSampleRatio: tracingSampleRatio,
     }
 
 	return conf
 }
 
 func (conf ReloadableConfig) withMerge(second ReloadableConfig) ReloadableConfig {
-	if second.ConfigFile.IsSome() {
-		conf.ConfigFile = second.ConfigFile
+	if second.ConfigFiles != nil {
+		conf.ConfigFiles = second.ConfigFiles
+	}
+
+	if second.Profile.IsSome() {
+		conf.Profile = second.Profile
 	}
 
 	if second.Host.IsSome() {
@@ -124,6 +394,174 @@ func (conf ReloadableConfig) withMerge(second ReloadableConfig) ReloadableConfig
 		conf.TlsSkipVerify = second.TlsSkipVerify
 	}
 
+	if second.TlsCertificates != nil {
+		conf.TlsCertificates = second.TlsCertificates
+	}
+
+	if second.TlsClientCA.IsSome() {
+		conf.TlsClientCA = second.TlsClientCA
+	}
+
+	if second.TlsExpiryWarnDays.IsSome() {
+		conf.TlsExpiryWarnDays = second.TlsExpiryWarnDays
+	}
+
+	if second.TlsExpiryCriticalDays.IsSome() {
+		conf.TlsExpiryCriticalDays = second.TlsExpiryCriticalDays
+	}
+
+	if second.Dev.IsSome() {
+		conf.Dev = second.Dev
+	}
+
+	if second.DevCertDir.IsSome() {
+		conf.DevCertDir = second.DevCertDir
+	}
+
+	if second.AdminToken.IsSome() {
+		conf.AdminToken = second.AdminToken
+	}
+
+	if second.SigningKeyring.IsSome() {
+		conf.SigningKeyring = second.SigningKeyring
+	}
+
+	if second.SigningPassphrase.IsSome() {
+		conf.SigningPassphrase = second.SigningPassphrase
+	}
+
+	if second.SigningPassphraseFile.IsSome() {
+		conf.SigningPassphraseFile = second.SigningPassphraseFile
+	}
+
+	if second.JwtIssuer.IsSome() {
+		conf.JwtIssuer = second.JwtIssuer
+	}
+
+	if second.JwtAudience.IsSome() {
+		conf.JwtAudience = second.JwtAudience
+	}
+
+	if second.JwtLifetimeMinutes.IsSome() {
+		conf.JwtLifetimeMinutes = second.JwtLifetimeMinutes
+	}
+
+	if second.JwtClaims != nil {
+		conf.JwtClaims = second.JwtClaims
+	}
+
+	if second.JwtRemoteIssuer.IsSome() {
+		conf.JwtRemoteIssuer = second.JwtRemoteIssuer
+	}
+
+	if second.JwtRemoteJwksURL.IsSome() {
+		conf.JwtRemoteJwksURL = second.JwtRemoteJwksURL
+	}
+
+	if second.HttpSigResponses.IsSome() {
+		conf.HttpSigResponses = second.HttpSigResponses
+	}
+
+	if second.HttpSigClientKeyring.IsSome() {
+		conf.HttpSigClientKeyring = second.HttpSigClientKeyring
+	}
+
+	if second.HttpSigRequired.IsSome() {
+		conf.HttpSigRequired = second.HttpSigRequired
+	}
+
+	if second.AuthKeysFile.IsSome() {
+		conf.AuthKeysFile = second.AuthKeysFile
+	}
+
+	if second.AuthRequired.IsSome() {
+		conf.AuthRequired = second.AuthRequired
+	}
+
+	if second.AuthJwt.IsSome() {
+		conf.AuthJwt = second.AuthJwt
+	}
+
+	if second.AuthPublicRoutes != nil {
+		conf.AuthPublicRoutes = second.AuthPublicRoutes
+	}
+
+	// Sections are merged per feature, so a file can configure one feature without
+	// repeating the others.
+	if second.Features != nil {
+		features := make(map[string]map[string]interface{}, len(conf.Features)+len(second.Features))
+		for name, section := range conf.Features {
+			features[name] = section
+		}
+		for name, section := range second.Features {
+			features[name] = section
+		}
+		conf.Features = features
+	}
+
+	if second.EnabledFeatures != nil {
+		conf.EnabledFeatures = second.EnabledFeatures
+	}
+
+	// Prefixes are merged per feature as well.
+	if second.FeaturePrefixes != nil {
+		prefixes := make(map[string]string, len(conf.FeaturePrefixes)+len(second.FeaturePrefixes))
+		for name, prefix := range conf.FeaturePrefixes {
+			prefixes[name] = prefix
+		}
+		for name, prefix := range second.FeaturePrefixes {
+			prefixes[name] = prefix
+		}
+		conf.FeaturePrefixes = prefixes
+	}
+
+	if second.AccessLogFormat.IsSome() {
+		conf.AccessLogFormat = second.AccessLogFormat
+	}
+
+	if second.AccessLogExclude != nil {
+		conf.AccessLogExclude = second.AccessLogExclude
+	}
+
+	if second.AccessLogSample != nil {
+		conf.AccessLogSample = second.AccessLogSample
+	}
+
+	if second.RequestIDTrustedNets != nil {
+		conf.RequestIDTrustedNets = second.RequestIDTrustedNets
+	}
+
+	if second.MetricsPath.IsSome() {
+		conf.MetricsPath = second.MetricsPath
+	}
+
+	if second.RateLimits != nil {
+		limits := make(map[string]RateLimit, len(conf.RateLimits)+len(second.RateLimits))
+		for name, limit := range conf.RateLimits {
+			limits[name] = limit
+		}
+		for name, limit := range second.RateLimits {
+			limits[name] = limit
+		}
+		conf.RateLimits = limits
+	}
+
+	if second.TracingExporter.IsSome() {
+		conf.TracingExporter = second.TracingExporter
+	}
+
+	if second.TracingEndpoint.IsSome() {
+		conf.TracingEndpoint = second.TracingEndpoint
+	}
+
+	if second.TracingFile.IsSome() {
+		conf.TracingFile = second.TracingFile
+	}
+
+	if second.TracingSampleRatio.IsSome() {
+		conf.TracingSampleRatio = second.TracingSampleRatio
+	}
+
 	return conf
 }
 
@@ -142,31 +580,33 @@ func NewFullReloadableConfig(flags *pflag.FlagSet) (*ReloadableConfig, error) {
 
 	conf = conf.withMerge(envConf)
 
-	if conf.ConfigFile.IsSome() {
-        tmp := conf.ConfigFile.Clone()
-        file := (&tmp).UnsafeUnwrap()
-		fileConf, err := NewReloadableConfigFromFile(file)
-		if err != nil {
-			log.Error("Error loadng config from file", "filename", conf.ConfigFile, "error", err)
-		} else {
-			conf = conf.withMerge(fileConf)
-		}
+	conf, err = conf.withFiles()
+	if err != nil {
+		log.Error("Error: could not load config files!", "error", err)
+		return &conf, err
 	}
 
-	log.Debug("Loaded combines config from all sources", "config", conf)
+	log.Debug("Loaded combines config from all sources", "config", conf.Redacted())
 
 	return &conf, nil
 }
 
 func NewReloadableConfigFromFlags(flags *pflag.FlagSet) (ReloadableConfig, error) {
-    var configFile option.Option[string]
-    tmp, err := flags.GetString("config")
+    configFiles, err := flags.GetStringSlice("config")
+	if err != nil || len(configFiles) == 0 {
+        configFiles = nil
+		log.Debug("Failed to load config file paths from flags")
+	} else {
+        log.Debug("Found config file paths in flags", "filenames", configFiles)
+    }
+
+    var profile option.Option[string]
+    tmp, err := flags.GetString("profile")
 	if err != nil || tmp == "" {
-        configFile = option.None[string]()
-		log.Debug("Failed to load config file path from flags")
+        profile = option.None[string]()
+		log.Debug("Failed to load profile from flags")
 	} else {
-        log.Debug("Found config file to load config file path from flags", "filename", tmp)
-        configFile = option.NewOption(tmp)
+        profile = option.NewOption(tmp)
     }
 
     var host option.Option[string]
@@ -234,8 +674,87 @@ func NewReloadableConfigFromFlags(flags *pflag.FlagSet) (ReloadableConfig, error
 	} else {
     }
 
+    var tlsClientCA option.Option[string]
+    tmp, err = flags.GetString("tlsClientCA")
+	if err != nil || tmp == "" {
+        tlsClientCA = option.None[string]()
+		log.Debug("Failed to load TlsClientCA file path from flags")
+	} else {
+        tlsClientCA = option.NewOption(tmp)
+    }
+
+    var dev option.Option[bool]
+    tmpbool, err = flags.GetBool("dev")
+	if err != nil {
+        dev = option.None[bool]()
+		log.Debug("Failed to load Dev from flags")
+	} else {
+        dev = option.NewOption(tmpbool)
+    }
+
+    var devCertDir option.Option[string]
+    tmp, err = flags.GetString("devCertDir")
+	if err != nil || tmp == "" {
+        devCertDir = option.None[string]()
+		log.Debug("Failed to load DevCertDir from flags")
+	} else {
+        devCertDir = option.NewOption(tmp)
+    }
+
+    var signingKeyring option.Option[string]
+    tmp, err = flags.GetString("signingKeyring")
+	if err != nil || tmp == "" {
+        signingKeyring = option.None[string]()
+		log.Debug("Failed to load SigningKeyring from flags")
+	} else {
+        signingKeyring = option.NewOption(tmp)
+    }
+
+    var signingPassphraseFile option.Option[string]
+    tmp, err = flags.GetString("signingPassphraseFile")
+	if err != nil || tmp == "" {
+        signingPassphraseFile = option.None[string]()
+		log.Debug("Failed to load SigningPassphraseFile from flags")
+	} else {
+        signingPassphraseFile = option.NewOption(tmp)
+    }
+
+    var httpSigClientKeyring option.Option[string]
+    tmp, err = flags.GetString("httpSigClientKeyring")
+	if err != nil || tmp == "" {
+        httpSigClientKeyring = option.None[string]()
+		log.Debug("Failed to load HttpSigClientKeyring from flags")
+	} else {
+        httpSigClientKeyring = option.NewOption(tmp)
+    }
+
+    enabledFeatures, err := flags.GetStringSlice("features")
+	if err != nil || len(enabledFeatures) == 0 {
+        enabledFeatures = nil
+		log.Debug("Failed to load EnabledFeatures from flags")
+	}
+
+    var tracingExporter option.Option[string]
+    tmp, err = flags.GetString("tracing")
+	if err != nil || tmp == "" {
+        tracingExporter = option.None[string]()
+		log.Debug("Failed to load TracingExporter from flags")
+	} else {
+        tracingExporter = option.NewOption(tmp)
+    }
+
+    var accessLogFormat option.Option[string]
+    tmp, err = flags.GetString("accessLogFormat")
+	if err != nil || tmp == "" {
+        accessLogFormat = option.None[string]()
+		log.Debug("Failed to load AccessLogFormat from flags")
+	} else {
+        accessLogFormat = option.NewOption(tmp)
+    }
+
     c := ReloadableConfig{
-        ConfigFile: configFile,
+        ConfigFiles: configFiles,
+        Profile: profile,
         Host: host,
         IP: ip,
         Port: port,
@@ -243,9 +762,37 @@ func NewReloadableConfigFromFlags(flags *pflag.FlagSet) (ReloadableConfig, error
         TlsKey: tlsKey,
         TlsEnabled: tlsEnabled,
         TlsSkipVerify: tlsSkipVerify,
+        TlsClientCA: tlsClientCA,
+        TlsExpiryWarnDays: option.None[int](),
+        TlsExpiryCriticalDays: option.None[int](),
+        Dev: dev,
+        DevCertDir: devCertDir,
+        AdminToken: option.None[string](),
+        SigningKeyring: signingKeyring,
+        // Never taken from flags, they show up in ps.
+        SigningPassphrase: option.None[string](),
+        SigningPassphraseFile: signingPassphraseFile,
+        JwtIssuer: option.None[string](),
+        JwtAudience: option.None[string](),
+        JwtLifetimeMinutes: option.None[int](),
+        JwtRemoteIssuer: option.None[string](),
+        JwtRemoteJwksURL: option.None[string](),
+        HttpSigResponses: option.None[bool](),
+        HttpSigClientKeyring: httpSigClientKeyring,
+        HttpSigRequired: option.None[bool](),
+        AuthKeysFile: option.None[string](),
+        AuthRequired: option.None[bool](),
+        AuthJwt: option.None[bool](),
+        EnabledFeatures: enabledFeatures,
+        AccessLogFormat: accessLogFormat,
+        MetricsPath: option.None[string](),
+        TracingExporter: tracingExporter,
+        TracingEndpoint: option.None[string](),
+        TracingFile: option.None[string](),
+        TracingSampleRatio: option.None[float64](),
     }
 
-	log.Info("Loaded config from flags", "config", c)
+	log.Info("Loaded config from flags", "config", c.Redacted())
 
 	return c, nil
 }
@@ -257,19 +804,26 @@ func NewReloadableConfigFromFile(ConfigFile string) (ReloadableConfig, error) {
 		return c, err
 	}
 
-	file, err := os.Open(ConfigFile)
+	data, err := os.ReadFile(ConfigFile)
 	if err != nil {
 		return c, err
 	}
 
-	decoder := json.NewDecoder(file)
-	err = decoder.Decode(&c)
-
+	err = json.Unmarshal(data, &c)
 	if err != nil {
 		return c, err
 	}
 
-	log.Info("Loaded config from file", "filename", ConfigFile, "config", c)
+	// configFile used to name the config file. Unknown keys are ignored, so without this
+	// an old config would lose it without a word.
+	var renamed struct {
+		ConfigFile json.RawMessage `json:"configFile"`
+	}
+	if err := json.Unmarshal(data, &renamed); err == nil && renamed.ConfigFile != nil {
+		return c, errors.New("configFile was replaced by configFiles, which only --config or ECHOPILOT_CONFIG_FILE can set")
+	}
+
+	log.Info("Loaded config from file", "filename", ConfigFile, "config", c.Redacted())
 
 	return c, nil
 }
@@ -282,7 +836,19 @@ func NewReloadableConfigFromEnv() (ReloadableConfig, error) {
 		return c, err
 	}
 
-	log.Debug("Loaded config from env variables", "config", c)
+	log.Debug("Loaded config from env variables", "config", c.Redacted())
 
 	return c, nil
 }
+
+// keyPassphrase is the passphrase source for encrypted keys in the signing keyring and
+// for the key of the development CA, or nil if none is configured.
+func (c StaticConfig) keyPassphrase() signing.Passphrase {
+	if c.SigningPassphraseFile != "" {
+		return signing.PassphraseFile(c.SigningPassphraseFile)
+	}
+	if c.SigningPassphrase != "" {
+		return signing.PassphraseString(c.SigningPassphrase)
+	}
+	return nil
+}
@@ -2,16 +2,62 @@ package config
 
 import (
 	"crypto/tls"
+    "net"
     "strings"
     "strconv"
+    "sync"
+    "sync/atomic"
+    "time"
+
+	"github.com/brnsampson/echopilot/pkg/auth"
+	"github.com/brnsampson/echopilot/pkg/certstore"
+	"github.com/brnsampson/echopilot/pkg/jwt"
+	"github.com/brnsampson/echopilot/pkg/localca"
+	"github.com/brnsampson/echopilot/pkg/signing"
 
 	"github.com/spf13/pflag"
     "github.com/charmbracelet/log"
 )
 
-func NewServerConfig(flags *pflag.FlagSet) (*ServerConfig, error) {
-    tlsConf := tls.Config{
-		Certificates:             []tls.Certificate{},
+// NewServerConfig loads the config. logger is used by the parts of the config which keep
+// running in the background, like the certificate expiry monitor.
+func NewServerConfig(flags *pflag.FlagSet, logger *log.Logger) (*ServerConfig, error) {
+    return newServerConfig(flags, logger, false)
+}
+
+// NewServerConfigWithoutSecrets loads the config like NewServerConfig, but none of the
+// certificates, keyrings and keys files it names. The result describes the server, e.g.
+// to list its routes, but cannot serve.
+func NewServerConfigWithoutSecrets(flags *pflag.FlagSet, logger *log.Logger) (*ServerConfig, error) {
+    return newServerConfig(flags, logger, true)
+}
+
+func newServerConfig(flags *pflag.FlagSet, logger *log.Logger, withoutSecrets bool) (*ServerConfig, error) {
+    certs := certstore.New()
+	conf := ServerConfig{
+		flags:  flags,
+        overrides: emptyReloadableConfig(),
+        certs: certs,
+        monitor: certstore.NewMonitor(logger, certs, certstore.ExpiryThresholds{}),
+        tlsConf: newTlsConfig(certs),
+        keyring: signing.NewKeyring(),
+        clientKeyring: signing.NewKeyring(),
+        apiKeys: auth.NewKeySet(),
+        loopbackAPIKey: auth.NewSecret(),
+        withoutSecrets: withoutSecrets,
+	}
+    conf.apiKeys.Add(auth.LoopbackName, auth.TypeAPIKey, conf.loopbackAPIKey)
+    if err := conf.update(); err != nil {
+        return nil, err
+    }
+	return &conf, nil
+}
+
+// The tls.Config is only built once. Certificates are served from the store, so swapping
+// them on reload does not require a new config or listener.
+func newTlsConfig(certs *certstore.Store) *tls.Config {
+	conf := &tls.Config{
+		GetCertificate:           certs.GetCertificate,
 		MinVersion:               tls.VersionTLS13,
 		CurvePreferences:         []tls.CurveID{tls.CurveP521, tls.CurveP384, tls.CurveP256},
 		PreferServerCipherSuites: true,
@@ -22,57 +68,348 @@ func NewServerConfig(flags *pflag.FlagSet) (*ServerConfig, error) {
 			tls.TLS_RSA_WITH_AES_256_CBC_SHA,
 		},
 	}
-	conf := ServerConfig{
-		flags:  flags,
-        tlsConf: &tlsConf,
+
+	// Client certificates are only requested once a client CA bundle is configured, and the
+	// bundle can change on reload, so the pool is looked up per handshake.
+	conf.GetConfigForClient = func(*tls.ClientHelloInfo) (*tls.Config, error) {
+		pool := certs.ClientCAPool()
+		if pool == nil {
+			return nil, nil
+		}
+		withCAs := conf.Clone()
+		withCAs.GetConfigForClient = nil
+		withCAs.ClientCAs = pool
+		withCAs.ClientAuth = tls.VerifyClientCertIfGiven
+		return withCAs, nil
 	}
-    if err := conf.update(); err != nil {
-        return nil, err
-    }
-	return &conf, nil
+	return conf
 }
 
 type ServerConfig struct {
 	flags   *pflag.FlagSet
-	config  *StaticConfig
+	// The config in effect, swapped in whole by every reload.
+	config  atomic.Pointer[StaticConfig]
+	// requestIdTrustedNets of the config in effect, parsed once per reload.
+	trustedNets atomic.Pointer[[]*net.IPNet]
+	// Serializes reloads.
+	updating sync.Mutex
+	// Runtime patches layered over every other source. Guarded by mu.
+	mu        sync.Mutex
+	overrides ReloadableConfig
+	certs   *certstore.Store
+	monitor *certstore.Monitor
 	tlsConf *tls.Config
+	keyring *signing.Keyring
+	// Public keys of clients which sign their requests.
+	clientKeyring *signing.Keyring
+	// Hashes of the API keys and bearer tokens of clients.
+	apiKeys *auth.KeySet
+	// Lets the UI call back into the server. Never leaves the process.
+	loopbackAPIKey string
+	// Keys of jwtRemoteIssuer. Guarded by mu.
+	remoteKeys *jwt.RemoteKeySet
+	// Only set when running with --dev. The CA outlives reloads so clients keep trusting us.
+	devCA   *localca.CA
+	devCert *localca.Issued
+	// Called after every reload which changed something.
+	listeners reloadListeners
+	// Set by NewServerConfigWithoutSecrets.
+	withoutSecrets bool
 }
 
+// loadedFiles holds scratch copies of everything a reload loads from files, which are
+// only swapped in once all of them loaded.
+type loadedFiles struct {
+	certs         *certstore.Store
+	keyring       *signing.Keyring
+	clientKeyring *signing.Keyring
+	apiKeys       *auth.KeySet
+}
+
+// update reloads the config from every source. It either applies all of it or, if
+// anything fails to load, nothing at all: certificates, keyrings and keys are loaded into
+// scratch copies first and only swapped in together with the config once all of them
+// loaded.
 func (c *ServerConfig) update() error {
+	c.updating.Lock()
+	defer c.updating.Unlock()
+
 	conf, err := NewFullReloadableConfig(c.flags)
 	if err != nil {
 		log.Error("Could not update echo server config due to error loading", "error", err)
 		return err
 	}
 
-    staticConf := conf.Finalize()
-	log.Info("Updating echo server config from merged config", "config", staticConf)
-	c.config = &staticConf
+	c.mu.Lock()
+	merged := conf.withMerge(c.overrides)
+	c.mu.Unlock()
 
-	if staticConf.TlsEnabled {
-        cert, err := tls.LoadX509KeyPair(staticConf.TlsCert, staticConf.TlsKey)
-		if err != nil {
+    staticConf := merged.Finalize()
+	log.Debug("Updating echo server config from merged config", "config", staticConf.Redacted())
+	// Reject the config as a whole before anything of it is applied.
+	if err := staticConf.validate(false); err != nil {
+		log.Error("Rejected invalid echo server config", "error", err)
+		return err
+	}
+
+	trustedNets, err := parseNets(staticConf.RequestIDTrustedNets)
+	if err != nil {
+		return err
+	}
+
+	current := c.config.Load()
+	event := ReloadEvent{Time: time.Now()}
+	if current != nil {
+		staticConf, event = c.diffReload(*current, staticConf)
+	}
+
+	files := loadedFiles{certstore.New(), signing.NewKeyring(), signing.NewKeyring(), auth.NewKeySet()}
+	if !c.withoutSecrets {
+		if files, err = c.loadFiles(staticConf); err != nil {
+			return err
+		}
+	}
+
+	// Everything loaded, so apply it all.
+	c.config.Store(&staticConf)
+	c.trustedNets.Store(&trustedNets)
+	if staticConf.Dev || staticConf.TlsEnabled {
+		c.certs.Replace(files.certs)
+	}
+	c.keyring.Replace(files.keyring)
+	c.clientKeyring.Replace(files.clientKeyring)
+	c.apiKeys.Replace(files.apiKeys)
+	c.updateRemoteKeys(staticConf)
+
+	if current == nil {
+		log.Info("Loaded echo server config", "config", staticConf.Redacted())
+	} else {
+		logReload(event)
+	}
+	if c.withoutSecrets {
+		log.Debug("Skipped loading certificates, keyrings and keys")
+	} else {
+		c.logLoadedFiles(staticConf)
+	}
+
+	if staticConf.TlsEnabled && !c.withoutSecrets {
+		c.monitor.SetThresholds(certstore.ExpiryThresholds{
+			Warning:  time.Duration(staticConf.TlsExpiryWarnDays) * 24 * time.Hour,
+			Critical: time.Duration(staticConf.TlsExpiryCriticalDays) * 24 * time.Hour,
+		})
+		c.monitor.Scan()
+	}
+
+	if event.Changed() {
+		c.listeners.emit(event)
+	}
+
+	return nil
+}
+
+// loadFiles loads the certificates, keyrings and keys file staticConf names into scratch
+// copies.
+func (c *ServerConfig) loadFiles(staticConf StaticConfig) (loadedFiles, error) {
+	certs := certstore.New()
+	if staticConf.Dev {
+		if err := c.loadDevCerts(staticConf, certs); err != nil {
+			log.Error("Generating development certificates failed", "error", err)
+			return loadedFiles{}, err
+		}
+	} else if staticConf.TlsEnabled {
+		def := certstore.KeyPair{CertFile: staticConf.TlsCert, KeyFile: staticConf.TlsKey}
+		if err := certs.Load(def, staticConf.TlsCertificates...); err != nil {
 			log.Error("Updating echo server TLS Certificate failed", "error", err)
+			return loadedFiles{}, err
+		}
+
+		if err := certs.LoadClientCAs(staticConf.TlsClientCA); err != nil {
+			log.Error("Updating echo server TLS client CA bundle failed", "error", err)
+			return loadedFiles{}, err
+		}
+	}
+
+	keyring := signing.NewKeyring()
+	if staticConf.SigningKeyring != "" {
+		if err := keyring.Load(staticConf.SigningKeyring, staticConf.keyPassphrase()); err != nil {
+			log.Error("Loading signing keyring failed", "dir", staticConf.SigningKeyring, "error", err)
+			return loadedFiles{}, err
+		}
+	}
+
+	clientKeyring := signing.NewKeyring()
+	if staticConf.HttpSigClientKeyring != "" {
+		if err := clientKeyring.Load(staticConf.HttpSigClientKeyring, nil); err != nil {
+			log.Error("Loading client keyring failed", "dir", staticConf.HttpSigClientKeyring, "error", err)
+			return loadedFiles{}, err
+		}
+	}
+
+	apiKeys := auth.NewKeySet()
+	if staticConf.AuthKeysFile != "" {
+		if err := apiKeys.Load(staticConf.AuthKeysFile); err != nil {
+			log.Error("Loading auth keys failed", "file", staticConf.AuthKeysFile, "error", err)
+			return loadedFiles{}, err
+		}
+	}
+
+	return loadedFiles{certs, keyring, clientKeyring, apiKeys}, nil
+}
+
+// logLoadedFiles reports on the keyrings and keys swapped in by a reload.
+func (c *ServerConfig) logLoadedFiles(staticConf StaticConfig) {
+	if staticConf.SigningKeyring != "" {
+		if active, err := c.keyring.Active(); err == nil {
+			log.Info("Loaded signing keyring", "dir", staticConf.SigningKeyring, "keys", len(c.keyring.Entries()), "active", active.ID)
+		} else {
+			log.Warn("Signing keyring has no key which can sign right now", "dir", staticConf.SigningKeyring)
+		}
+	}
+	if staticConf.HttpSigClientKeyring != "" {
+		log.Info("Loaded client keyring", "dir", staticConf.HttpSigClientKeyring, "keys", len(c.clientKeyring.Entries()))
+	}
+	if staticConf.AuthKeysFile != "" {
+		log.Info("Loaded auth keys", "file", staticConf.AuthKeysFile, "keys", c.apiKeys.Len())
+	}
+}
+
+// diffReload works out what a reload changes and keeps the old value of any field that
+// needs a restart to change.
+func (c *ServerConfig) diffReload(old, next StaticConfig) (StaticConfig, ReloadEvent) {
+	event := ReloadEvent{Time: time.Now(), Applied: []FieldChange{}, PendingRestart: []FieldChange{}}
+	for _, change := range Diff(old, next) {
+		if change.RequiresRestart {
+			event.PendingRestart = append(event.PendingRestart, change)
+		} else {
+			event.Applied = append(event.Applied, change)
+		}
+	}
+	return next.withRestartFieldsFrom(old), event
+}
+
+// logReload logs what a reload changed once it has been applied.
+func logReload(event ReloadEvent) {
+	for _, change := range event.PendingRestart {
+		log.Warn("Config change requires a restart and was not applied", "field", change.Field, "old", change.Old, "new", change.New)
+	}
+	for _, change := range event.Applied {
+		log.Info("Config changed", "field", change.Field, "old", change.Old, "new", change.New)
+	}
+	if !event.Changed() {
+		log.Info("Config reloaded without changes")
+	}
+}
+
+// OnReload registers a listener which is called after every reload that changed the
+// config. Listeners are called synchronously, so they should not block.
+func (c *ServerConfig) OnReload(listener ReloadListener) {
+	c.listeners.add(listener)
+}
+
+// loadDevCerts puts a certificate from the development CA into certs, creating the CA
+// and certificate on the first load.
+func (c *ServerConfig) loadDevCerts(conf StaticConfig, certs *certstore.Store) error {
+	if c.devCA == nil {
+		var ca *localca.CA
+		var err error
+		if conf.DevCertDir != "" {
+			var created bool
+			ca, created, err = localca.LoadOrCreateCA(conf.DevCertDir, "echopilot development CA", conf.keyPassphrase())
+			if created {
+				log.Info("Created development CA", "dir", conf.DevCertDir)
+			}
+		} else {
+			ca, err = localca.NewCA("echopilot development CA", localca.DefaultCAValidity)
+		}
+		if err != nil {
 			return err
 		}
 
-		c.tlsConf = &tls.Config{
-			Certificates:             []tls.Certificate{cert},
-			MinVersion:               tls.VersionTLS13,
-			CurvePreferences:         []tls.CurveID{tls.CurveP521, tls.CurveP384, tls.CurveP256},
-			PreferServerCipherSuites: true,
-			CipherSuites: []uint16{
-				tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
-				tls.TLS_ECDHE_RSA_WITH_AES_256_CBC_SHA,
-				tls.TLS_RSA_WITH_AES_256_GCM_SHA384,
-				tls.TLS_RSA_WITH_AES_256_CBC_SHA,
-			},
+		hosts := append([]string{}, localca.LocalHosts...)
+		if conf.Host != "" && conf.Host != "localhost" {
+			hosts = append(hosts, conf.Host)
 		}
+		leaf, err := ca.IssueServer(hosts, localca.DefaultCertValidity)
+		if err != nil {
+			return err
+		}
+
+		if conf.DevCertDir != "" {
+			if err := leaf.Save(conf.DevCertDir, "server"); err != nil {
+				return err
+			}
+		}
+
+		log.Warn("Serving an ephemeral development certificate. Do not use --dev in production!", "hosts", hosts)
+		c.devCA = ca
+		c.devCert = leaf
 	}
 
+	if err := certs.Set(c.devCert.TLSCertificate(c.devCA)); err != nil {
+		return err
+	}
+
+	// Trust the development CA for client certificates too so mTLS can be tested with
+	// certificates from `echopilot ca client`, unless a real bundle was configured.
+	if conf.TlsClientCA != "" {
+		return certs.LoadClientCAs(conf.TlsClientCA)
+	}
+	certs.SetClientCAs(c.devCA.Cert)
 	return nil
 }
 
+// GetStaticConfig returns a copy of the config currently in effect.
+func (c *ServerConfig) GetStaticConfig() StaticConfig {
+	return *c.config.Load()
+}
+
+// GetRequestIDTrustedNets returns the networks whose request IDs are kept.
+func (c *ServerConfig) GetRequestIDTrustedNets() []*net.IPNet {
+	return *c.trustedNets.Load()
+}
+
+// ApplyPatch validates a partial JSON config against the rest of the current config and,
+// if it is valid, layers it over every other config source. The patch only takes effect
+// on the next reload. With persist set, the patched fields are also written to the config
+// file with the highest precedence, so that no other file can shadow them. Returns the
+// config that the next reload will produce.
+func (c *ServerConfig) ApplyPatch(data []byte, persist bool) (StaticConfig, error) {
+	patch, raw, err := decodePatch(data)
+	if err != nil {
+		return StaticConfig{}, err
+	}
+
+	full, err := NewFullReloadableConfig(c.flags)
+	if err != nil {
+		return StaticConfig{}, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	overrides := c.overrides.withMerge(patch)
+	merged := full.withMerge(overrides)
+	staticConf := merged.Finalize()
+	if err := staticConf.Validate(); err != nil {
+		return staticConf, err
+	}
+
+	if persist {
+		file := staticConf.persistFile()
+		if file == "" {
+			return staticConf, &ValidationError{[]string{"cannot persist a patch without a config file"}}
+		}
+		if err := persistPatch(file, raw); err != nil {
+			return staticConf, err
+		}
+		log.Info("Persisted config patch", "filename", file)
+	}
+
+	c.overrides = overrides
+	log.Info("Applied config patch", "patch", patch.Redacted())
+	return staticConf, nil
+}
+
 func (c *ServerConfig) GetAddr(update bool) (string, error) {
 	if update {
 		if err := c.update(); err != nil {
@@ -80,7 +417,8 @@ func (c *ServerConfig) GetAddr(update bool) (string, error) {
 		}
 	}
 
-    addr := strings.Join([]string{c.config.IP, strconv.Itoa(c.config.Port)}, ":")
+    conf := c.config.Load()
+    addr := strings.Join([]string{conf.IP, strconv.Itoa(conf.Port)}, ":")
 	return addr, nil
 }
 
@@ -91,7 +429,7 @@ func (c *ServerConfig) GetHost(update bool) (string, error) {
 		}
 	}
 
-	return c.config.Host, nil
+	return c.config.Load().Host, nil
 }
 
 func (c *ServerConfig) GetTlsConfig(update bool) (*tls.Config, error) {
@@ -107,10 +445,72 @@ func (c *ServerConfig) GetTlsConfig(update bool) (*tls.Config, error) {
 func (esc *ServerConfig) GetTlsEnabled(update bool) (bool, error) {
 	if update {
 		if err := esc.update(); err != nil {
-			return esc.config.TlsEnabled, err
+			return esc.config.Load().TlsEnabled, err
 		}
 	}
 
-	return esc.config.TlsEnabled, nil
+	return esc.config.Load().TlsEnabled, nil
 }
 
+// GetFeatures returns the config sections of the features, by feature name.
+func (c *ServerConfig) GetFeatures() map[string]map[string]interface{} {
+	return c.GetStaticConfig().Features
+}
+
+func (c *ServerConfig) GetCertStore() *certstore.Store {
+	return c.certs
+}
+
+func (c *ServerConfig) GetCertMonitor() *certstore.Monitor {
+	return c.monitor
+}
+
+// GetKeyring returns the signing keyring. It is empty unless signingKeyring is set, and
+// its contents are replaced on every reload.
+func (c *ServerConfig) GetKeyring() *signing.Keyring {
+	return c.keyring
+}
+
+// GetClientKeyring returns the public keys of clients which sign their requests. It is
+// empty unless httpSigClientKeyring is set.
+func (c *ServerConfig) GetClientKeyring() *signing.Keyring {
+	return c.clientKeyring
+}
+
+// GetLoopbackURL returns the base URL the server can use to make requests to itself.
+func (c *ServerConfig) GetLoopbackURL(update bool) (string, error) {
+	if update {
+		if err := c.update(); err != nil {
+			return "", err
+		}
+	}
+
+	conf := c.config.Load()
+	ip := conf.IP
+	if ip == "" || ip == "0.0.0.0" || ip == "::" {
+		ip = "127.0.0.1"
+	}
+
+	scheme := "http"
+	if conf.TlsEnabled {
+		scheme = "https"
+	}
+	return scheme + "://" + net.JoinHostPort(ip, strconv.Itoa(conf.Port)), nil
+}
+
+// GetClientTlsConfig returns the TLS settings for clients calling back into this server.
+// Clients trust the certificates the server serves and expect the one for host, so
+// verification can stay on whoever issued them. In development mode the ephemeral CA is
+// trusted instead.
+func (c *ServerConfig) GetClientTlsConfig() *tls.Config {
+	static := c.config.Load()
+	conf := &tls.Config{
+		InsecureSkipVerify: static.TlsSkipVerify,
+		ServerName:         static.Host,
+		RootCAs:            c.certs.Pool(),
+	}
+	if c.devCA != nil {
+		conf.RootCAs = c.devCA.Pool()
+	}
+	return conf
+}
@@ -0,0 +1,149 @@
+package config_test
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/brnsampson/echopilot/pkg/auth"
+	"github.com/brnsampson/echopilot/pkg/config"
+	"github.com/brnsampson/echopilot/pkg/localca"
+	"github.com/charmbracelet/log"
+	"github.com/spf13/pflag"
+)
+
+func TestClientTlsConfig(t *testing.T) {
+	// Certificates from a CA nobody trusts, like a private CA in production.
+	dir := t.TempDir()
+	ca, err := localca.NewCA("test CA", time.Hour)
+	ok(t, err)
+	leaf, err := ca.IssueServer([]string{"echo.test"}, time.Hour)
+	ok(t, err)
+	ok(t, leaf.Save(dir, "server"))
+	certFile, keyFile := localca.Files(dir, "server")
+
+	file := filepath.Join(dir, "echopilot.json")
+	ok(t, os.WriteFile(file, []byte(fmt.Sprintf(`{"serverHost": "echo.test", "tlsCert": %q, "tlsKey": %q}`, certFile, keyFile)), 0640))
+	flags := pflag.NewFlagSet("test", pflag.ContinueOnError)
+	flags.StringSlice("config", []string{file}, "")
+	flags.Bool("tlsEnabled", true, "")
+	conf, err := config.NewServerConfig(flags, log.New(io.Discard))
+	ok(t, err)
+
+	tlsConf, err := conf.GetTlsConfig(false)
+	ok(t, err)
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	srv.TLS = tlsConf
+	srv.StartTLS()
+	defer srv.Close()
+
+	// The server is called by IP, but its certificate is for the configured host.
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: conf.GetClientTlsConfig()}}
+	resp, err := client.Get(srv.URL)
+	ok(t, err)
+	resp.Body.Close()
+	equals(t, http.StatusOK, resp.StatusCode)
+}
+
+// Run with -race: reads must never see a config which is being replaced.
+func TestConcurrentReload(t *testing.T) {
+	conf, _ := testServerConfig(t, `{"serverPort": 4000}`)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 20; i++ {
+			if _, err := conf.GetAddr(true); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+	}()
+
+	for {
+		select {
+		case <-done:
+			return
+		default:
+			equals(t, 4000, conf.GetStaticConfig().Port)
+			_, err := conf.GetLoopbackURL(false)
+			ok(t, err)
+			conf.GetClientTlsConfig()
+		}
+	}
+}
+
+func TestReloadRejectsInvalid(t *testing.T) {
+	conf, file := testServerConfig(t, `{"serverPort": 4000}`)
+
+	for _, contents := range []string{
+		// Would trust tokens without an issuer.
+		`{"serverPort": 4001, "jwtRemoteJwksUrl": "https://idp.example.com/jwks.json"}`,
+		`{"serverPort": 4001, "jwtRemoteIssuer": "idp", "jwtRemoteJwksUrl": "http://idp.example.com/jwks.json"}`,
+		`{"serverPort": 4001, "jwtLifetimeMinutes": 0}`,
+		`{"serverPort": 4001, "rateLimits": {"/echo": {"rate": 1, "by": "cookie"}}}`,
+	} {
+		ok(t, os.WriteFile(file, []byte(contents), 0640))
+		_, err := conf.GetAddr(true)
+		var invalid *config.ValidationError
+		equals(t, true, errors.As(err, &invalid))
+		equals(t, 4000, conf.GetStaticConfig().Port)
+	}
+
+	// Nor does an invalid config start.
+	flags := pflag.NewFlagSet("test", pflag.ContinueOnError)
+	flags.StringSlice("config", []string{file}, "")
+	flags.Bool("tlsEnabled", false, "")
+	_, err := config.NewServerConfig(flags, log.New(io.Discard))
+	equals(t, true, err != nil)
+}
+
+func TestFailedReloadAppliesNothing(t *testing.T) {
+	dir := t.TempDir()
+	keys := filepath.Join(dir, "keys.json")
+	ok(t, os.WriteFile(keys, []byte(`{"keys": [{"name": "ci", "sha256": "`+auth.Hash("secret")+`"}]}`), 0600))
+	conf, file := testServerConfig(t, fmt.Sprintf(`{"serverPort": 4000, "authKeysFile": %q}`, keys))
+	events := 0
+	conf.OnReload(func(config.ReloadEvent) { events++ })
+
+	// The keys file only fails once the port was already looked at.
+	broken := filepath.Join(dir, "broken.json")
+	ok(t, os.WriteFile(broken, []byte(`{"keys": [`), 0600))
+	ok(t, os.WriteFile(file, []byte(fmt.Sprintf(`{"serverPort": 4001, "authKeysFile": %q}`, broken)), 0640))
+	_, err := conf.GetAddr(true)
+	equals(t, true, err != nil)
+
+	equals(t, 4000, conf.GetStaticConfig().Port)
+	equals(t, keys, conf.GetStaticConfig().AuthKeysFile)
+	_, found := conf.GetAuthenticator().Keys.Lookup(auth.TypeAPIKey, "secret")
+	equals(t, true, found)
+	equals(t, 0, events)
+
+	// The loopback key survives every reload.
+	_, found = conf.GetAuthenticator().Keys.Lookup(auth.TypeAPIKey, conf.GetLoopbackAPIKey())
+	equals(t, true, found)
+}
+
+func TestWithoutSecrets(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "echopilot.json")
+	ok(t, os.WriteFile(file, []byte(`{"serverPort": 4000, "tlsCert": "/nowhere/cert.pem", "tlsKey": "/nowhere/key.pem", "signingKeyring": "/nowhere"}`), 0640))
+	flags := pflag.NewFlagSet("test", pflag.ContinueOnError)
+	flags.StringSlice("config", []string{file}, "")
+	flags.Bool("tlsEnabled", true, "")
+
+	_, err := config.NewServerConfig(flags, log.New(io.Discard))
+	equals(t, true, err != nil)
+
+	// Describing the server does not need any of the files.
+	conf, err := config.NewServerConfigWithoutSecrets(flags, log.New(io.Discard))
+	ok(t, err)
+	equals(t, 4000, conf.GetStaticConfig().Port)
+	equals(t, "/nowhere", conf.GetStaticConfig().SigningKeyring)
+	equals(t, 0, len(conf.GetCertStore().Entries()))
+}
@@ -0,0 +1,66 @@
+package config
+
+import (
+	"time"
+
+	"github.com/brnsampson/echopilot/pkg/auth"
+	"github.com/brnsampson/echopilot/pkg/jwt"
+)
+
+// updateRemoteKeys keeps the cached remote key set across reloads unless its URL changed.
+func (c *ServerConfig) updateRemoteKeys(conf StaticConfig) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if conf.JwtRemoteJwksURL == "" {
+		c.remoteKeys = nil
+	} else if c.remoteKeys == nil || c.remoteKeys.URL() != conf.JwtRemoteJwksURL {
+		c.remoteKeys = jwt.NewRemoteKeySet(conf.JwtRemoteJwksURL, nil)
+	}
+}
+
+// GetTokenIssuer returns an issuer for tokens signed with the active key of the signing
+// keyring, using the current jwt settings.
+func (c *ServerConfig) GetTokenIssuer() *jwt.Issuer {
+	conf := c.GetStaticConfig()
+	return &jwt.Issuer{
+		Keys:     c.keyring,
+		Name:     conf.JwtIssuer,
+		Lifetime: time.Duration(conf.JwtLifetimeMinutes) * time.Minute,
+		Claims:   conf.JwtClaims,
+	}
+}
+
+// GetTokenVerifier returns a verifier which accepts our own tokens while a signing
+// keyring is configured, and tokens from jwtRemoteIssuer if set.
+func (c *ServerConfig) GetTokenVerifier() *jwt.Verifier {
+	conf := c.GetStaticConfig()
+	verifier := jwt.NewVerifier(conf.JwtAudience)
+	if conf.SigningKeyring != "" {
+		verifier.Trust(conf.JwtIssuer, c.keyring)
+	}
+
+	c.mu.Lock()
+	remote := c.remoteKeys
+	c.mu.Unlock()
+	if remote != nil {
+		verifier.Trust(conf.JwtRemoteIssuer, remote)
+	}
+	return verifier
+}
+
+// GetAuthenticator returns an authenticator for the keys in authKeysFile, which also
+// accepts JWTs passing GetTokenVerifier while authJwt is set.
+func (c *ServerConfig) GetAuthenticator() auth.Authenticator {
+	authenticator := auth.Authenticator{Keys: c.apiKeys}
+	if c.GetStaticConfig().AuthJwt {
+		authenticator.Tokens = c.GetTokenVerifier
+	}
+	return authenticator
+}
+
+// GetLoopbackAPIKey returns the API key the server uses to call itself. It is made up
+// at startup and authenticates as auth.LoopbackName.
+func (c *ServerConfig) GetLoopbackAPIKey() string {
+	return c.loopbackAPIKey
+}
@@ -0,0 +1,131 @@
+package health
+
+// Aggregated health reporting for the server.
+//
+// Components register a Checker under a name and the Health registry combines their
+// results into a single report. The overall status is the worst status of any check.
+// Degraded services still answer 200 so load balancers keep routing to them, while a
+// failing service answers 503.
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"sync"
+)
+
+type Status int
+
+const (
+	StatusOK Status = iota
+	StatusDegraded
+	StatusFailing
+)
+
+func (s Status) String() string {
+	switch s {
+	case StatusOK:
+		return "ok"
+	case StatusDegraded:
+		return "degraded"
+	default:
+		return "failing"
+	}
+}
+
+func (s Status) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+type Result struct {
+	Status  Status `json:"status"`
+	Message string `json:"message,omitempty"`
+}
+
+func OK() Result {
+	return Result{Status: StatusOK}
+}
+
+func Degraded(msg string) Result {
+	return Result{StatusDegraded, msg}
+}
+
+func Failing(msg string) Result {
+	return Result{StatusFailing, msg}
+}
+
+type Checker interface {
+	Check(ctx context.Context) Result
+}
+
+type CheckerFunc func(ctx context.Context) Result
+
+func (f CheckerFunc) Check(ctx context.Context) Result {
+	return f(ctx)
+}
+
+type Report struct {
+	Status Status            `json:"status"`
+	Checks map[string]Result `json:"checks"`
+}
+
+func New() *Health {
+	return &Health{checks: make(map[string]Checker)}
+}
+
+type Health struct {
+	mu     sync.RWMutex
+	checks map[string]Checker
+}
+
+// Register adds a named check, replacing any previous check with the same name.
+func (h *Health) Register(name string, c Checker) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.checks[name] = c
+}
+
+func (h *Health) Unregister(name string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.checks, name)
+}
+
+func (h *Health) Check(ctx context.Context) Report {
+	h.mu.RLock()
+	names := make([]string, 0, len(h.checks))
+	for name := range h.checks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	checks := make([]Checker, len(names))
+	for i, name := range names {
+		checks[i] = h.checks[name]
+	}
+	h.mu.RUnlock()
+
+	report := Report{Status: StatusOK, Checks: make(map[string]Result, len(names))}
+	for i, c := range checks {
+		res := c.Check(ctx)
+		report.Checks[names[i]] = res
+		if res.Status > report.Status {
+			report.Status = res.Status
+		}
+	}
+	return report
+}
+
+func (h *Health) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	report := h.Check(r.Context())
+
+	status := http.StatusOK
+	if report.Status == StatusFailing {
+		status = http.StatusServiceUnavailable
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(report)
+}
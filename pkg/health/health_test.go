@@ -0,0 +1,70 @@
+package health_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"testing"
+
+	"github.com/brnsampson/echopilot/pkg/health"
+)
+
+// ok fails the test if an err is not nil.
+func ok(tb testing.TB, err error) {
+	if err != nil {
+		_, file, line, _ := runtime.Caller(1)
+		fmt.Printf("\033[31m%s:%d: unexpected error: %s\033[39m\n\n", filepath.Base(file), line, err.Error())
+		tb.FailNow()
+	}
+}
+
+// equals fails the test if exp is not equal to act.
+func equals(tb testing.TB, exp, act interface{}) {
+	if !reflect.DeepEqual(exp, act) {
+		_, file, line, _ := runtime.Caller(1)
+		fmt.Printf("\033[31m%s:%d:\n\n\texp: %#v\n\n\tgot: %#v\033[39m\n\n", filepath.Base(file), line, exp, act)
+		tb.FailNow()
+	}
+}
+
+func static(res health.Result) health.Checker {
+	return health.CheckerFunc(func(context.Context) health.Result { return res })
+}
+
+func TestWorstStatusWins(t *testing.T) {
+	h := health.New()
+	equals(t, health.StatusOK, h.Check(context.Background()).Status)
+
+	h.Register("a", static(health.OK()))
+	h.Register("b", static(health.Degraded("slow")))
+	equals(t, health.StatusDegraded, h.Check(context.Background()).Status)
+
+	h.Register("c", static(health.Failing("down")))
+	equals(t, health.StatusFailing, h.Check(context.Background()).Status)
+
+	h.Unregister("c")
+	equals(t, health.StatusDegraded, h.Check(context.Background()).Status)
+}
+
+func TestServeHTTP(t *testing.T) {
+	h := health.New()
+	h.Register("tls", static(health.Degraded("expiring")))
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/health", nil))
+	equals(t, http.StatusOK, rec.Code)
+
+	var body map[string]interface{}
+	ok(t, json.Unmarshal(rec.Body.Bytes(), &body))
+	equals(t, "degraded", body["status"])
+
+	h.Register("tls", static(health.Failing("expired")))
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/health", nil))
+	equals(t, http.StatusServiceUnavailable, rec.Code)
+}
@@ -0,0 +1,59 @@
+package httpsig
+
+// Content-Digest (RFC 9530). Signatures cover the digest rather than the body itself.
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// ContentDigest returns the Content-Digest field value for body, using SHA-256.
+func ContentDigest(body []byte) string {
+	sum := sha256.Sum256(body)
+	value, _ := serializeBareItem(sum[:])
+	return "sha-256=" + value
+}
+
+// checkDigest verifies the Content-Digest of a message, if it has one. Digests with
+// algorithms other than SHA-256 and SHA-512 are ignored, but at least one must be known.
+func checkDigest(header http.Header, body []byte) error {
+	values := header.Values("Content-Digest")
+	if len(values) == 0 {
+		return nil
+	}
+	members, err := parseDictionary(strings.Join(values, ", "))
+	if err != nil {
+		return fmt.Errorf("%w: Content-Digest: %v", ErrMalformedSignature, err)
+	}
+
+	checked := false
+	for _, m := range members {
+		digest, found := m.item.value.([]byte)
+		if !found {
+			continue
+		}
+		var sum []byte
+		switch m.key {
+		case "sha-256":
+			s := sha256.Sum256(body)
+			sum = s[:]
+		case "sha-512":
+			s := sha512.Sum512(body)
+			sum = s[:]
+		default:
+			continue
+		}
+		if subtle.ConstantTimeCompare(sum, digest) != 1 {
+			return fmt.Errorf("%w: %s", ErrDigestMismatch, m.key)
+		}
+		checked = true
+	}
+	if !checked {
+		return fmt.Errorf("%w: no supported algorithm in %q", ErrDigestMismatch, strings.Join(values, ", "))
+	}
+	return nil
+}
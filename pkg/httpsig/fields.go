@@ -0,0 +1,329 @@
+package httpsig
+
+// The subset of Structured Field Values (RFC 8941) needed for the Signature,
+// Signature-Input and Content-Digest fields: dictionaries whose members are inner lists
+// or items, each with parameters. Decimals are not supported.
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// A token is a bare word, as opposed to a quoted string.
+type token string
+
+type param struct {
+	key   string
+	value interface{}
+}
+
+// item is a bare item (string, token, int64, []byte or bool) or an inner list ([]item),
+// with its parameters.
+type item struct {
+	value  interface{}
+	params []param
+}
+
+func (i item) param(key string) (interface{}, bool) {
+	for _, p := range i.params {
+		if p.key == key {
+			return p.value, true
+		}
+	}
+	return nil, false
+}
+
+type member struct {
+	key  string
+	item item
+}
+
+type fieldParser struct {
+	s   string
+	pos int
+}
+
+func parseDictionary(s string) ([]member, error) {
+	p := &fieldParser{s: s}
+	p.skip(" \t")
+	members := make([]member, 0)
+	seen := make(map[string]int)
+	for p.pos < len(p.s) {
+		key, err := p.key()
+		if err != nil {
+			return nil, err
+		}
+
+		var it item
+		if p.peek() == '=' {
+			p.pos++
+			if it, err = p.member(); err != nil {
+				return nil, err
+			}
+		} else {
+			it.value = true
+			if it.params, err = p.params(); err != nil {
+				return nil, err
+			}
+		}
+
+		// Later members with the same key replace earlier ones.
+		if i, found := seen[key]; found {
+			members[i].item = it
+		} else {
+			seen[key] = len(members)
+			members = append(members, member{key, it})
+		}
+
+		p.skip(" \t")
+		if p.pos == len(p.s) {
+			break
+		}
+		if p.peek() != ',' {
+			return nil, p.errorf("expected ','")
+		}
+		p.pos++
+		p.skip(" \t")
+		if p.pos == len(p.s) {
+			return nil, p.errorf("trailing ','")
+		}
+	}
+	return members, nil
+}
+
+func (p *fieldParser) errorf(format string, v ...interface{}) error {
+	return fmt.Errorf("invalid structured field at offset %d: %s", p.pos, fmt.Sprintf(format, v...))
+}
+
+func (p *fieldParser) peek() byte {
+	if p.pos < len(p.s) {
+		return p.s[p.pos]
+	}
+	return 0
+}
+
+func (p *fieldParser) skip(chars string) {
+	for p.pos < len(p.s) && strings.IndexByte(chars, p.s[p.pos]) >= 0 {
+		p.pos++
+	}
+}
+
+func (p *fieldParser) member() (item, error) {
+	if p.peek() == '(' {
+		return p.innerList()
+	}
+	return p.item()
+}
+
+func (p *fieldParser) innerList() (item, error) {
+	p.pos++
+	list := make([]item, 0)
+	for {
+		p.skip(" ")
+		if p.peek() == ')' {
+			p.pos++
+			break
+		}
+		if p.pos == len(p.s) {
+			return item{}, p.errorf("unterminated inner list")
+		}
+		if len(list) > 0 && p.s[p.pos-1] != ' ' {
+			return item{}, p.errorf("expected a space between list items")
+		}
+		it, err := p.item()
+		if err != nil {
+			return item{}, err
+		}
+		list = append(list, it)
+	}
+	params, err := p.params()
+	return item{list, params}, err
+}
+
+func (p *fieldParser) item() (item, error) {
+	value, err := p.bareItem()
+	if err != nil {
+		return item{}, err
+	}
+	params, err := p.params()
+	return item{value, params}, err
+}
+
+func (p *fieldParser) params() ([]param, error) {
+	params := make([]param, 0)
+	for p.peek() == ';' {
+		p.pos++
+		p.skip(" ")
+		key, err := p.key()
+		if err != nil {
+			return nil, err
+		}
+		var value interface{} = true
+		if p.peek() == '=' {
+			p.pos++
+			if value, err = p.bareItem(); err != nil {
+				return nil, err
+			}
+		}
+		params = append(params, param{key, value})
+	}
+	return params, nil
+}
+
+func (p *fieldParser) key() (string, error) {
+	start := p.pos
+	if c := p.peek(); !(c >= 'a' && c <= 'z') && c != '*' {
+		return "", p.errorf("expected a key")
+	}
+	for p.pos < len(p.s) {
+		c := p.s[p.pos]
+		if !(c >= 'a' && c <= 'z') && !(c >= '0' && c <= '9') && strings.IndexByte("_-.*", c) < 0 {
+			break
+		}
+		p.pos++
+	}
+	return p.s[start:p.pos], nil
+}
+
+func (p *fieldParser) bareItem() (interface{}, error) {
+	c := p.peek()
+	switch {
+	case c == '"':
+		return p.string()
+	case c == ':':
+		return p.bytes()
+	case c == '?':
+		if p.pos+1 < len(p.s) && (p.s[p.pos+1] == '0' || p.s[p.pos+1] == '1') {
+			p.pos += 2
+			return p.s[p.pos-1] == '1', nil
+		}
+		return nil, p.errorf("invalid boolean")
+	case c == '-' || (c >= '0' && c <= '9'):
+		return p.integer()
+	case (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || c == '*':
+		start := p.pos
+		for p.pos < len(p.s) && isTokenChar(p.s[p.pos]) {
+			p.pos++
+		}
+		return token(p.s[start:p.pos]), nil
+	}
+	return nil, p.errorf("unexpected %q", c)
+}
+
+func (p *fieldParser) string() (string, error) {
+	p.pos++
+	var b strings.Builder
+	for p.pos < len(p.s) {
+		c := p.s[p.pos]
+		p.pos++
+		switch {
+		case c == '\\':
+			if p.pos == len(p.s) || (p.s[p.pos] != '"' && p.s[p.pos] != '\\') {
+				return "", p.errorf("invalid escape")
+			}
+			b.WriteByte(p.s[p.pos])
+			p.pos++
+		case c == '"':
+			return b.String(), nil
+		case c < 0x20 || c > 0x7e:
+			return "", p.errorf("invalid character in string")
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return "", p.errorf("unterminated string")
+}
+
+func (p *fieldParser) bytes() ([]byte, error) {
+	p.pos++
+	end := strings.IndexByte(p.s[p.pos:], ':')
+	if end < 0 {
+		return nil, p.errorf("unterminated byte sequence")
+	}
+	b, err := base64.StdEncoding.DecodeString(p.s[p.pos : p.pos+end])
+	if err != nil {
+		return nil, p.errorf("invalid byte sequence: %v", err)
+	}
+	p.pos += end + 1
+	return b, nil
+}
+
+func (p *fieldParser) integer() (int64, error) {
+	start := p.pos
+	if p.peek() == '-' {
+		p.pos++
+	}
+	for p.pos < len(p.s) && p.s[p.pos] >= '0' && p.s[p.pos] <= '9' {
+		p.pos++
+	}
+	if p.peek() == '.' {
+		return 0, p.errorf("decimals are not supported")
+	}
+	digits := p.s[start:p.pos]
+	if len(strings.TrimPrefix(digits, "-")) == 0 || len(strings.TrimPrefix(digits, "-")) > 15 {
+		return 0, p.errorf("invalid integer")
+	}
+	return strconv.ParseInt(digits, 10, 64)
+}
+
+func isTokenChar(c byte) bool {
+	return c > 0x20 && c < 0x7f && strings.IndexByte(`"(),;<=>?@[\]{}`, c) < 0
+}
+
+// serializeInnerList writes an inner list of strings with its parameters.
+func serializeInnerList(values []string, params []param) (string, error) {
+	var b strings.Builder
+	b.WriteByte('(')
+	for i, v := range values {
+		if i > 0 {
+			b.WriteByte(' ')
+		}
+		s, err := serializeBareItem(v)
+		if err != nil {
+			return "", err
+		}
+		b.WriteString(s)
+	}
+	b.WriteByte(')')
+	for _, p := range params {
+		b.WriteByte(';')
+		b.WriteString(p.key)
+		if p.value == true {
+			continue
+		}
+		s, err := serializeBareItem(p.value)
+		if err != nil {
+			return "", err
+		}
+		b.WriteByte('=')
+		b.WriteString(s)
+	}
+	return b.String(), nil
+}
+
+func serializeBareItem(v interface{}) (string, error) {
+	switch v := v.(type) {
+	case string:
+		for i := 0; i < len(v); i++ {
+			if v[i] < 0x20 || v[i] > 0x7e {
+				return "", errors.New("strings must be printable ASCII")
+			}
+		}
+		return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(v) + `"`, nil
+	case token:
+		return string(v), nil
+	case int64:
+		return strconv.FormatInt(v, 10), nil
+	case []byte:
+		return ":" + base64.StdEncoding.EncodeToString(v) + ":", nil
+	case bool:
+		if v {
+			return "?1", nil
+		}
+		return "?0", nil
+	}
+	return "", fmt.Errorf("cannot serialize %T", v)
+}
@@ -0,0 +1,466 @@
+package httpsig
+
+// HTTP Message Signatures (RFC 9421) with pkg/signing keys.
+//
+// A Signer covers a list of message components, e.g. the status, Content-Digest and Date
+// of a response, with the active key of a keyring and adds the Signature and
+// Signature-Input fields. A Verifier checks those fields against a key source, requires
+// that the signature covers a minimum set of components, that it is recent and that the
+// body matches its Content-Digest (RFC 9530).
+//
+// Supported algorithms are ecdsa-p256-sha256, ecdsa-p384-sha384, ed25519 and
+// rsa-v1_5-sha256. As with JWTs the algorithm is derived from the key; an alg parameter
+// which does not match the key is rejected.
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/brnsampson/echopilot/pkg/signing"
+)
+
+// Signatures older than this are rejected. Signatures created up to this far in the
+// future are accepted to allow for clock skew.
+const MaxAge = 5 * time.Minute
+
+// Bodies larger than this are neither signed nor verified.
+const MaxBodySize = 10 << 20
+
+// Label of the signatures we create.
+const DefaultLabel = "sig1"
+
+var (
+	ErrNoSignature        = errors.New("message is not signed")
+	ErrMalformedSignature = errors.New("malformed message signature")
+	ErrMissingComponent   = errors.New("signature does not cover a required component")
+	ErrSignatureExpired   = errors.New("message signature has expired")
+	ErrDigestMismatch     = errors.New("content digest does not match the body")
+)
+
+// Components covered when signing, if present in the message.
+var (
+	RequestComponents  = []string{"@method", "@authority", "@path", "@query", "content-type", "content-digest", "date"}
+	ResponseComponents = []string{"@status", "content-type", "content-digest", "date"}
+)
+
+// Components a signature must cover to be accepted.
+var (
+	RequiredRequestComponents  = []string{"@method", "@authority", "@path", "@query", "content-digest"}
+	RequiredResponseComponents = []string{"@status", "content-digest"}
+)
+
+type KeySigner interface {
+	Signer() (string, *signing.PrivateKey, error)
+}
+
+type KeySource interface {
+	Lookup(keyid string) (*signing.PublicKey, error)
+}
+
+// StaticKey signs with Private and verifies with Public, identified by their key IDs.
+// Either may be nil.
+type StaticKey struct {
+	Private *signing.PrivateKey
+	Public  *signing.PublicKey
+}
+
+func (k StaticKey) Signer() (string, *signing.PrivateKey, error) {
+	if k.Private == nil {
+		return "", nil, signing.ErrNoActiveKey
+	}
+	return k.Private.Public().KeyID(), k.Private, nil
+}
+
+func (k StaticKey) Lookup(keyid string) (*signing.PublicKey, error) {
+	if k.Public == nil || k.Public.KeyID() != keyid {
+		return nil, fmt.Errorf("%w: %s", signing.ErrUnknownKey, keyid)
+	}
+	return k.Public, nil
+}
+
+// AlgorithmName returns the RFC 9421 name of the algorithm used with keys of alg.
+func AlgorithmName(alg signing.Algorithm) (string, error) {
+	switch alg {
+	case signing.ECDSAP256:
+		return "ecdsa-p256-sha256", nil
+	case signing.ECDSAP384:
+		return "ecdsa-p384-sha384", nil
+	case signing.Ed25519:
+		return "ed25519", nil
+	case signing.RSA:
+		return "rsa-v1_5-sha256", nil
+	}
+	return "", fmt.Errorf("%w: %s has no HTTP signature algorithm", signing.ErrUnsupportedAlgorithm, alg)
+}
+
+type Signer struct {
+	Keys KeySigner
+	// Signatures are added under this label. Defaults to DefaultLabel.
+	Label string
+	// Components to cover. Defaults to RequestComponents or ResponseComponents. Header
+	// fields missing from a message are left out.
+	Components []string
+}
+
+func NewSigner(keys KeySigner) *Signer {
+	return &Signer{Keys: keys}
+}
+
+// SignRequest sets Content-Digest and, if missing, Date and signs the request. body must
+// be the complete request body.
+func (s *Signer) SignRequest(r *http.Request, body []byte) error {
+	prepare(r.Header, body)
+	return s.sign(requestMessage(r), s.components(RequestComponents), r.Header)
+}
+
+// SignResponse sets Content-Digest and, if missing, Date and signs the response.
+func (s *Signer) SignResponse(status int, header http.Header, body []byte) error {
+	prepare(header, body)
+	return s.sign(responseMessage(status, header), s.components(ResponseComponents), header)
+}
+
+func prepare(header http.Header, body []byte) {
+	header.Set("Content-Digest", ContentDigest(body))
+	if header.Get("Date") == "" {
+		header.Set("Date", time.Now().UTC().Format(http.TimeFormat))
+	}
+}
+
+func (s *Signer) components(defaults []string) []string {
+	if s.Components != nil {
+		return s.Components
+	}
+	return defaults
+}
+
+func (s *Signer) sign(m message, components []string, header http.Header) error {
+	keyid, key, err := s.Keys.Signer()
+	if err != nil {
+		return err
+	}
+	alg, err := AlgorithmName(key.Algorithm())
+	if err != nil {
+		return err
+	}
+
+	covered := make([]string, 0, len(components))
+	for _, c := range components {
+		if strings.HasPrefix(c, "@") || len(header.Values(c)) > 0 {
+			covered = append(covered, c)
+		}
+	}
+
+	params := []param{
+		{"created", time.Now().Unix()},
+		{"keyid", keyid},
+		{"alg", alg},
+	}
+	input, err := serializeInnerList(covered, params)
+	if err != nil {
+		return err
+	}
+	base, err := signatureBase(m, covered, input)
+	if err != nil {
+		return err
+	}
+	sig, err := key.Sign([]byte(base), signing.Raw)
+	if err != nil {
+		return err
+	}
+
+	label := s.Label
+	if label == "" {
+		label = DefaultLabel
+	}
+	sigValue, _ := serializeBareItem(sig)
+	header.Set("Signature-Input", label+"="+input)
+	header.Set("Signature", label+"="+sigValue)
+	return nil
+}
+
+type Verifier struct {
+	Keys KeySource
+	// Components every signature must cover. Defaults to RequiredRequestComponents or
+	// RequiredResponseComponents.
+	Required []string
+	// Maximum age of a signature. Zero disables the check.
+	MaxAge time.Duration
+}
+
+func NewVerifier(keys KeySource) *Verifier {
+	return &Verifier{Keys: keys, MaxAge: MaxAge}
+}
+
+// VerifyRequest checks the signatures of a request and returns the key ID of the first
+// valid one. body must be the complete request body.
+func (v *Verifier) VerifyRequest(r *http.Request, body []byte) (string, error) {
+	return v.verify(requestMessage(r), v.required(RequiredRequestComponents), body)
+}
+
+// VerifyResponse checks the signatures of a response and returns the key ID of the
+// first valid one. body must be the complete response body.
+func (v *Verifier) VerifyResponse(res *http.Response, body []byte) (string, error) {
+	return v.verify(responseMessage(res.StatusCode, res.Header), v.required(RequiredResponseComponents), body)
+}
+
+func (v *Verifier) required(defaults []string) []string {
+	if v.Required != nil {
+		return v.Required
+	}
+	return defaults
+}
+
+func (v *Verifier) verify(m message, required []string, body []byte) (string, error) {
+	inputs, sigs := m.header.Values("Signature-Input"), m.header.Values("Signature")
+	if len(inputs) == 0 && len(sigs) == 0 {
+		return "", ErrNoSignature
+	}
+	inputMembers, err := parseDictionary(strings.Join(inputs, ", "))
+	if err != nil {
+		return "", fmt.Errorf("%w: Signature-Input: %v", ErrMalformedSignature, err)
+	}
+	sigMembers, err := parseDictionary(strings.Join(sigs, ", "))
+	if err != nil {
+		return "", fmt.Errorf("%w: Signature: %v", ErrMalformedSignature, err)
+	}
+
+	signatures := make(map[string][]byte, len(sigMembers))
+	for _, s := range sigMembers {
+		if sig, found := s.item.value.([]byte); found {
+			signatures[s.key] = sig
+		}
+	}
+
+	// Accept the message if any of its signatures is valid, but report the first error
+	// otherwise. Labels are tried in order so that the error is stable.
+	sort.Slice(inputMembers, func(i, j int) bool { return inputMembers[i].key < inputMembers[j].key })
+	var first error
+	for _, input := range inputMembers {
+		sig, found := signatures[input.key]
+		if !found {
+			continue
+		}
+		keyid, err := v.verifySignature(m, required, input.item, sig)
+		if err == nil {
+			err = checkDigest(m.header, body)
+		}
+		if err == nil {
+			return keyid, nil
+		}
+		if first == nil {
+			first = fmt.Errorf("signature %s: %w", input.key, err)
+		}
+	}
+	if first == nil {
+		return "", fmt.Errorf("%w: no Signature for any Signature-Input", ErrMalformedSignature)
+	}
+	return "", first
+}
+
+func (v *Verifier) verifySignature(m message, required []string, input item, sig []byte) (string, error) {
+	list, found := input.value.([]item)
+	if !found {
+		return "", fmt.Errorf("%w: Signature-Input must be an inner list", ErrMalformedSignature)
+	}
+	covered := make([]string, 0, len(list))
+	for _, it := range list {
+		name, found := it.value.(string)
+		if !found || len(it.params) > 0 {
+			return "", fmt.Errorf("%w: unsupported component %v", ErrMalformedSignature, it.value)
+		}
+		covered = append(covered, name)
+	}
+	for _, r := range required {
+		if !contains(covered, r) {
+			return "", fmt.Errorf("%w: %s", ErrMissingComponent, r)
+		}
+	}
+
+	keyid, _ := paramString(input, "keyid")
+	if keyid == "" {
+		return "", fmt.Errorf("%w: missing keyid", ErrMalformedSignature)
+	}
+	if err := v.checkTimes(input); err != nil {
+		return keyid, err
+	}
+
+	pub, err := v.Keys.Lookup(keyid)
+	if err != nil {
+		return keyid, err
+	}
+	if alg, found := paramString(input, "alg"); found {
+		if want, err := AlgorithmName(pub.Algorithm()); err != nil || alg != want {
+			return keyid, fmt.Errorf("%w: key %s cannot be used with %q", ErrMalformedSignature, keyid, alg)
+		}
+	}
+
+	serialized, err := serializeInnerList(covered, input.params)
+	if err != nil {
+		return keyid, fmt.Errorf("%w: %v", ErrMalformedSignature, err)
+	}
+	base, err := signatureBase(m, covered, serialized)
+	if err != nil {
+		return keyid, err
+	}
+	return keyid, pub.Verify([]byte(base), sig, signing.Raw)
+}
+
+func (v *Verifier) checkTimes(input item) error {
+	now := time.Now()
+	if expires, found := input.param("expires"); found {
+		n, valid := expires.(int64)
+		if !valid {
+			return fmt.Errorf("%w: expires must be an integer", ErrMalformedSignature)
+		}
+		if now.After(time.Unix(n, 0)) {
+			return ErrSignatureExpired
+		}
+	}
+
+	if v.MaxAge <= 0 {
+		return nil
+	}
+	value, found := input.param("created")
+	n, valid := value.(int64)
+	if !found || !valid {
+		return fmt.Errorf("%w: created is missing", ErrMalformedSignature)
+	}
+	created := time.Unix(n, 0)
+	if now.Sub(created) > v.MaxAge {
+		return fmt.Errorf("%w: created %s", ErrSignatureExpired, created.UTC().Format(time.RFC3339))
+	}
+	if created.Sub(now) > v.MaxAge {
+		return fmt.Errorf("%w: created %s is in the future", ErrMalformedSignature, created.UTC().Format(time.RFC3339))
+	}
+	return nil
+}
+
+func paramString(it item, key string) (string, bool) {
+	value, found := it.param(key)
+	s, valid := value.(string)
+	return s, found && valid
+}
+
+func contains(ss []string, s string) bool {
+	for _, x := range ss {
+		if x == s {
+			return true
+		}
+	}
+	return false
+}
+
+// message provides the components of a request or a response.
+type message struct {
+	header  http.Header
+	request *http.Request
+	status  int
+}
+
+func requestMessage(r *http.Request) message {
+	return message{header: r.Header, request: r}
+}
+
+func responseMessage(status int, header http.Header) message {
+	return message{header: header, status: status}
+}
+
+func (m message) component(name string) (string, error) {
+	if !strings.HasPrefix(name, "@") {
+		if name != strings.ToLower(name) {
+			return "", fmt.Errorf("%w: component %q must be lowercase", ErrMalformedSignature, name)
+		}
+		values := m.header.Values(name)
+		if len(values) == 0 {
+			return "", fmt.Errorf("%w: %q is not in the message", ErrMissingComponent, name)
+		}
+		trimmed := make([]string, len(values))
+		for i, v := range values {
+			trimmed[i] = strings.TrimSpace(v)
+		}
+		return strings.Join(trimmed, ", "), nil
+	}
+
+	if name == "@status" {
+		if m.request != nil {
+			return "", fmt.Errorf("%w: %s is only defined for responses", ErrMalformedSignature, name)
+		}
+		return strconv.Itoa(m.status), nil
+	}
+
+	r := m.request
+	if r == nil {
+		return "", fmt.Errorf("%w: %s is not supported for responses", ErrMalformedSignature, name)
+	}
+	switch name {
+	case "@method":
+		if r.Method == "" {
+			return http.MethodGet, nil
+		}
+		return r.Method, nil
+	case "@authority":
+		// Clients may leave Host empty to use the URL, servers always see it.
+		if r.Host != "" {
+			return strings.ToLower(r.Host), nil
+		}
+		return strings.ToLower(r.URL.Host), nil
+	case "@scheme":
+		// Only reliable without a TLS terminating proxy in front of us.
+		if r.URL.Scheme != "" {
+			return strings.ToLower(r.URL.Scheme), nil
+		}
+		if r.TLS != nil {
+			return "https", nil
+		}
+		return "http", nil
+	case "@path":
+		if path := r.URL.EscapedPath(); path != "" {
+			return path, nil
+		}
+		return "/", nil
+	case "@query":
+		return "?" + r.URL.RawQuery, nil
+	}
+	return "", fmt.Errorf("%w: unsupported component %s", ErrMalformedSignature, name)
+}
+
+// signatureBase builds the signature base (RFC 9421, section 2.5) from the covered
+// components and the serialized signature parameters.
+func signatureBase(m message, covered []string, params string) (string, error) {
+	var b strings.Builder
+	for _, name := range covered {
+		value, err := m.component(name)
+		if err != nil {
+			return "", err
+		}
+		id, err := serializeBareItem(name)
+		if err != nil {
+			return "", fmt.Errorf("%w: %v", ErrMalformedSignature, err)
+		}
+		if strings.ContainsAny(value, "\r\n") {
+			return "", fmt.Errorf("%w: %s contains a line break", ErrMalformedSignature, name)
+		}
+		fmt.Fprintf(&b, "%s: %s\n", id, value)
+	}
+	fmt.Fprintf(&b, "\"@signature-params\": %s", params)
+	return b.String(), nil
+}
+
+type contextKey struct{}
+
+// WithKeyID records the key ID of a verified request signature in the context.
+func WithKeyID(ctx context.Context, keyid string) context.Context {
+	return context.WithValue(ctx, contextKey{}, keyid)
+}
+
+// KeyID returns the key ID recorded by WithKeyID.
+func KeyID(ctx context.Context) (string, bool) {
+	keyid, found := ctx.Value(contextKey{}).(string)
+	return keyid, found
+}
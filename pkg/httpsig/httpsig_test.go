@@ -0,0 +1,251 @@
+package httpsig_test
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/brnsampson/echopilot/pkg/httpsig"
+	"github.com/brnsampson/echopilot/pkg/signing"
+)
+
+// assert fails the test if the condition is false.
+func assert(tb testing.TB, condition bool, msg string, v ...interface{}) {
+	if !condition {
+		_, file, line, _ := runtime.Caller(1)
+		fmt.Printf("\033[31m%s:%d: "+msg+"\033[39m\n\n", append([]interface{}{filepath.Base(file), line}, v...)...)
+		tb.FailNow()
+	}
+}
+
+// ok fails the test if an err is not nil.
+func ok(tb testing.TB, err error) {
+	if err != nil {
+		_, file, line, _ := runtime.Caller(1)
+		fmt.Printf("\033[31m%s:%d: unexpected error: %s\033[39m\n\n", filepath.Base(file), line, err.Error())
+		tb.FailNow()
+	}
+}
+
+// equals fails the test if exp is not equal to act.
+func equals(tb testing.TB, exp, act interface{}) {
+	if !reflect.DeepEqual(exp, act) {
+		_, file, line, _ := runtime.Caller(1)
+		fmt.Printf("\033[31m%s:%d:\n\n\texp: %#v\n\n\tgot: %#v\033[39m\n\n", filepath.Base(file), line, exp, act)
+		tb.FailNow()
+	}
+}
+
+// The Ed25519 test key and the signed request of RFC 9421, appendix B.2.6.
+const rfcPublicKey = `-----BEGIN PUBLIC KEY-----
+MCowBQYDK2VwAyEAJrQLj5P/89iXES9+vFgrIy29clF9CC/oPPsw3c5D0bs=
+-----END PUBLIC KEY-----
+`
+
+func rfcRequest() *http.Request {
+	r := httptest.NewRequest("POST", "http://example.com/foo?param=Value&Pet=dog", strings.NewReader(`{"hello": "world"}`))
+	r.Header.Set("Date", "Tue, 20 Apr 2021 02:07:55 GMT")
+	r.Header.Set("Content-Type", "application/json")
+	r.Header.Set("Content-Digest", "sha-512=:WZDPaVn/7XgHaAy8pmojAkGWoRx2UFChF41A2svX+TaPm+AbwAgBWnrIiYllu7BNNyealdVLvRwEmTHWXvJwew==:")
+	r.Header.Set("Content-Length", "18")
+	r.Header.Set("Signature-Input", `sig-b26=("date" "@method" "@path" "@authority" "content-type" "content-length");created=1618884473;keyid="test-key-ed25519"`)
+	r.Header.Set("Signature", "sig-b26=:wqcAqbmYJ2ji2glfAMaRy4gruYYnx2nEFN2HN6jrnDnQCK1u02Gb04v9EDgwUPiu4A0w6vuQv5lIp5WPpBKRCw==:")
+	return r
+}
+
+// rfcKey serves the RFC test key under its name in the RFC.
+type rfcKey struct {
+	pub *signing.PublicKey
+}
+
+func (k rfcKey) Lookup(keyid string) (*signing.PublicKey, error) {
+	if keyid != "test-key-ed25519" {
+		return nil, signing.ErrUnknownKey
+	}
+	return k.pub, nil
+}
+
+func TestRFCExample(t *testing.T) {
+	pub, err := signing.ParsePublicKeyPEM([]byte(rfcPublicKey))
+	ok(t, err)
+
+	// The example is from 2021 and does not cover the digest.
+	verifier := &httpsig.Verifier{Keys: rfcKey{pub}, Required: []string{"@method", "@path", "@authority"}}
+	keyid, err := verifier.VerifyRequest(rfcRequest(), []byte(`{"hello": "world"}`))
+	ok(t, err)
+	equals(t, "test-key-ed25519", keyid)
+
+	_, err = verifier.VerifyRequest(rfcRequest(), []byte(`{"hello": "mallory"}`))
+	assert(t, errors.Is(err, httpsig.ErrDigestMismatch), "expected ErrDigestMismatch, got %v", err)
+
+	tampered := rfcRequest()
+	tampered.Method = "PUT"
+	_, err = verifier.VerifyRequest(tampered, []byte(`{"hello": "world"}`))
+	assert(t, errors.Is(err, signing.ErrInvalidSignature), "expected ErrInvalidSignature, got %v", err)
+
+	verifier.MaxAge = httpsig.MaxAge
+	_, err = verifier.VerifyRequest(rfcRequest(), []byte(`{"hello": "world"}`))
+	assert(t, errors.Is(err, httpsig.ErrSignatureExpired), "expected ErrSignatureExpired, got %v", err)
+
+	verifier.MaxAge = 0
+	verifier.Required = nil
+	_, err = verifier.VerifyRequest(rfcRequest(), []byte(`{"hello": "world"}`))
+	assert(t, errors.Is(err, httpsig.ErrMissingComponent), "expected ErrMissingComponent, got %v", err)
+}
+
+var algorithms = []signing.Algorithm{signing.ECDSAP256, signing.ECDSAP384, signing.Ed25519, signing.RSA}
+
+func TestSignVerifyResponse(t *testing.T) {
+	for _, alg := range algorithms {
+		key, err := signing.GenerateKey(alg)
+		ok(t, err)
+		body := []byte(`{"message": "hello"}`)
+
+		rec := httptest.NewRecorder()
+		rec.Header().Set("Content-Type", "application/json")
+		ok(t, httpsig.NewSigner(httpsig.StaticKey{Private: key}).SignResponse(http.StatusCreated, rec.Header(), body))
+		rec.WriteHeader(http.StatusCreated)
+		rec.Write(body)
+		res := rec.Result()
+		assert(t, strings.Contains(res.Header.Get("Signature-Input"), `"@status" "content-type" "content-digest" "date"`), "%s: unexpected components in %s", alg, res.Header.Get("Signature-Input"))
+
+		verifier := httpsig.NewVerifier(httpsig.StaticKey{Public: key.Public()})
+		keyid, err := verifier.VerifyResponse(res, body)
+		ok(t, err)
+		equals(t, key.Public().KeyID(), keyid)
+
+		res.StatusCode = http.StatusOK
+		_, err = verifier.VerifyResponse(res, body)
+		assert(t, errors.Is(err, signing.ErrInvalidSignature), "%s: expected ErrInvalidSignature, got %v", alg, err)
+
+		res.StatusCode = http.StatusCreated
+		_, err = verifier.VerifyResponse(res, []byte(`{"message": "goodbye"}`))
+		assert(t, errors.Is(err, httpsig.ErrDigestMismatch), "%s: expected ErrDigestMismatch, got %v", alg, err)
+
+		other, err := signing.GenerateKey(signing.Ed25519)
+		ok(t, err)
+		_, err = httpsig.NewVerifier(httpsig.StaticKey{Public: other.Public()}).VerifyResponse(res, body)
+		assert(t, errors.Is(err, signing.ErrUnknownKey), "%s: expected ErrUnknownKey, got %v", alg, err)
+	}
+}
+
+func TestVerifyRejects(t *testing.T) {
+	key, err := signing.GenerateKey(signing.ECDSAP256)
+	ok(t, err)
+	signer := httpsig.NewSigner(httpsig.StaticKey{Private: key})
+	verifier := httpsig.NewVerifier(httpsig.StaticKey{Public: key.Public()})
+
+	signed := func() *http.Request {
+		r := httptest.NewRequest("GET", "https://echo.test/v1/echo?x=1", nil)
+		ok(t, signer.SignRequest(r, nil))
+		return r
+	}
+
+	_, err = verifier.VerifyRequest(signed(), nil)
+	ok(t, err)
+
+	_, err = verifier.VerifyRequest(httptest.NewRequest("GET", "/", nil), nil)
+	assert(t, errors.Is(err, httpsig.ErrNoSignature), "expected ErrNoSignature, got %v", err)
+
+	for name, mutate := range map[string]func(r *http.Request){
+		"path":      func(r *http.Request) { r.URL.Path = "/v1/other" },
+		"query":     func(r *http.Request) { r.URL.RawQuery = "x=2" },
+		"authority": func(r *http.Request) { r.Host = "mallory.test" },
+	} {
+		r := signed()
+		mutate(r)
+		_, err = verifier.VerifyRequest(r, nil)
+		assert(t, errors.Is(err, signing.ErrInvalidSignature), "%s: expected ErrInvalidSignature, got %v", name, err)
+	}
+
+	// Signing fewer components than the verifier requires.
+	partial := httpsig.NewSigner(httpsig.StaticKey{Private: key})
+	partial.Components = []string{"@method", "@path"}
+	r := httptest.NewRequest("GET", "https://echo.test/", nil)
+	ok(t, partial.SignRequest(r, nil))
+	_, err = verifier.VerifyRequest(r, nil)
+	assert(t, errors.Is(err, httpsig.ErrMissingComponent), "expected ErrMissingComponent, got %v", err)
+
+	// The alg parameter must match the key.
+	r = signed()
+	r.Header.Set("Signature-Input", strings.Replace(r.Header.Get("Signature-Input"), "ecdsa-p256-sha256", "ed25519", 1))
+	_, err = verifier.VerifyRequest(r, nil)
+	assert(t, errors.Is(err, httpsig.ErrMalformedSignature), "expected ErrMalformedSignature, got %v", err)
+
+	for _, input := range []string{`sig1=("@method"`, `sig1="@method"`, `sig1=("@method");keyid=1`, `sig1=("@method" "@path";req);keyid="x"`, `SIG=()`} {
+		r = signed()
+		r.Header.Set("Signature-Input", input)
+		_, err = verifier.VerifyRequest(r, nil)
+		assert(t, errors.Is(err, httpsig.ErrMalformedSignature) || errors.Is(err, httpsig.ErrMissingComponent), "%s: expected a malformed signature, got %v", input, err)
+	}
+}
+
+func TestTransport(t *testing.T) {
+	serverKey, err := signing.GenerateKey(signing.Ed25519)
+	ok(t, err)
+	clientKey, err := signing.GenerateKey(signing.ECDSAP384)
+	ok(t, err)
+
+	serverSigner := httpsig.NewSigner(httpsig.StaticKey{Private: serverKey})
+	clientVerifier := httpsig.NewVerifier(httpsig.StaticKey{Public: clientKey.Public()})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		ok(t, err)
+		keyid, err := clientVerifier.VerifyRequest(r, body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		reply := []byte("hello " + keyid)
+		ok(t, serverSigner.SignResponse(http.StatusOK, w.Header(), reply))
+		w.Write(reply)
+	}))
+	defer srv.Close()
+
+	client := &http.Client{Transport: &httpsig.Transport{
+		Signer:   httpsig.NewSigner(httpsig.StaticKey{Private: clientKey}),
+		Verifier: httpsig.NewVerifier(httpsig.StaticKey{Public: serverKey.Public()}),
+	}}
+	res, err := client.Post(srv.URL+"/echo", "text/plain", bytes.NewReader([]byte("hi")))
+	ok(t, err)
+	body, err := io.ReadAll(res.Body)
+	ok(t, err)
+	equals(t, "hello "+clientKey.Public().KeyID(), string(body))
+
+	// Responses from anyone else are rejected.
+	impostor, err := signing.GenerateKey(signing.Ed25519)
+	ok(t, err)
+	serverSigner.Keys = httpsig.StaticKey{Private: impostor}
+	_, err = client.Post(srv.URL+"/echo", "text/plain", bytes.NewReader([]byte("hi")))
+	assert(t, errors.Is(err, signing.ErrUnknownKey), "expected ErrUnknownKey, got %v", err)
+
+	// Unsigned requests are rejected by the server, and the error response is unsigned.
+	client.Transport.(*httpsig.Transport).Signer = nil
+	_, err = client.Post(srv.URL+"/echo", "text/plain", bytes.NewReader([]byte("hi")))
+	assert(t, errors.Is(err, httpsig.ErrNoSignature), "expected ErrNoSignature, got %v", err)
+}
+
+func FuzzVerifyRequest(f *testing.F) {
+	r := rfcRequest()
+	f.Add(r.Header.Get("Signature-Input"), r.Header.Get("Signature"), r.Header.Get("Content-Digest"))
+	f.Add(`a=(), b=?0;x, c=("x" "y");n=-1;t=tok`, `a=:AA==:`, `sha-256=:AA==:, md5=1`)
+
+	pub, err := signing.ParsePublicKeyPEM([]byte(rfcPublicKey))
+	ok(f, err)
+	verifier := &httpsig.Verifier{Keys: rfcKey{pub}, Required: []string{}}
+	f.Fuzz(func(t *testing.T, input, signature, digest string) {
+		r := rfcRequest()
+		r.Header.Set("Signature-Input", input)
+		r.Header.Set("Signature", signature)
+		r.Header.Set("Content-Digest", digest)
+		verifier.VerifyRequest(r, []byte(`{"hello": "world"}`))
+	})
+}
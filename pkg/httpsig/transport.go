@@ -0,0 +1,75 @@
+package httpsig
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// Transport signs outgoing requests with Signer and verifies the signatures of responses
+// with Verifier. Either may be nil. Responses which fail verification are discarded and
+// returned as an error instead.
+type Transport struct {
+	Base     http.RoundTripper
+	Signer   *Signer
+	Verifier *Verifier
+}
+
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+
+	if t.Signer != nil {
+		body, err := readBody(req.Body)
+		if err != nil {
+			return nil, err
+		}
+		// RoundTrippers must not modify the request they were given.
+		req = req.Clone(req.Context())
+		req.Body = io.NopCloser(bytes.NewReader(body))
+		req.ContentLength = int64(len(body))
+		req.GetBody = func() (io.ReadCloser, error) {
+			return io.NopCloser(bytes.NewReader(body)), nil
+		}
+		if len(body) == 0 {
+			req.Body = http.NoBody
+		}
+		if err := t.Signer.SignRequest(req, body); err != nil {
+			return nil, fmt.Errorf("signing request: %w", err)
+		}
+	}
+
+	res, err := base.RoundTrip(req)
+	if err != nil || t.Verifier == nil {
+		return res, err
+	}
+
+	body, err := readBody(res.Body)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := t.Verifier.VerifyResponse(res, body); err != nil {
+		return nil, fmt.Errorf("response from %s: %w", req.URL.Host, err)
+	}
+	res.Body = io.NopCloser(bytes.NewReader(body))
+	return res, nil
+}
+
+// readBody reads and closes body, which may be nil.
+func readBody(body io.ReadCloser) ([]byte, error) {
+	if body == nil || body == http.NoBody {
+		return nil, nil
+	}
+	defer body.Close()
+	data, err := io.ReadAll(io.LimitReader(body, MaxBodySize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > MaxBodySize {
+		return nil, fmt.Errorf("body is larger than %d bytes", MaxBodySize)
+	}
+	return data, nil
+}
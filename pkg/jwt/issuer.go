@@ -0,0 +1,64 @@
+package jwt
+
+import (
+	"time"
+
+	"github.com/brnsampson/echopilot/pkg/signing"
+)
+
+// KeySigner provides the key tokens are currently signed with. *signing.Keyring
+// implements it.
+type KeySigner interface {
+	Signer() (string, *signing.PrivateKey, error)
+}
+
+// Issuer mints tokens with the active key of a keyring. The zero values of Audience and
+// Claims add nothing to the tokens.
+type Issuer struct {
+	Keys KeySigner
+	// The iss claim.
+	Name string
+	// Default aud claim for tokens which do not set their own.
+	Audience []string
+	// Default lifetime for tokens which do not set an expiry.
+	Lifetime time.Duration
+	// Claims added to every token. Claims given to Issue take precedence.
+	Claims map[string]interface{}
+}
+
+// Issue signs claims after filling in iss, iat, nbf, jti and, unless already set, exp
+// and aud. It returns the token along with the claims it contains.
+func (i *Issuer) Issue(claims Claims) (string, Claims, error) {
+	id, key, err := i.Keys.Signer()
+	if err != nil {
+		return "", claims, err
+	}
+
+	now := time.Now().Truncate(time.Second)
+	claims.Issuer = i.Name
+	claims.IssuedAt = now
+	if claims.NotBefore.IsZero() {
+		claims.NotBefore = now
+	}
+	if claims.ExpiresAt.IsZero() {
+		claims.ExpiresAt = now.Add(i.Lifetime)
+	}
+	if claims.ID == "" {
+		claims.ID = NewID()
+	}
+	if len(claims.Audience) == 0 {
+		claims.Audience = i.Audience
+	}
+
+	extra := make(map[string]interface{}, len(i.Claims)+len(claims.Extra))
+	for k, v := range i.Claims {
+		extra[k] = v
+	}
+	for k, v := range claims.Extra {
+		extra[k] = v
+	}
+	claims.Extra = extra
+
+	token, err := Sign(key, id, claims)
+	return token, claims, err
+}
@@ -0,0 +1,308 @@
+package jwt
+
+// JSON Web Keys (RFC 7517).
+//
+// KeySetHandler publishes the keys of a signing keyring, so that other services can
+// verify our tokens, and RemoteKeySet fetches the keys of another issuer so that we can
+// verify theirs.
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/brnsampson/echopilot/pkg/signing"
+)
+
+// Where the key set is conventionally published.
+const JWKSPath = "/.well-known/jwks.json"
+
+// How long clients may cache our key set. New keys should be published as verify-only
+// at least this long before they become active.
+const JWKSMaxAge = 5 * time.Minute
+
+// Remote key sets larger than this are refused.
+const maxJWKSSize = 1 << 20
+
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid,omitempty"`
+	Use string `json:"use,omitempty"`
+	Alg string `json:"alg,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+}
+
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// NewJWK describes a public key for signature verification.
+func NewJWK(pub *signing.PublicKey, kid string) (JWK, error) {
+	alg, err := Algorithm(pub.Algorithm())
+	if err != nil {
+		return JWK{}, err
+	}
+	jwk := JWK{Kid: kid, Use: "sig", Alg: alg}
+
+	switch key := pub.Key().(type) {
+	case *ecdsa.PublicKey:
+		size := (key.Curve.Params().BitSize + 7) / 8
+		jwk.Kty = "EC"
+		jwk.Crv = key.Curve.Params().Name
+		jwk.X = encodeSegment(key.X.FillBytes(make([]byte, size)))
+		jwk.Y = encodeSegment(key.Y.FillBytes(make([]byte, size)))
+	case ed25519.PublicKey:
+		jwk.Kty = "OKP"
+		jwk.Crv = "Ed25519"
+		jwk.X = encodeSegment(key)
+	case *rsa.PublicKey:
+		jwk.Kty = "RSA"
+		jwk.N = encodeSegment(key.N.Bytes())
+		jwk.E = encodeSegment(big.NewInt(int64(key.E)).Bytes())
+	default:
+		return JWK{}, fmt.Errorf("%w: %T", signing.ErrUnsupportedAlgorithm, key)
+	}
+	return jwk, nil
+}
+
+// PublicKey decodes the key. Keys which are not meant for signatures are rejected.
+func (j JWK) PublicKey() (*signing.PublicKey, error) {
+	if j.Use != "" && j.Use != "sig" {
+		return nil, fmt.Errorf("key %s is for %q, not signatures", j.Kid, j.Use)
+	}
+
+	var key interface{}
+	switch j.Kty {
+	case "EC":
+		var curve elliptic.Curve
+		switch j.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("%w: curve %q", signing.ErrUnsupportedAlgorithm, j.Crv)
+		}
+		x, errX := decodeSegment(j.X)
+		y, errY := decodeSegment(j.Y)
+		size := (curve.Params().BitSize + 7) / 8
+		if errX != nil || errY != nil || len(x) != size || len(y) != size {
+			return nil, fmt.Errorf("key %s has invalid coordinates", j.Kid)
+		}
+		point := append(append([]byte{4}, x...), y...)
+		px, py := elliptic.Unmarshal(curve, point)
+		if px == nil {
+			return nil, fmt.Errorf("key %s is not on curve %s", j.Kid, j.Crv)
+		}
+		key = &ecdsa.PublicKey{Curve: curve, X: px, Y: py}
+	case "OKP":
+		x, err := decodeSegment(j.X)
+		if j.Crv != "Ed25519" || err != nil || len(x) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("%w: OKP key %s", signing.ErrUnsupportedAlgorithm, j.Kid)
+		}
+		key = ed25519.PublicKey(x)
+	case "RSA":
+		n, errN := decodeSegment(j.N)
+		e, errE := decodeSegment(j.E)
+		if errN != nil || errE != nil || len(e) == 0 || len(e) > 4 || len(n) < 2048/8 {
+			return nil, fmt.Errorf("key %s has invalid RSA parameters", j.Kid)
+		}
+		key = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+	default:
+		return nil, fmt.Errorf("%w: key type %q", signing.ErrUnsupportedAlgorithm, j.Kty)
+	}
+
+	pub, err := signing.NewPublicKey(key)
+	if err != nil {
+		return nil, err
+	}
+	if alg, _ := Algorithm(pub.Algorithm()); j.Alg != "" && j.Alg != alg {
+		return nil, fmt.Errorf("%w: key %s is %s but claims %s", ErrUnsupportedAlg, j.Kid, alg, j.Alg)
+	}
+	return pub, nil
+}
+
+// KeySetOf describes every key of the keyring which may be used for verification.
+func KeySetOf(keyring *signing.Keyring) JWKS {
+	set := JWKS{Keys: make([]JWK, 0)}
+	for _, e := range keyring.Entries() {
+		if e.Status == signing.StatusRetired {
+			continue
+		}
+		jwk, err := NewJWK(e.Public, e.ID)
+		if err != nil {
+			// Keys without a JWS algorithm cannot sign tokens either.
+			continue
+		}
+		set.Keys = append(set.Keys, jwk)
+	}
+	return set
+}
+
+// KeySetHandler serves the current keys of the keyring as a JWKS document.
+func KeySetHandler(keyring *signing.Keyring) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/jwk-set+json")
+		w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", int(JWKSMaxAge.Seconds())))
+		json.NewEncoder(w).Encode(KeySetOf(keyring))
+	})
+}
+
+// RemoteKeySet verifies tokens against the keys published at a JWKS URL. Keys are
+// cached and fetched again once they are older than the TTL, or when a token names a
+// key we have not seen, at most once per MinRefresh so that garbage tokens cannot make
+// us hammer the issuer. Lookups read the cached keys without locking, and only one
+// fetch runs at a time; lookups of known keys do not wait for it.
+type RemoteKeySet struct {
+	url    string
+	client *http.Client
+
+	TTL        time.Duration
+	MinRefresh time.Duration
+
+	// nil until the first fetch.
+	cached atomic.Pointer[remoteKeys]
+
+	mu sync.Mutex
+	// Closed once the fetch in flight, if any, finishes.
+	fetching chan struct{}
+}
+
+type remoteKeys struct {
+	keys    map[string]*signing.PublicKey
+	fetched time.Time
+	// The last fetch error, if any.
+	err error
+}
+
+func NewRemoteKeySet(url string, client *http.Client) *RemoteKeySet {
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+	return &RemoteKeySet{
+		url:        url,
+		client:     client,
+		TTL:        JWKSMaxAge,
+		MinRefresh: 10 * time.Second,
+	}
+}
+
+func (s *RemoteKeySet) URL() string {
+	return s.url
+}
+
+func (s *RemoteKeySet) Lookup(kid string) (*signing.PublicKey, error) {
+	cached := s.cached.Load()
+	var pub *signing.PublicKey
+	found := false
+	if cached != nil {
+		pub, found = cached.keys[kid]
+	}
+
+	if cached == nil || s.due(cached, found) {
+		cached = s.refresh(cached, !found)
+		// Keep using what we have if the issuer is briefly unreachable.
+		pub, found = cached.keys[kid]
+	}
+
+	if !found && cached.err != nil {
+		return nil, cached.err
+	}
+	if !found {
+		return nil, fmt.Errorf("%w: %s", signing.ErrUnknownKey, kid)
+	}
+	return pub, nil
+}
+
+func (s *RemoteKeySet) due(cached *remoteKeys, found bool) bool {
+	since := time.Since(cached.fetched)
+	return since > s.TTL || (!found && since > s.MinRefresh)
+}
+
+// refresh fetches the key set again, unless the keys were replaced since prev was read.
+// If another fetch is already in flight it joins that one, or returns prev straight
+// away unless wait is set.
+func (s *RemoteKeySet) refresh(prev *remoteKeys, wait bool) *remoteKeys {
+	s.mu.Lock()
+	if cached := s.cached.Load(); cached != prev {
+		s.mu.Unlock()
+		return cached
+	}
+	if done := s.fetching; done != nil {
+		s.mu.Unlock()
+		if wait || prev == nil {
+			<-done
+			return s.cached.Load()
+		}
+		return prev
+	}
+	done := make(chan struct{})
+	s.fetching = done
+	s.mu.Unlock()
+
+	// Failures are only retried after MinRefresh too.
+	next := &remoteKeys{fetched: time.Now()}
+	next.keys, next.err = s.fetch()
+	if next.err != nil && prev != nil {
+		next.keys = prev.keys
+	}
+	s.cached.Store(next)
+
+	s.mu.Lock()
+	s.fetching = nil
+	s.mu.Unlock()
+	close(done)
+	return next
+}
+
+func (s *RemoteKeySet) fetch() (map[string]*signing.PublicKey, error) {
+	res, err := s.client.Get(s.url)
+	if err != nil {
+		return nil, fmt.Errorf("fetching %s: %w", s.url, err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetching %s: %s", s.url, res.Status)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(res.Body, maxJWKSSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("fetching %s: %w", s.url, err)
+	}
+	if len(data) > maxJWKSSize {
+		return nil, fmt.Errorf("fetching %s: key set is larger than %d bytes", s.url, maxJWKSSize)
+	}
+
+	var set JWKS
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, fmt.Errorf("invalid key set at %s: %w", s.url, err)
+	}
+
+	keys := make(map[string]*signing.PublicKey, len(set.Keys))
+	for _, jwk := range set.Keys {
+		// Skip keys we cannot use, e.g. encryption keys or unsupported algorithms.
+		if pub, err := jwk.PublicKey(); err == nil && jwk.Kid != "" {
+			keys[jwk.Kid] = pub
+		}
+	}
+	if len(keys) == 0 {
+		return nil, errors.New("no usable signing keys at " + s.url)
+	}
+	return keys, nil
+}
@@ -0,0 +1,294 @@
+package jwt
+
+// JSON Web Tokens (RFC 7519) signed with pkg/signing keys.
+//
+// Only compact JWS with asymmetric keys is supported: ES256, ES384 and ES512 for ECDSA
+// keys on the matching curve, EdDSA for Ed25519 and RS256 for RSA. The algorithm is
+// always derived from the key, never taken on trust from the token header, so "none"
+// and algorithm confusion attacks are rejected by construction.
+//
+// An Issuer mints tokens with the active key of a signing keyring and sets "kid" to
+// the key ID. A Verifier trusts a key source per issuer, e.g. the local keyring for
+// our own tokens and a RemoteKeySet for tokens minted by another service.
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/brnsampson/echopilot/pkg/signing"
+)
+
+// Tokens this much past exp or before nbf are still accepted to allow for clock skew.
+const Leeway = time.Minute
+
+// Tokens larger than this are refused before any parsing.
+const MaxTokenSize = 8 << 10
+
+var (
+	ErrMalformedToken   = errors.New("malformed token")
+	ErrUnsupportedAlg   = errors.New("unsupported token algorithm")
+	ErrTokenExpired     = errors.New("token has expired")
+	ErrTokenNotYetValid = errors.New("token is not valid yet")
+	ErrMissingExpiry    = errors.New("token has no expiry")
+	ErrUntrustedIssuer  = errors.New("token issuer is not trusted")
+	ErrInvalidAudience  = errors.New("token is not intended for this audience")
+)
+
+// Claims holds the registered claims of a token plus any others in Extra. Zero values
+// are left out of the token.
+type Claims struct {
+	Issuer    string
+	Subject   string
+	Audience  []string
+	ExpiresAt time.Time
+	NotBefore time.Time
+	IssuedAt  time.Time
+	ID        string
+	Extra     map[string]interface{}
+}
+
+var registeredClaims = map[string]bool{"iss": true, "sub": true, "aud": true, "exp": true, "nbf": true, "iat": true, "jti": true}
+
+func (c Claims) MarshalJSON() ([]byte, error) {
+	m := make(map[string]interface{}, len(c.Extra)+7)
+	for k, v := range c.Extra {
+		if !registeredClaims[k] {
+			m[k] = v
+		}
+	}
+	setString := func(name, value string) {
+		if value != "" {
+			m[name] = value
+		}
+	}
+	setTime := func(name string, value time.Time) {
+		if !value.IsZero() {
+			m[name] = value.Unix()
+		}
+	}
+	setString("iss", c.Issuer)
+	setString("sub", c.Subject)
+	setString("jti", c.ID)
+	setTime("exp", c.ExpiresAt)
+	setTime("nbf", c.NotBefore)
+	setTime("iat", c.IssuedAt)
+	switch len(c.Audience) {
+	case 0:
+	case 1:
+		m["aud"] = c.Audience[0]
+	default:
+		m["aud"] = c.Audience
+	}
+	return json.Marshal(m)
+}
+
+func (c *Claims) UnmarshalJSON(data []byte) error {
+	var m map[string]interface{}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	if err := decoder.Decode(&m); err != nil {
+		return err
+	}
+
+	claims := Claims{Extra: make(map[string]interface{})}
+	for k, v := range m {
+		var err error
+		switch k {
+		case "iss":
+			claims.Issuer, err = claimString(k, v)
+		case "sub":
+			claims.Subject, err = claimString(k, v)
+		case "jti":
+			claims.ID, err = claimString(k, v)
+		case "exp":
+			claims.ExpiresAt, err = claimTime(k, v)
+		case "nbf":
+			claims.NotBefore, err = claimTime(k, v)
+		case "iat":
+			claims.IssuedAt, err = claimTime(k, v)
+		case "aud":
+			claims.Audience, err = claimAudience(v)
+		default:
+			claims.Extra[k] = v
+		}
+		if err != nil {
+			return err
+		}
+	}
+	*c = claims
+	return nil
+}
+
+func claimString(name string, v interface{}) (string, error) {
+	s, found := v.(string)
+	if !found {
+		return "", fmt.Errorf("claim %s must be a string", name)
+	}
+	return s, nil
+}
+
+func claimTime(name string, v interface{}) (time.Time, error) {
+	n, found := v.(json.Number)
+	if !found {
+		return time.Time{}, fmt.Errorf("claim %s must be a number", name)
+	}
+	f, err := n.Float64()
+	if err != nil || math.IsNaN(f) || math.IsInf(f, 0) || math.Abs(f) > 1<<40 {
+		return time.Time{}, fmt.Errorf("claim %s is not a valid time", name)
+	}
+	sec, frac := math.Modf(f)
+	return time.Unix(int64(sec), int64(frac*1e9)), nil
+}
+
+// The audience may be a single string or an array of strings.
+func claimAudience(v interface{}) ([]string, error) {
+	switch aud := v.(type) {
+	case string:
+		return []string{aud}, nil
+	case []interface{}:
+		out := make([]string, 0, len(aud))
+		for _, a := range aud {
+			s, found := a.(string)
+			if !found {
+				return nil, errors.New("claim aud must be a string or an array of strings")
+			}
+			out = append(out, s)
+		}
+		return out, nil
+	}
+	return nil, errors.New("claim aud must be a string or an array of strings")
+}
+
+type header struct {
+	Alg string `json:"alg"`
+	Typ string `json:"typ,omitempty"`
+	Kid string `json:"kid,omitempty"`
+	// Extensions the verifier must understand. We do not implement any.
+	Crit []string `json:"crit,omitempty"`
+}
+
+// Algorithm returns the JWS "alg" used for keys of the given algorithm.
+func Algorithm(alg signing.Algorithm) (string, error) {
+	switch alg {
+	case signing.ECDSAP256:
+		return "ES256", nil
+	case signing.ECDSAP384:
+		return "ES384", nil
+	case signing.ECDSAP521:
+		return "ES512", nil
+	case signing.Ed25519:
+		return "EdDSA", nil
+	case signing.RSA:
+		return "RS256", nil
+	}
+	return "", fmt.Errorf("%w: %s", ErrUnsupportedAlg, alg)
+}
+
+// Sign encodes claims as a compact JWS signed by key, with kid in the header.
+func Sign(key *signing.PrivateKey, kid string, claims Claims) (string, error) {
+	alg, err := Algorithm(key.Algorithm())
+	if err != nil {
+		return "", err
+	}
+
+	h, err := json.Marshal(header{Alg: alg, Typ: "JWT", Kid: kid})
+	if err != nil {
+		return "", err
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+
+	signed := encodeSegment(h) + "." + encodeSegment(payload)
+	// JWS uses the fixed size r||s encoding for ECDSA. Other algorithms ignore the format.
+	sig, err := key.Sign([]byte(signed), signing.Raw)
+	if err != nil {
+		return "", err
+	}
+	return signed + "." + encodeSegment(sig), nil
+}
+
+// NewID returns a random token ID for the jti claim.
+func NewID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+type token struct {
+	header header
+	claims Claims
+	signed string
+	sig    []byte
+}
+
+// parse decodes a compact JWS without verifying it.
+func parse(s string) (token, error) {
+	var t token
+	if len(s) > MaxTokenSize {
+		return t, fmt.Errorf("%w: token is larger than %d bytes", ErrMalformedToken, MaxTokenSize)
+	}
+
+	parts := strings.Split(s, ".")
+	if len(parts) != 3 {
+		return t, fmt.Errorf("%w: expected 3 segments, got %d", ErrMalformedToken, len(parts))
+	}
+
+	h, err := decodeSegment(parts[0])
+	if err != nil {
+		return t, fmt.Errorf("%w: header: %v", ErrMalformedToken, err)
+	}
+	if err := json.Unmarshal(h, &t.header); err != nil {
+		return t, fmt.Errorf("%w: header: %v", ErrMalformedToken, err)
+	}
+	if len(t.header.Crit) > 0 {
+		return t, fmt.Errorf("%w: critical header extensions %v", ErrMalformedToken, t.header.Crit)
+	}
+
+	payload, err := decodeSegment(parts[1])
+	if err != nil {
+		return t, fmt.Errorf("%w: payload: %v", ErrMalformedToken, err)
+	}
+	if err := json.Unmarshal(payload, &t.claims); err != nil {
+		return t, fmt.Errorf("%w: payload: %v", ErrMalformedToken, err)
+	}
+
+	t.sig, err = decodeSegment(parts[2])
+	if err != nil {
+		return t, fmt.Errorf("%w: signature: %v", ErrMalformedToken, err)
+	}
+	t.signed = parts[0] + "." + parts[1]
+	return t, nil
+}
+
+// verifySignature checks the token against pub. The header must name the algorithm
+// that belongs to the key.
+func (t token) verifySignature(pub *signing.PublicKey) error {
+	alg, err := Algorithm(pub.Algorithm())
+	if err != nil {
+		return err
+	}
+	if t.header.Alg != alg {
+		return fmt.Errorf("%w: token uses %q but key %s is %s", ErrUnsupportedAlg, t.header.Alg, t.header.Kid, alg)
+	}
+	return pub.Verify([]byte(t.signed), t.sig, signing.Raw)
+}
+
+func encodeSegment(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func decodeSegment(s string) ([]byte, error) {
+	return base64.RawURLEncoding.Strict().DecodeString(s)
+}
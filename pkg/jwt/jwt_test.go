@@ -0,0 +1,372 @@
+package jwt_test
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/brnsampson/echopilot/pkg/jwt"
+	"github.com/brnsampson/echopilot/pkg/signing"
+)
+
+// assert fails the test if the condition is false.
+func assert(tb testing.TB, condition bool, msg string, v ...interface{}) {
+	if !condition {
+		_, file, line, _ := runtime.Caller(1)
+		fmt.Printf("\033[31m%s:%d: "+msg+"\033[39m\n\n", append([]interface{}{filepath.Base(file), line}, v...)...)
+		tb.FailNow()
+	}
+}
+
+// ok fails the test if an err is not nil.
+func ok(tb testing.TB, err error) {
+	if err != nil {
+		_, file, line, _ := runtime.Caller(1)
+		fmt.Printf("\033[31m%s:%d: unexpected error: %s\033[39m\n\n", filepath.Base(file), line, err.Error())
+		tb.FailNow()
+	}
+}
+
+// equals fails the test if exp is not equal to act.
+func equals(tb testing.TB, exp, act interface{}) {
+	if !reflect.DeepEqual(exp, act) {
+		_, file, line, _ := runtime.Caller(1)
+		fmt.Printf("\033[31m%s:%d:\n\n\texp: %#v\n\n\tgot: %#v\033[39m\n\n", filepath.Base(file), line, exp, act)
+		tb.FailNow()
+	}
+}
+
+var algorithms = []signing.Algorithm{signing.ECDSAP256, signing.ECDSAP384, signing.ECDSAP521, signing.Ed25519, signing.RSA}
+
+// RSA key generation is slow, so every test shares one key per algorithm.
+var generated = map[signing.Algorithm]*signing.PrivateKey{}
+
+func testKey(t testing.TB, alg signing.Algorithm) *signing.PrivateKey {
+	if key, found := generated[alg]; found {
+		return key
+	}
+	key, err := signing.GenerateKey(alg)
+	ok(t, err)
+	generated[alg] = key
+	return key
+}
+
+// staticKeys signs with a single key and looks up any number of keys.
+type staticKeys struct {
+	signer *signing.PrivateKey
+	keys   map[string]*signing.PublicKey
+}
+
+func keysOf(signer *signing.PrivateKey, others ...*signing.PrivateKey) *staticKeys {
+	s := &staticKeys{signer, map[string]*signing.PublicKey{}}
+	for _, k := range append(others, signer) {
+		s.keys[k.Public().KeyID()] = k.Public()
+	}
+	return s
+}
+
+func (s *staticKeys) Signer() (string, *signing.PrivateKey, error) {
+	return s.signer.Public().KeyID(), s.signer, nil
+}
+
+func (s *staticKeys) Lookup(kid string) (*signing.PublicKey, error) {
+	if pub, found := s.keys[kid]; found {
+		return pub, nil
+	}
+	return nil, signing.ErrUnknownKey
+}
+
+func TestIssueVerify(t *testing.T) {
+	for _, alg := range algorithms {
+		keys := keysOf(testKey(t, alg))
+		issuer := &jwt.Issuer{Keys: keys, Name: "echopilot", Lifetime: time.Minute, Claims: map[string]interface{}{"env": "test", "role": "default"}}
+
+		token, issued, err := issuer.Issue(jwt.Claims{Subject: "alice", Audience: []string{"api"}, Extra: map[string]interface{}{"role": "admin"}})
+		ok(t, err)
+		equals(t, "echopilot", issued.Issuer)
+
+		verifier := jwt.NewVerifier("api")
+		verifier.Trust("echopilot", keys)
+		claims, err := verifier.Verify(token)
+		ok(t, err)
+		equals(t, "alice", claims.Subject)
+		equals(t, []string{"api"}, claims.Audience)
+		equals(t, issued.ID, claims.ID)
+		equals(t, "test", claims.Extra["env"])
+		equals(t, "admin", claims.Extra["role"])
+		assert(t, claims.ExpiresAt.Sub(claims.IssuedAt) == time.Minute, "%s: expected a one minute lifetime, got %v", alg, claims.ExpiresAt.Sub(claims.IssuedAt))
+
+		header := decodeHeader(t, token)
+		wantAlg, err := jwt.Algorithm(alg)
+		ok(t, err)
+		equals(t, wantAlg, header["alg"])
+		equals(t, keys.signer.Public().KeyID(), header["kid"])
+	}
+}
+
+func TestVerifyRejects(t *testing.T) {
+	key := testKey(t, signing.ECDSAP256)
+	keys := keysOf(key)
+	issue := func(claims jwt.Claims) string {
+		token, err := jwt.Sign(key, key.Public().KeyID(), claims)
+		ok(t, err)
+		return token
+	}
+	valid := jwt.Claims{Issuer: "echopilot", Audience: []string{"api"}, ExpiresAt: time.Now().Add(time.Hour)}
+
+	verifier := jwt.NewVerifier("api")
+	verifier.Trust("echopilot", keys)
+
+	_, err := verifier.Verify(issue(valid))
+	ok(t, err)
+
+	expired := valid
+	expired.ExpiresAt = time.Now().Add(-2 * jwt.Leeway)
+	_, err = verifier.Verify(issue(expired))
+	assert(t, errors.Is(err, jwt.ErrTokenExpired), "expected ErrTokenExpired, got %v", err)
+
+	// Within the leeway is fine.
+	expired.ExpiresAt = time.Now().Add(-jwt.Leeway / 2)
+	_, err = verifier.Verify(issue(expired))
+	ok(t, err)
+
+	early := valid
+	early.NotBefore = time.Now().Add(2 * jwt.Leeway)
+	_, err = verifier.Verify(issue(early))
+	assert(t, errors.Is(err, jwt.ErrTokenNotYetValid), "expected ErrTokenNotYetValid, got %v", err)
+
+	forever := valid
+	forever.ExpiresAt = time.Time{}
+	_, err = verifier.Verify(issue(forever))
+	assert(t, errors.Is(err, jwt.ErrMissingExpiry), "expected ErrMissingExpiry, got %v", err)
+
+	elsewhere := valid
+	elsewhere.Audience = []string{"other"}
+	_, err = verifier.Verify(issue(elsewhere))
+	assert(t, errors.Is(err, jwt.ErrInvalidAudience), "expected ErrInvalidAudience, got %v", err)
+
+	stranger := valid
+	stranger.Issuer = "mallory"
+	_, err = verifier.Verify(issue(stranger))
+	assert(t, errors.Is(err, jwt.ErrUntrustedIssuer), "expected ErrUntrustedIssuer, got %v", err)
+
+	// A key trusted for one issuer cannot sign for another.
+	other := testKey(t, signing.Ed25519)
+	verifier.Trust("partner", keysOf(other))
+	impersonated, err := jwt.Sign(other, other.Public().KeyID(), valid)
+	ok(t, err)
+	_, err = verifier.Verify(impersonated)
+	assert(t, errors.Is(err, signing.ErrUnknownKey), "expected ErrUnknownKey, got %v", err)
+
+	parts := strings.Split(issue(valid), ".")
+
+	tampered := valid
+	tampered.Audience = []string{"api", "admin"}
+	payload, err := json.Marshal(tampered)
+	ok(t, err)
+	_, err = verifier.Verify(parts[0] + "." + base64.RawURLEncoding.EncodeToString(payload) + "." + parts[2])
+	assert(t, errors.Is(err, signing.ErrInvalidSignature), "expected ErrInvalidSignature, got %v", err)
+
+	for _, h := range []string{
+		`{"alg":"none","kid":"` + key.Public().KeyID() + `"}`,
+		`{"alg":"ES384","kid":"` + key.Public().KeyID() + `"}`,
+		`{"alg":"HS256","kid":"` + key.Public().KeyID() + `"}`,
+	} {
+		_, err = verifier.Verify(base64.RawURLEncoding.EncodeToString([]byte(h)) + "." + parts[1] + "." + parts[2])
+		assert(t, errors.Is(err, jwt.ErrUnsupportedAlg), "%s: expected ErrUnsupportedAlg, got %v", h, err)
+	}
+
+	crit := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"ES256","kid":"` + key.Public().KeyID() + `","crit":["exp"]}`))
+	for _, token := range []string{"", "a.b", parts[0] + "." + parts[1], crit + "." + parts[1] + "." + parts[2], strings.Repeat("a", jwt.MaxTokenSize+1)} {
+		_, err = verifier.Verify(token)
+		assert(t, errors.Is(err, jwt.ErrMalformedToken), "expected ErrMalformedToken, got %v", err)
+	}
+}
+
+func TestClaimsJSON(t *testing.T) {
+	var claims jwt.Claims
+	ok(t, json.Unmarshal([]byte(`{"iss":"a","aud":"b","exp":1700000000.5,"nbf":1700000000,"custom":{"x":1}}`), &claims))
+	equals(t, "a", claims.Issuer)
+	equals(t, []string{"b"}, claims.Audience)
+	equals(t, int64(1700000000), claims.ExpiresAt.Unix())
+	equals(t, 500*time.Millisecond, time.Duration(claims.ExpiresAt.Nanosecond()))
+	equals(t, map[string]interface{}{"x": json.Number("1")}, claims.Extra["custom"])
+
+	ok(t, json.Unmarshal([]byte(`{"aud":["b","c"]}`), &claims))
+	equals(t, []string{"b", "c"}, claims.Audience)
+
+	for _, invalid := range []string{`{"aud":1}`, `{"exp":"soon"}`, `{"iss":false}`, `{"aud":[1]}`} {
+		assert(t, json.Unmarshal([]byte(invalid), &claims) != nil, "%s: expected an error", invalid)
+	}
+
+	// Extra never overrides registered claims.
+	data, err := json.Marshal(jwt.Claims{Issuer: "a", Extra: map[string]interface{}{"iss": "b"}})
+	ok(t, err)
+	equals(t, `{"iss":"a"}`, string(data))
+}
+
+func TestJWK(t *testing.T) {
+	for _, alg := range algorithms {
+		pub := testKey(t, alg).Public()
+		jwk, err := jwt.NewJWK(pub, pub.KeyID())
+		ok(t, err)
+		equals(t, "sig", jwk.Use)
+
+		data, err := json.Marshal(jwk)
+		ok(t, err)
+		var decoded jwt.JWK
+		ok(t, json.Unmarshal(data, &decoded))
+		parsed, err := decoded.PublicKey()
+		ok(t, err)
+		assert(t, parsed.Equal(pub), "%s: JWK round trip changed the key", alg)
+
+		decoded.Use = "enc"
+		_, err = decoded.PublicKey()
+		assert(t, err != nil, "%s: expected encryption keys to be rejected", alg)
+	}
+
+	// A point which is not on the curve.
+	jwk, err := jwt.NewJWK(testKey(t, signing.ECDSAP256).Public(), "kid")
+	ok(t, err)
+	jwk.Y = jwk.X
+	_, err = jwk.PublicKey()
+	assert(t, err != nil, "expected an invalid point to be rejected")
+}
+
+func TestKeySetHandler(t *testing.T) {
+	dir := t.TempDir()
+	active, retired := testKey(t, signing.ECDSAP384), testKey(t, signing.Ed25519)
+	ok(t, signing.WriteKeyFiles(active, filepath.Join(dir, "active.pem"), filepath.Join(dir, "active.pub.pem"), true))
+	ok(t, signing.WriteKeyFiles(retired, filepath.Join(dir, "retired.pem"), filepath.Join(dir, "retired.pub.pem"), true))
+	manifest := `{"keys": [{"file": "active.pem", "status": "active"}, {"file": "retired.pem", "status": "retired"}]}`
+	ok(t, os.WriteFile(filepath.Join(dir, signing.KeyringManifest), []byte(manifest), 0644))
+	keyring, err := signing.LoadKeyring(dir, nil)
+	ok(t, err)
+
+	srv := httptest.NewServer(jwt.KeySetHandler(keyring))
+	defer srv.Close()
+
+	res, err := http.Get(srv.URL)
+	ok(t, err)
+	defer res.Body.Close()
+	equals(t, "application/jwk-set+json", res.Header.Get("Content-Type"))
+	var set jwt.JWKS
+	ok(t, json.NewDecoder(res.Body).Decode(&set))
+	equals(t, 1, len(set.Keys))
+	equals(t, active.Public().KeyID(), set.Keys[0].Kid)
+
+	// Tokens from the keyring verify against the published keys.
+	token, _, err := (&jwt.Issuer{Keys: keyring, Name: "echopilot", Lifetime: time.Minute}).Issue(jwt.Claims{})
+	ok(t, err)
+	verifier := jwt.NewVerifier("")
+	verifier.Trust("echopilot", jwt.NewRemoteKeySet(srv.URL, srv.Client()))
+	_, err = verifier.Verify(token)
+	ok(t, err)
+}
+
+func TestRemoteKeySet(t *testing.T) {
+	first, second := testKey(t, signing.ECDSAP256), testKey(t, signing.Ed25519)
+	var published atomic.Pointer[jwt.JWKS]
+	var fetches atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches.Add(1)
+		json.NewEncoder(w).Encode(published.Load())
+	}))
+	defer srv.Close()
+
+	publish := func(keys ...*signing.PrivateKey) {
+		set := &jwt.JWKS{}
+		for _, k := range keys {
+			jwk, err := jwt.NewJWK(k.Public(), k.Public().KeyID())
+			ok(t, err)
+			set.Keys = append(set.Keys, jwk)
+		}
+		published.Store(set)
+	}
+
+	publish(first)
+	remote := jwt.NewRemoteKeySet(srv.URL, srv.Client())
+	_, err := remote.Lookup(first.Public().KeyID())
+	ok(t, err)
+	_, err = remote.Lookup(first.Public().KeyID())
+	ok(t, err)
+	equals(t, int32(1), fetches.Load())
+
+	// Unknown keys trigger a refresh, but not more often than MinRefresh.
+	publish(first, second)
+	_, err = remote.Lookup(second.Public().KeyID())
+	assert(t, errors.Is(err, signing.ErrUnknownKey), "expected ErrUnknownKey, got %v", err)
+	equals(t, int32(1), fetches.Load())
+
+	remote.MinRefresh = 0
+	_, err = remote.Lookup(second.Public().KeyID())
+	ok(t, err)
+	equals(t, int32(2), fetches.Load())
+
+	// Known keys keep working while the issuer is down.
+	remote.TTL = 0
+	srv.Config.Handler = http.NotFoundHandler()
+	_, err = remote.Lookup(first.Public().KeyID())
+	ok(t, err)
+	_, err = remote.Lookup("unknown")
+	assert(t, err != nil && strings.Contains(err.Error(), "404"), "expected the fetch error, got %v", err)
+}
+
+func TestRemoteKeySetSlowIssuer(t *testing.T) {
+	key := testKey(t, signing.ECDSAP256)
+	jwk, err := jwt.NewJWK(key.Public(), key.Public().KeyID())
+	ok(t, err)
+	release := make(chan struct{})
+	var fetches atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if fetches.Add(1) > 1 {
+			<-release
+		}
+		json.NewEncoder(w).Encode(jwt.JWKS{Keys: []jwt.JWK{jwk}})
+	}))
+	defer srv.Close()
+	defer close(release)
+
+	remote := jwt.NewRemoteKeySet(srv.URL, srv.Client())
+	remote.MinRefresh = 0
+	_, err = remote.Lookup(key.Public().KeyID())
+	ok(t, err)
+
+	// An unknown key starts a fetch which hangs...
+	go remote.Lookup("unknown")
+	for fetches.Load() < 2 {
+		time.Sleep(time.Millisecond)
+	}
+
+	// ...but known keys are still served meanwhile.
+	found := make(chan error)
+	go func() {
+		_, err := remote.Lookup(key.Public().KeyID())
+		found <- err
+	}()
+	select {
+	case err := <-found:
+		ok(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("lookup of a known key waited for the issuer")
+	}
+}
+
+func decodeHeader(t *testing.T, token string) map[string]interface{} {
+	data, err := base64.RawURLEncoding.DecodeString(strings.Split(token, ".")[0])
+	ok(t, err)
+	var header map[string]interface{}
+	ok(t, json.Unmarshal(data, &header))
+	return header
+}
@@ -0,0 +1,81 @@
+package jwt
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/brnsampson/echopilot/pkg/signing"
+)
+
+// KeySource looks up public keys by key ID. *signing.Keyring and *RemoteKeySet
+// implement it.
+type KeySource interface {
+	Lookup(kid string) (*signing.PublicKey, error)
+}
+
+// Verifier checks tokens from a fixed set of issuers, each with its own keys, so that a
+// key trusted for one issuer can never vouch for another.
+type Verifier struct {
+	trusted map[string]KeySource
+	// If set, tokens must list this audience.
+	audience string
+}
+
+func NewVerifier(audience string) *Verifier {
+	return &Verifier{trusted: make(map[string]KeySource), audience: audience}
+}
+
+// Trust accepts tokens with the given iss claim which are signed by one of keys. It must
+// not be called once the verifier is in use.
+func (v *Verifier) Trust(issuer string, keys KeySource) {
+	v.trusted[issuer] = keys
+}
+
+// Verify checks the signature, issuer, lifetime and audience of a token and returns
+// its claims.
+func (v *Verifier) Verify(s string) (Claims, error) {
+	t, err := parse(s)
+	if err != nil {
+		return Claims{}, err
+	}
+
+	keys, found := v.trusted[t.claims.Issuer]
+	if !found {
+		return Claims{}, fmt.Errorf("%w: %q", ErrUntrustedIssuer, t.claims.Issuer)
+	}
+	if t.header.Kid == "" {
+		return Claims{}, fmt.Errorf("%w: no kid in header", ErrMalformedToken)
+	}
+	pub, err := keys.Lookup(t.header.Kid)
+	if err != nil {
+		return Claims{}, err
+	}
+	if err := t.verifySignature(pub); err != nil {
+		return Claims{}, err
+	}
+
+	now := time.Now()
+	c := t.claims
+	if c.ExpiresAt.IsZero() {
+		return Claims{}, ErrMissingExpiry
+	}
+	if now.After(c.ExpiresAt.Add(Leeway)) {
+		return Claims{}, ErrTokenExpired
+	}
+	if !c.NotBefore.IsZero() && now.Add(Leeway).Before(c.NotBefore) {
+		return Claims{}, ErrTokenNotYetValid
+	}
+	if v.audience != "" && !contains(c.Audience, v.audience) {
+		return Claims{}, ErrInvalidAudience
+	}
+	return c, nil
+}
+
+func contains(list []string, s string) bool {
+	for _, l := range list {
+		if l == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,262 @@
+package localca
+
+// A minimal certificate authority for development and test setups.
+//
+// This is NOT meant to run anything exposed to the internet. It exists so that a local
+// server, its clients and mTLS tests can all share a trust root without anybody having
+// to remember an openssl incantation. Keys are generated with pkg/signing. The CA key may
+// be saved encrypted with a passphrase, the keys of issued certificates are served by TLS
+// and stay in plaintext.
+
+import (
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/brnsampson/echopilot/pkg/signing"
+)
+
+const (
+	DefaultCAValidity   = 365 * 24 * time.Hour
+	DefaultCertValidity = 90 * 24 * time.Hour
+
+	caName = "ca"
+)
+
+// Hosts a development server certificate is always valid for.
+var LocalHosts = []string{"localhost", "127.0.0.1", "::1"}
+
+type CA struct {
+	Cert    *x509.Certificate
+	CertPEM []byte
+	key     *ecdsa.PrivateKey
+}
+
+// Issued is a certificate signed by the CA along with its private key.
+type Issued struct {
+	Cert    *x509.Certificate
+	CertPEM []byte
+	key     *ecdsa.PrivateKey
+}
+
+func NewCA(commonName string, validity time.Duration) (*CA, error) {
+	key, pub, err := signing.GenerateECDSA()
+	if err != nil {
+		return nil, err
+	}
+
+	serial, err := newSerial()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: commonName, Organization: []string{"echopilot development CA"}},
+		NotBefore:             now.Add(-5 * time.Minute),
+		NotAfter:              now.Add(validity),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		MaxPathLenZero:        true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, pub, key)
+	if err != nil {
+		return nil, err
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+
+	return &CA{cert, encodeCert(der), key}, nil
+}
+
+// LoadCA reads a CA previously written with Save. passphrase decrypts an encrypted key,
+// and may be nil if the key is not.
+func LoadCA(dir string, passphrase signing.Passphrase) (*CA, error) {
+	certPEM, err := os.ReadFile(filepath.Join(dir, caName+".pem"))
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(certPEM)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil, fmt.Errorf("no certificate found in %s", filepath.Join(dir, caName+".pem"))
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	if !cert.IsCA {
+		return nil, fmt.Errorf("certificate in %s is not a CA", dir)
+	}
+
+	keyFile, pubFile := keyFiles(dir, caName)
+	key, _, err := signing.ReadECDSAFiles(keyFile, pubFile, passphrase)
+	if err != nil {
+		return nil, err
+	}
+
+	return &CA{cert, certPEM, key}, nil
+}
+
+// LoadOrCreateCA loads the CA in dir, creating and saving a new one if none exists yet. A
+// new CA's key is encrypted with passphrase unless it is nil.
+func LoadOrCreateCA(dir, commonName string, passphrase signing.Passphrase) (*CA, bool, error) {
+	ca, err := LoadCA(dir, passphrase)
+	if err == nil {
+		return ca, false, nil
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return nil, false, err
+	}
+
+	ca, err = NewCA(commonName, DefaultCAValidity)
+	if err != nil {
+		return nil, false, err
+	}
+	var pass []byte
+	if passphrase != nil {
+		if pass, err = passphrase(); err != nil {
+			return nil, false, err
+		}
+	}
+	if err := ca.Save(dir, pass); err != nil {
+		return nil, false, err
+	}
+	return ca, true, nil
+}
+
+// Save writes ca.pem, ca-key.pem and ca-pub.pem into dir. The key is encrypted with
+// passphrase unless it is empty.
+func (ca *CA) Save(dir string, passphrase []byte) error {
+	return save(dir, caName, ca.CertPEM, ca.key, passphrase)
+}
+
+// Pool returns a certificate pool that trusts only this CA.
+func (ca *CA) Pool() *x509.CertPool {
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.Cert)
+	return pool
+}
+
+// IssueServer signs a server certificate valid for the given DNS names and IP addresses.
+func (ca *CA) IssueServer(hosts []string, validity time.Duration) (*Issued, error) {
+	if len(hosts) == 0 {
+		return nil, errors.New("a server certificate needs at least one host")
+	}
+
+	template := &x509.Certificate{
+		Subject:     pkix.Name{CommonName: hosts[0]},
+		KeyUsage:    x509.KeyUsageDigitalSignature,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	for _, h := range hosts {
+		if ip := net.ParseIP(h); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, h)
+		}
+	}
+	return ca.issue(template, validity)
+}
+
+// IssueClient signs a client certificate for mTLS identified by commonName.
+func (ca *CA) IssueClient(commonName string, validity time.Duration) (*Issued, error) {
+	template := &x509.Certificate{
+		Subject:     pkix.Name{CommonName: commonName},
+		KeyUsage:    x509.KeyUsageDigitalSignature,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	return ca.issue(template, validity)
+}
+
+func (ca *CA) issue(template *x509.Certificate, validity time.Duration) (*Issued, error) {
+	key, pub, err := signing.GenerateECDSA()
+	if err != nil {
+		return nil, err
+	}
+
+	serial, err := newSerial()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	template.SerialNumber = serial
+	template.NotBefore = now.Add(-5 * time.Minute)
+	template.NotAfter = now.Add(validity)
+	if template.NotAfter.After(ca.Cert.NotAfter) {
+		template.NotAfter = ca.Cert.NotAfter
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.Cert, pub, ca.key)
+	if err != nil {
+		return nil, err
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Issued{cert, encodeCert(der), key}, nil
+}
+
+// TLSCertificate returns the certificate and key ready to be served or presented by a
+// client. The CA certificate is included in the chain.
+func (i *Issued) TLSCertificate(ca *CA) tls.Certificate {
+	return tls.Certificate{
+		Certificate: [][]byte{i.Cert.Raw, ca.Cert.Raw},
+		PrivateKey:  i.key,
+		Leaf:        i.Cert,
+	}
+}
+
+// Save writes <name>.pem, <name>-key.pem and <name>-pub.pem into dir.
+func (i *Issued) Save(dir, name string) error {
+	return save(dir, name, i.CertPEM, i.key, nil)
+}
+
+// Files returns the certificate and key paths used by Save.
+func Files(dir, name string) (certFile, keyFile string) {
+	keyFile, _ = keyFiles(dir, name)
+	return filepath.Join(dir, name+".pem"), keyFile
+}
+
+func keyFiles(dir, name string) (keyFile, pubFile string) {
+	return filepath.Join(dir, name+"-key.pem"), filepath.Join(dir, name+"-pub.pem")
+}
+
+func save(dir, name string, certPEM []byte, key *ecdsa.PrivateKey, passphrase []byte) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	if err := signing.WriteFileAtomic(filepath.Join(dir, name+".pem"), certPEM, signing.PubKeyFilePerms, true); err != nil {
+		return err
+	}
+
+	keyFile, pubFile := keyFiles(dir, name)
+	return signing.WriteECDSAFiles(key, keyFile, &key.PublicKey, pubFile, passphrase)
+}
+
+func encodeCert(der []byte) []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+}
+
+func newSerial() (*big.Int, error) {
+	return rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+}
@@ -0,0 +1,126 @@
+package localca_test
+
+import (
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/brnsampson/echopilot/pkg/localca"
+	"github.com/brnsampson/echopilot/pkg/signing"
+)
+
+// assert fails the test if the condition is false.
+func assert(tb testing.TB, condition bool, msg string, v ...interface{}) {
+	if !condition {
+		_, file, line, _ := runtime.Caller(1)
+		fmt.Printf("\033[31m%s:%d: "+msg+"\033[39m\n\n", append([]interface{}{filepath.Base(file), line}, v...)...)
+		tb.FailNow()
+	}
+}
+
+// ok fails the test if an err is not nil.
+func ok(tb testing.TB, err error) {
+	if err != nil {
+		_, file, line, _ := runtime.Caller(1)
+		fmt.Printf("\033[31m%s:%d: unexpected error: %s\033[39m\n\n", filepath.Base(file), line, err.Error())
+		tb.FailNow()
+	}
+}
+
+// equals fails the test if exp is not equal to act.
+func equals(tb testing.TB, exp, act interface{}) {
+	if !reflect.DeepEqual(exp, act) {
+		_, file, line, _ := runtime.Caller(1)
+		fmt.Printf("\033[31m%s:%d:\n\n\texp: %#v\n\n\tgot: %#v\033[39m\n\n", filepath.Base(file), line, exp, act)
+		tb.FailNow()
+	}
+}
+
+func TestIssueServer(t *testing.T) {
+	ca, err := localca.NewCA("test CA", time.Hour)
+	ok(t, err)
+
+	issued, err := ca.IssueServer(localca.LocalHosts, localca.DefaultCertValidity)
+	ok(t, err)
+
+	for _, host := range localca.LocalHosts {
+		_, err = issued.Cert.Verify(x509.VerifyOptions{
+			DNSName:   host,
+			Roots:     ca.Pool(),
+			KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		})
+		ok(t, err)
+	}
+
+	// Certificates never outlive the CA that signed them.
+	assert(t, !issued.Cert.NotAfter.After(ca.Cert.NotAfter), "issued certificate outlives its CA")
+}
+
+func TestIssueClient(t *testing.T) {
+	ca, err := localca.NewCA("test CA", time.Hour)
+	ok(t, err)
+
+	issued, err := ca.IssueClient("alice", time.Hour)
+	ok(t, err)
+	equals(t, "alice", issued.Cert.Subject.CommonName)
+
+	_, err = issued.Cert.Verify(x509.VerifyOptions{
+		Roots:     ca.Pool(),
+		KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	})
+	ok(t, err)
+}
+
+func TestSaveAndLoad(t *testing.T) {
+	dir := t.TempDir()
+
+	ca, created, err := localca.LoadOrCreateCA(dir, "test CA", nil)
+	ok(t, err)
+	assert(t, created, "expected a new CA to be created in an empty directory")
+
+	loaded, created, err := localca.LoadOrCreateCA(dir, "test CA", nil)
+	ok(t, err)
+	assert(t, !created, "expected the existing CA to be loaded")
+	equals(t, ca.Cert.Raw, loaded.Cert.Raw)
+
+	// The loaded CA must still be able to sign.
+	issued, err := loaded.IssueServer([]string{"localhost"}, time.Hour)
+	ok(t, err)
+	_, err = issued.Cert.Verify(x509.VerifyOptions{DNSName: "localhost", Roots: ca.Pool()})
+	ok(t, err)
+}
+
+func TestEncryptedCA(t *testing.T) {
+	dir := t.TempDir()
+
+	ca, created, err := localca.LoadOrCreateCA(dir, "test CA", signing.PassphraseString("correct-horse"))
+	ok(t, err)
+	assert(t, created, "expected a new CA to be created in an empty directory")
+	_, keyFile := localca.Files(dir, "ca")
+	data, err := os.ReadFile(keyFile)
+	ok(t, err)
+	assert(t, signing.IsEncryptedPEM(data), "expected the CA key to be encrypted")
+
+	_, err = localca.LoadCA(dir, nil)
+	assert(t, errors.Is(err, signing.ErrNoPassphrase), "expected ErrNoPassphrase, got %v", err)
+	_, err = localca.LoadCA(dir, signing.PassphraseString("battery-staple"))
+	assert(t, errors.Is(err, signing.ErrIncorrectPassphrase), "expected ErrIncorrectPassphrase, got %v", err)
+	loaded, err := localca.LoadCA(dir, signing.PassphraseString("correct-horse"))
+	ok(t, err)
+	equals(t, ca.Cert.Raw, loaded.Cert.Raw)
+
+	// Certificates it issues are served by TLS, so their keys are not encrypted.
+	issued, err := loaded.IssueServer([]string{"localhost"}, time.Hour)
+	ok(t, err)
+	ok(t, issued.Save(dir, "server"))
+	_, keyFile = localca.Files(dir, "server")
+	data, err = os.ReadFile(keyFile)
+	ok(t, err)
+	assert(t, !signing.IsEncryptedPEM(data), "expected the server key in plaintext")
+}
@@ -0,0 +1,251 @@
+package metrics
+
+// Prometheus metrics of the server.
+//
+// Each server has its own registry rather than using the global one, so that servers
+// started by tests do not collide. Features register their own metrics through the
+// Registerer handed to them in router.Host.
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"connectrpc.com/connect"
+	"github.com/brnsampson/echopilot/pkg/certstore"
+	"github.com/brnsampson/echopilot/pkg/router"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const Namespace = "echopilot"
+
+// Label of requests which matched no route. Using the path instead would let anyone
+// create new series at will.
+const unmatchedRoute = "unmatched"
+
+type Metrics struct {
+	registry *prometheus.Registry
+
+	requests    *prometheus.CounterVec
+	duration    *prometheus.HistogramVec
+	inFlight    prometheus.Gauge
+	calls       *prometheus.CounterVec
+	callLatency *prometheus.HistogramVec
+	callsActive *prometheus.GaugeVec
+	handshakes  prometheus.Counter
+	reloads     *prometheus.CounterVec
+}
+
+// New creates the metrics of a server, including the Go runtime and process metrics.
+func New() *Metrics {
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: Namespace,
+			Subsystem: "http",
+			Name:      "requests_total",
+			Help:      "HTTP requests by method, route pattern and status code.",
+		}, []string{"method", "route", "code"}),
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: Namespace,
+			Subsystem: "http",
+			Name:      "request_duration_seconds",
+			Help:      "Latency of HTTP requests by method and route pattern.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"method", "route"}),
+		inFlight: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: Namespace,
+			Subsystem: "http",
+			Name:      "requests_in_flight",
+			Help:      "HTTP requests currently being served.",
+		}),
+		calls: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: Namespace,
+			Subsystem: "rpc",
+			Name:      "requests_total",
+			Help:      "Connect calls by procedure and Connect code.",
+		}, []string{"procedure", "code"}),
+		callLatency: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: Namespace,
+			Subsystem: "rpc",
+			Name:      "request_duration_seconds",
+			Help:      "Latency of Connect calls by procedure.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"procedure"}),
+		callsActive: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: Namespace,
+			Subsystem: "rpc",
+			Name:      "requests_in_flight",
+			Help:      "Connect calls currently being served by procedure.",
+		}, []string{"procedure"}),
+		handshakes: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: Namespace,
+			Subsystem: "tls",
+			Name:      "handshake_errors_total",
+			Help:      "Clients which failed the TLS handshake.",
+		}),
+		reloads: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: Namespace,
+			Subsystem: "config",
+			Name:      "reloads_total",
+			Help:      "Config reloads by result, success or failure.",
+		}, []string{"result"}),
+	}
+
+	m.registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		m.requests, m.duration, m.inFlight,
+		m.calls, m.callLatency, m.callsActive,
+		m.handshakes, m.reloads,
+	)
+	// Both results show up as 0 before the first reload.
+	m.reloads.WithLabelValues("success")
+	m.reloads.WithLabelValues("failure")
+	return m
+}
+
+// Registerer is where features and other parts of the server register their metrics.
+func (m *Metrics) Registerer() prometheus.Registerer {
+	return m.registry
+}
+
+// Handler serves the metrics in the Prometheus exposition format.
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
+
+// Middleware counts and times every request by its route pattern.
+func (m *Metrics) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		m.inFlight.Inc()
+		defer m.inFlight.Dec()
+
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		begin := time.Now()
+		next.ServeHTTP(ww, r)
+		latency := time.Since(begin)
+
+		route := router.RoutePattern(r)
+		if route == "" {
+			route = unmatchedRoute
+		}
+		status := ww.Status()
+		if status == 0 {
+			status = http.StatusOK
+		}
+		m.requests.WithLabelValues(r.Method, route, strconv.Itoa(status)).Inc()
+		m.duration.WithLabelValues(r.Method, route).Observe(latency.Seconds())
+	})
+}
+
+// TLSHandshakeError counts a failed TLS handshake. See server.OnTLSHandshakeError.
+func (m *Metrics) TLSHandshakeError() {
+	m.handshakes.Inc()
+}
+
+// ObserveReload counts a reload by its result. See server.OnReload.
+func (m *Metrics) ObserveReload(err error) {
+	if err != nil {
+		m.reloads.WithLabelValues("failure").Inc()
+	} else {
+		m.reloads.WithLabelValues("success").Inc()
+	}
+}
+
+// WatchCertificates exports the days remaining on every certificate of the monitor,
+// inspected whenever metrics are scraped.
+func (m *Metrics) WatchCertificates(monitor *certstore.Monitor) {
+	m.registry.MustRegister(certCollector{monitor})
+}
+
+var certDaysDesc = prometheus.NewDesc(
+	prometheus.BuildFQName(Namespace, "tls", "cert_days_until_expiry"),
+	"Days until a certificate expires, negative once it has.",
+	[]string{"source", "subject"}, nil,
+)
+
+type certCollector struct {
+	monitor *certstore.Monitor
+}
+
+func (c certCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- certDaysDesc
+}
+
+func (c certCollector) Collect(ch chan<- prometheus.Metric) {
+	seen := make(map[string]bool)
+	for _, e := range c.monitor.Inspect() {
+		// The same certificate may be loaded twice, e.g. as leaf and client CA.
+		if seen[e.Name()] {
+			continue
+		}
+		seen[e.Name()] = true
+		ch <- prometheus.MustNewConstMetric(certDaysDesc, prometheus.GaugeValue, e.Days(), e.Source, e.Subject)
+	}
+}
+
+// Interceptor counts and times Connect calls by procedure and code. Client calls are
+// passed through.
+func (m *Metrics) Interceptor() connect.Interceptor {
+	return interceptor{m}
+}
+
+type interceptor struct {
+	m *Metrics
+}
+
+// observe starts timing a call of procedure. The returned function records its outcome.
+func (i interceptor) observe(procedure string) func(error) {
+	procedure = trimProcedure(procedure)
+	active := i.m.callsActive.WithLabelValues(procedure)
+	active.Inc()
+	begin := time.Now()
+	return func(err error) {
+		active.Dec()
+		code := "ok"
+		if err != nil {
+			code = connect.CodeOf(err).String()
+		}
+		i.m.calls.WithLabelValues(procedure, code).Inc()
+		i.m.callLatency.WithLabelValues(procedure).Observe(time.Since(begin).Seconds())
+	}
+}
+
+func (i interceptor) WrapUnary(next connect.UnaryFunc) connect.UnaryFunc {
+	return func(ctx context.Context, req connect.AnyRequest) (connect.AnyResponse, error) {
+		if req.Spec().IsClient {
+			return next(ctx, req)
+		}
+		done := i.observe(req.Spec().Procedure)
+		res, err := next(ctx, req)
+		done(err)
+		return res, err
+	}
+}
+
+func (i interceptor) WrapStreamingClient(next connect.StreamingClientFunc) connect.StreamingClientFunc {
+	return next
+}
+
+func (i interceptor) WrapStreamingHandler(next connect.StreamingHandlerFunc) connect.StreamingHandlerFunc {
+	return func(ctx context.Context, conn connect.StreamingHandlerConn) error {
+		done := i.observe(conn.Spec().Procedure)
+		err := next(ctx, conn)
+		done(err)
+		return err
+	}
+}
+
+// trimProcedure turns /echo.v1.EchoService/EchoString into echo.v1.EchoService/EchoString,
+// matching the access log.
+func trimProcedure(procedure string) string {
+	if len(procedure) > 0 && procedure[0] == '/' {
+		return procedure[1:]
+	}
+	return procedure
+}
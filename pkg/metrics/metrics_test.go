@@ -0,0 +1,119 @@
+package metrics_test
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"connectrpc.com/connect"
+	"github.com/brnsampson/echopilot/pkg/metrics"
+	"github.com/brnsampson/echopilot/pkg/option"
+	"github.com/brnsampson/echopilot/pkg/router"
+	"github.com/brnsampson/echopilot/rpc/echo"
+	"github.com/charmbracelet/log"
+)
+
+// ok fails the test if an err is not nil.
+func ok(tb testing.TB, err error) {
+	if err != nil {
+		_, file, line, _ := runtime.Caller(1)
+		fmt.Printf("\033[31m%s:%d: unexpected error: %s\033[39m\n\n", filepath.Base(file), line, err.Error())
+		tb.FailNow()
+	}
+}
+
+// equals fails the test if exp is not equal to act.
+func equals(tb testing.TB, exp, act interface{}) {
+	if !reflect.DeepEqual(exp, act) {
+		_, file, line, _ := runtime.Caller(1)
+		fmt.Printf("\033[31m%s:%d:\n\n\texp: %#v\n\n\tgot: %#v\033[39m\n\n", filepath.Base(file), line, exp, act)
+		tb.FailNow()
+	}
+}
+
+// scrape returns the value of every sample in the exposition format by series, e.g.
+// echopilot_http_requests_total{code="200",method="GET",route="/hello/{name}"}.
+func scrape(t *testing.T, m *metrics.Metrics) map[string]string {
+	rec := httptest.NewRecorder()
+	m.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	equals(t, http.StatusOK, rec.Code)
+
+	samples := make(map[string]string)
+	for _, line := range strings.Split(rec.Body.String(), "\n") {
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		i := strings.LastIndex(line, " ")
+		samples[line[:i]] = line[i+1:]
+	}
+	return samples
+}
+
+func TestHTTPMetrics(t *testing.T) {
+	m := metrics.New()
+	routes := router.NewRouter()
+	routes.Use(m.Middleware)
+	routes.Mux().Get("/hello/{name}", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("hello"))
+	})
+	srv := httptest.NewServer(routes)
+	defer srv.Close()
+
+	for _, path := range []string{"/hello/a", "/hello/b", "/nowhere"} {
+		res, err := http.Get(srv.URL + path)
+		ok(t, err)
+		io.ReadAll(res.Body)
+	}
+
+	samples := scrape(t, m)
+	equals(t, "2", samples[`echopilot_http_requests_total{code="200",method="GET",route="/hello/{name}"}`])
+	equals(t, "1", samples[`echopilot_http_requests_total{code="404",method="GET",route="unmatched"}`])
+	equals(t, "2", samples[`echopilot_http_request_duration_seconds_count{method="GET",route="/hello/{name}"}`])
+	equals(t, "0", samples[`echopilot_http_requests_in_flight`])
+	// Runtime and process metrics come for free.
+	_, found := samples["go_goroutines"]
+	equals(t, true, found)
+}
+
+func TestRPCMetrics(t *testing.T) {
+	m := metrics.New()
+	routes := router.NewRouter()
+	host := router.Host{Logger: log.New(io.Discard), Interceptors: []connect.Interceptor{m.Interceptor()}}
+	ok(t, routes.Add(echo.NewFeature(host)))
+	srv := httptest.NewServer(routes)
+	defer srv.Close()
+
+	client, err := echo.NewRemoteEchoClient(srv.URL, option.None[time.Duration](), option.None[bool]())
+	ok(t, err)
+	_, err = client.EchoString(echo.NewStringRequest("hi"))
+	ok(t, err)
+
+	samples := scrape(t, m)
+	equals(t, "1", samples[`echopilot_rpc_requests_total{code="ok",procedure="echo.v1.EchoService/EchoString"}`])
+	equals(t, "1", samples[`echopilot_rpc_request_duration_seconds_count{procedure="echo.v1.EchoService/EchoString"}`])
+	equals(t, "0", samples[`echopilot_rpc_requests_in_flight{procedure="echo.v1.EchoService/EchoString"}`])
+}
+
+func TestServerMetrics(t *testing.T) {
+	m := metrics.New()
+	samples := scrape(t, m)
+	equals(t, "0", samples[`echopilot_config_reloads_total{result="failure"}`])
+
+	m.ObserveReload(nil)
+	m.ObserveReload(errors.New("broken config"))
+	m.ObserveReload(nil)
+	m.TLSHandshakeError()
+
+	samples = scrape(t, m)
+	equals(t, "2", samples[`echopilot_config_reloads_total{result="success"}`])
+	equals(t, "1", samples[`echopilot_config_reloads_total{result="failure"}`])
+	equals(t, "1", samples[`echopilot_tls_handshake_errors_total`])
+}
@@ -0,0 +1,84 @@
+package option
+
+// Generic combinators for Option.
+//
+// Methods in Go cannot introduce new type parameters, so anything which changes the type
+// inside an Option is a free function. None of these consume their arguments.
+
+// Pair is the result of Zip.
+type Pair[T, U comparable] struct {
+	First  T
+	Second U
+}
+
+// Get returns the value and whether there was one, without changing the Option.
+func (o Option[T]) Get() (T, bool) {
+	return o.inner, o.some
+}
+
+// MustGet returns the value without changing the Option and panics on None.
+func (o Option[T]) MustGet() T {
+	if o.IsNone() {
+		panic("Attempted to get the value of an Option with None value")
+	}
+	return o.inner
+}
+
+// GetOr returns the value, or def on None, without changing the Option.
+func (o Option[T]) GetOr(def T) T {
+	if o.IsNone() {
+		return def
+	}
+	return o.inner
+}
+
+// Map applies f to the value of a Some.
+func Map[T, U comparable](o Option[T], f func(T) U) Option[U] {
+	if o.IsNone() {
+		return None[U]()
+	}
+	return Some(f(o.inner))
+}
+
+// FlatMap applies f to the value of a Some and returns its result as is.
+func FlatMap[T, U comparable](o Option[T], f func(T) Option[U]) Option[U] {
+	if o.IsNone() {
+		return None[U]()
+	}
+	return f(o.inner)
+}
+
+// Filter turns a Some into None unless its value matches pred.
+func Filter[T comparable](o Option[T], pred func(T) bool) Option[T] {
+	if o.IsNone() || !pred(o.inner) {
+		return None[T]()
+	}
+	return o
+}
+
+// Zip returns Some of both values if both are Some.
+func Zip[T, U comparable](a Option[T], b Option[U]) Option[Pair[T, U]] {
+	if a.IsNone() || b.IsNone() {
+		return None[Pair[T, U]]()
+	}
+	return Some(Pair[T, U]{a.inner, b.inner})
+}
+
+// Or returns the first Some, or None if there is none. This is handy for layering
+// config sources in order of precedence.
+func Or[T comparable](options ...Option[T]) Option[T] {
+	for _, o := range options {
+		if o.IsSome() {
+			return o
+		}
+	}
+	return None[T]()
+}
+
+// OkOr turns a Some into an Ok and None into err.
+func OkOr[T comparable](o Option[T], err error) Result[T] {
+	if o.IsNone() {
+		return Err[T](err)
+	}
+	return Ok(o.inner)
+}
@@ -0,0 +1,86 @@
+package option_test
+
+import (
+	"errors"
+	"strconv"
+	"testing"
+
+	"github.com/brnsampson/echopilot/pkg/option"
+)
+
+func TestGetDoesNotConsume(t *testing.T) {
+	o := option.Some(3000)
+
+	v, found := o.Get()
+	equals(t, 3000, v)
+	assert(t, found, "expected a value")
+	equals(t, 3000, o.MustGet())
+	assert(t, o.IsSome(), "Get should not consume the Option")
+
+	// Unwrap still takes the value.
+	v, err := o.Unwrap()
+	ok(t, err)
+	equals(t, 3000, v)
+	assert(t, o.IsNone(), "Unwrap should consume the Option")
+	equals(t, 1, o.GetOr(1))
+}
+
+func TestMap(t *testing.T) {
+	equals(t, option.Some("3000"), option.Map(option.Some(3000), strconv.Itoa))
+	equals(t, option.None[string](), option.Map(option.None[int](), strconv.Itoa))
+
+	parse := func(s string) option.Option[int] {
+		i, err := strconv.Atoi(s)
+		if err != nil {
+			return option.None[int]()
+		}
+		return option.Some(i)
+	}
+	equals(t, option.Some(42), option.FlatMap(option.Some("42"), parse))
+	equals(t, option.None[int](), option.FlatMap(option.Some("many"), parse))
+	equals(t, option.None[int](), option.FlatMap(option.None[string](), parse))
+}
+
+func TestFilterZipOr(t *testing.T) {
+	positive := func(i int) bool { return i > 0 }
+	equals(t, option.Some(1), option.Filter(option.Some(1), positive))
+	equals(t, option.None[int](), option.Filter(option.Some(-1), positive))
+
+	equals(t, option.Some(option.Pair[string, int]{"localhost", 3000}), option.Zip(option.Some("localhost"), option.Some(3000)))
+	equals(t, option.None[option.Pair[string, int]](), option.Zip(option.Some("localhost"), option.None[int]()))
+
+	equals(t, option.Some(2), option.Or(option.None[int](), option.Some(2), option.Some(3)))
+	equals(t, option.None[int](), option.Or[int]())
+}
+
+func TestResult(t *testing.T) {
+	missing := errors.New("missing")
+
+	r := option.OkOr(option.Some("42"), missing)
+	assert(t, r.IsOk(), "expected Ok, got %v", r)
+	parsed := option.AndThen(r, strconv.Atoi)
+	equals(t, 42, parsed.MustGet())
+	equals(t, 84, option.MapResult(parsed, func(i int) int { return i * 2 }).MustGet())
+
+	r = option.OkOr(option.None[string](), missing)
+	assert(t, errors.Is(r.Err(), missing), "expected the given error, got %v", r)
+	assert(t, errors.Is(option.AndThen(r, strconv.Atoi).Err(), missing), "errors should pass through AndThen")
+	equals(t, option.None[string](), option.ToOption(r))
+
+	bad := option.AndThen(option.Ok("many"), strconv.Atoi)
+	assert(t, bad.IsErr(), "expected a parse error")
+	equals(t, -1, bad.GetOr(-1))
+
+	assert(t, option.Err[int](nil).IsErr(), "Err with a nil error should still be an Err")
+}
+
+func TestCollect(t *testing.T) {
+	results := make([]option.Result[int], 0)
+	for _, s := range []string{"1", "2", "3"} {
+		results = append(results, option.ResultOf(strconv.Atoi(s)))
+	}
+	equals(t, []int{1, 2, 3}, option.Collect(results).MustGet())
+
+	results = append(results, option.ResultOf(strconv.Atoi("many")))
+	assert(t, option.Collect(results).IsErr(), "expected the first error")
+}
@@ -0,0 +1,228 @@
+package option
+
+// Encoding support for Option.
+//
+// None is represented as the natural "nothing" of each encoding: null in JSON and YAML,
+// NULL in SQL and empty text. Text parsing covers strings, bools, numbers,
+// time.Duration and anything implementing encoding.TextUnmarshaler.
+
+import (
+	"bytes"
+	"database/sql"
+	"database/sql/driver"
+	"encoding"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+var (
+	_ json.Marshaler           = Option[int]{}
+	_ json.Unmarshaler         = (*Option[int])(nil)
+	_ encoding.TextMarshaler   = Option[int]{}
+	_ encoding.TextUnmarshaler = (*Option[int])(nil)
+	_ yaml.Marshaler           = Option[int]{}
+	_ yaml.Unmarshaler         = (*Option[int])(nil)
+	_ sql.Scanner              = (*Option[int])(nil)
+	_ driver.Valuer            = Option[int]{}
+	_ ConfigOptional[int]      = (*Option[int])(nil)
+)
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// MarshalJSON encodes None as null.
+func (o Option[T]) MarshalJSON() ([]byte, error) {
+	if o.IsNone() {
+		return []byte("null"), nil
+	}
+	return json.Marshal(o.inner)
+}
+
+// UnmarshalJSON decodes null as None.
+func (o *Option[T]) UnmarshalJSON(data []byte) error {
+	if bytes.Equal(bytes.TrimSpace(data), []byte("null")) {
+		o.Clear()
+		return nil
+	}
+
+	var tmp T
+	if err := json.Unmarshal(data, &tmp); err != nil {
+		return err
+	}
+	o.Set(tmp)
+	return nil
+}
+
+// MarshalText encodes None as empty text.
+func (o Option[T]) MarshalText() ([]byte, error) {
+	if o.IsNone() {
+		return []byte{}, nil
+	}
+	text, err := formatText(o.inner)
+	return []byte(text), err
+}
+
+// UnmarshalText decodes empty text as None. This means an Option[string] can never hold
+// an empty string when decoded from text, which matches how empty flags and environment
+// variables are treated elsewhere.
+func (o *Option[T]) UnmarshalText(text []byte) error {
+	if len(text) == 0 {
+		o.Clear()
+		return nil
+	}
+
+	var tmp T
+	if err := parseText(&tmp, string(text)); err != nil {
+		return err
+	}
+	o.Set(tmp)
+	return nil
+}
+
+// MarshalYAML encodes None as null.
+func (o Option[T]) MarshalYAML() (interface{}, error) {
+	if o.IsNone() {
+		return nil, nil
+	}
+	return o.inner, nil
+}
+
+// UnmarshalYAML decodes null as None. Note that yaml leaves a field untouched rather than
+// calling this for a null value, which works out the same as the zero value is None.
+func (o *Option[T]) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.ScalarNode && value.ShortTag() == "!!null" {
+		o.Clear()
+		return nil
+	}
+
+	var tmp T
+	if err := value.Decode(&tmp); err != nil {
+		return err
+	}
+	o.Set(tmp)
+	return nil
+}
+
+// Value satisfies driver.Valuer. None is stored as NULL.
+func (o Option[T]) Value() (driver.Value, error) {
+	if o.IsNone() {
+		return nil, nil
+	}
+	return driver.DefaultParameterConverter.ConvertValue(o.inner)
+}
+
+// Scan satisfies sql.Scanner. NULL is read as None.
+func (o *Option[T]) Scan(src interface{}) error {
+	if src == nil {
+		o.Clear()
+		return nil
+	}
+
+	var tmp T
+	if scanner, ok := interface{}(&tmp).(sql.Scanner); ok {
+		if err := scanner.Scan(src); err != nil {
+			return err
+		}
+		o.Set(tmp)
+		return nil
+	}
+
+	switch s := src.(type) {
+	case string:
+		if err := parseText(&tmp, s); err != nil {
+			return err
+		}
+	case []byte:
+		if err := parseText(&tmp, string(s)); err != nil {
+			return err
+		}
+	default:
+		sv := reflect.ValueOf(src)
+		tv := reflect.ValueOf(&tmp).Elem()
+		// reflect happily converts an int64 into a one rune string, which is never what
+		// a database column means.
+		if tv.Kind() == reflect.String || !sv.Type().ConvertibleTo(tv.Type()) {
+			return fmt.Errorf("cannot scan %T into Option[%T]", src, tmp)
+		}
+		tv.Set(sv.Convert(tv.Type()))
+	}
+	o.Set(tmp)
+	return nil
+}
+
+func formatText(value interface{}) (string, error) {
+	if m, ok := value.(encoding.TextMarshaler); ok {
+		text, err := m.MarshalText()
+		return string(text), err
+	}
+	if d, ok := value.(time.Duration); ok {
+		return d.String(), nil
+	}
+
+	v := reflect.ValueOf(value)
+	switch v.Kind() {
+	case reflect.String:
+		return v.String(), nil
+	case reflect.Bool:
+		return strconv.FormatBool(v.Bool()), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(v.Int(), 10), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return strconv.FormatUint(v.Uint(), 10), nil
+	case reflect.Float32, reflect.Float64:
+		return strconv.FormatFloat(v.Float(), 'g', -1, v.Type().Bits()), nil
+	}
+	return "", fmt.Errorf("cannot encode %T as text", value)
+}
+
+func parseText(target interface{}, text string) error {
+	if u, ok := target.(encoding.TextUnmarshaler); ok {
+		return u.UnmarshalText([]byte(text))
+	}
+
+	v := reflect.ValueOf(target).Elem()
+	if v.Type() == durationType {
+		d, err := time.ParseDuration(text)
+		if err != nil {
+			return err
+		}
+		v.SetInt(int64(d))
+		return nil
+	}
+
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(text)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(text)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i, err := strconv.ParseInt(text, 0, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetInt(i)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		u, err := strconv.ParseUint(text, 0, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetUint(u)
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(text, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetFloat(f)
+	default:
+		return fmt.Errorf("cannot decode text into %s", v.Type())
+	}
+	return nil
+}
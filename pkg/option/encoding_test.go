@@ -0,0 +1,153 @@
+package option_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/brnsampson/echopilot/pkg/option"
+	"github.com/spf13/pflag"
+	"gopkg.in/yaml.v3"
+)
+
+// assert fails the test if the condition is false.
+func assert(tb testing.TB, condition bool, msg string, v ...interface{}) {
+	if !condition {
+		_, file, line, _ := runtime.Caller(1)
+		fmt.Printf("\033[31m%s:%d: "+msg+"\033[39m\n\n", append([]interface{}{filepath.Base(file), line}, v...)...)
+		tb.FailNow()
+	}
+}
+
+// ok fails the test if an err is not nil.
+func ok(tb testing.TB, err error) {
+	if err != nil {
+		_, file, line, _ := runtime.Caller(1)
+		fmt.Printf("\033[31m%s:%d: unexpected error: %s\033[39m\n\n", filepath.Base(file), line, err.Error())
+		tb.FailNow()
+	}
+}
+
+// equals fails the test if exp is not equal to act.
+func equals(tb testing.TB, exp, act interface{}) {
+	if !reflect.DeepEqual(exp, act) {
+		_, file, line, _ := runtime.Caller(1)
+		fmt.Printf("\033[31m%s:%d:\n\n\texp: %#v\n\n\tgot: %#v\033[39m\n\n", filepath.Base(file), line, exp, act)
+		tb.FailNow()
+	}
+}
+
+type encoded struct {
+	Port    option.Option[int]           `json:"port" yaml:"port"`
+	Host    option.Option[string]        `json:"host" yaml:"host"`
+	Timeout option.Option[time.Duration] `json:"timeout" yaml:"timeout"`
+}
+
+func TestZeroValueIsNone(t *testing.T) {
+	var o option.Option[int]
+	assert(t, o.IsNone(), "zero value should be None")
+}
+
+func TestJSON(t *testing.T) {
+	data, err := json.Marshal(encoded{Port: option.Some(3000), Host: option.None[string]()})
+	ok(t, err)
+	equals(t, `{"port":3000,"host":null,"timeout":null}`, string(data))
+
+	var decoded encoded
+	decoded.Host = option.Some("stale")
+	ok(t, json.Unmarshal([]byte(`{"port": 4000, "host": null}`), &decoded))
+	equals(t, option.Some(4000), decoded.Port)
+	equals(t, option.None[string](), decoded.Host)
+	equals(t, option.None[time.Duration](), decoded.Timeout)
+}
+
+func TestText(t *testing.T) {
+	var d option.Option[time.Duration]
+	ok(t, d.UnmarshalText([]byte("1m30s")))
+	equals(t, option.Some(90*time.Second), d)
+
+	text, err := d.MarshalText()
+	ok(t, err)
+	equals(t, "1m30s", string(text))
+
+	var b option.Option[bool]
+	ok(t, b.UnmarshalText([]byte("true")))
+	equals(t, option.Some(true), b)
+	ok(t, b.UnmarshalText(nil))
+	equals(t, option.None[bool](), b)
+
+	var i option.Option[int8]
+	assert(t, i.UnmarshalText([]byte("300")) != nil, "expected an out of range error")
+
+	text, err = option.None[int]().MarshalText()
+	ok(t, err)
+	equals(t, "", string(text))
+}
+
+func TestYAML(t *testing.T) {
+	data, err := yaml.Marshal(encoded{Port: option.Some(3000), Timeout: option.Some(time.Second)})
+	ok(t, err)
+
+	var decoded encoded
+	ok(t, yaml.Unmarshal(data, &decoded))
+	equals(t, option.Some(3000), decoded.Port)
+	equals(t, option.None[string](), decoded.Host)
+	equals(t, option.Some(time.Second), decoded.Timeout)
+
+	decoded = encoded{}
+	ok(t, yaml.Unmarshal([]byte("port: null\nhost: example.test\n"), &decoded))
+	equals(t, option.None[int](), decoded.Port)
+	equals(t, option.Some("example.test"), decoded.Host)
+}
+
+func TestSQL(t *testing.T) {
+	v, err := option.Some(3000).Value()
+	ok(t, err)
+	equals(t, int64(3000), v)
+
+	v, err = option.None[string]().Value()
+	ok(t, err)
+	equals(t, nil, v)
+
+	var port option.Option[int]
+	ok(t, port.Scan(int64(4000)))
+	equals(t, option.Some(4000), port)
+	ok(t, port.Scan(nil))
+	equals(t, option.None[int](), port)
+	ok(t, port.Scan([]byte("5000")))
+	equals(t, option.Some(5000), port)
+
+	var host option.Option[string]
+	ok(t, host.Scan("example.test"))
+	equals(t, option.Some("example.test"), host)
+	assert(t, host.Scan(int64(65)) != nil, "integers should not scan into strings")
+}
+
+func TestFlagValue(t *testing.T) {
+	var port option.Option[int]
+	var dev option.Option[bool]
+	var timeout option.Option[time.Duration]
+
+	flags := pflag.NewFlagSet("test", pflag.ContinueOnError)
+	option.BindFlag(flags, &port, "port", "")
+	option.BindFlag(flags, &dev, "dev", "")
+	option.BindFlag(flags, &timeout, "timeout", "")
+
+	ok(t, flags.Parse([]string{"--port", "4000", "--dev"}))
+	equals(t, option.Some(4000), port)
+	equals(t, option.Some(true), dev)
+	// Unset flags stay None rather than taking a default.
+	equals(t, option.None[time.Duration](), timeout)
+	equals(t, "duration", flags.Lookup("timeout").Value.Type())
+
+	assert(t, flags.Parse([]string{"--port", "many"}) != nil, "expected a parse error")
+}
+
+func TestString(t *testing.T) {
+	equals(t, "Some(3000)", fmt.Sprint(option.Some(3000)))
+	equals(t, "None", fmt.Sprint(option.None[int]()))
+}
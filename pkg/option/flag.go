@@ -0,0 +1,60 @@
+package option
+
+import (
+	"reflect"
+
+	"github.com/spf13/pflag"
+)
+
+// FlagValue adapts an Option to pflag.Value. The Option stays None unless the flag is
+// actually passed on the command line, so flags bound this way never shadow config
+// from other sources with their defaults.
+type FlagValue[T comparable] struct {
+	opt *Option[T]
+}
+
+var _ pflag.Value = (*FlagValue[int])(nil)
+
+func NewFlagValue[T comparable](opt *Option[T]) *FlagValue[T] {
+	return &FlagValue[T]{opt}
+}
+
+func (f *FlagValue[T]) String() string {
+	if f.opt == nil || f.opt.IsNone() {
+		return ""
+	}
+	text, err := formatText(f.opt.inner)
+	if err != nil {
+		return ""
+	}
+	return text
+}
+
+func (f *FlagValue[T]) Set(text string) error {
+	var tmp T
+	if err := parseText(&tmp, text); err != nil {
+		return err
+	}
+	f.opt.Set(tmp)
+	return nil
+}
+
+// Type is the name pflag shows in help output, e.g. "int" or "duration".
+func (f *FlagValue[T]) Type() string {
+	var tmp T
+	if reflect.TypeOf(tmp) == durationType {
+		return "duration"
+	}
+	return reflect.TypeOf(&tmp).Elem().Kind().String()
+}
+
+// BindFlag defines a flag on flags which sets opt. Boolean flags can be passed without a
+// value like any other pflag bool.
+func BindFlag[T comparable](flags *pflag.FlagSet, opt *Option[T], name, usage string) *pflag.Flag {
+	value := NewFlagValue(opt)
+	flag := flags.VarPF(value, name, "", usage)
+	if value.Type() == reflect.Bool.String() {
+		flag.NoOptDefVal = "true"
+	}
+	return flag
+}
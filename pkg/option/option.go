@@ -1,7 +1,6 @@
 package option
 
 import (
-	"encoding/json"
 	"fmt"
 )
 
@@ -18,7 +17,7 @@ func (e NoneError) Error() string {
 }
 
 type primatives interface {
-	~int8 | ~int16 | ~int32 | ~int64 | ~float32 | ~float64 | ~bool | ~string
+	~int | ~int8 | ~int16 | ~int32 | ~int64 | ~uint | ~uint8 | ~uint16 | ~uint32 | ~uint64 | ~float32 | ~float64 | ~bool | ~string
 }
 
 type Optional[T comparable] interface {
@@ -35,58 +34,86 @@ type Optional[T comparable] interface {
 	Transform(func(T) (T, error)) error
 	TransformOr(func(T) (T, error), T)
 	TransformOrElse(func(T) (T, error), func() T)
-	BinaryTransform(second T, f func(T, T) error) error
+	BinaryTransform(second T, f func(T, T) (T, error)) error
 }
 
 type ConfigOptional[T primatives] interface {
 	Optional[T]
 
-	// Satisfies encoding.TextUnmarshaler
+	// Satisfies encoding.TextMarshaler and encoding.TextUnmarshaler
+	MarshalText() ([]byte, error)
 	UnmarshalText(text []byte) error
 }
 
+// Option holds either a value (Some) or nothing (None). The zero value is None, so a
+// field which is never set by a decoder stays None.
 type Option[T comparable] struct {
 	inner T
-	none  bool
+	some  bool
 }
 
 func Some[T comparable](value T) Option[T] {
-	return Option[T]{inner: value, none: false}
+	return Option[T]{inner: value, some: true}
 }
 
 func None[T comparable]() Option[T] {
-	var tmp T
-	return Option[T]{inner: tmp, none: true}
+	return Option[T]{}
+}
+
+// NewOption is an alias for Some, kept for readability at call sites which build
+// an Option from a value that is always present.
+func NewOption[T comparable](value T) Option[T] {
+	return Some(value)
+}
+
+// Clone returns a copy of the Option so that consuming methods such as Unwrap can
+// be called without clearing the original.
+func (o Option[T]) Clone() Option[T] {
+	return o
 }
 
 func (o Option[T]) IsSome() bool {
-	return !o.none
+	return o.some
 }
 
 func (o Option[T]) IsNone() bool {
-	return o.none
+	return !o.some
 }
 
 func (o *Option[T]) Clear() {
-	o.none = true
+	var zero T
+	o.inner = zero
+	o.some = false
 }
 
 func (o *Option[T]) Set(value T) {
 	o.inner = value
-	o.none = false
+	o.some = true
+}
+
+// String satisfies fmt.Stringer so that logged Options show their value rather than
+// their internals.
+func (o Option[T]) String() string {
+	if o.IsNone() {
+		return "None"
+	}
+	return fmt.Sprintf("Some(%v)", o.inner)
 }
 
+// Unwrap takes the value out of the Option, leaving None behind. Use Get to read the
+// value without changing the Option.
 func (o *Option[T]) Unwrap() (T, error) {
 	if o.IsSome() {
-		o.none = true
+		o.some = false
 		return o.inner, nil
 	}
 	return o.inner, fmt.Errorf("Attempted to unwrap Option with None value")
 }
 
+// UnsafeUnwrap takes the value out of the Option like Unwrap, but panics on None.
 func (o *Option[T]) UnsafeUnwrap() T {
 	if o.IsSome() {
-		o.none = true
+		o.some = false
 		return o.inner
 	}
 	panic("Attempted to unsafely unwrap an Option with None value")
@@ -107,7 +134,7 @@ func (o *Option[T]) UnwrapOrElse(f func() T) T {
 }
 
 func (o Option[T]) Match(probe T) bool {
-	if o.none {
+	if o.IsNone() {
 		return false
 	} else {
 		return o.inner == probe
@@ -115,9 +142,9 @@ func (o Option[T]) Match(probe T) bool {
 }
 
 func (o Option[T]) Eq(other Optional[T]) bool {
-	if o.none && other.IsNone() {
+	if o.IsNone() && other.IsNone() {
 		return true
-	} else if !o.none && other.IsSome() {
+	} else if o.IsSome() && other.IsSome() {
 		// We do not know if other is a pointer type or not, so play it safe
 		return other.Match(o.inner)
 	} else {
@@ -179,13 +206,3 @@ func (o *Option[T]) BinaryTransform(second T, f func(T, T) (T, error)) error {
 	o.inner = tmp
 	return nil
 }
-
-// Unmarshaller interface
-func (o *Option[T]) UnmarshalJSON(data []byte) error {
-	var tmp T
-	if err := json.Unmarshal(data, &tmp); err != nil {
-		return err
-	}
-	o.Set(tmp)
-	return nil
-}
@@ -0,0 +1,21 @@
+package option
+
+// Pointers are how proto3 optional fields, and plenty of other generated code, say a
+// value may be missing.
+
+// FromPtr returns None for a nil pointer and Some of the pointed to value otherwise.
+func FromPtr[T comparable](p *T) Option[T] {
+	if p == nil {
+		return None[T]()
+	}
+	return Some(*p)
+}
+
+// Ptr returns nil for None and a pointer to a copy of the value otherwise.
+func (o Option[T]) Ptr() *T {
+	if o.IsNone() {
+		return nil
+	}
+	v := o.inner
+	return &v
+}
@@ -0,0 +1,108 @@
+package option
+
+import "fmt"
+
+// Result holds either a value (Ok) or an error (Err). It is mostly useful for carrying
+// the outcome of an operation through a pipeline, e.g. over a slice, before handling
+// errors in one place. Unlike Option, T does not need to be comparable.
+type Result[T any] struct {
+	value T
+	err   error
+}
+
+func Ok[T any](value T) Result[T] {
+	return Result[T]{value: value}
+}
+
+// Err builds a failed Result. A nil err is treated as an unknown error so that an Err
+// can never be mistaken for an Ok.
+func Err[T any](err error) Result[T] {
+	if err == nil {
+		err = fmt.Errorf("Result created with a nil error")
+	}
+	return Result[T]{err: err}
+}
+
+// ResultOf wraps the usual (value, error) return pair.
+func ResultOf[T any](value T, err error) Result[T] {
+	if err != nil {
+		return Err[T](err)
+	}
+	return Ok(value)
+}
+
+func (r Result[T]) IsOk() bool {
+	return r.err == nil
+}
+
+func (r Result[T]) IsErr() bool {
+	return r.err != nil
+}
+
+// Get unpacks the Result back into a (value, error) pair.
+func (r Result[T]) Get() (T, error) {
+	return r.value, r.err
+}
+
+func (r Result[T]) Err() error {
+	return r.err
+}
+
+// GetOr returns the value, or def if the Result is an Err.
+func (r Result[T]) GetOr(def T) T {
+	if r.IsErr() {
+		return def
+	}
+	return r.value
+}
+
+// MustGet returns the value and panics if the Result is an Err.
+func (r Result[T]) MustGet() T {
+	if r.IsErr() {
+		panic(fmt.Sprintf("Attempted to get the value of an Err Result: %v", r.err))
+	}
+	return r.value
+}
+
+func (r Result[T]) String() string {
+	if r.IsErr() {
+		return fmt.Sprintf("Err(%v)", r.err)
+	}
+	return fmt.Sprintf("Ok(%v)", r.value)
+}
+
+// MapResult applies f to the value of an Ok.
+func MapResult[T, U any](r Result[T], f func(T) U) Result[U] {
+	if r.IsErr() {
+		return Err[U](r.err)
+	}
+	return Ok(f(r.value))
+}
+
+// AndThen applies a fallible f to the value of an Ok.
+func AndThen[T, U any](r Result[T], f func(T) (U, error)) Result[U] {
+	if r.IsErr() {
+		return Err[U](r.err)
+	}
+	return ResultOf(f(r.value))
+}
+
+// Collect returns Ok with every value if all results are Ok, otherwise the first Err.
+func Collect[T any](results []Result[T]) Result[[]T] {
+	values := make([]T, 0, len(results))
+	for _, r := range results {
+		if r.IsErr() {
+			return Err[[]T](r.err)
+		}
+		values = append(values, r.value)
+	}
+	return Ok(values)
+}
+
+// ToOption turns an Ok into Some and discards the error of an Err.
+func ToOption[T comparable](r Result[T]) Option[T] {
+	if r.IsErr() {
+		return None[T]()
+	}
+	return Some(r.value)
+}
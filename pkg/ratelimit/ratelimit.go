@@ -0,0 +1,163 @@
+package ratelimit
+
+// Token bucket rate limiting per client.
+//
+// Every limit has a bucket for each client, which fills at the rate of the limit up to its
+// burst and gives up a token per request. Limits are passed in on every call rather than
+// stored, so a reloaded limit applies its new rate and burst to the tokens each client has
+// left instead of handing everyone a full bucket again.
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/brnsampson/echopilot/pkg/auth"
+)
+
+// How often idle buckets are looked for, and how long a full bucket is kept unused.
+const (
+	sweepInterval = time.Minute
+	maxIdle       = 10 * time.Minute
+)
+
+// Limit allows Rate requests per second with bursts of up to Burst requests.
+type Limit struct {
+	Rate  float64
+	Burst int
+}
+
+// burst defaults to the rate rounded up, but at least a single request.
+func (l Limit) burst() float64 {
+	if l.Burst > 0 {
+		return float64(l.Burst)
+	}
+	return math.Max(1, math.Ceil(l.Rate))
+}
+
+type key struct {
+	limit  string
+	client string
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+	limit  Limit
+}
+
+// fill adds the tokens gained since the bucket was last used, up to the burst of limit.
+func (b *bucket) fill(now time.Time, limit Limit) {
+	gained := now.Sub(b.last).Seconds() * limit.Rate
+	b.tokens = math.Min(limit.burst(), b.tokens+gained)
+	b.last = now
+	b.limit = limit
+}
+
+type Limiter struct {
+	mu        sync.Mutex
+	buckets   map[key]*bucket
+	now       func() time.Time
+	lastSweep time.Time
+}
+
+func New() *Limiter {
+	return NewWithClock(time.Now)
+}
+
+// NewWithClock is New with a clock of its own, for tests.
+func NewWithClock(now func() time.Time) *Limiter {
+	return &Limiter{buckets: make(map[key]*bucket), now: now, lastSweep: now()}
+}
+
+// Allow takes a token from the bucket of client under the limit called name. Without one
+// the request is refused and the duration is how long until the bucket has one again. A
+// limit without a positive rate allows everything.
+func (l *Limiter) Allow(name string, limit Limit, client string) (bool, time.Duration) {
+	return l.take(name, limit, client, true)
+}
+
+// Check is Allow without taking the token, for requests which are only counted once it
+// is known how they went.
+func (l *Limiter) Check(name string, limit Limit, client string) (bool, time.Duration) {
+	return l.take(name, limit, client, false)
+}
+
+func (l *Limiter) take(name string, limit Limit, client string, take bool) (bool, time.Duration) {
+	if limit.Rate <= 0 {
+		return true, 0
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.now()
+	l.sweep(now)
+
+	k := key{name, client}
+	b, found := l.buckets[k]
+	if !found {
+		// A new bucket would be full.
+		if !take {
+			return true, 0
+		}
+		b = &bucket{tokens: limit.burst(), last: now}
+		l.buckets[k] = b
+	}
+	b.fill(now, limit)
+	if b.tokens >= 1 {
+		if take {
+			b.tokens--
+		}
+		return true, 0
+	}
+	return false, time.Duration((1 - b.tokens) / limit.Rate * float64(time.Second))
+}
+
+// Len is the number of buckets kept.
+func (l *Limiter) Len() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return len(l.buckets)
+}
+
+// sweep forgets buckets which have been idle for a while and are full by now, since a
+// new bucket would be just the same.
+func (l *Limiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < sweepInterval {
+		return
+	}
+	l.lastSweep = now
+	for k, b := range l.buckets {
+		idle := now.Sub(b.last)
+		if idle >= maxIdle && b.tokens+idle.Seconds()*b.limit.Rate >= b.limit.burst() {
+			delete(l.buckets, k)
+		}
+	}
+}
+
+// Client tells who sent r, by ip, apiKey or identity: the subject of a verified client
+// certificate. apiKey is the principal r was authenticated as, whether by API key, bearer
+// token, JWT or request signature, so it only works behind the authentication
+// middleware. Keys nobody verified are never used, or clients could make up a fresh one
+// for every request. Requests without a principal or verified certificate are told apart
+// by IP.
+func Client(r *http.Request, by string) string {
+	switch by {
+	case "apiKey":
+		if principal, found := auth.PrincipalFrom(r.Context()); found {
+			return "principal:" + string(principal.Method) + "/" + principal.Name
+		}
+	case "identity":
+		if r.TLS != nil && len(r.TLS.VerifiedChains) > 0 {
+			return "identity:" + r.TLS.VerifiedChains[0][0].Subject.String()
+		}
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return "ip:" + host
+}
@@ -0,0 +1,144 @@
+package ratelimit_test
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/brnsampson/echopilot/pkg/auth"
+	"github.com/brnsampson/echopilot/pkg/ratelimit"
+)
+
+// equals fails the test if exp is not equal to act.
+func equals(tb testing.TB, exp, act interface{}) {
+	if !reflect.DeepEqual(exp, act) {
+		_, file, line, _ := runtime.Caller(1)
+		fmt.Printf("\033[31m%s:%d:\n\n\texp: %#v\n\n\tgot: %#v\033[39m\n\n", filepath.Base(file), line, exp, act)
+		tb.FailNow()
+	}
+}
+
+// clock is a clock which only moves when told to.
+type clock struct{ now time.Time }
+
+func (c *clock) Now() time.Time { return c.now }
+
+func TestAllow(t *testing.T) {
+	c := &clock{time.Unix(0, 0)}
+	limiter := ratelimit.NewWithClock(c.Now)
+	limit := ratelimit.Limit{Rate: 2, Burst: 3}
+
+	// A burst, then nothing until the bucket refills.
+	for i := 0; i < 3; i++ {
+		allowed, _ := limiter.Allow("*", limit, "a")
+		equals(t, true, allowed)
+	}
+	allowed, retry := limiter.Allow("*", limit, "a")
+	equals(t, false, allowed)
+	equals(t, 500*time.Millisecond, retry)
+
+	// Other clients and limits have buckets of their own.
+	allowed, _ = limiter.Allow("*", limit, "b")
+	equals(t, true, allowed)
+	allowed, _ = limiter.Allow("/echo", limit, "a")
+	equals(t, true, allowed)
+
+	c.now = c.now.Add(500 * time.Millisecond)
+	allowed, _ = limiter.Allow("*", limit, "a")
+	equals(t, true, allowed)
+	allowed, _ = limiter.Allow("*", limit, "a")
+	equals(t, false, allowed)
+
+	// The burst defaults to the rate.
+	for i := 0; i < 2; i++ {
+		allowed, _ = limiter.Allow("default", ratelimit.Limit{Rate: 1.5}, "a")
+		equals(t, true, allowed)
+	}
+	allowed, _ = limiter.Allow("default", ratelimit.Limit{Rate: 1.5}, "a")
+	equals(t, false, allowed)
+}
+
+func TestCheck(t *testing.T) {
+	c := &clock{time.Unix(0, 0)}
+	limiter := ratelimit.NewWithClock(c.Now)
+	limit := ratelimit.Limit{Rate: 1, Burst: 1}
+
+	// Checking takes nothing, nor does it keep a bucket for new clients.
+	for i := 0; i < 3; i++ {
+		allowed, _ := limiter.Check("*", limit, "a")
+		equals(t, true, allowed)
+	}
+	equals(t, 0, limiter.Len())
+
+	allowed, _ := limiter.Allow("*", limit, "a")
+	equals(t, true, allowed)
+	allowed, retry := limiter.Check("*", limit, "a")
+	equals(t, false, allowed)
+	equals(t, time.Second, retry)
+}
+
+func TestChangedLimit(t *testing.T) {
+	c := &clock{time.Unix(0, 0)}
+	limiter := ratelimit.NewWithClock(c.Now)
+
+	for i := 0; i < 2; i++ {
+		limiter.Allow("*", ratelimit.Limit{Rate: 1, Burst: 2}, "a")
+	}
+	// Raising the burst does not refill the bucket, the tokens left are kept.
+	allowed, retry := limiter.Allow("*", ratelimit.Limit{Rate: 10, Burst: 20}, "a")
+	equals(t, false, allowed)
+	equals(t, 100*time.Millisecond, retry)
+
+	// But the new rate fills it from now on.
+	c.now = c.now.Add(time.Second)
+	for i := 0; i < 10; i++ {
+		allowed, _ = limiter.Allow("*", ratelimit.Limit{Rate: 10, Burst: 20}, "a")
+		equals(t, true, allowed)
+	}
+
+	// Lowering the burst takes away what is above it.
+	c.now = c.now.Add(time.Minute)
+	allowed, _ = limiter.Allow("*", ratelimit.Limit{Rate: 1, Burst: 1}, "a")
+	equals(t, true, allowed)
+	allowed, _ = limiter.Allow("*", ratelimit.Limit{Rate: 1, Burst: 1}, "a")
+	equals(t, false, allowed)
+}
+
+func TestSweep(t *testing.T) {
+	c := &clock{time.Unix(0, 0)}
+	limiter := ratelimit.NewWithClock(c.Now)
+	limiter.Allow("*", ratelimit.Limit{Rate: 1}, "a")
+	// Refills a token every 10000s, so it is not full again within the hour.
+	limiter.Allow("*", ratelimit.Limit{Rate: 0.0001}, "b")
+	equals(t, 2, limiter.Len())
+
+	c.now = c.now.Add(time.Hour)
+	limiter.Allow("*", ratelimit.Limit{Rate: 1}, "c")
+	equals(t, 2, limiter.Len())
+}
+
+func TestClient(t *testing.T) {
+	r := httptest.NewRequest("GET", "/", nil)
+	r.RemoteAddr = "192.0.2.1:1234"
+	equals(t, "ip:192.0.2.1", ratelimit.Client(r, "ip"))
+	// Without a key or certificate the IP is used.
+	equals(t, "ip:192.0.2.1", ratelimit.Client(r, "apiKey"))
+	equals(t, "ip:192.0.2.1", ratelimit.Client(r, "identity"))
+
+	// Keys are only trusted once they were authenticated.
+	r.Header.Set(auth.APIKeyHeader, "made-up")
+	equals(t, "ip:192.0.2.1", ratelimit.Client(r, "apiKey"))
+	r = r.WithContext(auth.WithPrincipal(r.Context(), auth.Principal{Name: "ci", Method: auth.MethodAPIKey}))
+	equals(t, "principal:apiKey/ci", ratelimit.Client(r, "apiKey"))
+
+	cert := &x509.Certificate{Subject: pkix.Name{CommonName: "client"}}
+	r.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{cert}}}
+	equals(t, "identity:CN=client", ratelimit.Client(r, "identity"))
+}
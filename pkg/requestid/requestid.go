@@ -0,0 +1,191 @@
+package requestid
+
+// Request IDs for correlating log lines across calls.
+//
+// Every request handled by the server gets an ID, either accepted from the X-Request-ID
+// or traceparent (W3C Trace Context) header of a trusted peer or generated. The ID is kept
+// in the context, added to log lines through Logger, returned to the caller and forwarded
+// on outgoing calls made with the context.
+//
+// Generated IDs are 32 lowercase hex digits, the format of a W3C trace ID, so the ID of
+// a request and the trace it belongs to can be the same.
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strings"
+
+	"connectrpc.com/connect"
+	"github.com/charmbracelet/log"
+)
+
+const Header = "X-Request-ID"
+
+const TraceparentHeader = "traceparent"
+
+// Longer IDs from peers are replaced, they only bloat every log line.
+const MaxLength = 128
+
+// New generates a random ID.
+func New() string {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		panic("requestid: reading random bytes failed: " + err.Error())
+	}
+	return hex.EncodeToString(id)
+}
+
+// Valid reports whether an ID received from a peer is safe to log and forward: up to
+// MaxLength letters, digits and any of -_.:
+func Valid(id string) bool {
+	if id == "" || len(id) > MaxLength {
+		return false
+	}
+	for _, c := range id {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case c == '-', c == '_', c == '.', c == ':':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// FromTraceparent returns the trace ID of a traceparent header, e.g.
+// 00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01.
+func FromTraceparent(value string) (string, bool) {
+	parts := strings.Split(strings.TrimSpace(value), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" || len(parts[1]) != 32 || len(parts[2]) != 16 {
+		return "", false
+	}
+	// Version 00 has exactly four fields, later versions may append more.
+	if parts[0] == "00" && len(parts) != 4 {
+		return "", false
+	}
+	traceID := parts[1]
+	if !isLowerHex(parts[0]) || !isLowerHex(traceID) || !isLowerHex(parts[2]) || traceID == strings.Repeat("0", 32) {
+		return "", false
+	}
+	return traceID, true
+}
+
+func isLowerHex(s string) bool {
+	for _, c := range s {
+		if !(c >= '0' && c <= '9' || c >= 'a' && c <= 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+// FromRequest returns the ID a peer sent with r, preferring X-Request-ID over the trace ID
+// of traceparent. Only use it for requests from trusted peers.
+func FromRequest(r *http.Request) (string, bool) {
+	if id := r.Header.Get(Header); Valid(id) {
+		return id, true
+	}
+	return FromTraceparent(r.Header.Get(TraceparentHeader))
+}
+
+type contextKey struct{}
+
+// WithID records the ID of the current request in the context.
+func WithID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, contextKey{}, id)
+}
+
+// ID returns the ID recorded by WithID.
+func ID(ctx context.Context) (string, bool) {
+	id, found := ctx.Value(contextKey{}).(string)
+	return id, found
+}
+
+// Logger returns logger with the request ID of ctx added to every line, or logger itself
+// outside of a request.
+func Logger(ctx context.Context, logger *log.Logger) *log.Logger {
+	if id, found := ID(ctx); found {
+		return logger.With("request_id", id)
+	}
+	return logger
+}
+
+// Transport forwards the request ID of the request context on outgoing requests.
+type Transport struct {
+	Base http.RoundTripper
+}
+
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	id, found := ID(req.Context())
+	if !found || req.Header.Get(Header) != "" {
+		return base.RoundTrip(req)
+	}
+	// RoundTrippers must not modify the request they were given.
+	req = req.Clone(req.Context())
+	req.Header.Set(Header, id)
+	return base.RoundTrip(req)
+}
+
+// NewInterceptor returns a Connect interceptor which forwards the request ID of the
+// context on client calls and returns it in the response trailers of handled calls. The
+// response header is left to the HTTP middleware which assigned the ID.
+func NewInterceptor() connect.Interceptor {
+	return interceptor{}
+}
+
+type interceptor struct{}
+
+func (interceptor) WrapUnary(next connect.UnaryFunc) connect.UnaryFunc {
+	return func(ctx context.Context, req connect.AnyRequest) (connect.AnyResponse, error) {
+		id, found := ID(ctx)
+		if !found {
+			return next(ctx, req)
+		}
+		if req.Spec().IsClient {
+			if req.Header().Get(Header) == "" {
+				req.Header().Set(Header, id)
+			}
+			return next(ctx, req)
+		}
+
+		res, err := next(ctx, req)
+		if err != nil {
+			// Connect turns other errors into CodeUnknown anyway.
+			var connectErr *connect.Error
+			if !errors.As(err, &connectErr) {
+				connectErr = connect.NewError(connect.CodeUnknown, err)
+				err = connectErr
+			}
+			connectErr.Meta().Set(Header, id)
+		} else if res != nil {
+			res.Trailer().Set(Header, id)
+		}
+		return res, err
+	}
+}
+
+func (interceptor) WrapStreamingClient(next connect.StreamingClientFunc) connect.StreamingClientFunc {
+	return func(ctx context.Context, spec connect.Spec) connect.StreamingClientConn {
+		conn := next(ctx, spec)
+		if id, found := ID(ctx); found && conn.RequestHeader().Get(Header) == "" {
+			conn.RequestHeader().Set(Header, id)
+		}
+		return conn
+	}
+}
+
+func (interceptor) WrapStreamingHandler(next connect.StreamingHandlerFunc) connect.StreamingHandlerFunc {
+	return func(ctx context.Context, conn connect.StreamingHandlerConn) error {
+		if id, found := ID(ctx); found {
+			conn.ResponseTrailer().Set(Header, id)
+		}
+		return next(ctx, conn)
+	}
+}
@@ -0,0 +1,105 @@
+package requestid_test
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/brnsampson/echopilot/pkg/requestid"
+)
+
+// ok fails the test if an err is not nil.
+func ok(tb testing.TB, err error) {
+	if err != nil {
+		_, file, line, _ := runtime.Caller(1)
+		fmt.Printf("\033[31m%s:%d: unexpected error: %s\033[39m\n\n", filepath.Base(file), line, err.Error())
+		tb.FailNow()
+	}
+}
+
+// equals fails the test if exp is not equal to act.
+func equals(tb testing.TB, exp, act interface{}) {
+	if !reflect.DeepEqual(exp, act) {
+		_, file, line, _ := runtime.Caller(1)
+		fmt.Printf("\033[31m%s:%d:\n\n\texp: %#v\n\n\tgot: %#v\033[39m\n\n", filepath.Base(file), line, exp, act)
+		tb.FailNow()
+	}
+}
+
+func TestNew(t *testing.T) {
+	id := requestid.New()
+	equals(t, 32, len(id))
+	equals(t, true, requestid.Valid(id))
+	equals(t, false, id == requestid.New())
+}
+
+func TestValid(t *testing.T) {
+	equals(t, true, requestid.Valid("abc-123_x.y:z"))
+	equals(t, false, requestid.Valid(""))
+	equals(t, false, requestid.Valid("has space"))
+	equals(t, false, requestid.Valid("new\nline"))
+	equals(t, false, requestid.Valid(strings.Repeat("a", requestid.MaxLength+1)))
+}
+
+func TestFromTraceparent(t *testing.T) {
+	id, found := requestid.FromTraceparent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	equals(t, true, found)
+	equals(t, "4bf92f3577b34da6a3ce929d0e0e4736", id)
+
+	// Later versions may add fields.
+	_, found = requestid.FromTraceparent("01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra")
+	equals(t, true, found)
+
+	for _, invalid := range []string{
+		"",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra",
+		"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+		"00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6-00f067aa0ba902b7-01",
+	} {
+		_, found = requestid.FromTraceparent(invalid)
+		equals(t, false, found)
+	}
+}
+
+func TestFromRequest(t *testing.T) {
+	r := httptest.NewRequest("GET", "/", nil)
+	_, found := requestid.FromRequest(r)
+	equals(t, false, found)
+
+	r.Header.Set(requestid.TraceparentHeader, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	id, _ := requestid.FromRequest(r)
+	equals(t, "4bf92f3577b34da6a3ce929d0e0e4736", id)
+
+	r.Header.Set(requestid.Header, "abc123")
+	id, _ = requestid.FromRequest(r)
+	equals(t, "abc123", id)
+}
+
+func TestTransport(t *testing.T) {
+	var sent string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sent = r.Header.Get(requestid.Header)
+	}))
+	defer srv.Close()
+	client := &http.Client{Transport: &requestid.Transport{}}
+
+	req, err := http.NewRequestWithContext(requestid.WithID(context.Background(), "abc123"), "GET", srv.URL, nil)
+	ok(t, err)
+	_, err = client.Do(req)
+	ok(t, err)
+	equals(t, "abc123", sent)
+	equals(t, "", req.Header.Get(requestid.Header))
+
+	// Outside of a request nothing is added.
+	_, err = client.Get(srv.URL)
+	ok(t, err)
+	equals(t, "", sent)
+}
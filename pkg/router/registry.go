@@ -0,0 +1,91 @@
+package router
+
+// Registry of the features compiled into the binary.
+//
+// Features register a Factory under their name from an init function, the same way
+// database/sql drivers do, and are only constructed if the config enables them. Blank
+// importing a feature's package is enough to make it available.
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// Factory constructs a feature. It is only called for enabled features.
+type Factory func(host Host) Feature
+
+var (
+	registryMu sync.RWMutex
+	registry   = make(map[string]Factory)
+)
+
+// Register makes a feature available by name. It panics if factory is nil or the name is
+// already registered.
+func Register(name string, factory Factory) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	if factory == nil {
+		panic("router: Register factory is nil for feature " + name)
+	}
+	if _, dup := registry[name]; dup {
+		panic("router: Register called twice for feature " + name)
+	}
+	registry[name] = factory
+}
+
+// Registered returns the sorted names of the registered features.
+func Registered() []string {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// IsRegistered reports whether a feature with the given name was registered.
+func IsRegistered(name string) bool {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	_, found := registry[name]
+	return found
+}
+
+// NewFeatures constructs the named features in order, or every registered feature in
+// name order if names is nil. Unknown names are an error and nothing is constructed.
+func NewFeatures(host Host, names []string) ([]Feature, error) {
+	if names == nil {
+		names = Registered()
+	}
+
+	registryMu.RLock()
+	factories := make([]Factory, 0, len(names))
+	seen := make(map[string]bool, len(names))
+	for _, name := range names {
+		factory, found := registry[name]
+		if !found {
+			registryMu.RUnlock()
+			return nil, fmt.Errorf("unknown feature %s, registered features are %v", name, Registered())
+		}
+		if seen[name] {
+			registryMu.RUnlock()
+			return nil, fmt.Errorf("feature %s is enabled twice", name)
+		}
+		seen[name] = true
+		factories = append(factories, factory)
+	}
+	registryMu.RUnlock()
+
+	features := make([]Feature, 0, len(factories))
+	for i, factory := range factories {
+		f := factory(host)
+		if f.Name() != names[i] {
+			return nil, fmt.Errorf("feature registered as %s is named %s", names[i], f.Name())
+		}
+		features = append(features, f)
+	}
+	return features, nil
+}
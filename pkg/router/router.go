@@ -1,7 +1,25 @@
 package router
 
+// Composition of the server out of features.
+//
+// A Feature is a self-contained part of the server, e.g. the echo RPC service or the
+// memory pages. It brings its own routes, middleware, background work and config
+// section, and the app server only assembles features through a Router. Adding a
+// feature should never require changes to the app server itself.
+
 import (
+    "context"
+    "encoding/json"
+    "errors"
+    "fmt"
     "net/http"
+    "sort"
+    "strings"
+
+    "connectrpc.com/connect"
+    "github.com/charmbracelet/log"
+    "github.com/go-chi/chi/v5"
+    "github.com/prometheus/client_golang/prometheus"
 )
 
 func All[T any](ts []T, pred func(T) bool) bool {
@@ -23,86 +41,214 @@ func DoAll[T any](ts []T, pred func(T) error) error {
     return err
 }
 
-// Common server implementation boilerplate
-type HandlerGenerator interface {
-    // If a HandlerGenerator does not run a goroutine, then Run() and Halt() are no-ops and IsRunning and IsHalted() always return true
-    Run() error // Run() should be idempotent
+type Feature interface {
+    // Name identifies the feature in logs and names its config section.
+    Name() string
+    // Prefix is the path the feature is mounted at. Routes are relative to it. An empty
+    // prefix mounts the routes at the root.
+    Prefix() string
+    // Routes registers the feature's handlers.
+    Routes(r chi.Router)
+    // Middleware wraps every route of the feature, outermost first.
+    Middleware() []func(http.Handler) http.Handler
+    // Run starts any background work. It should be idempotent.
+    Run(ctx context.Context) error
+    // Halt stops the background work started by Run.
     Halt() error
-    IsRunning() bool
-    IsHalted() bool
-	GetHttpHandlers() []*HandlerPair
+    // Configure is called with the feature's section of the config (features.<name>)
+    // at startup and after every reload which changed the config. section is nil if the
+    // config has no section for the feature.
+    Configure(section json.RawMessage) error
 }
 
-type HandlerPair struct {
-    path string
-    handler http.Handler
-}
+// Base provides no-op defaults for everything but the routes of a Feature. Embed it to
+// only implement what a feature needs.
+type Base struct{}
+
+func (Base) Prefix() string { return "" }
+
+func (Base) Middleware() []func(http.Handler) http.Handler { return nil }
+
+func (Base) Run(context.Context) error { return nil }
+
+func (Base) Halt() error { return nil }
 
-func NewHandlerPair(path string, handler http.Handler) *HandlerPair {
-    return &HandlerPair {path, handler}
+func (Base) Configure(json.RawMessage) error { return nil }
+
+// Config is the part of the server config features can see. The server's config
+// satisfies it, without this package having to depend on it.
+type Config interface {
+    // GetFeatures returns the current config sections of all features, by name.
+    GetFeatures() map[string]map[string]interface{}
 }
 
-type HandlerFuncPair struct {
-    path string
-    handler http.HandlerFunc
+// Host is what the app server provides to features.
+type Host struct {
+    Logger *log.Logger
+    Config Config
+    // Authenticate wraps API handlers in the client authentication configured for the
+    // server. Features serving an API should add it to their middleware.
+    Authenticate func(http.Handler) http.Handler
+    // Interceptors are added to every Connect handler a feature serves, outermost first.
+    Interceptors []connect.Interceptor
+    // Metrics registers the feature's Prometheus metrics with the server. Nil if the
+    // server exports none.
+    Metrics prometheus.Registerer
 }
 
 func NewRouter() *Router {
-    handlers := make([]HandlerPair, 0)
-    handlerFuncs := make([]HandlerFuncPair, 0)
-    handlerGens := make([]HandlerGenerator, 0)
-    return &Router { handlers, handlerFuncs, handlerGens }
+    return &Router{mux: chi.NewRouter(), features: make([]Feature, 0), prefixes: make(map[string]string), owners: make(map[string]string), exempt: make(map[string][]string)}
 }
 
+// Router mounts features on a chi router. It is the http.Handler of the server.
 type Router struct {
-    handlers []HandlerPair
-    handlerFuncs []HandlerFuncPair
-    handlerGens []HandlerGenerator
+    mux      *chi.Mux
+    features []Feature
+    // Where each feature is mounted by name.
+    prefixes map[string]string
+    // Which feature added a route, by method and pattern. See routes.go.
+    owners map[string]string
+    // Path prefixes named middleware passes straight on, by name. See routes.go.
+    exempt map[string][]string
 }
 
-func (r *Router) AddHandler(path string, handler http.Handler) {
-    r.handlers = append(r.handlers, HandlerPair{path, handler}) }
+// Use adds middleware to every route, including those of features. chi only allows
+// this before the first route is added.
+func (r *Router) Use(middlewares ...func(http.Handler) http.Handler) {
+    r.mux.Use(middlewares...)
+}
 
-func (r *Router) AddHandlerFunc(path string, handler http.HandlerFunc) {
-    r.handlerFuncs = append(r.handlerFuncs, HandlerFuncPair{path, handler})
+// Mux returns the underlying router for routes which belong to the server itself rather
+// than to a feature, e.g. health checks.
+func (r *Router) Mux() chi.Router {
+    return r.mux
 }
 
-func (r *Router) AddHandlerGen(handleGen HandlerGenerator) {
-    r.handlerGens = append(r.handlerGens, handleGen)
+// Add mounts a feature at its prefix, wrapped in its middleware.
+func (r *Router) Add(f Feature) error {
+    return r.AddAt(f, f.Prefix())
 }
 
-// implement HandlerGenerator for Router
-func (r *Router) Run() error{
-    return DoAll(r.handlerGens, func(h HandlerGenerator) error { return h.Run() })
+// AddAt mounts a feature at the given prefix instead of its own, wrapped in its middleware.
+func (r *Router) AddAt(f Feature, prefix string) (err error) {
+    for _, existing := range r.features {
+        if existing.Name() == f.Name() {
+            return fmt.Errorf("feature %s was added twice", f.Name())
+        }
+    }
+
+    if prefix != "" && !strings.HasPrefix(prefix, "/") {
+        return fmt.Errorf("feature %s: prefix %q must start with /", f.Name(), prefix)
+    }
+    prefix = strings.TrimSuffix(prefix, "/")
+
+    // Whatever shows up in the tree while the feature adds its routes belongs to it.
+    before := r.patterns()
+    defer func() {
+        for route := range r.patterns() {
+            if !before[route] {
+                r.owners[route] = f.Name()
+            }
+        }
+    }()
+
+    // chi panics on conflicting routes.
+    defer func() {
+        if p := recover(); p != nil {
+            err = fmt.Errorf("feature %s: %v", f.Name(), p)
+        }
+    }()
+
+    // Middleware goes on the subrouter rather than on a group around it, where chi.Walk
+    // would not see it.
+    if prefix == "" {
+        r.mux.Group(func(g chi.Router) {
+            g.Use(f.Middleware()...)
+            f.Routes(g)
+        })
+    } else {
+        r.mux.Route(prefix, func(sub chi.Router) {
+            sub.Use(f.Middleware()...)
+            f.Routes(sub)
+        })
+    }
+    r.features = append(r.features, f)
+    r.prefixes[f.Name()] = prefix
+    return nil
 }
 
-func (r *Router) Halt() error {
-    return DoAll(r.handlerGens, func(h HandlerGenerator) error { return h.Halt() })
+// Prefix returns the path a feature is mounted at, without a trailing slash. found is
+// false if no feature of that name was added, e.g. because it is disabled.
+func (r *Router) Prefix(name string) (prefix string, found bool) {
+    prefix, found = r.prefixes[name]
+    return prefix, found
 }
 
-func (r *Router) IsRunning() bool {
-    return All(r.handlerGens, func(h HandlerGenerator) bool { return h.IsRunning() })
+// Features lists the mounted features in the order they were added.
+func (r *Router) Features() []Feature {
+    return append([]Feature{}, r.features...)
 }
 
-func (r *Router) IsHalted() bool {
-    return All(r.handlerGens, func(h HandlerGenerator) bool { return h.IsHalted() })
+func (r *Router) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+    r.mux.ServeHTTP(w, req)
+}
+
+func (r *Router) Run(ctx context.Context) error {
+    return DoAll(r.features, func(f Feature) error {
+        if err := f.Run(ctx); err != nil {
+            return fmt.Errorf("feature %s: %w", f.Name(), err)
+        }
+        return nil
+    })
 }
 
-func (r *Router) GetHttpHandler() (path string, handler http.Handler) {
-    mux := http.NewServeMux()
-    for _, handlerPair := range r.handlers {
-        mux.Handle(handlerPair.path, handlerPair.handler)
+// Halt stops features in the reverse order they were added.
+func (r *Router) Halt() error {
+    reversed := make([]Feature, len(r.features))
+    for i, f := range r.features {
+        reversed[len(r.features)-1-i] = f
     }
+    return DoAll(reversed, func(f Feature) error {
+        if err := f.Halt(); err != nil {
+            return fmt.Errorf("feature %s: %w", f.Name(), err)
+        }
+        return nil
+    })
+}
 
-    for _, handlerFuncPair := range r.handlerFuncs {
-        mux.Handle(handlerFuncPair.path, handlerFuncPair.handler)
+// Configure hands every feature its config section. Every feature is configured even if
+// another one fails. Sections for registered features which are not mounted are ignored
+// so that disabling a feature does not require removing its config, but sections for
+// features which do not exist at all are an error.
+func (r *Router) Configure(sections map[string]map[string]interface{}) error {
+    errs := make([]error, 0)
+    known := make(map[string]bool, len(r.features))
+    for _, f := range r.features {
+        known[f.Name()] = true
+
+        var section json.RawMessage
+        if s, found := sections[f.Name()]; found {
+            data, err := json.Marshal(s)
+            if err != nil {
+                errs = append(errs, fmt.Errorf("feature %s: %w", f.Name(), err))
+                continue
+            }
+            section = data
+        }
+        if err := f.Configure(section); err != nil {
+            errs = append(errs, fmt.Errorf("feature %s: %w", f.Name(), err))
+        }
     }
 
-    for _, handlerGen := range r.handlerGens {
-        for _, handlerpair := range handlerGen.GetHttpHandlers() {
-            mux.Handle(handlerpair.path, handlerpair.handler)
+    unknown := make([]string, 0)
+    for name := range sections {
+        if !known[name] && !IsRegistered(name) {
+            unknown = append(unknown, name)
         }
     }
-    return "/", mux
+    sort.Strings(unknown)
+    for _, name := range unknown {
+        errs = append(errs, fmt.Errorf("config section for unknown feature %s", name))
+    }
+    return errors.Join(errs...)
 }
-// End implementation of HandlerGenerator for Router
@@ -0,0 +1,285 @@
+package router_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/brnsampson/echopilot/pkg/router"
+	"github.com/go-chi/chi/v5"
+)
+
+// assert fails the test if the condition is false.
+func assert(tb testing.TB, condition bool, msg string, v ...interface{}) {
+	if !condition {
+		_, file, line, _ := runtime.Caller(1)
+		fmt.Printf("\033[31m%s:%d: "+msg+"\033[39m\n\n", append([]interface{}{filepath.Base(file), line}, v...)...)
+		tb.FailNow()
+	}
+}
+
+// ok fails the test if an err is not nil.
+func ok(tb testing.TB, err error) {
+	if err != nil {
+		_, file, line, _ := runtime.Caller(1)
+		fmt.Printf("\033[31m%s:%d: unexpected error: %s\033[39m\n\n", filepath.Base(file), line, err.Error())
+		tb.FailNow()
+	}
+}
+
+// equals fails the test if exp is not equal to act.
+func equals(tb testing.TB, exp, act interface{}) {
+	if !reflect.DeepEqual(exp, act) {
+		_, file, line, _ := runtime.Caller(1)
+		fmt.Printf("\033[31m%s:%d:\n\n\texp: %#v\n\n\tgot: %#v\033[39m\n\n", filepath.Base(file), line, exp, act)
+		tb.FailNow()
+	}
+}
+
+type testFeature struct {
+	router.Base
+	name    string
+	prefix  string
+	events  *[]string
+	section json.RawMessage
+}
+
+func (f *testFeature) Name() string   { return f.name }
+func (f *testFeature) Prefix() string { return f.prefix }
+
+func (f *testFeature) Routes(r chi.Router) {
+	r.Get("/hello", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "%s at %s", f.name, r.URL.Path)
+	})
+}
+
+func (f *testFeature) Middleware() []func(http.Handler) http.Handler {
+	return []func(http.Handler) http.Handler{func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Add("X-Feature", f.name)
+			next.ServeHTTP(w, r)
+		})
+	}}
+}
+
+func (f *testFeature) Run(context.Context) error {
+	*f.events = append(*f.events, "run "+f.name)
+	return nil
+}
+
+func (f *testFeature) Halt() error {
+	*f.events = append(*f.events, "halt "+f.name)
+	return nil
+}
+
+func (f *testFeature) Configure(section json.RawMessage) error {
+	f.section = section
+	if strings.Contains(string(section), "invalid") {
+		return fmt.Errorf("invalid section")
+	}
+	return nil
+}
+
+func get(t *testing.T, h http.Handler, path string) (int, string, []string) {
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", path, nil))
+	body, err := io.ReadAll(rec.Body)
+	ok(t, err)
+	return rec.Code, string(body), rec.Header().Values("X-Feature")
+}
+
+func TestRouter(t *testing.T) {
+	events := make([]string, 0)
+	a := &testFeature{name: "a", prefix: "/a/", events: &events}
+	b := &testFeature{name: "b", prefix: "", events: &events}
+
+	r := router.NewRouter()
+	r.Mux().Get("/health", func(w http.ResponseWriter, r *http.Request) { w.Write([]byte("ok")) })
+	ok(t, r.Add(a))
+	ok(t, r.Add(b))
+	equals(t, []router.Feature{a, b}, r.Features())
+
+	code, body, features := get(t, r, "/a/hello")
+	equals(t, http.StatusOK, code)
+	equals(t, "a at /a/hello", body)
+	equals(t, []string{"a"}, features)
+
+	code, body, features = get(t, r, "/hello")
+	equals(t, http.StatusOK, code)
+	equals(t, "b at /hello", body)
+	equals(t, []string{"b"}, features)
+
+	// Routes of the server itself get no feature middleware.
+	code, body, features = get(t, r, "/health")
+	equals(t, "ok", body)
+	equals(t, []string(nil), features)
+
+	code, _, _ = get(t, r, "/b/hello")
+	equals(t, http.StatusNotFound, code)
+
+	ok(t, r.Run(context.Background()))
+	ok(t, r.Halt())
+	equals(t, []string{"run a", "run b", "halt b", "halt a"}, events)
+}
+
+func TestRouterRejects(t *testing.T) {
+	events := make([]string, 0)
+	r := router.NewRouter()
+	ok(t, r.Add(&testFeature{name: "a", prefix: "/a", events: &events}))
+
+	err := r.Add(&testFeature{name: "a", prefix: "/other", events: &events})
+	assert(t, err != nil, "expected an error for a duplicate name")
+
+	err = r.Add(&testFeature{name: "c", prefix: "/a", events: &events})
+	assert(t, err != nil, "expected an error for a conflicting prefix")
+
+	err = r.Add(&testFeature{name: "d", prefix: "relative", events: &events})
+	assert(t, err != nil, "expected an error for a relative prefix")
+
+	equals(t, 1, len(r.Features()))
+}
+
+func TestConfigure(t *testing.T) {
+	events := make([]string, 0)
+	a := &testFeature{name: "a", prefix: "/a", events: &events}
+	b := &testFeature{name: "b", prefix: "/b", events: &events}
+	r := router.NewRouter()
+	ok(t, r.Add(a))
+	ok(t, r.Add(b))
+
+	ok(t, r.Configure(map[string]map[string]interface{}{"a": {"limit": 3}}))
+	equals(t, `{"limit":3}`, string(a.section))
+	equals(t, json.RawMessage(nil), b.section)
+
+	err := r.Configure(map[string]map[string]interface{}{
+		"a":       {"mode": "invalid"},
+		"b":       {"limit": 1},
+		"missing": {},
+	})
+	assert(t, err != nil && strings.Contains(err.Error(), "feature a") && strings.Contains(err.Error(), "unknown feature missing"), "unexpected error %v", err)
+	// Other features are configured regardless.
+	equals(t, `{"limit":1}`, string(b.section))
+}
+
+func TestRegistry(t *testing.T) {
+	events := make([]string, 0)
+	for _, name := range []string{"registry-b", "registry-a"} {
+		name := name
+		router.Register(name, func(router.Host) router.Feature {
+			events = append(events, "new "+name)
+			return &testFeature{name: name, prefix: "/" + name, events: &events}
+		})
+	}
+	assert(t, router.IsRegistered("registry-a"), "registry-a should be registered")
+
+	defer func() {
+		assert(t, recover() != nil, "expected a panic for a duplicate registration")
+	}()
+
+	// Only enabled features are constructed, in the order they are listed.
+	features, err := router.NewFeatures(router.Host{}, []string{"registry-b"})
+	ok(t, err)
+	equals(t, 1, len(features))
+	equals(t, "registry-b", features[0].Name())
+	equals(t, []string{"new registry-b"}, events)
+
+	features, err = router.NewFeatures(router.Host{}, []string{})
+	ok(t, err)
+	equals(t, 0, len(features))
+
+	_, err = router.NewFeatures(router.Host{}, []string{"registry-a", "missing"})
+	assert(t, err != nil, "expected an error for an unknown feature")
+	_, err = router.NewFeatures(router.Host{}, []string{"registry-a", "registry-a"})
+	assert(t, err != nil, "expected an error for a feature enabled twice")
+	equals(t, []string{"new registry-b"}, events)
+
+	// Sections of registered but disabled features are ignored.
+	r := router.NewRouter()
+	ok(t, r.Add(features[0]))
+	ok(t, r.Configure(map[string]map[string]interface{}{"registry-a": {"limit": 1}}))
+
+	router.Register("registry-a", func(router.Host) router.Feature { return nil })
+}
+
+func TestAddAt(t *testing.T) {
+	events := make([]string, 0)
+	a := &testFeature{name: "a", prefix: "/a", events: &events}
+	r := router.NewRouter()
+	ok(t, r.AddAt(a, "/api/a/"))
+
+	code, body, _ := get(t, r, "/api/a/hello")
+	equals(t, http.StatusOK, code)
+	equals(t, "a at /api/a/hello", body)
+	code, _, _ = get(t, r, "/a/hello")
+	equals(t, http.StatusNotFound, code)
+
+	prefix, found := r.Prefix("a")
+	assert(t, found, "a should be mounted")
+	equals(t, "/api/a", prefix)
+	_, found = r.Prefix("b")
+	assert(t, !found, "b should not be mounted")
+}
+
+type rpcFeature struct {
+	router.Base
+}
+
+func (rpcFeature) Name() string { return "rpc" }
+
+func (rpcFeature) Routes(r chi.Router) {
+	r.Handle("/pkg.Service/*", http.NotFoundHandler())
+}
+
+func (rpcFeature) Procedures() []string {
+	return []string{"/pkg.Service/Get", "/pkg.Service/Put", "/other.Service/Get"}
+}
+
+func TestRoutes(t *testing.T) {
+	events := make([]string, 0)
+	r := router.NewRouter()
+	r.Use(router.Named("outer", func(next http.Handler) http.Handler { return next }))
+	r.Mux().Get("/health", func(w http.ResponseWriter, r *http.Request) {})
+	ok(t, r.AddAt(&testFeature{name: "a", events: &events}, "/api/a"))
+	ok(t, r.AddAt(rpcFeature{}, "/rpc"))
+
+	routes := r.Routes()
+	equals(t, 4, len(routes))
+	equals(t, router.Route{Method: "GET", Path: "/api/a/hello", Feature: "a", Middleware: []string{"outer", "router_test.(*testFeature).Middleware.func1"}}, routes[0])
+	equals(t, router.Route{Method: "GET", Path: "/health", Middleware: []string{"outer"}}, routes[1])
+	// The catch-all is replaced by the procedures behind it.
+	equals(t, router.Route{Method: "POST", Path: "/rpc/pkg.Service/Get", Feature: "rpc", Procedure: "pkg.Service/Get", Middleware: []string{"outer"}}, routes[2])
+	equals(t, "/rpc/pkg.Service/Put", routes[3].Path)
+
+	// Middleware skipping some paths is not listed for them.
+	r.Exempt("outer", "/health", "/api/")
+	routes = r.Routes()
+	equals(t, []string{"router_test.(*testFeature).Middleware.func1"}, routes[0].Middleware)
+	equals(t, []string{}, routes[1].Middleware)
+	equals(t, []string{"outer"}, routes[2].Middleware)
+}
+
+func TestMatch(t *testing.T) {
+	events := make([]string, 0)
+	r := router.NewRouter()
+	r.Mux().Route("/", func(r chi.Router) {
+		r.Get("/", func(w http.ResponseWriter, r *http.Request) {})
+	})
+	r.Mux().Get("/echo/{content}", func(w http.ResponseWriter, r *http.Request) {})
+	ok(t, r.AddAt(&testFeature{name: "a", events: &events}, "/api/a"))
+	ok(t, r.AddAt(rpcFeature{}, "/rpc"))
+
+	equals(t, "/", r.Match("GET", "/"))
+	equals(t, "/echo/{content}", r.Match("GET", "/echo/hi"))
+	equals(t, "/api/a/hello", r.Match("GET", "/api/a/hello"))
+	equals(t, "/rpc/pkg.Service/*", r.Match("POST", "/rpc/pkg.Service/Get"))
+	equals(t, "", r.Match("POST", "/echo/hi"))
+	equals(t, "", r.Match("GET", "/nowhere"))
+}
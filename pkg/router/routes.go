@@ -0,0 +1,228 @@
+package router
+
+// Introspection of the assembled router.
+//
+// Routes are found by walking the chi tree, so the table always shows what is actually
+// served rather than what was meant to be. Connect services are mounted as a single
+// catch-all route, so features serving them can list their procedures to have the
+// catch-all replaced by one entry per procedure.
+
+import (
+	"net/http"
+	"reflect"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// Route is one entry of the route table.
+type Route struct {
+	// Method is * if the route accepts every method.
+	Method string `json:"method"`
+	Path   string `json:"path"`
+	// Feature owning the route, empty for routes of the server itself.
+	Feature string `json:"feature,omitempty"`
+	// Procedure is the Connect procedure served at Path, if any.
+	Procedure string `json:"procedure,omitempty"`
+	// Middleware wrapping the route, outermost first.
+	Middleware []string `json:"middleware"`
+}
+
+// ProcedureLister is implemented by features serving Connect or gRPC procedures, e.g.
+// /echo.v1.EchoService/EchoString. Paths are relative to the prefix of the feature.
+type ProcedureLister interface {
+	Procedures() []string
+}
+
+// The methods chi registers for routes which accept any method.
+var allMethods = []string{
+	http.MethodConnect, http.MethodDelete, http.MethodGet, http.MethodHead, http.MethodOptions,
+	http.MethodPatch, http.MethodPost, http.MethodPut, http.MethodTrace,
+}
+
+var middlewareNames sync.Map
+
+// Named gives middleware a name in the route table. Without one, middleware is shown by
+// the name of its function, which is not very telling for closures.
+func Named(name string, mw func(http.Handler) http.Handler) func(http.Handler) http.Handler {
+	middlewareNames.Store(reflect.ValueOf(mw).Pointer(), name)
+	return mw
+}
+
+func middlewareName(mw func(http.Handler) http.Handler) string {
+	pc := reflect.ValueOf(mw).Pointer()
+	if name, found := middlewareNames.Load(pc); found {
+		return name.(string)
+	}
+	fn := runtime.FuncForPC(pc)
+	if fn == nil {
+		return "unknown"
+	}
+	// github.com/go-chi/chi/v5/middleware.Logger becomes middleware.Logger.
+	name := fn.Name()
+	name = name[strings.LastIndex(name, "/")+1:]
+	return strings.TrimSuffix(name, "-fm")
+}
+
+// Exempt records that the middleware called name passes requests under the given path
+// prefixes straight on, so the route table does not list it for routes there. The
+// middleware has to skip them itself. Empty prefixes are ignored.
+func (r *Router) Exempt(name string, prefixes ...string) {
+	for _, prefix := range prefixes {
+		if prefix != "" {
+			r.exempt[name] = append(r.exempt[name], strings.TrimSuffix(prefix, "/"))
+		}
+	}
+}
+
+// exempted tells whether the middleware called name skips path.
+func (r *Router) exempted(name, path string) bool {
+	for _, prefix := range r.exempt[name] {
+		if path == prefix || strings.HasPrefix(path, prefix+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// patterns lists the method and pattern of every route currently in the tree.
+func (r *Router) patterns() map[string]bool {
+	found := make(map[string]bool)
+	chi.Walk(r.mux, func(method string, route string, _ http.Handler, _ ...func(http.Handler) http.Handler) error {
+		found[method+" "+route] = true
+		return nil
+	})
+	return found
+}
+
+// Routes lists every route of the router sorted by path and method. Routes which accept
+// every method are listed once with method *.
+func (r *Router) Routes() []Route {
+	type key struct {
+		path, feature, middleware string
+	}
+	methods := make(map[key][]string)
+	middleware := make(map[key][]string)
+	chi.Walk(r.mux, func(method string, route string, _ http.Handler, mws ...func(http.Handler) http.Handler) error {
+		path := cleanPattern(route)
+		names := make([]string, 0, len(mws))
+		for _, mw := range mws {
+			if name := middlewareName(mw); !r.exempted(name, path) {
+				names = append(names, name)
+			}
+		}
+		k := key{path, r.owners[method+" "+route], strings.Join(names, " ")}
+		for _, m := range methods[k] {
+			if m == method {
+				return nil
+			}
+		}
+		methods[k] = append(methods[k], method)
+		middleware[k] = names
+		return nil
+	})
+
+	routes := make([]Route, 0, len(methods))
+	for k, ms := range methods {
+		sort.Strings(ms)
+		if len(ms) < len(allMethods) {
+			for _, m := range ms {
+				routes = append(routes, Route{Method: m, Path: k.path, Feature: k.feature, Middleware: middleware[k]})
+			}
+			continue
+		}
+
+		route := Route{Method: "*", Path: k.path, Feature: k.feature, Middleware: middleware[k]}
+		procedures := r.procedures(route)
+		if len(procedures) == 0 {
+			routes = append(routes, route)
+		}
+		routes = append(routes, procedures...)
+	}
+
+	sort.Slice(routes, func(i, j int) bool {
+		if routes[i].Path != routes[j].Path {
+			return routes[i].Path < routes[j].Path
+		}
+		return routes[i].Method < routes[j].Method
+	})
+	return routes
+}
+
+// cleanPattern undoes what chi.Walk leaves of nested mounts, e.g. /memory/*/*/ for a router
+// mounted at / inside a route at /memory.
+func cleanPattern(pattern string) string {
+	for strings.Contains(pattern, "/*/") {
+		pattern = strings.ReplaceAll(pattern, "/*/", "/")
+	}
+	if len(pattern) > 1 {
+		pattern = strings.TrimSuffix(pattern, "/")
+	}
+	return pattern
+}
+
+// RoutePattern returns the pattern of the route which served r, e.g. /echo/{content}, or
+// an empty string if no route matched. It is only complete once the route has been found,
+// so middleware should call it after passing the request on.
+func RoutePattern(r *http.Request) string {
+	rctx := chi.RouteContext(r.Context())
+	if rctx == nil {
+		return ""
+	}
+	pattern := rctx.RoutePattern()
+	if pattern == "" {
+		return ""
+	}
+	return cleanPattern(pattern)
+}
+
+// Match returns the pattern of the route which would serve a request for method and path,
+// or an empty string if there is none. Unlike RoutePattern it works before the request
+// has been routed.
+func (r *Router) Match(method, path string) string {
+	rctx := chi.NewRouteContext()
+	if !r.mux.Match(rctx, method, path) {
+		return ""
+	}
+	// chi trims the trailing slash, which leaves nothing of the index route.
+	if pattern := cleanPattern(rctx.RoutePattern()); pattern != "" {
+		return pattern
+	}
+	return "/"
+}
+
+// procedures expands a catch-all route of a feature into the procedures it serves.
+func (r *Router) procedures(route Route) []Route {
+	base, catchAll := strings.CutSuffix(route.Path, "*")
+	if !catchAll || route.Feature == "" {
+		return nil
+	}
+	var lister ProcedureLister
+	for _, f := range r.features {
+		if l, ok := f.(ProcedureLister); ok && f.Name() == route.Feature {
+			lister = l
+		}
+	}
+	if lister == nil {
+		return nil
+	}
+
+	routes := make([]Route, 0)
+	for _, procedure := range lister.Procedures() {
+		path := r.prefixes[route.Feature] + procedure
+		if strings.HasPrefix(path, base) {
+			// Connect and gRPC always POST.
+			routes = append(routes, Route{
+				Method:     http.MethodPost,
+				Path:       path,
+				Feature:    route.Feature,
+				Procedure:  strings.TrimPrefix(procedure, "/"),
+				Middleware: route.Middleware,
+			})
+		}
+	}
+	return routes
+}
@@ -1,8 +1,11 @@
 package server
 
 import (
+	"bytes"
 	"context"
 	"crypto/tls"
+	"io"
+	stdlog "log"
 	"net/http"
 	"os"
 	"os/signal"
@@ -27,80 +30,134 @@ type Server struct {
 	stop     chan os.Signal
 	reload   chan os.Signal
 	exitCode int
+	// Called for every failed TLS handshake.
+	onHandshakeError func()
+	// Called after every reload.
+	onReload func(error)
 }
 
-func (s *Server) ServeWithReload(router http.Handler, sopts ServerOptions) {
-	for {
-		addr, err := sopts.GetAddr(true)
-		if err != nil {
-			s.logger.Error("Error: failed to refresh server config. May use some old settings.")
-		}
+// OnReload registers a function which is called after every reload with the error
+// loading the new config, nil if it loaded. Must be called before the server runs.
+func (s *Server) OnReload(fn func(error)) {
+	s.onReload = fn
+}
 
-		tlsConf, _ := sopts.GetTlsConfig(false)
-		if err != nil {
-			s.logger.Error("Error: failed to refresh server config. May use some old settings.")
-		}
+// OnTLSHandshakeError registers a function which is called for every client failing the
+// TLS handshake. Must be called before the server runs.
+func (s *Server) OnTLSHandshakeError(fn func()) {
+	s.onHandshakeError = fn
+}
 
-		tlsEnabled, _ := sopts.GetTlsEnabled(false)
-		if err != nil {
-			s.logger.Error("Error: failed to refresh server config. May use some old settings.")
-		}
+// net/http only reports failed handshakes through the error log of the server.
+var handshakeErrorLine = []byte("http: TLS handshake error")
 
-        stdlog := s.logger.StandardLog(log.StandardLogOptions{
-            ForceLevel: log.ErrorLevel,
-        })
-		httpServ := &http.Server{
-			Addr:         addr,
-			Handler:      router,
-            ErrorLog:     stdlog,
-			TLSConfig:    tlsConf,
-			ReadTimeout:  5 * time.Second,
-			WriteTimeout: 10 * time.Second,
-			IdleTimeout:  120 * time.Second,
-		}
-		go func(err chan<- error) {
-			if tlsEnabled {
-				// Note that the certificate is already embedded in the tlsConf and that will override
-				// any cert/key filenames we pass anyways.
-				s.logger.Infof("https server listening on %s", addr)
-				if e := httpServ.ListenAndServeTLS("", ""); e != nil && e != http.ErrServerClosed {
-					err <- e
+type handshakeErrorSpy struct {
+	io.Writer
+	onHandshakeError func()
+}
+
+func (h handshakeErrorSpy) Write(line []byte) (int, error) {
+	if bytes.Contains(line, handshakeErrorLine) {
+		h.onHandshakeError()
+	}
+	return h.Writer.Write(line)
+}
+
+// ServeWithReload serves router until the server shuts down. A reload refreshes the
+// config while the listener keeps serving: certificates are swapped in the store behind
+// the TLS config, so the listener is only restarted when the address or whether TLS is
+// enabled changed.
+func (s *Server) ServeWithReload(router http.Handler, sopts ServerOptions) {
+	addr, err := sopts.GetAddr(true)
+	if err != nil {
+		s.logger.Error("Error: failed to refresh server config. May use some old settings.")
+	}
+	tlsEnabled, _ := sopts.GetTlsEnabled(false)
+
+	for {
+		httpServ := s.listen(router, sopts, addr, tlsEnabled)
+
+		restart := false
+		for !restart {
+			select {
+			case <-s.reload:
+				s.logger.Info("SIGHUP received. Reloading...")
+				nextAddr, err := sopts.GetAddr(true)
+				if s.onReload != nil {
+					s.onReload(err)
 				}
-			} else {
-				s.logger.Infof("http server listening on %s", addr)
-				if e := httpServ.ListenAndServe(); e != nil && e != http.ErrServerClosed {
-					err <- e
+				if err != nil {
+					s.logger.Error("Error: failed to refresh server config. Still serving the previous one.", "error", err)
+					continue
 				}
+				nextTlsEnabled, _ := sopts.GetTlsEnabled(false)
+				if nextAddr == addr && nextTlsEnabled == tlsEnabled {
+					s.logger.Debug("Reloaded without restarting the HTTP server")
+					continue
+				}
+
+				s.logger.Info("Restarting HTTP server", "addr", nextAddr, "tls", nextTlsEnabled)
+				s.halt(httpServ)
+				addr, tlsEnabled = nextAddr, nextTlsEnabled
+				restart = true
+			case <-s.done:
+				s.logger.Info("Server shutting down...")
+				s.halt(httpServ)
+				s.wg.Done()
+				return
 			}
-		}(s.err)
-
-		select {
-		case <-s.reload:
-			s.logger.Info("SIGHUP received. Reloading...")
-			begin := time.Now()
-			s.logger.Debug("Halting HTTP Server...")
-			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-			if err := httpServ.Shutdown(ctx); err != nil {
-				s.logger.Debugf("Failed to gracefully shutdown server: %v", err)
-			} else {
-				s.logger.Debugf("HTTP server halted in %v", time.Since(begin))
+		}
+	}
+}
+
+// listen starts serving router on addr in the background. Errors other than the server
+// being shut down end up in s.err.
+func (s *Server) listen(router http.Handler, sopts ServerOptions, addr string, tlsEnabled bool) *http.Server {
+	tlsConf, _ := sopts.GetTlsConfig(false)
+
+	errorLog := s.logger.StandardLog(log.StandardLogOptions{
+		ForceLevel: log.ErrorLevel,
+	})
+	if s.onHandshakeError != nil {
+		errorLog = stdlog.New(handshakeErrorSpy{errorLog.Writer(), s.onHandshakeError}, "", 0)
+	}
+	httpServ := &http.Server{
+		Addr:         addr,
+		Handler:      router,
+		ErrorLog:     errorLog,
+		TLSConfig:    tlsConf,
+		ReadTimeout:  5 * time.Second,
+		WriteTimeout: 10 * time.Second,
+		IdleTimeout:  120 * time.Second,
+	}
+	go func(err chan<- error) {
+		if tlsEnabled {
+			// Note that the certificate is already embedded in the tlsConf and that will override
+			// any cert/key filenames we pass anyways.
+			s.logger.Infof("https server listening on %s", addr)
+			if e := httpServ.ListenAndServeTLS("", ""); e != nil && e != http.ErrServerClosed {
+				err <- e
 			}
-			cancel()
-		case <-s.done:
-			s.logger.Info("Server shutting down...")
-			begin := time.Now()
-			s.logger.Debug("Halting HTTP Server...")
-			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-			if err := httpServ.Shutdown(ctx); err != nil {
-				s.logger.Debugf("Failed to gracefully shutdown HTTP server: %v", err)
-			} else {
-				s.logger.Debugf("HTTP server halted in %v", time.Since(begin))
+		} else {
+			s.logger.Infof("http server listening on %s", addr)
+			if e := httpServ.ListenAndServe(); e != nil && e != http.ErrServerClosed {
+				err <- e
 			}
-
-			s.wg.Done()
-			cancel()
-			return
 		}
+	}(s.err)
+	return httpServ
+}
+
+// halt gracefully shuts down httpServ, giving requests in flight a few seconds to finish.
+func (s *Server) halt(httpServ *http.Server) {
+	begin := time.Now()
+	s.logger.Debug("Halting HTTP Server...")
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := httpServ.Shutdown(ctx); err != nil {
+		s.logger.Debugf("Failed to gracefully shutdown HTTP server: %v", err)
+	} else {
+		s.logger.Debugf("HTTP server halted in %v", time.Since(begin))
 	}
 }
 
@@ -131,6 +188,16 @@ func (s *Server) waitOnInterrupt() {
 	}
 }
 
+// Reload triggers the same reload path as receiving SIGHUP. If a reload is already
+// pending this is a no-op.
+func (s *Server) Reload() {
+	select {
+	case s.reload <- syscall.SIGHUP:
+	default:
+		s.logger.Debug("Reload already pending")
+	}
+}
+
 func (s *Server) Shutdown() {
 	signal.Stop(s.reload)
 	signal.Stop(s.stop)
@@ -159,6 +226,6 @@ func NewServer(logger *log.Logger) *Server {
 	err := make(chan error, 1)
 
 	// If exitCode is -1 then execution has not completed yet.
-	server := &Server{logger.With("package", "server"), &waitgroup, err, done, stop, hups, -1}
+	server := &Server{logger.With("package", "server"), &waitgroup, err, done, stop, hups, -1, nil, nil}
 	return server
 }
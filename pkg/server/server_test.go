@@ -0,0 +1,119 @@
+package server_test
+
+import (
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/brnsampson/echopilot/pkg/server"
+	"github.com/charmbracelet/log"
+)
+
+// ok fails the test if an err is not nil.
+func ok(tb testing.TB, err error) {
+	if err != nil {
+		_, file, line, _ := runtime.Caller(1)
+		fmt.Printf("\033[31m%s:%d: unexpected error: %s\033[39m\n\n", filepath.Base(file), line, err.Error())
+		tb.FailNow()
+	}
+}
+
+// equals fails the test if exp is not equal to act.
+func equals(tb testing.TB, exp, act interface{}) {
+	if !reflect.DeepEqual(exp, act) {
+		_, file, line, _ := runtime.Caller(1)
+		fmt.Printf("\033[31m%s:%d:\n\n\texp: %#v\n\n\tgot: %#v\033[39m\n\n", filepath.Base(file), line, exp, act)
+		tb.FailNow()
+	}
+}
+
+type options struct {
+	mu   sync.Mutex
+	addr string
+}
+
+func (o *options) GetAddr(bool) (string, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.addr, nil
+}
+
+func (o *options) GetTlsConfig(bool) (*tls.Config, error) { return nil, nil }
+func (o *options) GetTlsEnabled(bool) (bool, error)       { return false, nil }
+
+func (o *options) setAddr(addr string) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.addr = addr
+}
+
+func freeAddr(t *testing.T) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	ok(t, err)
+	defer l.Close()
+	return l.Addr().String()
+}
+
+// get requests url and tells whether it went over a connection used before.
+func get(t *testing.T, client *http.Client, url string) bool {
+	var reused bool
+	trace := &httptrace.ClientTrace{GotConn: func(info httptrace.GotConnInfo) { reused = info.Reused }}
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	ok(t, err)
+	req = req.WithContext(httptrace.WithClientTrace(req.Context(), trace))
+
+	var resp *http.Response
+	for i := 0; i < 50; i++ {
+		if resp, err = client.Do(req); err == nil {
+			break
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	ok(t, err)
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	equals(t, http.StatusOK, resp.StatusCode)
+	return reused
+}
+
+func TestReloadKeepsListener(t *testing.T) {
+	opts := &options{addr: freeAddr(t)}
+	srv := server.NewServer(log.New(io.Discard))
+	reloads := make(chan error, 1)
+	srv.OnReload(func(err error) { reloads <- err })
+	exited := make(chan int)
+	go func() {
+		exited <- srv.BlockingRun(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}), opts)
+	}()
+
+	client := &http.Client{Transport: &http.Transport{}}
+	first := opts.addr
+	equals(t, false, get(t, client, "http://"+first))
+
+	// Nothing the listener depends on changed, so the connection stays open.
+	srv.Reload()
+	ok(t, <-reloads)
+	equals(t, true, get(t, client, "http://"+first))
+
+	// A new address needs a new listener.
+	second := freeAddr(t)
+	opts.setAddr(second)
+	srv.Reload()
+	ok(t, <-reloads)
+	equals(t, false, get(t, client, "http://"+second))
+	_, err := net.DialTimeout("tcp", first, time.Second)
+	equals(t, true, err != nil)
+
+	ok(t, syscall.Kill(syscall.Getpid(), syscall.SIGTERM))
+	equals(t, 0, <-exited)
+}
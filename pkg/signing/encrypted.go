@@ -0,0 +1,376 @@
+package signing
+
+// Passphrase encrypted private keys.
+//
+// Encrypted keys are standard PKCS#8 "ENCRYPTED PRIVATE KEY" PEM blocks (RFC 5958) using
+// PBES2 (RFC 8018), so they can be created and read by openssl as well:
+//
+//	openssl pkcs8 -topk8 -scrypt -in key.pem -out key.enc.pem
+//	openssl pkey -in key.enc.pem -pubout
+//
+// Keys written by this package derive the key with scrypt and encrypt with AES-256-CBC.
+// For reading, PBKDF2 (HMAC-SHA1/224/256/384/512) and AES-128/192/256-CBC are accepted
+// too, which covers the defaults of openssl and most other tools. KDF parameters are
+// bounded so that a hostile key file cannot make us burn minutes of CPU or gigabytes
+// of memory.
+//
+// The passphrase is supplied through a Passphrase, which is only called when an
+// encrypted key is actually read. Plaintext keys are still accepted everywhere a
+// Passphrase is taken.
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"strings"
+
+	"golang.org/x/crypto/pbkdf2"
+	"golang.org/x/crypto/scrypt"
+)
+
+// scrypt parameters for newly encrypted keys, the same as openssl pkcs8 -scrypt. Larger
+// values exceed openssl's default memory limit of 32MiB and could not be read by it.
+const (
+	ScryptN = 1 << 14
+	ScryptR = 8
+	ScryptP = 1
+)
+
+// Limits for parameters read from key files.
+const (
+	maxScryptN          = 1 << 20
+	maxScryptMemory     = 256 << 20
+	maxPBKDF2Iterations = 10_000_000
+)
+
+var (
+	ErrNoPassphrase        = errors.New("key is encrypted but no passphrase was given")
+	ErrIncorrectPassphrase = errors.New("incorrect passphrase")
+)
+
+var (
+	oidPBES2      = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 5, 13}
+	oidPBKDF2     = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 5, 12}
+	oidScrypt     = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 11591, 4, 11}
+	oidHMACSHA1   = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 7}
+	oidHMACSHA224 = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 8}
+	oidHMACSHA256 = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 9}
+	oidHMACSHA384 = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 10}
+	oidHMACSHA512 = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 11}
+	oidAES128CBC  = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 2}
+	oidAES192CBC  = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 22}
+	oidAES256CBC  = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 42}
+)
+
+type encryptedPrivateKeyInfo struct {
+	Algorithm     pkix.AlgorithmIdentifier
+	EncryptedData []byte
+}
+
+type pbes2Params struct {
+	KeyDerivationFunc pkix.AlgorithmIdentifier
+	EncryptionScheme  pkix.AlgorithmIdentifier
+}
+
+type pbkdf2Params struct {
+	Salt           []byte
+	IterationCount int
+	KeyLength      int                      `asn1:"optional"`
+	PRF            pkix.AlgorithmIdentifier `asn1:"optional"`
+}
+
+type scryptParams struct {
+	Salt                     []byte
+	CostParameter            int
+	BlockSize                int
+	ParallelizationParameter int
+	KeyLength                int `asn1:"optional"`
+}
+
+// A Passphrase returns the passphrase protecting a key. It is only called when an
+// encrypted key is read.
+type Passphrase func() ([]byte, error)
+
+// PassphraseString always returns s.
+func PassphraseString(s string) Passphrase {
+	return func() ([]byte, error) {
+		return []byte(s), nil
+	}
+}
+
+// PassphraseFile reads the passphrase from the first line of a file, e.g. a mounted
+// secret. The file is re-read every time so a rotated secret is picked up on reload.
+func PassphraseFile(file string) Passphrase {
+	return func() ([]byte, error) {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("Error reading passphrase: %w", err)
+		}
+		line, _, _ := strings.Cut(string(data), "\n")
+		return []byte(strings.TrimSuffix(line, "\r")), nil
+	}
+}
+
+// IsEncryptedPEM reports whether the first PEM block in data is an encrypted private key.
+func IsEncryptedPEM(data []byte) bool {
+	block, _ := pem.Decode(data)
+	return block != nil && (block.Type == "ENCRYPTED PRIVATE KEY" || block.Headers["Proc-Type"] != "")
+}
+
+// MarshalEncryptedPEM encodes the key as a PKCS#8 "ENCRYPTED PRIVATE KEY" PEM block,
+// using scrypt and AES-256-CBC.
+func (k *PrivateKey) MarshalEncryptedPEM(passphrase []byte) ([]byte, error) {
+	if len(passphrase) == 0 {
+		return nil, ErrNoPassphrase
+	}
+
+	der, err := x509.MarshalPKCS8PrivateKey(k.signer)
+	if err != nil {
+		return nil, err
+	}
+
+	salt := make([]byte, 16)
+	iv := make([]byte, aes.BlockSize)
+	if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+		return nil, err
+	}
+	if _, err := io.ReadFull(rand.Reader, iv); err != nil {
+		return nil, err
+	}
+
+	kdf, err := asn1.Marshal(scryptParams{
+		Salt:                     salt,
+		CostParameter:            ScryptN,
+		BlockSize:                ScryptR,
+		ParallelizationParameter: ScryptP,
+	})
+	if err != nil {
+		return nil, err
+	}
+	ivParam, err := asn1.Marshal(iv)
+	if err != nil {
+		return nil, err
+	}
+	params, err := asn1.Marshal(pbes2Params{
+		KeyDerivationFunc: pkix.AlgorithmIdentifier{Algorithm: oidScrypt, Parameters: asn1.RawValue{FullBytes: kdf}},
+		EncryptionScheme:  pkix.AlgorithmIdentifier{Algorithm: oidAES256CBC, Parameters: asn1.RawValue{FullBytes: ivParam}},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	derived, err := scrypt.Key(passphrase, salt, ScryptN, ScryptR, ScryptP, 32)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(derived)
+	if err != nil {
+		return nil, err
+	}
+	// PKCS#7 padding, which always adds at least one byte.
+	padding := aes.BlockSize - len(der)%aes.BlockSize
+	plaintext := append(der, bytes.Repeat([]byte{byte(padding)}, padding)...)
+	encrypted := make([]byte, len(plaintext))
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(encrypted, plaintext)
+
+	info, err := asn1.Marshal(encryptedPrivateKeyInfo{
+		Algorithm:     pkix.AlgorithmIdentifier{Algorithm: oidPBES2, Parameters: asn1.RawValue{FullBytes: params}},
+		EncryptedData: encrypted,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "ENCRYPTED PRIVATE KEY", Bytes: info}), nil
+}
+
+// DecryptPrivateKeyPEM decodes the first PEM block in data, decrypting it if needed. The
+// passphrase is only asked for if the key is encrypted, and may be nil otherwise.
+func DecryptPrivateKeyPEM(data []byte, passphrase Passphrase) (*PrivateKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, ErrNoPEMBlock
+	}
+	if block.Type != "ENCRYPTED PRIVATE KEY" {
+		return ParsePrivateKeyPEM(data)
+	}
+	if passphrase == nil {
+		return nil, ErrNoPassphrase
+	}
+	pass, err := passphrase()
+	if err != nil {
+		return nil, err
+	}
+	if len(pass) == 0 {
+		return nil, ErrNoPassphrase
+	}
+
+	der, err := decryptPKCS8(block.Bytes, pass)
+	if err != nil {
+		return nil, err
+	}
+	key, err := x509.ParsePKCS8PrivateKey(der)
+	if err != nil {
+		// CBC padding only catches most wrong passphrases. The rest decrypt to garbage.
+		return nil, ErrIncorrectPassphrase
+	}
+	return NewPrivateKey(key)
+}
+
+func decryptPKCS8(der, pass []byte) ([]byte, error) {
+	malformed := func(err error) error {
+		return &MalformedKeyError{"ENCRYPTED PRIVATE KEY", err}
+	}
+
+	var info encryptedPrivateKeyInfo
+	if rest, err := asn1.Unmarshal(der, &info); err != nil {
+		return nil, malformed(err)
+	} else if len(rest) > 0 {
+		return nil, malformed(errors.New("trailing data"))
+	}
+	if !info.Algorithm.Algorithm.Equal(oidPBES2) {
+		return nil, malformed(fmt.Errorf("%w: encryption scheme %s", ErrUnsupportedAlgorithm, info.Algorithm.Algorithm))
+	}
+
+	var params pbes2Params
+	if _, err := asn1.Unmarshal(info.Algorithm.Parameters.FullBytes, &params); err != nil {
+		return nil, malformed(err)
+	}
+
+	var keyLen int
+	switch scheme := params.EncryptionScheme.Algorithm; {
+	case scheme.Equal(oidAES128CBC):
+		keyLen = 16
+	case scheme.Equal(oidAES192CBC):
+		keyLen = 24
+	case scheme.Equal(oidAES256CBC):
+		keyLen = 32
+	default:
+		return nil, malformed(fmt.Errorf("%w: cipher %s", ErrUnsupportedAlgorithm, scheme))
+	}
+	var iv []byte
+	if _, err := asn1.Unmarshal(params.EncryptionScheme.Parameters.FullBytes, &iv); err != nil {
+		return nil, malformed(err)
+	}
+	if len(iv) != aes.BlockSize {
+		return nil, malformed(errors.New("invalid IV"))
+	}
+
+	derived, err := deriveKey(params.KeyDerivationFunc, pass, keyLen)
+	if err != nil {
+		return nil, malformed(err)
+	}
+
+	encrypted := info.EncryptedData
+	if len(encrypted) == 0 || len(encrypted)%aes.BlockSize != 0 {
+		return nil, malformed(errors.New("encrypted data is not a whole number of blocks"))
+	}
+	block, err := aes.NewCipher(derived)
+	if err != nil {
+		return nil, err
+	}
+	plaintext := make([]byte, len(encrypted))
+	cipher.NewCBCDecrypter(block, iv).CryptBlocks(plaintext, encrypted)
+
+	padding := int(plaintext[len(plaintext)-1])
+	if padding == 0 || padding > aes.BlockSize {
+		return nil, ErrIncorrectPassphrase
+	}
+	for _, b := range plaintext[len(plaintext)-padding:] {
+		if int(b) != padding {
+			return nil, ErrIncorrectPassphrase
+		}
+	}
+	return plaintext[:len(plaintext)-padding], nil
+}
+
+func deriveKey(kdf pkix.AlgorithmIdentifier, pass []byte, keyLen int) ([]byte, error) {
+	switch {
+	case kdf.Algorithm.Equal(oidScrypt):
+		var params scryptParams
+		if _, err := asn1.Unmarshal(kdf.Parameters.FullBytes, &params); err != nil {
+			return nil, err
+		}
+		n, r, p := params.CostParameter, params.BlockSize, params.ParallelizationParameter
+		if n < 2 || n > maxScryptN || r < 1 || p < 1 || r*p >= 1<<30/128 || 128*r*(n+p) > maxScryptMemory {
+			return nil, fmt.Errorf("scrypt parameters N=%d r=%d p=%d are out of range", n, r, p)
+		}
+		if params.KeyLength != 0 && params.KeyLength != keyLen {
+			return nil, errors.New("key length does not match the cipher")
+		}
+		return scrypt.Key(pass, params.Salt, n, r, p, keyLen)
+
+	case kdf.Algorithm.Equal(oidPBKDF2):
+		var params pbkdf2Params
+		if _, err := asn1.Unmarshal(kdf.Parameters.FullBytes, &params); err != nil {
+			return nil, err
+		}
+		if params.IterationCount < 1 || params.IterationCount > maxPBKDF2Iterations {
+			return nil, fmt.Errorf("PBKDF2 iteration count %d is out of range", params.IterationCount)
+		}
+		if params.KeyLength != 0 && params.KeyLength != keyLen {
+			return nil, errors.New("key length does not match the cipher")
+		}
+
+		var h func() hash.Hash
+		switch prf := params.PRF.Algorithm; {
+		case len(prf) == 0, prf.Equal(oidHMACSHA1):
+			h = sha1.New
+		case prf.Equal(oidHMACSHA224):
+			h = sha256.New224
+		case prf.Equal(oidHMACSHA256):
+			h = sha256.New
+		case prf.Equal(oidHMACSHA384):
+			h = sha512.New384
+		case prf.Equal(oidHMACSHA512):
+			h = sha512.New
+		default:
+			return nil, fmt.Errorf("%w: PBKDF2 PRF %s", ErrUnsupportedAlgorithm, prf)
+		}
+		return pbkdf2.Key(pass, params.Salt, params.IterationCount, keyLen, h), nil
+	}
+	return nil, fmt.Errorf("%w: key derivation function %s", ErrUnsupportedAlgorithm, kdf.Algorithm)
+}
+
+// ReadEncryptedPrivateKey reads a private key which may be encrypted.
+func ReadEncryptedPrivateKey(r io.Reader, passphrase Passphrase) (*PrivateKey, error) {
+	data, err := readKey(r)
+	if err != nil {
+		return nil, err
+	}
+	return DecryptPrivateKeyPEM(data, passphrase)
+}
+
+// ReadEncryptedPrivateKeyFile reads a private key file which may be encrypted. Even
+// encrypted key files must have KeyFilePerms.
+func ReadEncryptedPrivateKeyFile(keyFile string, passphrase Passphrase) (*PrivateKey, error) {
+	keyReader, err := openKeyFile(keyFile)
+	if err != nil {
+		return nil, err
+	}
+	defer keyReader.Close()
+
+	return ReadEncryptedPrivateKey(keyReader, passphrase)
+}
+
+// WriteEncryptedKeyFiles is WriteKeyFiles with the private key encrypted under
+// passphrase.
+func WriteEncryptedKeyFiles(key *PrivateKey, keyFile, pubFile string, passphrase []byte, overwrite bool) error {
+	keyPEM, err := key.MarshalEncryptedPEM(passphrase)
+	if err != nil {
+		return err
+	}
+	return writeKeyFiles(keyPEM, key.Public(), keyFile, pubFile, overwrite)
+}
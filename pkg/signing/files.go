@@ -0,0 +1,120 @@
+package signing
+
+// Writing key files safely.
+//
+// Files are written to a temporary file in the destination directory which already has
+// its final permissions, then moved into place. A reader therefore sees either the old
+// file or the complete new one, and a private key is never readable by anybody else,
+// not even for a moment.
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// WriteFileAtomic replaces path with data. Unless overwrite is set it fails with an
+// error wrapping fs.ErrExist if path already exists, without any window in which a
+// concurrent writer could be clobbered.
+func WriteFileAtomic(path string, data []byte, perm fs.FileMode, overwrite bool) error {
+	dir := filepath.Dir(path)
+	tmp, err := os.CreateTemp(dir, "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	// CreateTemp uses 0600, and Chmod is not affected by the umask.
+	if err := tmp.Chmod(perm); err != nil {
+		tmp.Close()
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	if overwrite {
+		return os.Rename(tmp.Name(), path)
+	}
+
+	// Link fails if the destination exists, unlike Rename.
+	if err := os.Link(tmp.Name(), path); err != nil {
+		if errors.Is(err, fs.ErrExist) {
+			return fmt.Errorf("%s: %w", path, fs.ErrExist)
+		}
+		return err
+	}
+	return nil
+}
+
+// WriteKeyFiles writes a private key as PKCS#8 with KeyFilePerms and its public key with
+// PubKeyFilePerms. Unless overwrite is set, neither file is written if either exists.
+func WriteKeyFiles(key *PrivateKey, keyFile, pubFile string, overwrite bool) error {
+	keyPEM, err := key.MarshalPEM()
+	if err != nil {
+		return err
+	}
+	return writeKeyFiles(keyPEM, key.Public(), keyFile, pubFile, overwrite)
+}
+
+func writeKeyFiles(keyPEM []byte, pub *PublicKey, keyFile, pubFile string, overwrite bool) error {
+	pubPEM, err := pub.MarshalPEM()
+	if err != nil {
+		return err
+	}
+
+	if !overwrite {
+		for _, f := range []string{keyFile, pubFile} {
+			if _, err := os.Stat(f); err == nil {
+				return fmt.Errorf("%s: %w", f, fs.ErrExist)
+			}
+		}
+	}
+
+	for _, dir := range []string{filepath.Dir(keyFile), filepath.Dir(pubFile)} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+	}
+
+	// Write the public key last so that a reader never finds a public key without its
+	// private key.
+	if err := WriteFileAtomic(keyFile, keyPEM, KeyFilePerms, overwrite); err != nil {
+		return fmt.Errorf("Error writing private key: %w", err)
+	}
+	if err := WriteFileAtomic(pubFile, pubPEM, PubKeyFilePerms, overwrite); err != nil {
+		return fmt.Errorf("Error writing public key: %w", err)
+	}
+	return nil
+}
+
+// Fingerprint is the hex encoded SHA-256 of the PKIX encoded public key, which is the
+// same as `openssl pkey -pubin -outform DER | sha256sum`.
+func (p *PublicKey) Fingerprint() string {
+	der, err := x509.MarshalPKIXPublicKey(p.key)
+	if err != nil {
+		// Every key type accepted by NewPublicKey can be marshalled.
+		panic(err)
+	}
+	sum := sha256.Sum256(der)
+	return hex.EncodeToString(sum[:])
+}
+
+// KeyID is a short form of the fingerprint which is still unique enough to tell keys
+// apart, e.g. in a JWT header or a file name.
+func (p *PublicKey) KeyID() string {
+	return p.Fingerprint()[:16]
+}
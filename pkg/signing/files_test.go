@@ -0,0 +1,92 @@
+package signing_test
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/brnsampson/echopilot/pkg/signing"
+)
+
+func TestWriteFileAtomic(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "key.pem")
+
+	ok(t, signing.WriteFileAtomic(file, []byte("first"), signing.KeyFilePerms, false))
+	info, err := os.Stat(file)
+	ok(t, err)
+	equalsOctal(t, signing.KeyFilePerms, info.Mode().Perm())
+
+	err = signing.WriteFileAtomic(file, []byte("second"), signing.KeyFilePerms, false)
+	assert(t, errors.Is(err, fs.ErrExist), "expected fs.ErrExist, got %v", err)
+	data, err := os.ReadFile(file)
+	ok(t, err)
+	equals(t, "first", string(data))
+
+	ok(t, signing.WriteFileAtomic(file, []byte("second"), signing.PubKeyFilePerms, true))
+	data, err = os.ReadFile(file)
+	ok(t, err)
+	equals(t, "second", string(data))
+	info, err = os.Stat(file)
+	ok(t, err)
+	equalsOctal(t, signing.PubKeyFilePerms, info.Mode().Perm())
+
+	// No temporary files may be left behind.
+	entries, err := os.ReadDir(filepath.Dir(file))
+	ok(t, err)
+	equals(t, 1, len(entries))
+}
+
+func TestWriteKeyFiles(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "signing")
+	keyFile, pubFile := filepath.Join(dir, "key.pem"), filepath.Join(dir, "pub.pem")
+	key := testKey(t, signing.Ed25519)
+
+	ok(t, signing.WriteKeyFiles(key, keyFile, pubFile, false))
+	keyValid, pubValid, err := signing.KeyFilePermsValid(keyFile, pubFile)
+	ok(t, err)
+	assert(t, keyValid && pubValid, "key files were written with the wrong permissions")
+
+	read, err := signing.ReadPrivateKeyFile(keyFile)
+	ok(t, err)
+	assert(t, read.Public().Equal(key.Public()), "read back a different key")
+
+	// Neither file is touched if one of them exists.
+	ok(t, os.Remove(keyFile))
+	err = signing.WriteKeyFiles(testKey(t, signing.ECDSAP256), keyFile, pubFile, false)
+	assert(t, errors.Is(err, fs.ErrExist), "expected fs.ErrExist, got %v", err)
+	_, err = os.Stat(keyFile)
+	assert(t, errors.Is(err, fs.ErrNotExist), "private key should not have been written")
+
+	ok(t, signing.WriteKeyFiles(testKey(t, signing.ECDSAP256), keyFile, pubFile, true))
+	pub, err := signing.ReadPublicKeyFile(pubFile)
+	ok(t, err)
+	equals(t, signing.ECDSAP256, pub.Algorithm())
+}
+
+func TestWriteECDSAFilesCreates(t *testing.T) {
+	dir := t.TempDir()
+	keyFile, pubFile := filepath.Join(dir, "key.pem"), filepath.Join(dir, "pub.pem")
+	key, pub, err := signing.GenerateECDSA()
+	ok(t, err)
+
+	ok(t, signing.WriteECDSAFiles(key, keyFile, pub, pubFile, nil))
+	newKey, newPub, err := signing.ReadECDSAFiles(keyFile, pubFile, nil)
+	ok(t, err)
+	equals(t, key, newKey)
+	equals(t, pub, newPub)
+}
+
+func TestFingerprint(t *testing.T) {
+	pub := testKey(t, signing.ECDSAP384).Public()
+	der, err := x509.MarshalPKIXPublicKey(pub.Key())
+	ok(t, err)
+	sum := sha256.Sum256(der)
+
+	equals(t, hex.EncodeToString(sum[:]), pub.Fingerprint())
+	equals(t, pub.Fingerprint()[:16], pub.KeyID())
+}
@@ -0,0 +1,341 @@
+package signing
+
+// A keyring is a directory of keys which lets signing keys be rotated without a flag day.
+//
+// The directory holds a keyring.json manifest next to the key files it lists:
+//
+//	{
+//	  "keys": [
+//	    {"file": "2026-07.pem", "status": "verify-only"},
+//	    {"file": "2026-10.pem", "status": "active", "notBefore": "2026-10-01T00:00:00Z"},
+//	    {"file": "partner.pub.pem", "status": "verify-only"}
+//	  ]
+//	}
+//
+// Every key is identified by its KeyID, which is derived from the public key and so
+// never has to be kept in sync by hand. Signing uses the active key whose window
+// (notBefore/notAfter, both optional) contains the current time, preferring the most
+// recent notBefore. Verification accepts any key which is not retired, so a new key
+// can be published as verify-only ahead of switching to it and the old key stays
+// verify-only until everything it signed has expired.
+//
+// Private keys may be encrypted (see encrypted.go), in which case the passphrase given to
+// Load is used for all of them.
+//
+// Like the certificate store, a load builds a complete snapshot and swaps it in, so a
+// broken manifest or key file leaves the previous keys in use.
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync/atomic"
+	"time"
+
+	"github.com/brnsampson/echopilot/pkg/option"
+)
+
+const KeyringManifest = "keyring.json"
+
+type KeyStatus string
+
+const (
+	// Used for signing (within its window) and verification.
+	StatusActive KeyStatus = "active"
+	// Only used for verification, e.g. a key being phased in or out.
+	StatusVerifyOnly KeyStatus = "verify-only"
+	// Kept on disk for reference but never used.
+	StatusRetired KeyStatus = "retired"
+)
+
+var (
+	ErrNoActiveKey = errors.New("keyring has no active signing key")
+	ErrUnknownKey  = errors.New("key is not in the keyring")
+	ErrRetiredKey  = errors.New("key has been retired")
+)
+
+type keyringManifest struct {
+	Keys []manifestEntry `json:"keys"`
+}
+
+type manifestEntry struct {
+	File      string                   `json:"file"`
+	Status    KeyStatus                `json:"status"`
+	NotBefore option.Option[time.Time] `json:"notBefore"`
+	NotAfter  option.Option[time.Time] `json:"notAfter"`
+}
+
+// KeyringEntry describes one key in the keyring.
+type KeyringEntry struct {
+	ID        string
+	File      string
+	Status    KeyStatus
+	NotBefore option.Option[time.Time]
+	NotAfter  option.Option[time.Time]
+	Public    *PublicKey
+	// nil for keys which were given as a public key only.
+	private *PrivateKey
+}
+
+// CanSign reports whether the entry may be used for signing at the given time.
+func (e KeyringEntry) CanSign(now time.Time) bool {
+	if e.Status != StatusActive || e.private == nil {
+		return false
+	}
+	if nb, found := e.NotBefore.Get(); found && now.Before(nb) {
+		return false
+	}
+	if na, found := e.NotAfter.Get(); found && !now.Before(na) {
+		return false
+	}
+	return true
+}
+
+type keyringSnapshot struct {
+	dir        string
+	passphrase Passphrase
+	entries    []KeyringEntry
+	byID       map[string]*KeyringEntry
+}
+
+type Keyring struct {
+	current atomic.Pointer[keyringSnapshot]
+}
+
+func NewKeyring() *Keyring {
+	k := &Keyring{}
+	k.current.Store(&keyringSnapshot{byID: make(map[string]*KeyringEntry)})
+	return k
+}
+
+// LoadKeyring is shorthand for NewKeyring followed by Load.
+func LoadKeyring(dir string, passphrase Passphrase) (*Keyring, error) {
+	k := NewKeyring()
+	if err := k.Load(dir, passphrase); err != nil {
+		return nil, err
+	}
+	return k, nil
+}
+
+// Load reads the manifest and every key in dir and atomically replaces the contents of
+// the keyring. If anything fails to load, the keyring is left untouched. passphrase
+// may be nil if none of the private keys are encrypted.
+func (k *Keyring) Load(dir string, passphrase Passphrase) error {
+	snap, err := loadKeyring(dir, passphrase)
+	if err != nil {
+		return err
+	}
+	k.current.Store(snap)
+	return nil
+}
+
+// Reload re-reads the directory the keyring was last loaded from.
+func (k *Keyring) Reload() error {
+	snap := k.current.Load()
+	if snap.dir == "" {
+		return nil
+	}
+	return k.Load(snap.dir, snap.passphrase)
+}
+
+// Replace swaps in the keys other holds, along with the directory and passphrase they
+// were loaded from.
+func (k *Keyring) Replace(other *Keyring) {
+	k.current.Store(other.current.Load())
+}
+
+// Clear empties the keyring.
+func (k *Keyring) Clear() {
+	k.current.Store(&keyringSnapshot{byID: make(map[string]*KeyringEntry)})
+}
+
+// Dir is the directory the keyring was loaded from, if any.
+func (k *Keyring) Dir() string {
+	return k.current.Load().dir
+}
+
+// Entries describes every key in the keyring, including retired ones.
+func (k *Keyring) Entries() []KeyringEntry {
+	snap := k.current.Load()
+	entries := make([]KeyringEntry, len(snap.entries))
+	copy(entries, snap.entries)
+	return entries
+}
+
+// Active returns the key currently used for signing.
+func (k *Keyring) Active() (KeyringEntry, error) {
+	now := time.Now()
+	snap := k.current.Load()
+	var best *KeyringEntry
+	for i, e := range snap.entries {
+		if !e.CanSign(now) {
+			continue
+		}
+		if best == nil || e.NotBefore.GetOr(time.Time{}).After(best.NotBefore.GetOr(time.Time{})) {
+			best = &snap.entries[i]
+		}
+	}
+	if best == nil {
+		return KeyringEntry{}, ErrNoActiveKey
+	}
+	return *best, nil
+}
+
+// Signer returns the private key currently used for signing along with its ID.
+func (k *Keyring) Signer() (string, *PrivateKey, error) {
+	e, err := k.Active()
+	if err != nil {
+		return "", nil, err
+	}
+	return e.ID, e.private, nil
+}
+
+// Lookup returns the public key with the given ID if it may be used for verification.
+func (k *Keyring) Lookup(id string) (*PublicKey, error) {
+	e, found := k.current.Load().byID[id]
+	if !found {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownKey, id)
+	}
+	if e.Status == StatusRetired {
+		return nil, fmt.Errorf("%w: %s", ErrRetiredKey, id)
+	}
+	return e.Public, nil
+}
+
+// Sign signs msg with the active key and returns the ID of the key used.
+func (k *Keyring) Sign(msg []byte, format Format) (string, []byte, error) {
+	id, key, err := k.Signer()
+	if err != nil {
+		return "", nil, err
+	}
+	sig, err := key.Sign(msg, format)
+	return id, sig, err
+}
+
+// SignReader signs everything read from r with the active key and returns the ID of the
+// key used.
+func (k *Keyring) SignReader(r io.Reader, format Format) (string, []byte, error) {
+	id, key, err := k.Signer()
+	if err != nil {
+		return "", nil, err
+	}
+	sig, err := key.SignReader(r, format)
+	return id, sig, err
+}
+
+// Verify checks sig with the key with the given ID. If id is empty every key which is
+// not retired is tried.
+func (k *Keyring) Verify(id string, msg, sig []byte, format Format) error {
+	if id != "" {
+		pub, err := k.Lookup(id)
+		if err != nil {
+			return err
+		}
+		return pub.Verify(msg, sig, format)
+	}
+
+	for _, e := range k.current.Load().entries {
+		if e.Status == StatusRetired {
+			continue
+		}
+		if err := e.Public.Verify(msg, sig, format); err == nil {
+			return nil
+		}
+	}
+	return ErrInvalidSignature
+}
+
+func loadKeyring(dir string, passphrase Passphrase) (*keyringSnapshot, error) {
+	manifestFile := filepath.Join(dir, KeyringManifest)
+	data, err := os.ReadFile(manifestFile)
+	if err != nil {
+		return nil, err
+	}
+
+	var manifest keyringManifest
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&manifest); err != nil {
+		return nil, fmt.Errorf("invalid keyring manifest %s: %w", manifestFile, err)
+	}
+
+	snap := &keyringSnapshot{
+		dir:        dir,
+		passphrase: passphrase,
+		entries:    make([]KeyringEntry, 0, len(manifest.Keys)),
+		byID:       make(map[string]*KeyringEntry),
+	}
+	for _, m := range manifest.Keys {
+		e, err := loadKeyringEntry(dir, m, passphrase)
+		if err != nil {
+			return nil, err
+		}
+		snap.entries = append(snap.entries, e)
+	}
+
+	sort.SliceStable(snap.entries, func(i, j int) bool {
+		return snap.entries[i].NotBefore.GetOr(time.Time{}).Before(snap.entries[j].NotBefore.GetOr(time.Time{}))
+	})
+	for i := range snap.entries {
+		e := &snap.entries[i]
+		if _, found := snap.byID[e.ID]; found {
+			return nil, fmt.Errorf("key %s (%s) is listed more than once in %s", e.ID, e.File, manifestFile)
+		}
+		snap.byID[e.ID] = e
+	}
+	return snap, nil
+}
+
+func loadKeyringEntry(dir string, m manifestEntry, passphrase Passphrase) (KeyringEntry, error) {
+	e := KeyringEntry{
+		File:      m.File,
+		Status:    m.Status,
+		NotBefore: m.NotBefore,
+		NotAfter:  m.NotAfter,
+	}
+
+	switch m.Status {
+	case StatusActive, StatusVerifyOnly, StatusRetired:
+	case "":
+		return e, fmt.Errorf("keyring entry %s has no status", m.File)
+	default:
+		return e, fmt.Errorf("keyring entry %s has unknown status %q", m.File, m.Status)
+	}
+
+	if m.File == "" || filepath.IsAbs(m.File) || m.File != filepath.Base(m.File) {
+		return e, fmt.Errorf("keyring entry %q must name a file inside the keyring directory", m.File)
+	}
+	path := filepath.Join(dir, m.File)
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return e, err
+	}
+	if len(data) > MaxKeySize {
+		return e, fmt.Errorf("%s: %w", path, ErrKeyTooLarge)
+	}
+
+	if pub, err := ParsePublicKeyPEM(data); err == nil {
+		e.Public = pub
+	} else {
+		// Anything which is not a public key has to be a private key, which must not be
+		// readable by anybody else.
+		key, err := ReadEncryptedPrivateKeyFile(path, passphrase)
+		if err != nil {
+			return e, fmt.Errorf("%s: %w", path, err)
+		}
+		e.private = key
+		e.Public = key.Public()
+	}
+
+	if m.Status == StatusActive && e.private == nil {
+		return e, fmt.Errorf("keyring entry %s is active but only has a public key", m.File)
+	}
+	e.ID = e.Public.KeyID()
+	return e, nil
+}
@@ -0,0 +1,170 @@
+package signing_test
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/brnsampson/echopilot/pkg/signing"
+)
+
+type testKeyringEntry struct {
+	File      string     `json:"file"`
+	Status    string     `json:"status"`
+	NotBefore *time.Time `json:"notBefore,omitempty"`
+	NotAfter  *time.Time `json:"notAfter,omitempty"`
+}
+
+func writeKeyring(t *testing.T, dir string, entries ...testKeyringEntry) {
+	data, err := json.Marshal(map[string]any{"keys": entries})
+	ok(t, err)
+	ok(t, os.WriteFile(filepath.Join(dir, signing.KeyringManifest), data, 0644))
+}
+
+// writeKeyPair writes key as <name>.pem and its public key as <name>.pub.pem.
+func writeKeyPair(t *testing.T, dir, name string, key *signing.PrivateKey) {
+	ok(t, signing.WriteKeyFiles(key, filepath.Join(dir, name+".pem"), filepath.Join(dir, name+".pub.pem"), true))
+}
+
+func at(d time.Duration) *time.Time {
+	when := time.Now().Add(d).UTC().Truncate(time.Second)
+	return &when
+}
+
+func TestKeyringActiveKey(t *testing.T) {
+	dir := t.TempDir()
+	old, current, next := testKey(t, signing.ECDSAP256), testKey(t, signing.Ed25519), testKey(t, signing.ECDSAP384)
+	writeKeyPair(t, dir, "old", old)
+	writeKeyPair(t, dir, "current", current)
+	writeKeyPair(t, dir, "next", next)
+	writeKeyring(t, dir,
+		testKeyringEntry{File: "old.pem", Status: "active", NotBefore: at(-48 * time.Hour), NotAfter: at(-time.Hour)},
+		testKeyringEntry{File: "current.pem", Status: "active", NotBefore: at(-24 * time.Hour)},
+		testKeyringEntry{File: "next.pem", Status: "active", NotBefore: at(24 * time.Hour)},
+	)
+
+	keyring, err := signing.LoadKeyring(dir, nil)
+	ok(t, err)
+	equals(t, 3, len(keyring.Entries()))
+
+	active, err := keyring.Active()
+	ok(t, err)
+	equals(t, current.Public().KeyID(), active.ID)
+
+	msg := []byte("rotate me")
+	id, sig, err := keyring.Sign(msg, signing.Raw)
+	ok(t, err)
+	equals(t, active.ID, id)
+	ok(t, keyring.Verify(id, msg, sig, signing.Raw))
+	ok(t, keyring.Verify("", msg, sig, signing.Raw))
+
+	// Keys outside their signing window can still verify.
+	oldSig, err := old.Sign(msg, signing.ASN1)
+	ok(t, err)
+	ok(t, keyring.Verify(old.Public().KeyID(), msg, oldSig, signing.ASN1))
+
+	err = keyring.Verify(old.Public().KeyID(), msg, sig, signing.Raw)
+	assert(t, errors.Is(err, signing.ErrInvalidSignature), "expected ErrInvalidSignature, got %v", err)
+}
+
+func TestKeyringStatuses(t *testing.T) {
+	dir := t.TempDir()
+	signer, partner, retired := testKey(t, signing.ECDSAP256), testKey(t, signing.Ed25519), testKey(t, signing.RSA)
+	writeKeyPair(t, dir, "signer", signer)
+	writeKeyPair(t, dir, "partner", partner)
+	writeKeyPair(t, dir, "retired", retired)
+	writeKeyring(t, dir,
+		testKeyringEntry{File: "signer.pem", Status: "verify-only"},
+		testKeyringEntry{File: "partner.pub.pem", Status: "verify-only"},
+		testKeyringEntry{File: "retired.pem", Status: "retired"},
+	)
+
+	keyring, err := signing.LoadKeyring(dir, nil)
+	ok(t, err)
+
+	_, err = keyring.Active()
+	assert(t, errors.Is(err, signing.ErrNoActiveKey), "expected ErrNoActiveKey, got %v", err)
+	_, _, err = keyring.Sign([]byte("msg"), signing.ASN1)
+	assert(t, errors.Is(err, signing.ErrNoActiveKey), "expected ErrNoActiveKey, got %v", err)
+
+	msg := []byte("from a partner")
+	sig, err := partner.Sign(msg, signing.ASN1)
+	ok(t, err)
+	ok(t, keyring.Verify(partner.Public().KeyID(), msg, sig, signing.ASN1))
+
+	sig, err = retired.Sign(msg, signing.ASN1)
+	ok(t, err)
+	err = keyring.Verify(retired.Public().KeyID(), msg, sig, signing.ASN1)
+	assert(t, errors.Is(err, signing.ErrRetiredKey), "expected ErrRetiredKey, got %v", err)
+	err = keyring.Verify("", msg, sig, signing.ASN1)
+	assert(t, errors.Is(err, signing.ErrInvalidSignature), "expected ErrInvalidSignature, got %v", err)
+
+	_, err = keyring.Lookup("0123456789abcdef")
+	assert(t, errors.Is(err, signing.ErrUnknownKey), "expected ErrUnknownKey, got %v", err)
+}
+
+func TestKeyringReloadKeepsKeysOnError(t *testing.T) {
+	dir := t.TempDir()
+	first, second := testKey(t, signing.ECDSAP256), testKey(t, signing.Ed25519)
+	writeKeyPair(t, dir, "first", first)
+	writeKeyPair(t, dir, "second", second)
+	writeKeyring(t, dir, testKeyringEntry{File: "first.pem", Status: "active"})
+
+	keyring, err := signing.LoadKeyring(dir, nil)
+	ok(t, err)
+	equals(t, dir, keyring.Dir())
+
+	// A broken manifest leaves the previous keys in place.
+	ok(t, os.WriteFile(filepath.Join(dir, signing.KeyringManifest), []byte("{"), 0644))
+	assert(t, keyring.Reload() != nil, "expected reloading a broken manifest to fail")
+	active, err := keyring.Active()
+	ok(t, err)
+	equals(t, first.Public().KeyID(), active.ID)
+
+	writeKeyring(t, dir,
+		testKeyringEntry{File: "first.pem", Status: "verify-only"},
+		testKeyringEntry{File: "second.pem", Status: "active"},
+	)
+	ok(t, keyring.Reload())
+	active, err = keyring.Active()
+	ok(t, err)
+	equals(t, second.Public().KeyID(), active.ID)
+
+	keyring.Clear()
+	equals(t, 0, len(keyring.Entries()))
+}
+
+func TestKeyringInvalid(t *testing.T) {
+	dir := t.TempDir()
+	writeKeyPair(t, dir, "key", testKey(t, signing.ECDSAP256))
+	ok(t, os.WriteFile(filepath.Join(dir, "loose.pem"), mustReadFile(t, filepath.Join(dir, "key.pem")), 0644))
+
+	cases := map[string][]testKeyringEntry{
+		"unknown status":  {{File: "key.pem", Status: "sometimes"}},
+		"missing status":  {{File: "key.pem"}},
+		"outside dir":     {{File: "../key.pem", Status: "active"}},
+		"missing file":    {{File: "nope.pem", Status: "active"}},
+		"public active":   {{File: "key.pub.pem", Status: "active"}},
+		"duplicate key":   {{File: "key.pem", Status: "active"}, {File: "key.pub.pem", Status: "verify-only"}},
+		"readable secret": {{File: "loose.pem", Status: "active"}},
+	}
+	for name, entries := range cases {
+		writeKeyring(t, dir, entries...)
+		_, err := signing.LoadKeyring(dir, nil)
+		assert(t, err != nil, "%s: expected an error", name)
+	}
+
+	ok(t, os.WriteFile(filepath.Join(dir, signing.KeyringManifest), []byte(`{"keys": [], "extra": true}`), 0644))
+	_, err := signing.LoadKeyring(dir, nil)
+	assert(t, err != nil && strings.Contains(err.Error(), "extra"), "expected unknown fields to be rejected, got %v", err)
+}
+
+func mustReadFile(t *testing.T, file string) []byte {
+	data, err := os.ReadFile(file)
+	ok(t, err)
+	return data
+}
@@ -0,0 +1,371 @@
+package signing
+
+// Keys of any supported algorithm.
+//
+// PrivateKey and PublicKey wrap the standard library key types so that callers can sign,
+// verify and encode without caring which algorithm is behind them. Keys are always
+// written as PKCS#8 (private) and PKIX (public) PEM. For reading, the legacy SEC1
+// ("EC PRIVATE KEY") and PKCS#1 ("RSA PRIVATE KEY") forms are accepted too, as are SEC1
+// keys mislabeled "PRIVATE KEY" by earlier versions of this package.
+//
+// Passphrase encrypted keys are handled in encrypted.go. ParsePrivateKeyPEM rejects them
+// with ErrEncryptedKey.
+//
+// ECDSA signatures honour the requested Format. RSA (PKCS#1 v1.5 with SHA-256) and
+// Ed25519 signatures have a single encoding, so Format is ignored for them.
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+type Algorithm int
+
+const (
+	ECDSAP256 Algorithm = iota + 1
+	ECDSAP384
+	ECDSAP521
+	Ed25519
+	RSA
+)
+
+// Keys larger than this are refused rather than read into memory. A 16k bit RSA key is
+// still well under the limit.
+const MaxKeySize = 64 << 10
+
+// Size of newly generated RSA keys.
+const RSAKeyBits = 3072
+
+var (
+	ErrNoPEMBlock           = errors.New("no PEM block found")
+	ErrKeyTooLarge          = fmt.Errorf("key is larger than %d bytes", MaxKeySize)
+	ErrUnsupportedAlgorithm = errors.New("unsupported key algorithm")
+	ErrEncryptedKey         = errors.New("key is encrypted")
+)
+
+// MalformedKeyError is returned when a PEM block was found but its contents could not be
+// parsed as the key type its header claims.
+type MalformedKeyError struct {
+	BlockType string
+	Err       error
+}
+
+func (e *MalformedKeyError) Error() string {
+	return fmt.Sprintf("malformed %q PEM block: %v", e.BlockType, e.Err)
+}
+
+func (e *MalformedKeyError) Unwrap() error {
+	return e.Err
+}
+
+func (a Algorithm) String() string {
+	switch a {
+	case ECDSAP256:
+		return "ecdsa-p256"
+	case ECDSAP384:
+		return "ecdsa-p384"
+	case ECDSAP521:
+		return "ecdsa-p521"
+	case Ed25519:
+		return "ed25519"
+	case RSA:
+		return "rsa"
+	}
+	return fmt.Sprintf("Algorithm(%d)", int(a))
+}
+
+func ParseAlgorithm(s string) (Algorithm, error) {
+	switch strings.ToLower(s) {
+	case "ecdsa-p256", "p256", "p-256":
+		return ECDSAP256, nil
+	case "ecdsa-p384", "p384", "p-384", "ecdsa":
+		return ECDSAP384, nil
+	case "ecdsa-p521", "p521", "p-521":
+		return ECDSAP521, nil
+	case "ed25519", "eddsa":
+		return Ed25519, nil
+	case "rsa":
+		return RSA, nil
+	}
+	return 0, fmt.Errorf("%w: %q, expected one of ecdsa-p256, ecdsa-p384, ecdsa-p521, ed25519 or rsa", ErrUnsupportedAlgorithm, s)
+}
+
+type PrivateKey struct {
+	signer crypto.Signer
+	alg    Algorithm
+}
+
+type PublicKey struct {
+	key crypto.PublicKey
+	alg Algorithm
+}
+
+func GenerateKey(alg Algorithm) (*PrivateKey, error) {
+	var signer crypto.Signer
+	var err error
+	switch alg {
+	case ECDSAP256:
+		signer, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case ECDSAP384:
+		signer, err = ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	case ECDSAP521:
+		signer, err = ecdsa.GenerateKey(elliptic.P521(), rand.Reader)
+	case Ed25519:
+		_, signer, err = ed25519.GenerateKey(rand.Reader)
+	case RSA:
+		signer, err = rsa.GenerateKey(rand.Reader, RSAKeyBits)
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedAlgorithm, alg)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &PrivateKey{signer, alg}, nil
+}
+
+// NewPrivateKey wraps a standard library private key.
+func NewPrivateKey(key crypto.PrivateKey) (*PrivateKey, error) {
+	signer, found := key.(crypto.Signer)
+	if !found {
+		return nil, fmt.Errorf("%w: %T", ErrUnsupportedAlgorithm, key)
+	}
+	alg, err := algorithmOf(signer.Public())
+	if err != nil {
+		return nil, err
+	}
+	return &PrivateKey{signer, alg}, nil
+}
+
+// NewPublicKey wraps a standard library public key.
+func NewPublicKey(key crypto.PublicKey) (*PublicKey, error) {
+	alg, err := algorithmOf(key)
+	if err != nil {
+		return nil, err
+	}
+	return &PublicKey{key, alg}, nil
+}
+
+func algorithmOf(pub crypto.PublicKey) (Algorithm, error) {
+	switch k := pub.(type) {
+	case *ecdsa.PublicKey:
+		switch k.Curve {
+		case elliptic.P256():
+			return ECDSAP256, nil
+		case elliptic.P384():
+			return ECDSAP384, nil
+		case elliptic.P521():
+			return ECDSAP521, nil
+		}
+		return 0, fmt.Errorf("%w: curve %s", ErrUnsupportedAlgorithm, k.Curve.Params().Name)
+	case ed25519.PublicKey:
+		return Ed25519, nil
+	case *rsa.PublicKey:
+		return RSA, nil
+	}
+	return 0, fmt.Errorf("%w: %T", ErrUnsupportedAlgorithm, pub)
+}
+
+func (k *PrivateKey) Algorithm() Algorithm {
+	return k.alg
+}
+
+// Signer returns the underlying key, e.g. for use with crypto/tls or crypto/x509.
+func (k *PrivateKey) Signer() crypto.Signer {
+	return k.signer
+}
+
+func (k *PrivateKey) Public() *PublicKey {
+	return &PublicKey{k.signer.Public(), k.alg}
+}
+
+func (p *PublicKey) Algorithm() Algorithm {
+	return p.alg
+}
+
+// Key returns the underlying standard library public key.
+func (p *PublicKey) Key() crypto.PublicKey {
+	return p.key
+}
+
+func (p *PublicKey) Equal(other *PublicKey) bool {
+	eq, found := p.key.(interface{ Equal(crypto.PublicKey) bool })
+	return found && other != nil && eq.Equal(other.key)
+}
+
+// Sign returns a detached signature over msg.
+func (k *PrivateKey) Sign(msg []byte, format Format) ([]byte, error) {
+	return k.SignReader(bytes.NewReader(msg), format)
+}
+
+// SignReader signs everything read from r. Ed25519 signs the message itself rather than
+// a digest, so for Ed25519 keys the whole input is held in memory.
+func (k *PrivateKey) SignReader(r io.Reader, format Format) ([]byte, error) {
+	switch key := k.signer.(type) {
+	case *ecdsa.PrivateKey:
+		return SignReader(key, r, format)
+	case ed25519.PrivateKey:
+		msg, err := io.ReadAll(r)
+		if err != nil {
+			return nil, err
+		}
+		return ed25519.Sign(key, msg), nil
+	case *rsa.PrivateKey:
+		h := sha256.New()
+		if _, err := io.Copy(h, r); err != nil {
+			return nil, err
+		}
+		return rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, h.Sum(nil))
+	}
+	return nil, fmt.Errorf("%w: %T", ErrUnsupportedAlgorithm, k.signer)
+}
+
+// Verify checks a detached signature over msg. It returns ErrInvalidSignature if the
+// signature does not match.
+func (p *PublicKey) Verify(msg, sig []byte, format Format) error {
+	return p.VerifyReader(bytes.NewReader(msg), sig, format)
+}
+
+func (p *PublicKey) VerifyReader(r io.Reader, sig []byte, format Format) error {
+	switch key := p.key.(type) {
+	case *ecdsa.PublicKey:
+		return VerifyReader(key, r, sig, format)
+	case ed25519.PublicKey:
+		msg, err := io.ReadAll(r)
+		if err != nil {
+			return err
+		}
+		if !ed25519.Verify(key, msg, sig) {
+			return ErrInvalidSignature
+		}
+		return nil
+	case *rsa.PublicKey:
+		h := sha256.New()
+		if _, err := io.Copy(h, r); err != nil {
+			return err
+		}
+		if err := rsa.VerifyPKCS1v15(key, crypto.SHA256, h.Sum(nil), sig); err != nil {
+			return ErrInvalidSignature
+		}
+		return nil
+	}
+	return fmt.Errorf("%w: %T", ErrUnsupportedAlgorithm, p.key)
+}
+
+// MarshalPEM encodes the key as a PKCS#8 "PRIVATE KEY" PEM block.
+func (k *PrivateKey) MarshalPEM() ([]byte, error) {
+	der, err := x509.MarshalPKCS8PrivateKey(k.signer)
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), nil
+}
+
+// MarshalPEM encodes the key as a PKIX "PUBLIC KEY" PEM block.
+func (p *PublicKey) MarshalPEM() ([]byte, error) {
+	der, err := x509.MarshalPKIXPublicKey(p.key)
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), nil
+}
+
+// ParsePrivateKeyPEM decodes the first PEM block in data.
+func ParsePrivateKeyPEM(data []byte) (*PrivateKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, ErrNoPEMBlock
+	}
+
+	if block.Headers["Proc-Type"] != "" {
+		// Legacy OpenSSL encryption (RFC 1423) is insecure and not supported.
+		return nil, fmt.Errorf("%w with legacy PEM encryption, convert it with 'openssl pkcs8 -topk8 -scrypt'", ErrEncryptedKey)
+	}
+
+	var key crypto.PrivateKey
+	var err error
+	switch block.Type {
+	case "PRIVATE KEY":
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			// Earlier versions of this package wrote SEC1 keys under this header.
+			if ecKey, ecErr := x509.ParseECPrivateKey(block.Bytes); ecErr == nil {
+				key, err = ecKey, nil
+			}
+		}
+	case "EC PRIVATE KEY":
+		key, err = x509.ParseECPrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "ENCRYPTED PRIVATE KEY":
+		return nil, ErrEncryptedKey
+	default:
+		return nil, &MalformedKeyError{block.Type, errors.New("not a private key")}
+	}
+	if err != nil {
+		return nil, &MalformedKeyError{block.Type, err}
+	}
+	return NewPrivateKey(key)
+}
+
+// ParsePublicKeyPEM decodes the first PEM block in data.
+func ParsePublicKeyPEM(data []byte) (*PublicKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, ErrNoPEMBlock
+	}
+
+	var key crypto.PublicKey
+	var err error
+	switch block.Type {
+	case "PUBLIC KEY":
+		key, err = x509.ParsePKIXPublicKey(block.Bytes)
+	case "RSA PUBLIC KEY":
+		key, err = x509.ParsePKCS1PublicKey(block.Bytes)
+	default:
+		return nil, &MalformedKeyError{block.Type, errors.New("not a public key")}
+	}
+	if err != nil {
+		return nil, &MalformedKeyError{block.Type, err}
+	}
+	return NewPublicKey(key)
+}
+
+func ReadPrivateKey(r io.Reader) (*PrivateKey, error) {
+	data, err := readKey(r)
+	if err != nil {
+		return nil, err
+	}
+	return ParsePrivateKeyPEM(data)
+}
+
+func ReadPublicKey(r io.Reader) (*PublicKey, error) {
+	data, err := readKey(r)
+	if err != nil {
+		return nil, err
+	}
+	return ParsePublicKeyPEM(data)
+}
+
+// readKey reads at most MaxKeySize bytes and fails if there is more.
+func readKey(r io.Reader) ([]byte, error) {
+	data, err := io.ReadAll(io.LimitReader(r, MaxKeySize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > MaxKeySize {
+		return nil, ErrKeyTooLarge
+	}
+	return data, nil
+}
@@ -0,0 +1,214 @@
+package signing
+
+// Detached signatures over messages.
+//
+// The digest is picked to match the curve (SHA-256 for P-256, SHA-384 for P-384 and
+// SHA-512 for P-521) so a signature can be verified by anybody with the public key and
+// no other agreement. Signatures come in two formats:
+//
+//	ASN1  DER encoded (r, s) as produced by openssl and most libraries
+//	Raw   r || s, each left padded to the curve size, as used by JOSE/JWS and WebCrypto
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/asn1"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"math/big"
+	"strings"
+)
+
+type Format int
+
+const (
+	ASN1 Format = iota
+	Raw
+)
+
+var ErrInvalidSignature = errors.New("invalid signature")
+
+func (f Format) String() string {
+	switch f {
+	case ASN1:
+		return "asn1"
+	case Raw:
+		return "raw"
+	}
+	return fmt.Sprintf("Format(%d)", int(f))
+}
+
+func ParseFormat(s string) (Format, error) {
+	switch strings.ToLower(s) {
+	case "asn1", "der":
+		return ASN1, nil
+	case "raw", "p1363", "jose":
+		return Raw, nil
+	}
+	return ASN1, fmt.Errorf("unknown signature format %q, expected asn1 or raw", s)
+}
+
+// HashFor returns the digest used with keys on the given curve.
+func HashFor(curve elliptic.Curve) (crypto.Hash, error) {
+	switch curve {
+	case elliptic.P256():
+		return crypto.SHA256, nil
+	case elliptic.P384():
+		return crypto.SHA384, nil
+	case elliptic.P521():
+		return crypto.SHA512, nil
+	}
+	return 0, fmt.Errorf("unsupported curve %s", curve.Params().Name)
+}
+
+// Sign returns a detached signature over msg.
+func Sign(key *ecdsa.PrivateKey, msg []byte, format Format) ([]byte, error) {
+	h, err := newHash(&key.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+	h.Write(msg)
+	return signDigest(key, h.Sum(nil), format)
+}
+
+// SignReader signs everything read from r without holding it in memory.
+func SignReader(key *ecdsa.PrivateKey, r io.Reader, format Format) ([]byte, error) {
+	h, err := newHash(&key.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := io.Copy(h, r); err != nil {
+		return nil, err
+	}
+	return signDigest(key, h.Sum(nil), format)
+}
+
+// Verify checks a detached signature over msg. It returns ErrInvalidSignature if the
+// signature does not match.
+func Verify(pub *ecdsa.PublicKey, msg, sig []byte, format Format) error {
+	h, err := newHash(pub)
+	if err != nil {
+		return err
+	}
+	h.Write(msg)
+	return verifyDigest(pub, h.Sum(nil), sig, format)
+}
+
+// VerifyReader checks a detached signature over everything read from r.
+func VerifyReader(pub *ecdsa.PublicKey, r io.Reader, sig []byte, format Format) error {
+	h, err := newHash(pub)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(h, r); err != nil {
+		return err
+	}
+	return verifyDigest(pub, h.Sum(nil), sig, format)
+}
+
+// EncodeSignature encodes a signature as standard, padded base64.
+func EncodeSignature(sig []byte) string {
+	return base64.StdEncoding.EncodeToString(sig)
+}
+
+// DecodeSignature accepts standard or URL safe base64 with or without padding, so that
+// signatures copied out of JWTs, headers and terminals all work.
+func DecodeSignature(s string) ([]byte, error) {
+	s = strings.TrimSpace(s)
+	s = strings.TrimRight(s, "=")
+	if strings.ContainsAny(s, "-_") {
+		return base64.RawURLEncoding.DecodeString(s)
+	}
+	return base64.RawStdEncoding.DecodeString(s)
+}
+
+func newHash(pub *ecdsa.PublicKey) (hash.Hash, error) {
+	h, err := HashFor(pub.Curve)
+	if err != nil {
+		return nil, err
+	}
+	return h.New(), nil
+}
+
+func signDigest(key *ecdsa.PrivateKey, digest []byte, format Format) ([]byte, error) {
+	switch format {
+	case ASN1:
+		return ecdsa.SignASN1(rand.Reader, key, digest)
+	case Raw:
+		r, s, err := ecdsa.Sign(rand.Reader, key, digest)
+		if err != nil {
+			return nil, err
+		}
+		size := curveBytes(key.Curve)
+		sig := make([]byte, 2*size)
+		r.FillBytes(sig[:size])
+		s.FillBytes(sig[size:])
+		return sig, nil
+	}
+	return nil, fmt.Errorf("unknown signature format %s", format)
+}
+
+func verifyDigest(pub *ecdsa.PublicKey, digest, sig []byte, format Format) error {
+	valid := false
+	switch format {
+	case ASN1:
+		valid = ecdsa.VerifyASN1(pub, digest, sig)
+	case Raw:
+		size := curveBytes(pub.Curve)
+		if len(sig) != 2*size {
+			return ErrInvalidSignature
+		}
+		r := new(big.Int).SetBytes(sig[:size])
+		s := new(big.Int).SetBytes(sig[size:])
+		valid = ecdsa.Verify(pub, digest, r, s)
+	default:
+		return fmt.Errorf("unknown signature format %s", format)
+	}
+
+	if !valid {
+		return ErrInvalidSignature
+	}
+	return nil
+}
+
+// ConvertSignature re-encodes a signature made with a key on curve into another format.
+func ConvertSignature(curve elliptic.Curve, sig []byte, from, to Format) ([]byte, error) {
+	if from == to {
+		return sig, nil
+	}
+
+	size := curveBytes(curve)
+	switch {
+	case from == ASN1 && to == Raw:
+		var parsed struct{ R, S *big.Int }
+		rest, err := asn1.Unmarshal(sig, &parsed)
+		if err != nil || len(rest) > 0 {
+			return nil, ErrInvalidSignature
+		}
+		if parsed.R.BitLen() > size*8 || parsed.S.BitLen() > size*8 {
+			return nil, ErrInvalidSignature
+		}
+		raw := make([]byte, 2*size)
+		parsed.R.FillBytes(raw[:size])
+		parsed.S.FillBytes(raw[size:])
+		return raw, nil
+	case from == Raw && to == ASN1:
+		if len(sig) != 2*size {
+			return nil, ErrInvalidSignature
+		}
+		return asn1.Marshal(struct{ R, S *big.Int }{
+			new(big.Int).SetBytes(sig[:size]),
+			new(big.Int).SetBytes(sig[size:]),
+		})
+	}
+	return nil, fmt.Errorf("cannot convert signature from %s to %s", from, to)
+}
+
+func curveBytes(curve elliptic.Curve) int {
+	return (curve.Params().BitSize + 7) / 8
+}
@@ -0,0 +1,98 @@
+package signing_test
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/brnsampson/echopilot/pkg/signing"
+)
+
+func TestSignVerify(t *testing.T) {
+	msg := []byte("This is a seeeeecret")
+	for _, curve := range []elliptic.Curve{elliptic.P256(), elliptic.P384(), elliptic.P521()} {
+		key, err := ecdsa.GenerateKey(curve, rand.Reader)
+		ok(t, err)
+
+		for _, format := range []signing.Format{signing.ASN1, signing.Raw} {
+			sig, err := signing.Sign(key, msg, format)
+			ok(t, err)
+			ok(t, signing.Verify(&key.PublicKey, msg, sig, format))
+
+			// Streaming input must produce interchangeable signatures.
+			ok(t, signing.VerifyReader(&key.PublicKey, bytes.NewReader(msg), sig, format))
+			streamed, err := signing.SignReader(key, bytes.NewReader(msg), format)
+			ok(t, err)
+			ok(t, signing.Verify(&key.PublicKey, msg, streamed, format))
+
+			err = signing.Verify(&key.PublicKey, []byte("This is not the secret"), sig, format)
+			assert(t, errors.Is(err, signing.ErrInvalidSignature), "expected an invalid signature, got %v", err)
+		}
+	}
+}
+
+func TestRawSignatureSize(t *testing.T) {
+	key, _, err := signing.GenerateECDSA()
+	ok(t, err)
+
+	sig, err := signing.Sign(key, []byte("msg"), signing.Raw)
+	ok(t, err)
+	equals(t, 96, len(sig))
+
+	err = signing.Verify(&key.PublicKey, []byte("msg"), sig[:95], signing.Raw)
+	assert(t, errors.Is(err, signing.ErrInvalidSignature), "expected a truncated signature to be invalid, got %v", err)
+}
+
+func TestConvertSignature(t *testing.T) {
+	key, _, err := signing.GenerateECDSA()
+	ok(t, err)
+	msg := []byte("msg")
+
+	der, err := signing.Sign(key, msg, signing.ASN1)
+	ok(t, err)
+	raw, err := signing.ConvertSignature(key.Curve, der, signing.ASN1, signing.Raw)
+	ok(t, err)
+	ok(t, signing.Verify(&key.PublicKey, msg, raw, signing.Raw))
+
+	back, err := signing.ConvertSignature(key.Curve, raw, signing.Raw, signing.ASN1)
+	ok(t, err)
+	ok(t, signing.Verify(&key.PublicKey, msg, back, signing.ASN1))
+}
+
+func TestHashFor(t *testing.T) {
+	h, err := signing.HashFor(elliptic.P384())
+	ok(t, err)
+	equals(t, crypto.SHA384, h)
+}
+
+func TestDecodeSignature(t *testing.T) {
+	sig := []byte{0xfb, 0xff, 0xfe, 0x01}
+	encoded := signing.EncodeSignature(sig)
+	equals(t, "+//+AQ==", encoded)
+
+	for _, s := range []string{encoded, "+//+AQ", "-__-AQ", "-__-AQ==", " +//+AQ==\n"} {
+		decoded, err := signing.DecodeSignature(s)
+		ok(t, err)
+		equals(t, sig, decoded)
+	}
+
+	_, err := signing.DecodeSignature("not base64!")
+	assert(t, err != nil, "expected a decoding error")
+}
+
+func TestParseFormat(t *testing.T) {
+	for name, exp := range map[string]signing.Format{"asn1": signing.ASN1, "DER": signing.ASN1, "raw": signing.Raw, "jose": signing.Raw} {
+		format, err := signing.ParseFormat(name)
+		ok(t, err)
+		equals(t, exp, format)
+		assert(t, format.String() == strings.ToLower(format.String()), "format names should be lower case")
+	}
+
+	_, err := signing.ParseFormat("pgp")
+	assert(t, err != nil, "expected an unknown format error")
+}
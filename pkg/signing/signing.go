@@ -19,17 +19,16 @@ const (
 )
 
 func encode(privateKey *ecdsa.PrivateKey, publicKey *ecdsa.PublicKey) ([]byte, []byte, error) {
-	x509Encoded, err := x509.MarshalECPrivateKey(privateKey)
+	pkcs8Encoded, err := x509.MarshalPKCS8PrivateKey(privateKey)
 	if err != nil {
 		return nil, nil, err
 	}
-	pemEncoded := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: x509Encoded})
+	pemEncoded := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: pkcs8Encoded})
 
-	x509EncodedPub, err := x509.MarshalPKIXPublicKey(publicKey)
+	pemEncodedPub, err := encodePub(publicKey)
 	if err != nil {
 		return nil, nil, err
 	}
-	pemEncodedPub := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: x509EncodedPub})
 
 	return pemEncoded, pemEncodedPub, nil
 }
@@ -45,39 +44,45 @@ func encodePub(publicKey *ecdsa.PublicKey) ([]byte, error) {
 }
 
 func decode(pemEncoded []byte, pemEncodedPub []byte) (*ecdsa.PrivateKey, *ecdsa.PublicKey, error) {
-	// BUG WATCH
-	// This only processes the first PEM formatted block, so if this were a cert chain or something
-	// we could not throw away the second return value (the remaining input)
-	block, _ := pem.Decode(pemEncoded)
-	x509Encoded := block.Bytes
-	privateKey, err := x509.ParseECPrivateKey(x509Encoded)
+	privateKey, err := decodeKey(pemEncoded)
 	if err != nil {
 		return nil, nil, err
 	}
 
-	// BUG WATCH - See above
-	blockPub, _ := pem.Decode(pemEncodedPub)
-
-	x509EncodedPub := blockPub.Bytes
-	genericPublicKey, _ := x509.ParsePKIXPublicKey(x509EncodedPub)
-	publicKey := genericPublicKey.(*ecdsa.PublicKey)
+	publicKey, err := decodePub(pemEncodedPub)
+	if err != nil {
+		return nil, nil, err
+	}
 
 	return privateKey, publicKey, nil
 }
 
 func decodePub(pemEncodedPub []byte) (*ecdsa.PublicKey, error) {
-	// BUG WATCH
-	// This only processes the first PEM formatted block, so if this were a cert chain or something
-	// we could not throw away the second return value (the remaining input)
-	blockPub, _ := pem.Decode(pemEncodedPub)
-
-	x509EncodedPub := blockPub.Bytes
-	genericPublicKey, _ := x509.ParsePKIXPublicKey(x509EncodedPub)
-	publicKey := genericPublicKey.(*ecdsa.PublicKey)
+	pub, err := ParsePublicKeyPEM(pemEncodedPub)
+	if err != nil {
+		return nil, err
+	}
 
+	publicKey, found := pub.Key().(*ecdsa.PublicKey)
+	if !found {
+		return nil, fmt.Errorf("%w: expected an ECDSA key, got %s", ErrUnsupportedAlgorithm, pub.Algorithm())
+	}
 	return publicKey, nil
 }
 
+func decodeKey(pemEncoded []byte) (*ecdsa.PrivateKey, error) {
+	key, err := ParsePrivateKeyPEM(pemEncoded)
+	if err != nil {
+		return nil, err
+	}
+
+	privateKey, found := key.Signer().(*ecdsa.PrivateKey)
+	if !found {
+		return nil, fmt.Errorf("%w: expected an ECDSA key, got %s", ErrUnsupportedAlgorithm, key.Algorithm())
+	}
+	return privateKey, nil
+}
+
 func GenerateECDSA() (*ecdsa.PrivateKey, *ecdsa.PublicKey, error) {
 	key, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
 	if err != nil {
@@ -165,9 +170,10 @@ func SetKeyFilePerms(keyFile, pubFile string) error {
 	return nil
 }
 
-func ReadECDSAFiles(keyFile, pubFile string) (*ecdsa.PrivateKey, *ecdsa.PublicKey, error) {
-	// BUG WATCH - this function is untested despite being part of the public interface. This is
-	// because it only interacts with tested interfaces, external resources, and error propogation.
+// ReadECDSAFiles reads a key pair written by WriteECDSAFiles. The private key may be
+// encrypted, in which case it is decrypted with passphrase. A nil passphrase only reads
+// plaintext keys.
+func ReadECDSAFiles(keyFile, pubFile string, passphrase Passphrase) (*ecdsa.PrivateKey, *ecdsa.PublicKey, error) {
 	keyValid, pubValid, err := KeyFilePermsValid(keyFile, pubFile)
 	if err != nil {
 		return nil, nil, fmt.Errorf("Error while checking key/pubkey permissions: %w", err)
@@ -178,19 +184,23 @@ func ReadECDSAFiles(keyFile, pubFile string) (*ecdsa.PrivateKey, *ecdsa.PublicKe
 		return nil, nil, fmt.Errorf("File permisisons for signing keys unacceptable. %s should be 0600 and %s should be 0644.", keyFile, pubFile)
 	}
 
-	keyReader, err := os.Open(keyFile)
+	key, err := ReadEncryptedPrivateKeyFile(keyFile, passphrase)
 	if err != nil {
 		return nil, nil, err
 	}
-	defer keyReader.Close()
+	privateKey, found := key.Signer().(*ecdsa.PrivateKey)
+	if !found {
+		return nil, nil, fmt.Errorf("%w: expected an ECDSA key, got %s", ErrUnsupportedAlgorithm, key.Algorithm())
+	}
 
-	pubReader, err := os.Open(pubFile)
+	publicKey, err := ReadPubECDSAFile(pubFile)
 	if err != nil {
 		return nil, nil, err
 	}
-	defer pubReader.Close()
-
-	return ReadECDSA(keyReader, pubReader)
+	if !privateKey.PublicKey.Equal(publicKey) {
+		return nil, nil, fmt.Errorf("%s does not belong to %s", pubFile, keyFile)
+	}
+	return privateKey, publicKey, nil
 }
 
 func ReadPubECDSAFile(pubFile string) (*ecdsa.PublicKey, error) {
@@ -215,68 +225,110 @@ func ReadPubECDSAFile(pubFile string) (*ecdsa.PublicKey, error) {
 	return ReadPubECDSA(pubReader)
 }
 
-func ReadECDSA(keyReader, pubReader io.Reader) (*ecdsa.PrivateKey, *ecdsa.PublicKey, error) {
-	// BUG WATCH a key should be small so it should be fine to just use ReadAll,
-	// but what if someone passed us a massive file instead?
-	keyEncoded, err := io.ReadAll(keyReader)
+// ReadECDSAKeyFile reads just a private key. The public key is always available from
+// the private key, so signing does not need the public key file.
+func ReadECDSAKeyFile(keyFile string) (*ecdsa.PrivateKey, error) {
+	keyReader, err := openKeyFile(keyFile)
 	if err != nil {
-		return nil, nil, err
+		return nil, err
 	}
+	defer keyReader.Close()
 
-	pubEncoded, err := io.ReadAll(pubReader)
+	return ReadECDSAKey(keyReader)
+}
+
+// ReadPrivateKeyFile reads a private key of any supported algorithm.
+func ReadPrivateKeyFile(keyFile string) (*PrivateKey, error) {
+	keyReader, err := openKeyFile(keyFile)
 	if err != nil {
-		return nil, nil, err
+		return nil, err
 	}
+	defer keyReader.Close()
 
-	return decode(keyEncoded, pubEncoded)
+	return ReadPrivateKey(keyReader)
 }
 
-func ReadPubECDSA(pubReader io.Reader) (*ecdsa.PublicKey, error) {
-	// BUG WATCH a key should be small so it should be fine to just use ReadAll,
-	// but what if someone passed us a massive file instead?
-	pubEncoded, err := io.ReadAll(pubReader)
+// ReadPublicKeyFile reads a public key of any supported algorithm.
+func ReadPublicKeyFile(pubFile string) (*PublicKey, error) {
+	pubValid, err := PubKeyFilePermsValid(pubFile)
+	if err != nil {
+		return nil, fmt.Errorf("Error while checking key/pubkey permissions: %w", err)
+	}
+
+	if pubValid != true {
+		return nil, fmt.Errorf("File permisisons for signing keys unacceptable. %s should be 0644.", pubFile)
+	}
+
+	pubReader, err := os.Open(pubFile)
 	if err != nil {
 		return nil, err
 	}
+	defer pubReader.Close()
 
-	return decodePub(pubEncoded)
+	return ReadPublicKey(pubReader)
 }
 
-func WriteECDSAFiles(key *ecdsa.PrivateKey, keyFile string, pub *ecdsa.PublicKey, pubFile string) error {
-	// BUG WATCH - this function is untested despite being part of the public interface.
-	// This is because this bunction just interacts with external resources and calling other
-	// tested functions.
+func openKeyFile(keyFile string) (*os.File, error) {
+	keyStat, err := os.Stat(keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("Error while checking key permissions: %w", err)
+	}
+
+	if keyStat.Mode()^KeyFilePerms != 0 {
+		return nil, fmt.Errorf("File permisisons for signing keys unacceptable. %s should be 0600.", keyFile)
+	}
 
-	// There is an implicit contract to the user that this function will create the keyfiles
-	// if they do not exist.
+	return os.Open(keyFile)
+}
 
-	// SECURITY - always write keys with restrictive permissions of 0600
-	keyWriter, err := os.Open(keyFile)
+func ReadECDSA(keyReader, pubReader io.Reader) (*ecdsa.PrivateKey, *ecdsa.PublicKey, error) {
+	keyEncoded, err := readKey(keyReader)
 	if err != nil {
-		return err
+		return nil, nil, err
 	}
-	defer keyWriter.Close()
 
-	// SECURITY - public keys should be readable by anyone but only writable by the service user: 0644
-	pubWriter, err := os.Open(pubFile)
+	pubEncoded, err := readKey(pubReader)
 	if err != nil {
-		return err
+		return nil, nil, err
 	}
-	defer pubWriter.Close()
 
-	keyValid, pubValid, err := KeyFilePermsValid(keyFile, pubFile)
+	return decode(keyEncoded, pubEncoded)
+}
+
+func ReadECDSAKey(keyReader io.Reader) (*ecdsa.PrivateKey, error) {
+	keyEncoded, err := readKey(keyReader)
 	if err != nil {
-		return fmt.Errorf("Error while checking key/pubkey permissions: %w", err)
+		return nil, err
 	}
 
-	if keyValid != true || pubValid != true {
-		err := SetKeyFilePerms(keyFile, pubFile)
-		if err != nil {
-			return fmt.Errorf("Error while attempting to set key/pubkey permissions: %w", err)
-		}
+	return decodeKey(keyEncoded)
+}
+
+func ReadPubECDSA(pubReader io.Reader) (*ecdsa.PublicKey, error) {
+	pubEncoded, err := readKey(pubReader)
+	if err != nil {
+		return nil, err
+	}
+
+	return decodePub(pubEncoded)
+}
+
+// WriteECDSAFiles creates or replaces both key files atomically with KeyFilePerms and
+// PubKeyFilePerms. The private key is encrypted under passphrase unless it is empty. See
+// WriteKeyFiles and WriteEncryptedKeyFiles.
+func WriteECDSAFiles(key *ecdsa.PrivateKey, keyFile string, pub *ecdsa.PublicKey, pubFile string, passphrase []byte) error {
+	if !key.PublicKey.Equal(pub) {
+		return fmt.Errorf("public key does not belong to the private key")
 	}
 
-	return WriteECDSA(key, keyWriter, pub, pubWriter)
+	k, err := NewPrivateKey(key)
+	if err != nil {
+		return err
+	}
+	if len(passphrase) == 0 {
+		return WriteKeyFiles(k, keyFile, pubFile, true)
+	}
+	return WriteEncryptedKeyFiles(k, keyFile, pubFile, passphrase, true)
 }
 
 func WriteECDSA(key *ecdsa.PrivateKey, keyWriter io.Writer, pub *ecdsa.PublicKey, pubWriter io.Writer) error {
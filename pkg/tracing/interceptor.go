@@ -0,0 +1,138 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"sync"
+
+	"connectrpc.com/connect"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.21.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Interceptor starts a span for every Connect call, named after its procedure, e.g.
+// echo.v1.EchoService/EchoString. Handled calls get a server span, which is a child of
+// the request span if Middleware runs in front of the handler. Client calls get a client
+// span and pass the trace on to the server.
+func Interceptor() connect.Interceptor {
+	return interceptor{}
+}
+
+type interceptor struct{}
+
+func (interceptor) WrapUnary(next connect.UnaryFunc) connect.UnaryFunc {
+	return func(ctx context.Context, req connect.AnyRequest) (connect.AnyResponse, error) {
+		spec := req.Spec()
+		if spec.IsClient {
+			ctx, span := startCall(ctx, spec, trace.SpanKindClient, req.Peer())
+			defer span.End()
+			otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(req.Header()))
+			res, err := next(ctx, req)
+			endCall(span, spec, err)
+			return res, err
+		}
+
+		ctx = extract(ctx, req.Header())
+		ctx, span := startCall(ctx, spec, trace.SpanKindServer, connect.Peer{})
+		defer span.End()
+		res, err := next(ctx, req)
+		endCall(span, spec, err)
+		return res, err
+	}
+}
+
+func (interceptor) WrapStreamingClient(next connect.StreamingClientFunc) connect.StreamingClientFunc {
+	return func(ctx context.Context, spec connect.Spec) connect.StreamingClientConn {
+		ctx, span := startCall(ctx, spec, trace.SpanKindClient, connect.Peer{})
+		conn := next(ctx, spec)
+		otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(conn.RequestHeader()))
+		return &streamingClientConn{StreamingClientConn: conn, span: span}
+	}
+}
+
+func (interceptor) WrapStreamingHandler(next connect.StreamingHandlerFunc) connect.StreamingHandlerFunc {
+	return func(ctx context.Context, conn connect.StreamingHandlerConn) error {
+		ctx = extract(ctx, conn.RequestHeader())
+		ctx, span := startCall(ctx, conn.Spec(), trace.SpanKindServer, connect.Peer{})
+		defer span.End()
+		err := next(ctx, conn)
+		endCall(span, conn.Spec(), err)
+		return err
+	}
+}
+
+// streamingClientConn ends the span of a streaming call once the response is done with.
+type streamingClientConn struct {
+	connect.StreamingClientConn
+	span trace.Span
+	once sync.Once
+	err  error
+}
+
+func (c *streamingClientConn) Receive(msg any) error {
+	err := c.StreamingClientConn.Receive(msg)
+	if err != nil && !errors.Is(err, io.EOF) {
+		c.err = err
+	}
+	return err
+}
+
+func (c *streamingClientConn) CloseResponse() error {
+	err := c.StreamingClientConn.CloseResponse()
+	c.once.Do(func() {
+		endCall(c.span, c.Spec(), c.err)
+		c.span.End()
+	})
+	return err
+}
+
+// extract continues the trace of the caller, unless the request span already did.
+func extract(ctx context.Context, header map[string][]string) context.Context {
+	if trace.SpanContextFromContext(ctx).IsValid() {
+		return ctx
+	}
+	return otel.GetTextMapPropagator().Extract(ctx, propagation.HeaderCarrier(header))
+}
+
+// startCall starts the span of a call. peer is the server called by a client.
+func startCall(ctx context.Context, spec connect.Spec, kind trace.SpanKind, peer connect.Peer) (context.Context, trace.Span) {
+	name := strings.TrimPrefix(spec.Procedure, "/")
+	service, method, _ := strings.Cut(name, "/")
+	attrs := []attribute.KeyValue{
+		semconv.RPCSystemKey.String("connect_rpc"),
+		semconv.RPCService(service),
+		semconv.RPCMethod(method),
+	}
+	if peer.Addr != "" {
+		attrs = append(attrs, semconv.ServerAddress(peer.Addr))
+	}
+	return otel.Tracer(Name).Start(ctx, name, trace.WithSpanKind(kind), trace.WithAttributes(attrs...))
+}
+
+func endCall(span trace.Span, spec connect.Spec, err error) {
+	if err == nil {
+		return
+	}
+	code := connect.CodeOf(err)
+	span.SetAttributes(semconv.RPCConnectRPCErrorCodeKey.String(code.String()))
+	// Servers only report errors which are their own fault, clients report every error.
+	if spec.IsClient || serverFault(code) {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+}
+
+func serverFault(code connect.Code) bool {
+	switch code {
+	case connect.CodeUnknown, connect.CodeDeadlineExceeded, connect.CodeUnimplemented,
+		connect.CodeInternal, connect.CodeUnavailable, connect.CodeDataLoss:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,181 @@
+package tracing
+
+// OpenTelemetry tracing.
+//
+// Setup installs the tracer provider configured by the tracing* config fields and the
+// W3C trace context propagator globally, so instrumentation only ever needs otel.Tracer.
+// Servers get a span per request from Middleware and a child span per Connect call from
+// Interceptor. The same interceptor starts client spans and propagates the trace on
+// outgoing calls, so a call back into the server shows up in the trace of its caller.
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+
+	"github.com/brnsampson/echopilot/pkg/config"
+	"github.com/brnsampson/echopilot/pkg/requestid"
+	"github.com/brnsampson/echopilot/pkg/router"
+	"github.com/go-chi/chi/v5/middleware"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.21.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Name of the instrumentation in this package.
+const Name = "github.com/brnsampson/echopilot/pkg/tracing"
+
+const ServiceName = "echopilot"
+
+// Attribute holding the request ID of a request span. See pkg/requestid.
+const RequestIDKey = attribute.Key("echopilot.request_id")
+
+// Setup installs the tracer provider configured by conf. The returned function flushes
+// the spans still buffered and stops the provider. With the none exporter nothing is
+// recorded, but trace context is still passed on from callers to outgoing calls.
+func Setup(ctx context.Context, conf config.StaticConfig) (func(context.Context) error, error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+	if conf.TracingSampleRatio < 0 || conf.TracingSampleRatio > 1 {
+		return nil, fmt.Errorf("tracingSampleRatio must be between 0 and 1, not %v", conf.TracingSampleRatio)
+	}
+
+	var processor sdktrace.SpanProcessor
+	var file io.Closer
+	switch conf.TracingExporter {
+	case "", "none":
+		return func(context.Context) error { return nil }, nil
+	case "otlp":
+		exporter, err := newOTLPExporter(ctx, conf.TracingEndpoint)
+		if err != nil {
+			return nil, err
+		}
+		processor = sdktrace.NewBatchSpanProcessor(exporter)
+	case "stdout":
+		exporter, err := stdouttrace.New(stdouttrace.WithWriter(os.Stdout))
+		if err != nil {
+			return nil, err
+		}
+		// Spans are written as they end, which is what tests reading them want.
+		processor = sdktrace.NewSimpleSpanProcessor(exporter)
+	case "file":
+		if conf.TracingFile == "" {
+			return nil, fmt.Errorf("the file exporter needs tracingFile")
+		}
+		f, err := os.OpenFile(conf.TracingFile, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+		if err != nil {
+			return nil, err
+		}
+		exporter, err := stdouttrace.New(stdouttrace.WithWriter(f))
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		processor = sdktrace.NewSimpleSpanProcessor(exporter)
+		file = f
+	default:
+		return nil, fmt.Errorf("tracingExporter must be none, otlp, stdout or file, not %q", conf.TracingExporter)
+	}
+
+	res, err := resource.New(ctx,
+		resource.WithAttributes(semconv.ServiceName(ServiceName)),
+		resource.WithHost(),
+		resource.WithProcessPID(),
+		resource.WithTelemetrySDK(),
+	)
+	if err != nil {
+		return nil, err
+	}
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithSpanProcessor(processor),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(conf.TracingSampleRatio))),
+	)
+	otel.SetTracerProvider(provider)
+
+	return func(ctx context.Context) error {
+		err := provider.Shutdown(ctx)
+		if file != nil {
+			file.Close()
+		}
+		return err
+	}, nil
+}
+
+// newOTLPExporter exports to the OTLP/HTTP collector at endpoint, e.g.
+// http://localhost:4318. Without a path spans go to the default /v1/traces.
+func newOTLPExporter(ctx context.Context, endpoint string) (sdktrace.SpanExporter, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("tracingEndpoint: %w", err)
+	}
+	opts := []otlptracehttp.Option{otlptracehttp.WithEndpoint(u.Host)}
+	switch u.Scheme {
+	case "http":
+		opts = append(opts, otlptracehttp.WithInsecure())
+	case "https":
+	default:
+		return nil, fmt.Errorf("tracingEndpoint must be an http or https URL, not %q", endpoint)
+	}
+	if u.Path != "" && u.Path != "/" {
+		opts = append(opts, otlptracehttp.WithURLPath(u.Path))
+	}
+	return otlptracehttp.New(ctx, opts...)
+}
+
+// Middleware starts a server span for every request, continuing the trace of the caller.
+// Spans are named by method and route pattern, e.g. GET /echo/{content}.
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+		scheme := "http"
+		if r.TLS != nil {
+			scheme = "https"
+		}
+		attrs := []attribute.KeyValue{
+			semconv.HTTPRequestMethodKey.String(r.Method),
+			semconv.URLScheme(scheme),
+			semconv.URLPath(r.URL.Path),
+			semconv.UserAgentOriginal(r.UserAgent()),
+		}
+		if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+			attrs = append(attrs, semconv.ClientAddress(host))
+		}
+		if id, found := requestid.ID(ctx); found {
+			attrs = append(attrs, RequestIDKey.String(id))
+		}
+		ctx, span := otel.Tracer(Name).Start(ctx, r.Method,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(attrs...),
+		)
+		defer span.End()
+
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		next.ServeHTTP(ww, r.WithContext(ctx))
+
+		// The route is only known once the router has found it.
+		if route := router.RoutePattern(r); route != "" {
+			span.SetName(r.Method + " " + route)
+			span.SetAttributes(semconv.HTTPRoute(route))
+		}
+		status := ww.Status()
+		if status == 0 {
+			status = http.StatusOK
+		}
+		span.SetAttributes(semconv.HTTPResponseStatusCode(status))
+		// Client errors are the client's problem, not the server's.
+		if status >= 500 {
+			span.SetStatus(codes.Error, http.StatusText(status))
+		}
+	})
+}
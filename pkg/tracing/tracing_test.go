@@ -0,0 +1,166 @@
+package tracing_test
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"connectrpc.com/connect"
+	"github.com/brnsampson/echopilot/features/memory"
+	"github.com/brnsampson/echopilot/pkg/config"
+	"github.com/brnsampson/echopilot/pkg/option"
+	"github.com/brnsampson/echopilot/pkg/router"
+	"github.com/brnsampson/echopilot/pkg/tracing"
+	"github.com/brnsampson/echopilot/rpc/echo"
+	"github.com/charmbracelet/log"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+// ok fails the test if an err is not nil.
+func ok(tb testing.TB, err error) {
+	if err != nil {
+		_, file, line, _ := runtime.Caller(1)
+		fmt.Printf("\033[31m%s:%d: unexpected error: %s\033[39m\n\n", filepath.Base(file), line, err.Error())
+		tb.FailNow()
+	}
+}
+
+// equals fails the test if exp is not equal to act.
+func equals(tb testing.TB, exp, act interface{}) {
+	if !reflect.DeepEqual(exp, act) {
+		_, file, line, _ := runtime.Caller(1)
+		fmt.Printf("\033[31m%s:%d:\n\n\texp: %#v\n\n\tgot: %#v\033[39m\n\n", filepath.Base(file), line, exp, act)
+		tb.FailNow()
+	}
+}
+
+// record installs a tracer provider which keeps every ended span.
+func record() *tracetest.SpanRecorder {
+	recorder := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	return recorder
+}
+
+// byName indexes spans by name, failing the test if a name is not unique.
+func byName(t *testing.T, spans []sdktrace.ReadOnlySpan) map[string]sdktrace.ReadOnlySpan {
+	named := make(map[string]sdktrace.ReadOnlySpan)
+	for _, span := range spans {
+		_, dup := named[span.Name()]
+		equals(t, false, dup)
+		named[span.Name()] = span
+	}
+	return named
+}
+
+func newServer(t *testing.T) *httptest.Server {
+	routes := router.NewRouter()
+	routes.Use(tracing.Middleware)
+	routes.Mux().Get("/broken", func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "broken", http.StatusInternalServerError)
+	})
+	host := router.Host{Logger: log.New(io.Discard), Interceptors: []connect.Interceptor{tracing.Interceptor()}}
+	ok(t, routes.Add(echo.NewFeature(host)))
+	ok(t, routes.Add(memory.NewFeature(host)))
+	return httptest.NewServer(routes)
+}
+
+func TestRPCTrace(t *testing.T) {
+	recorder := record()
+	srv := newServer(t)
+	defer srv.Close()
+
+	client, err := echo.NewRemoteEchoClient(srv.URL, option.None[time.Duration](), option.None[bool]())
+	ok(t, err)
+	ctx, parent := otel.Tracer("test").Start(context.Background(), "caller")
+	_, err = client.EchoStringContext(ctx, echo.NewStringRequest("hi"))
+	ok(t, err)
+	parent.End()
+
+	// Client and server spans of a call share its name.
+	spans := make(map[string]sdktrace.ReadOnlySpan)
+	for _, span := range recorder.Ended() {
+		spans[span.SpanKind().String()+" "+span.Name()] = span
+	}
+	equals(t, 4, len(spans))
+	caller := spans["internal caller"]
+	call := spans["client echo.v1.EchoService/EchoString"]
+	request := spans["server POST /echo.v1.EchoService/*"]
+	handled := spans["server echo.v1.EchoService/EchoString"]
+
+	// caller > client call > request > handled call, all in one trace.
+	equals(t, caller.SpanContext().SpanID(), call.Parent().SpanID())
+	equals(t, call.SpanContext().SpanID(), request.Parent().SpanID())
+	equals(t, true, request.Parent().IsRemote())
+	equals(t, request.SpanContext().SpanID(), handled.Parent().SpanID())
+	for _, span := range spans {
+		equals(t, caller.SpanContext().TraceID(), span.SpanContext().TraceID())
+	}
+}
+
+func TestHTTPTrace(t *testing.T) {
+	recorder := record()
+	srv := newServer(t)
+	defer srv.Close()
+
+	// The trace of the caller is continued.
+	req, err := http.NewRequest("POST", srv.URL+"/memory", strings.NewReader(url.Values{"content": {"remember"}}.Encode()))
+	ok(t, err)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	// Stay on the POST, the redirect would be a new request.
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	_, err = client.Do(req)
+	ok(t, err)
+
+	spans := byName(t, recorder.Ended())
+	request := spans["POST /memory"]
+	equals(t, "4bf92f3577b34da6a3ce929d0e0e4736", request.SpanContext().TraceID().String())
+	equals(t, "00f067aa0ba902b7", request.Parent().SpanID().String())
+	// The store operation is a child of the request.
+	equals(t, request.SpanContext().SpanID(), spans["MemoryStore.Create"].Parent().SpanID())
+
+	res, err := http.Get(srv.URL + "/broken")
+	ok(t, err)
+	io.ReadAll(res.Body)
+	broken := byName(t, recorder.Ended())["GET /broken"]
+	equals(t, "Error", broken.Status().Code.String())
+}
+
+func TestFileExporter(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "spans.json")
+	conf := config.StaticConfig{TracingExporter: "file", TracingFile: file, TracingSampleRatio: 1}
+	shutdown, err := tracing.Setup(context.Background(), conf)
+	ok(t, err)
+
+	_, span := otel.Tracer("test").Start(context.Background(), "written")
+	span.End()
+	ok(t, shutdown(context.Background()))
+
+	f, err := os.Open(file)
+	ok(t, err)
+	defer f.Close()
+	lines := bufio.NewScanner(f)
+	equals(t, true, lines.Scan())
+	var written struct{ Name string }
+	ok(t, json.Unmarshal(lines.Bytes(), &written))
+	equals(t, "written", written.Name)
+
+	_, err = tracing.Setup(context.Background(), config.StaticConfig{TracingExporter: "carrier-pigeon"})
+	equals(t, true, err != nil)
+}
@@ -9,7 +9,11 @@ import (
 	pb "github.com/brnsampson/echopilot/proto/gen/echo/v1"
 	"github.com/brnsampson/echopilot/proto/gen/echo/v1/echov1connect"
     "connectrpc.com/connect"
+    "github.com/brnsampson/echopilot/pkg/auth"
+    "github.com/brnsampson/echopilot/pkg/httpsig"
     "github.com/brnsampson/echopilot/pkg/option"
+    "github.com/brnsampson/echopilot/pkg/requestid"
+    "github.com/brnsampson/echopilot/pkg/tracing"
 )
 
 type EchoClient interface {
@@ -23,8 +27,14 @@ type RemoteEchoClient struct {
 }
 
 func (ec *RemoteEchoClient) EchoString(request *pb.EchoStringRequest) (*pb.EchoStringResponse, error) {
+	return ec.EchoStringContext(context.Background(), request)
+}
+
+// EchoStringContext is EchoString as part of the request or operation of ctx, whose
+// request ID and credentials (see auth.WithAPIKey) are sent to the server.
+func (ec *RemoteEchoClient) EchoStringContext(ctx context.Context, request *pb.EchoStringRequest) (*pb.EchoStringResponse, error) {
 	req := connect.NewRequest(request)
-	response, err := ec.connectClient.EchoString(context.Background(), req)
+	response, err := ec.connectClient.EchoString(ctx, req)
 	if err != nil {
 		return nil, err
 	}
@@ -32,8 +42,14 @@ func (ec *RemoteEchoClient) EchoString(request *pb.EchoStringRequest) (*pb.EchoS
 }
 
 func (ec *RemoteEchoClient) EchoInt(request *pb.EchoIntRequest) (*pb.EchoIntResponse, error) {
+	return ec.EchoIntContext(context.Background(), request)
+}
+
+// EchoIntContext is EchoInt as part of the request or operation of ctx, whose request ID
+// and credentials (see auth.WithAPIKey) are sent to the server.
+func (ec *RemoteEchoClient) EchoIntContext(ctx context.Context, request *pb.EchoIntRequest) (*pb.EchoIntResponse, error) {
 	req := connect.NewRequest(request)
-	response, err := ec.connectClient.EchoInt(context.Background(), req)
+	response, err := ec.connectClient.EchoInt(ctx, req)
 	if err != nil {
 		return nil, err
 	}
@@ -41,20 +57,35 @@ func (ec *RemoteEchoClient) EchoInt(request *pb.EchoIntRequest) (*pb.EchoIntResp
 }
 
 func NewRemoteEchoClient(addr string, timeout option.Option[time.Duration], skipVerify option.Option[bool]) (*RemoteEchoClient, error) {
+	sv := skipVerify.UnwrapOrDefault(false)
+
+	tlsConf := tls.Config{InsecureSkipVerify: sv}
+	return NewRemoteEchoClientWithTLS(addr, timeout, &tlsConf)
+}
+
+// NewRemoteEchoClientWithTLS creates a client using the given TLS settings, e.g. to trust
+// a private CA instead of skipping verification.
+func NewRemoteEchoClientWithTLS(addr string, timeout option.Option[time.Duration], tlsConf *tls.Config) (*RemoteEchoClient, error) {
+	return NewSignedRemoteEchoClient(addr, timeout, tlsConf, nil, nil)
+}
+
+// NewSignedRemoteEchoClient creates a client which signs its requests with signer and
+// rejects responses whose signatures do not pass verifier (RFC 9421). Either may be nil.
+func NewSignedRemoteEchoClient(addr string, timeout option.Option[time.Duration], tlsConf *tls.Config, signer *httpsig.Signer, verifier *httpsig.Verifier) (*RemoteEchoClient, error) {
 	if addr == "" {
 		addr = "127.0.0.1:3000"
 	}
 
-	sv := skipVerify.UnwrapOrDefault(false)
-
-	tlsConf := tls.Config{InsecureSkipVerify: sv}
-	transport := http.Transport{TLSClientConfig: &tlsConf}
+	var transport http.RoundTripper = &http.Transport{TLSClientConfig: tlsConf}
+	if signer != nil || verifier != nil {
+		transport = &httpsig.Transport{Base: transport, Signer: signer, Verifier: verifier}
+	}
 
 	to := timeout.UnwrapOrDefault(time.Duration(10) * time.Second)
 
-	client := http.Client{Timeout: to, Transport: &transport}
+	client := http.Client{Timeout: to, Transport: transport}
 
-	echoclient := echov1connect.NewEchoServiceClient(&client, addr)
+	echoclient := echov1connect.NewEchoServiceClient(&client, addr, connect.WithInterceptors(tracing.Interceptor(), requestid.NewInterceptor(), auth.NewClientInterceptor()))
 
 	return &RemoteEchoClient{echoclient}, nil
 }
@@ -2,6 +2,7 @@ package echo
 
 import (
     "net/http"
+    "connectrpc.com/connect"
     "github.com/brnsampson/echopilot/rpc/echo/internal"
 	pb "github.com/brnsampson/echopilot/proto/gen/echo/v1"
     "github.com/charmbracelet/log"
@@ -44,7 +45,7 @@ func (s *Service) EchoInt(req *pb.EchoIntRequest) (*pb.EchoIntResponse, error) {
 	return res, nil
 }
 
-func (s *Service) GetHandler() (string, http.Handler) {
-    connect := internal.NewEchoConnectServer(s)
-    return connect.GetHttpHandler()
+func (s *Service) GetHandler(opts ...connect.HandlerOption) (string, http.Handler) {
+    server := internal.NewEchoConnectServer(s)
+    return server.GetHttpHandler(opts...)
 }
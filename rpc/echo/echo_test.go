@@ -2,12 +2,18 @@ package echo_test
 
 import (
 	"fmt"
+	"io"
+	"net/http/httptest"
 	"path/filepath"
 	"reflect"
 	"runtime"
 	"testing"
+	"time"
 
+	"github.com/brnsampson/echopilot/pkg/option"
+	"github.com/brnsampson/echopilot/pkg/router"
 	"github.com/brnsampson/echopilot/rpc/echo"
+	"github.com/charmbracelet/log"
 )
 
 // assert fails the test if the condition is false.
@@ -38,17 +44,36 @@ func equals(tb testing.TB, exp, act interface{}) {
 }
 
 func TestEchoString(t *testing.T) {
-	srv := echo.NewService()
+	srv := echo.NewService(log.New(io.Discard))
 	testString := "Testeroo"
-	result, err := srv.EchoString(testString)
+	result, err := srv.EchoString(echo.NewStringRequest(testString))
 	ok(t, err)
-	equals(t, testString, result)
+	equals(t, testString, echo.ReadStringResult(result))
 }
 
 func TestEchoInt(t *testing.T) {
-	srv := echo.NewService()
+	srv := echo.NewService(log.New(io.Discard))
 	testInt := int32(42)
-	result, err := srv.EchoInt(testInt)
+	result, err := srv.EchoInt(echo.NewIntRequest(testInt))
 	ok(t, err)
-	equals(t, testInt, result)
+	equals(t, testInt, echo.ReadIntResult(result))
+}
+
+func TestFeaturePrefix(t *testing.T) {
+	assert(t, router.IsRegistered("echo"), "echo should register itself")
+	host := router.Host{Logger: log.New(io.Discard)}
+
+	for _, prefix := range []string{"", "/api/rpc"} {
+		r := router.NewRouter()
+		ok(t, r.AddAt(echo.NewFeature(host), prefix))
+		srv := httptest.NewServer(r)
+
+		// Connect clients take the prefix as part of their base URL.
+		client, err := echo.NewRemoteEchoClient(srv.URL+prefix, option.None[time.Duration](), option.None[bool]())
+		ok(t, err)
+		result, err := client.EchoString(echo.NewStringRequest("mounted"))
+		srv.Close()
+		ok(t, err)
+		equals(t, "mounted", echo.ReadStringResult(result))
+	}
 }
@@ -0,0 +1,68 @@
+package echo
+
+import (
+	"net/http"
+
+	"connectrpc.com/connect"
+	"github.com/brnsampson/echopilot/pkg/router"
+	pb "github.com/brnsampson/echopilot/proto/gen/echo/v1"
+	"github.com/go-chi/chi/v5"
+)
+
+func init() {
+	router.Register("echo", func(host router.Host) router.Feature {
+		return NewFeature(host)
+	})
+}
+
+// Feature serves the echo service over Connect, gRPC and gRPC-Web.
+func NewFeature(host router.Host) *Feature {
+	service := NewService(host.Logger)
+	return &Feature{service: &service, host: host}
+}
+
+type Feature struct {
+	router.Base
+	service *Service
+	host    router.Host
+}
+
+func (f *Feature) Name() string {
+	return "echo"
+}
+
+// The service is mounted at the root by default, which is where gRPC clients expect it.
+// Under another prefix, the prefix becomes part of the base URL of Connect clients.
+func (f *Feature) Routes(r chi.Router) {
+	path, handler := f.service.GetHandler(connect.WithInterceptors(f.host.Interceptors...))
+	// Connect routes on the full path, so strip whatever prefix we are mounted at.
+	r.Handle(path+"*", http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		rewritten := path + chi.URLParam(req, "*")
+		if rewritten != req.URL.Path {
+			req = req.Clone(req.Context())
+			req.URL.Path = rewritten
+			req.URL.RawPath = ""
+		}
+		handler.ServeHTTP(w, req)
+	}))
+}
+
+// Procedures lists every RPC of the services in echo.proto for the route table.
+func (f *Feature) Procedures() []string {
+	procedures := make([]string, 0)
+	services := pb.File_echo_v1_echo_proto.Services()
+	for i := 0; i < services.Len(); i++ {
+		methods := services.Get(i).Methods()
+		for j := 0; j < methods.Len(); j++ {
+			procedures = append(procedures, "/"+string(services.Get(i).FullName())+"/"+string(methods.Get(j).Name()))
+		}
+	}
+	return procedures
+}
+
+func (f *Feature) Middleware() []func(http.Handler) http.Handler {
+	if f.host.Authenticate == nil {
+		return nil
+	}
+	return []func(http.Handler) http.Handler{f.host.Authenticate}
+}
@@ -26,8 +26,8 @@ type EchoConnectServer struct {
 	EchoService
 }
 
-func (es *EchoConnectServer) GetHttpHandler() (string, http.Handler) {
-	return echov1connect.NewEchoServiceHandler(es)
+func (es *EchoConnectServer) GetHttpHandler(opts ...connect.HandlerOption) (string, http.Handler) {
+	return echov1connect.NewEchoServiceHandler(es, opts...)
 }
 
 func (es *EchoConnectServer) EchoString(ctx context.Context, req *connect.Request[pb.EchoStringRequest]) (*connect.Response[pb.EchoStringResponse], error) {